@@ -0,0 +1,14 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"go-musthave-metrics/internal/metricsmigrate"
+)
+
+func main() {
+	if err := metricsmigrate.RunApp(context.Background(), nil, nil); err != nil {
+		log.Fatal(err)
+	}
+}
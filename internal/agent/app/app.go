@@ -8,11 +8,13 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"reflect"
 	"sync"
 	"syscall"
 	"time"
 
 	"go-musthave-metrics/internal/agent/config"
+	myErr "go-musthave-metrics/internal/agent/error"
 )
 
 type BuildMetadata struct {
@@ -25,14 +27,18 @@ type app struct {
 	wg  *sync.WaitGroup
 	cfg *config.Config
 	m   *MetricsCollects
+	// remote last config applied from server
+	remote *config.RemoteConfig
 }
 
-func newApp(cfg *config.Config) *app {
-	return &app{
+func newApp(cfg *config.Config, buildMetadata BuildMetadata) *app {
+	a := &app{
 		cfg: cfg,
 		m:   NewMetricsCollects(cfg),
 		wg:  &sync.WaitGroup{},
 	}
+	a.m.identity = NewIdentity(cfg, buildMetadata)
+	return a
 }
 
 func RunApp(ctx context.Context, cfg *config.Config, buildMetadata BuildMetadata) {
@@ -51,7 +57,7 @@ func RunApp(ctx context.Context, cfg *config.Config, buildMetadata BuildMetadata
 	ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	a := newApp(cfg)
+	a := newApp(cfg, buildMetadata)
 
 	log.Printf(`Started with build info:
   BuildVersion: %s
@@ -64,16 +70,27 @@ With config:
   Rate limit: %d
   Number of metrics at once: %d
   Key: %s
+  Key ID: %s
   CryptoKey: %s
   GRPCAddres: %s
   Metric names count: %d
+  Agent ID: %s
+  Hostname: %s
+  Tenant: %s
 `,
 		buildInfo(buildMetadata.Version),
 		buildInfo(buildMetadata.Date),
 		buildInfo(buildMetadata.Commit),
 		a.cfg.Address, constant.BaseURL, a.cfg.ReportInterval, a.cfg.PollInterval,
-		a.cfg.RateLimit, a.cfg.SendSize, a.cfg.Key, a.cfg.CryptoKey, a.cfg.GRPCAddress,
-		len(a.cfg.GaugesList)+len(a.cfg.CountersList))
+		a.cfg.RateLimit, a.cfg.SendSize, a.cfg.Key, a.cfg.KeyID, a.cfg.CryptoKey, a.cfg.GRPCAddress,
+		len(a.cfg.GaugesList)+len(a.cfg.CountersList),
+		a.m.identity.ID, a.m.identity.Hostname, a.cfg.Tenant)
+
+	// config from server
+	a.applyRemoteConfig(ctx)
+
+	// reload config on SIGHUP and config file change, fetch config from server
+	a.configWatcher(ctx)
 
 	// collect runtime metrics
 	a.collectRuntime(ctx)
@@ -91,16 +108,18 @@ With config:
 
 func (a *app) collectRuntime(ctx context.Context) {
 	// collect runtime metrics
-	log.Printf("daemon started: collect runtime metrics with interval %v", a.cfg.PollInterval)
+	log.Printf("daemon started: collect runtime metrics with interval %v", a.m.Config().PollInterval)
 
 	a.wg.Add(1)
 	go func() {
 		defer a.wg.Done()
 		for {
+			changed := a.m.Changed()
 			select {
-			case <-time.After(time.Duration(a.cfg.PollInterval) * time.Second):
+			case <-time.After(time.Duration(a.m.Config().PollInterval) * time.Second):
 				log.Println("Collect runtime metrics")
 				a.m.GetMetrics()
+			case <-changed:
 			case <-ctx.Done():
 				log.Println("Runtime metrics collector is stopped")
 				return
@@ -111,18 +130,20 @@ func (a *app) collectRuntime(ctx context.Context) {
 
 func (a *app) collectPSUtil(ctx context.Context) {
 	// collect psutil metrics
-	log.Printf("daemon started: collect psutil metrics with interval %v", a.cfg.PollInterval)
+	log.Printf("daemon started: collect psutil metrics with interval %v", a.m.Config().PollInterval)
 
 	a.wg.Add(1)
 	go func() {
 		defer a.wg.Done()
 		for {
+			changed := a.m.Changed()
 			select {
-			case <-time.After(time.Duration(a.cfg.PollInterval) * time.Second):
+			case <-time.After(time.Duration(a.m.Config().PollInterval) * time.Second):
 				log.Println("Collect psutil metrics")
 				if err := a.m.GetGopMetrics(); err != nil {
 					log.Println("Error", err.Error())
 				}
+			case <-changed:
 			case <-ctx.Done():
 				log.Println("PSUtil metrics collector is stopped")
 				return
@@ -133,27 +154,34 @@ func (a *app) collectPSUtil(ctx context.Context) {
 
 func (a *app) sender(ctx context.Context) {
 	a.wg.Add(1)
-	log.Printf("daemon started: send metrics interval %v", a.cfg.ReportInterval)
+	log.Printf("daemon started: send metrics interval %v", a.m.Config().ReportInterval)
 	go func() {
 		defer a.wg.Done()
 		urlErr := &url.Error{}
 		for {
+			changed := a.m.Changed()
 			select {
-			case <-time.After(time.Duration(a.cfg.ReportInterval) * time.Second):
+			case <-time.After(time.Duration(a.m.Config().ReportInterval) * time.Second):
 				for i := 0; i <= len(config.Backoff); i++ {
 					if n, err := a.m.SendMetrics(ctx); err != nil {
-						if !errors.As(err, &urlErr) {
+						// rate limited requests are repeated after time from server hint
+						var retryErr *myErr.RetryAfterError
+						if !errors.As(err, &retryErr) && !errors.As(err, &urlErr) {
 							log.Println(err)
 							break
 						}
 						log.Printf("try %d: %s", i+1, err)
 						if i < len(config.Backoff) {
-							log.Printf("wait %d second before next try", config.Backoff[i]/time.Second)
+							wait := config.Backoff[i]
+							if retryErr != nil && retryErr.After > 0 {
+								wait = retryErr.After
+							}
+							log.Printf("wait %d second before next try", wait/time.Second)
 							select {
 							case <-ctx.Done():
 								log.Print("ctx done, do not try more")
 								return
-							case <-time.After(config.Backoff[i]):
+							case <-time.After(wait):
 							}
 						}
 					} else {
@@ -161,6 +189,7 @@ func (a *app) sender(ctx context.Context) {
 						break
 					}
 				}
+			case <-changed:
 			case <-ctx.Done():
 				log.Println("Metrics sender is stopped")
 				return
@@ -169,3 +198,100 @@ func (a *app) sender(ctx context.Context) {
 	}()
 
 }
+
+// configWatcher reload local config on SIGHUP or config file change and fetch config from server periodically.
+// Local config is owned by this goroutine, collectors and sender get applied config by MetricsCollects.Config
+func (a *app) configWatcher(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	remote := time.NewTicker(time.Second)
+	resetRemote := func() {
+		remote.Stop()
+		if a.cfg.RemoteConfigInterval > 0 {
+			log.Printf("daemon started: fetch config from server interval %v", a.cfg.RemoteConfigInterval)
+			remote.Reset(time.Duration(a.cfg.RemoteConfigInterval) * time.Second)
+		}
+	}
+	resetRemote()
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer signal.Stop(hup)
+		defer remote.Stop()
+		fileCheck := time.NewTicker(constant.ConfigCheckInterval)
+		defer fileCheck.Stop()
+		modTime := a.cfg.ConfigModTime()
+		for {
+			select {
+			case <-remote.C:
+				a.applyRemoteConfig(ctx)
+			case <-hup:
+				log.Println("SIGHUP received, reload config")
+				if a.reload(ctx) {
+					modTime = a.cfg.ConfigModTime()
+					resetRemote()
+				}
+			case <-fileCheck.C:
+				if t := a.cfg.ConfigModTime(); !t.Equal(modTime) {
+					modTime = t
+					log.Println("Config file changed, reload config")
+					if a.reload(ctx) {
+						resetRemote()
+					}
+				}
+			case <-ctx.Done():
+				log.Println("Config watcher is stopped")
+				return
+			}
+		}
+	}()
+}
+
+// reload local config and apply it with config from server, current config is kept on error
+func (a *app) reload(ctx context.Context) bool {
+	cfg, err := a.cfg.Reload()
+	if err != nil {
+		log.Printf("Reload config: %s, current config is kept", err)
+		return false
+	}
+	a.cfg = cfg
+	a.applyRemoteConfig(ctx)
+	log.Println("Config reloaded")
+	return true
+}
+
+// applyRemoteConfig fetch config from server and apply it over local config,
+// last config from server is kept if server is unavailable, local config is used if server has no config
+func (a *app) applyRemoteConfig(ctx context.Context) {
+	if a.cfg.RemoteConfigInterval >= 0 {
+		rc, err := a.m.FetchRemoteConfig(ctx, a.cfg)
+		switch {
+		case err == nil:
+			a.remote = rc
+		case errors.Is(err, myErr.ErrNoRemoteConfig):
+			a.remote = nil
+		case a.remote != nil:
+			log.Printf("Get config from server: %s, last config from server is kept", err)
+		default:
+			log.Printf("Get config from server: %s, local config is used", err)
+		}
+	} else {
+		a.remote = nil
+	}
+	cfg := a.cfg
+	if a.remote != nil {
+		cfg = a.cfg.Apply(a.remote)
+	}
+	if reflect.DeepEqual(cfg, a.m.Config()) {
+		return
+	}
+	a.m.SetConfig(cfg)
+	if cfg == a.cfg {
+		log.Println("Local config applied")
+		return
+	}
+	log.Printf("Config from server applied: report interval: %d, poll interval: %d, rate limit: %d, send size: %d, metric names count: %d",
+		cfg.ReportInterval, cfg.PollInterval, cfg.RateLimit, cfg.SendSize, len(cfg.GaugesList)+len(cfg.CountersList))
+}
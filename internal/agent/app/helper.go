@@ -1,6 +1,11 @@
 package app
 
-import "net"
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+)
 
 func GetLocalIP() string {
 	addrs, err := net.InterfaceAddrs()
@@ -24,3 +29,18 @@ func buildInfo(s string) string {
 	}
 	return s
 }
+
+// signData hmac sha256 sign of data as hex string
+func signData(key string, data []byte) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkSign check data sign, any sign is ok if no key
+func checkSign(key string, data []byte, sign string) bool {
+	if key == "" {
+		return true
+	}
+	return hmac.Equal([]byte(signData(key, data)), []byte(sign))
+}
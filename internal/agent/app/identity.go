@@ -0,0 +1,59 @@
+package app
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+
+	"go-musthave-metrics/internal/agent/config"
+	"go-musthave-metrics/internal/agent/constant"
+)
+
+// Identity agent identity, sent to server with every report
+type Identity struct {
+	ID       string
+	Hostname string
+	Version  string
+}
+
+// NewIdentity create agent identity from config and build info
+func NewIdentity(c *config.Config, b BuildMetadata) *Identity {
+	hostname, _ := os.Hostname()
+	id := c.AgentID
+	if id == "" {
+		id = hostname
+	}
+	return &Identity{
+		ID:       id,
+		Hostname: hostname,
+		Version:  buildInfo(b.Version),
+	}
+}
+
+// Headers return identity with current config as header (or grpc metadata) values
+func (i *Identity) Headers(c *config.Config) map[string]string {
+	if i == nil {
+		return nil
+	}
+	h := map[string]string{
+		constant.HeaderAgentID:       i.ID,
+		constant.HeaderAgentHostname: i.Hostname,
+		constant.HeaderAgentVersion:  i.Version,
+		constant.HeaderAgentConfig:   configSummary(c),
+	}
+	if c.AgentGroup != "" {
+		h[constant.HeaderAgentGroup] = c.AgentGroup
+	}
+	return h
+}
+
+// configSummary short config description, report_interval is used by server for detect missing agents
+func configSummary(c *config.Config) string {
+	return url.Values{
+		"report_interval": {strconv.Itoa(c.ReportInterval)},
+		"poll_interval":   {strconv.Itoa(c.PollInterval)},
+		"rate_limit":      {strconv.Itoa(c.RateLimit)},
+		"send_size":       {strconv.Itoa(c.SendSize)},
+		"metrics":         {strconv.Itoa(len(c.GaugesList) + len(c.CountersList))},
+	}.Encode()
+}
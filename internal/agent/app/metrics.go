@@ -4,11 +4,6 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/hmac"
-	crand "crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,39 +15,76 @@ import (
 	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go-musthave-metrics/internal/agent/config"
 	"go-musthave-metrics/internal/agent/constant"
 	myErr "go-musthave-metrics/internal/agent/error"
+	"go-musthave-metrics/internal/envelope"
 	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/sign"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // MetricsCollects metrics collection
 type MetricsCollects struct {
-	c              *config.Config
+	c              atomic.Pointer[config.Config]
+	identity       *Identity
 	CPUutilization []float64
 	runtime.MemStats
 	PollCount   int64
 	RandomValue float64
 	TotalMemory float64
 	FreeMemory  float64
+	changed     chan struct{}
 	m           sync.RWMutex
+	cm          sync.Mutex
 }
 
 func NewMetricsCollects(c *config.Config) *MetricsCollects {
-	return &MetricsCollects{
-		c: c,
+	m := &MetricsCollects{}
+	m.c.Store(c)
+	return m
+}
+
+// Config current config of metrics collection
+func (m *MetricsCollects) Config() *config.Config {
+	return m.c.Load()
+}
+
+// SetConfig replace config of metrics collection and notify about change
+func (m *MetricsCollects) SetConfig(c *config.Config) {
+	m.cm.Lock()
+	defer m.cm.Unlock()
+	m.c.Store(c)
+	if m.changed != nil {
+		close(m.changed)
+		m.changed = nil
+	}
+}
+
+// Changed return channel closed on next config change
+func (m *MetricsCollects) Changed() <-chan struct{} {
+	m.cm.Lock()
+	defer m.cm.Unlock()
+	if m.changed == nil {
+		m.changed = make(chan struct{})
 	}
+	return m.changed
 }
 
 // GetMetrics reg runtime MemStats metrics
@@ -87,8 +119,9 @@ func (m *MetricsCollects) ListMetrics() (metrics []*Metric, err error) {
 	var er error
 
 	mRefVal := reflect.Indirect(reflect.ValueOf(m))
-	lRefVal := reflect.ValueOf(m.c.MetricLists)
-	lRefType := reflect.TypeOf(m.c.MetricLists)
+	lists := m.Config().MetricLists
+	lRefVal := reflect.ValueOf(lists)
+	lRefType := reflect.TypeOf(lists)
 	var mType string
 	for i := 0; i < lRefVal.NumField(); i++ {
 		if mType = lRefType.Field(i).Tag.Get("type"); mType == "" {
@@ -147,11 +180,12 @@ func (m *MetricsCollects) SendMetrics(ctx context.Context) (n int, err error) {
 	}
 	n = len(metrics)
 
-	semaphore := NewSemaphore(m.c.RateLimit)
+	c := m.Config()
+	semaphore := NewSemaphore(c.RateLimit)
 	sendCount := 1
-	if m.c.SendSize > 0 && m.c.SendSize < len(metrics) {
-		sendCount = len(metrics) / m.c.SendSize
-		if len(metrics)%m.c.SendSize > 0 {
+	if c.SendSize > 0 && c.SendSize < len(metrics) {
+		sendCount = len(metrics) / c.SendSize
+		if len(metrics)%c.SendSize > 0 {
 			sendCount++
 		}
 	}
@@ -164,7 +198,7 @@ func (m *MetricsCollects) SendMetrics(ctx context.Context) (n int, err error) {
 		default:
 		}
 		wg.Add(1)
-		start, finish := s*m.c.SendSize, (s+1)*m.c.SendSize
+		start, finish := s*c.SendSize, (s+1)*c.SendSize
 		if finish > len(metrics) || finish == 0 {
 			finish = len(metrics)
 		}
@@ -173,10 +207,10 @@ func (m *MetricsCollects) SendMetrics(ctx context.Context) (n int, err error) {
 				semaphore.Acquire()
 				defer wg.Done()
 				defer semaphore.Release()
-				if m.c.GRPCAddress == "" {
-					err = m.httpRequest(metrics)
+				if c.GRPCAddress == "" {
+					err = m.httpRequest(c, metrics)
 				} else {
-					err = m.grpcRequest(metrics)
+					err = m.grpcRequest(c, metrics)
 				}
 			}(metrics[start:finish])
 			return err
@@ -188,7 +222,7 @@ func (m *MetricsCollects) SendMetrics(ctx context.Context) (n int, err error) {
 	return
 }
 
-func (m *MetricsCollects) httpRequest(metrics []*Metric) (err error) {
+func (m *MetricsCollects) httpRequest(c *config.Config, metrics []*Metric) (err error) {
 	var er error
 
 	// data to json body
@@ -206,18 +240,17 @@ func (m *MetricsCollects) httpRequest(metrics []*Metric) (err error) {
 		return
 	}
 
-	urlStr := m.c.Address + constant.BaseURL
+	urlStr := c.Address + constant.BaseURL
 	if er = zb.Close(); er != nil {
 		err = errors.Join(err, myErr.ErrWrap(er))
 		return
 	}
 
 	// crypto stage
-	if m.c.GetPublicKey() != nil {
+	var scheme string
+	if c.GetPublicKey() != nil {
 		var cipherBody []byte
-
-		cipherBody, err = rsa.EncryptOAEP(sha256.New(), crand.Reader, m.c.GetPublicKey(), bodyBuf.Bytes(), nil)
-		if err != nil {
+		if scheme, cipherBody, er = envelope.Seal(c.GetPublicKey(), bodyBuf.Bytes()); er != nil {
 			err = errors.Join(err, myErr.ErrWrap(er))
 			return
 		}
@@ -236,20 +269,29 @@ func (m *MetricsCollects) httpRequest(metrics []*Metric) (err error) {
 	req.Header.Set(constant.HeaderXRealIP, ip)
 	req.Header.Set("Content-Encoding", "gzip")
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if scheme != "" {
+		req.Header.Set(envelope.Header, scheme)
+	}
+	for k, v := range m.identity.Headers(c) {
+		req.Header.Set(k, v)
+	}
+	if c.APIToken != "" {
+		req.Header.Set(constant.HeaderAuthorization, constant.BearerPrefix+c.APIToken)
+	}
+	if c.Tenant != "" {
+		req.Header.Set(constant.HeaderTenant, c.Tenant)
+	}
 
 	// sign at header
-	if m.c != nil && m.c.Key != "" {
-		h := hmac.New(sha256.New, []byte(m.c.Key))
-		if _, err = h.Write(body); err != nil {
-			err = errors.Join(err, myErr.ErrWrap(er))
-			return
+	if c.Key != "" {
+		for k, v := range signHeaders(c, sign.New(req.Method, req.URL.Path, body)) {
+			req.Header.Set(k, v)
 		}
-		req.Header.Set(constant.HeaderSignKey, hex.EncodeToString(h.Sum(nil)))
 	}
 
 	// do httpRequest
 	var res *http.Response
-	if res, er = http.DefaultClient.Do(req); er != nil {
+	if res, er = c.HTTPClient().Do(req); er != nil {
 		err = errors.Join(err, myErr.ErrWrap(er))
 		return
 	}
@@ -262,7 +304,9 @@ func (m *MetricsCollects) httpRequest(metrics []*Metric) (err error) {
 	if er = res.Body.Close(); er != nil {
 		err = errors.Join(err, myErr.ErrWrap(er))
 	}
-	if res.StatusCode != http.StatusOK {
+	if res.StatusCode == http.StatusTooManyRequests {
+		err = errors.Join(err, &myErr.RetryAfterError{After: retryAfter(res.Header.Get(constant.HeaderRetryAfter))})
+	} else if res.StatusCode != http.StatusOK {
 		err = errors.Join(err, fmt.Errorf("post from %s to %s with body: %s. Get: statusCode: %d;  answer body: %s",
 			ip, urlStr, body, res.StatusCode, resultBody))
 	}
@@ -270,19 +314,10 @@ func (m *MetricsCollects) httpRequest(metrics []*Metric) (err error) {
 	return
 }
 
-func (m *MetricsCollects) grpcRequest(metrics []*Metric) (err error) {
+func (m *MetricsCollects) grpcRequest(c *config.Config, metrics []*Metric) (err error) {
 	ctx := context.Background()
-	logger := log.New(os.Stderr, "", log.Ldate|log.Ltime|log.Lshortfile)
-	opts := []logging.Option{
-		logging.WithLogOnEvents(logging.FinishCall),
-	}
 	var conn *grpc.ClientConn
-	conn, err = grpc.DialContext(ctx, m.c.GRPCAddress,
-		grpc.WithChainUnaryInterceptor(
-			logging.UnaryClientInterceptor(interceptorLogger(logger), opts...),
-		),
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
-
+	conn, err = dialGRPC(ctx, c)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -305,26 +340,147 @@ func (m *MetricsCollects) grpcRequest(metrics []*Metric) (err error) {
 	}
 
 	var callOpt []grpc.CallOption
+	ctx, callOpt = m.grpcMetadata(ctx, c)
 
-	if m.c.GRPCToken != "" {
-		meta := metadata.New(map[string]string{"token": m.c.GRPCToken})
-		ctx = metadata.NewOutgoingContext(ctx, meta)
-		callOpt = append(callOpt, grpc.Header(&meta))
+	var er error
+	req := &pb.SetMetricsRequest{Metric: reqM}
+	if c.Key != "" {
+		var data []byte
+		if data, er = (proto.MarshalOptions{Deterministic: true}).Marshal(req); er != nil {
+			err = errors.Join(err, myErr.ErrWrap(er))
+			return
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, signMetadata(c,
+			sign.New(sign.MethodGRPC, constant.GRPCSetMetricsMethod, data))...)
+	}
+	if c.GetPublicKey() != nil {
+		if req, er = sealGRPCRequest(c, req); er != nil {
+			err = errors.Join(err, myErr.ErrWrap(er))
+			return
+		}
 	}
 
-	c := pb.NewMetricsClient(conn)
-	result, er := c.SetMetrics(ctx, &pb.SetMetricsRequest{
-		Metric: reqM,
-	}, callOpt...)
+	client := pb.NewMetricsClient(conn)
+	var (
+		result  *pb.SetMetricsResponse
+		header  metadata.MD
+		trailer metadata.MD
+	)
+	result, er = client.SetMetrics(ctx, req, append(callOpt, grpc.Header(&header), grpc.Trailer(&trailer))...)
 	if er != nil {
+		if status.Code(er) == codes.ResourceExhausted {
+			var after string
+			if values := trailer.Get(constant.HeaderRetryAfter); len(values) > 0 {
+				after = values[0]
+			}
+			er = errors.Join(er, &myErr.RetryAfterError{After: retryAfter(after)})
+		}
 		err = errors.Join(err, myErr.ErrWrap(er))
 		return
 	}
+	if c.Key != "" {
+		var data []byte
+		if data, er = (proto.MarshalOptions{Deterministic: true}).Marshal(result); er != nil {
+			err = errors.Join(err, myErr.ErrWrap(er))
+			return
+		}
+		var responseSign string
+		if values := header.Get(constant.HeaderSignKey); len(values) > 0 {
+			responseSign = values[0]
+		}
+		if !checkSign(c.Key, data, responseSign) {
+			err = errors.Join(err, myErr.ErrWrap(myErr.ErrBadSign))
+			return
+		}
+	}
 
 	log.Printf("grpc set metrics success len: %v", len(result.Metric))
 	return
 }
 
+// retryAfter wait time from seconds of retry-after hint, zero if not set
+func retryAfter(v string) time.Duration {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// signHeaders sign headers of request, key id selects agent key at server
+func signHeaders(c *config.Config, p sign.Params) map[string]string {
+	h := p.Headers(c.Key, constant.HeaderSignKey)
+	if c.KeyID != "" {
+		h[constant.HeaderKeyID] = c.KeyID
+	}
+	return h
+}
+
+// signMetadata sign key values pairs for grpc metadata
+func signMetadata(c *config.Config, p sign.Params) (kv []string) {
+	for k, v := range signHeaders(c, p) {
+		kv = append(kv, strings.ToLower(k), v)
+	}
+	return
+}
+
+// sealGRPCRequest encrypt metrics of request by server public key
+func sealGRPCRequest(c *config.Config, req *pb.SetMetricsRequest) (*pb.SetMetricsRequest, error) {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	scheme, encrypted, err := envelope.Seal(c.GetPublicKey(), data)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SetMetricsRequest{Encrypted: encrypted, Encryption: scheme}, nil
+}
+
+// dialGRPC connect to grpc server of config
+func dialGRPC(ctx context.Context, c *config.Config) (*grpc.ClientConn, error) {
+	logger := log.New(os.Stderr, "", log.Ldate|log.Ltime|log.Lshortfile)
+	opts := []logging.Option{
+		logging.WithLogOnEvents(logging.FinishCall),
+	}
+	creds := insecure.NewCredentials()
+	if c.GetTLSConfig() != nil {
+		creds = credentials.NewTLS(c.GetTLSConfig())
+	}
+	return grpc.DialContext(ctx, c.GRPCAddress,
+		grpc.WithChainUnaryInterceptor(
+			logging.UnaryClientInterceptor(interceptorLogger(logger), opts...),
+		),
+		grpc.WithTransportCredentials(creds))
+}
+
+// grpcMetadata add agent identity and token to outgoing context
+func (m *MetricsCollects) grpcMetadata(ctx context.Context, c *config.Config) (context.Context, []grpc.CallOption) {
+	var callOpt []grpc.CallOption
+	metaData := m.identity.Headers(c)
+	if metaData == nil {
+		metaData = map[string]string{}
+	}
+	if ip := GetLocalIP(); ip != "" {
+		metaData[constant.HeaderXRealIP] = ip
+	}
+	if c.GRPCToken != "" {
+		metaData["token"] = c.GRPCToken
+	}
+	if c.APIToken != "" {
+		metaData[constant.HeaderAuthorization] = constant.BearerPrefix + c.APIToken
+	}
+	if c.Tenant != "" {
+		metaData[constant.HeaderTenant] = c.Tenant
+	}
+	if len(metaData) > 0 {
+		meta := metadata.New(metaData)
+		ctx = metadata.NewOutgoingContext(ctx, meta)
+		callOpt = append(callOpt, grpc.Header(&meta))
+	}
+	return ctx, callOpt
+}
+
 func interceptorLogger(l *log.Logger) logging.Logger {
 	return logging.LoggerFunc(func(_ context.Context, lvl logging.Level, msg string, fields ...any) {
 		switch lvl {
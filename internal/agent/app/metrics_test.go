@@ -4,10 +4,15 @@ import (
 	"context"
 	"errors"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"go-musthave-metrics/internal/agent/config"
+	"go-musthave-metrics/internal/agent/constant"
+	myErr "go-musthave-metrics/internal/agent/error"
 	testhelpers "go-musthave-metrics/tests"
 
 	"github.com/stretchr/testify/assert"
@@ -137,3 +142,24 @@ func TestMetricsCollects_SendMetricsGrpc(t *testing.T) {
 		assert.NotEmpty(t, n)
 	})
 }
+
+func TestMetricsCollects_SendMetricsRateLimited(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set(constant.HeaderRetryAfter, "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	c := config.NewConfig()
+	c.Address = ts.URL
+	c.SendSize = 0
+	m := NewMetricsCollects(c)
+	m.GetMetrics()
+
+	t.Run("Send Metrics rate limited", func(t *testing.T) {
+		_, err := m.SendMetrics(context.TODO())
+		var retryErr *myErr.RetryAfterError
+		require.True(t, errors.As(err, &retryErr), err)
+		assert.Equal(t, 2*time.Second, retryErr.After)
+	})
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go-musthave-metrics/internal/agent/config"
+	"go-musthave-metrics/internal/agent/constant"
+	myErr "go-musthave-metrics/internal/agent/error"
+	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/sign"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const remoteConfigTimeout = 5 * time.Second
+
+// FetchRemoteConfig get agent config from server, the server is taken from local config.
+// Config is checked by sign if key is set
+func (m *MetricsCollects) FetchRemoteConfig(ctx context.Context, local *config.Config) (rc *config.RemoteConfig, err error) {
+	if m.identity == nil {
+		return nil, myErr.ErrNoRemoteConfig
+	}
+	ctx, cancel := context.WithTimeout(ctx, remoteConfigTimeout)
+	defer cancel()
+
+	var data []byte
+	if local.GRPCAddress == "" {
+		data, err = m.httpRemoteConfig(ctx, local)
+	} else {
+		data, err = m.grpcRemoteConfig(ctx, local)
+	}
+	if err != nil {
+		return
+	}
+	rc = new(config.RemoteConfig)
+	if err = json.Unmarshal(data, rc); err != nil {
+		rc = nil
+		err = myErr.ErrWrap(err)
+	}
+	return
+}
+
+func (m *MetricsCollects) httpRemoteConfig(ctx context.Context, c *config.Config) (data []byte, err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, c.Address+constant.AgentConfigURL, nil); err != nil {
+		return nil, myErr.ErrWrap(err)
+	}
+	req.Header.Set(constant.HeaderXRealIP, GetLocalIP())
+	for k, v := range m.identity.Headers(c) {
+		req.Header.Set(k, v)
+	}
+	if c.APIToken != "" {
+		req.Header.Set(constant.HeaderAuthorization, constant.BearerPrefix+c.APIToken)
+	}
+	if c.Key != "" {
+		for k, v := range signHeaders(c, sign.New(req.Method, req.URL.Path, nil)) {
+			req.Header.Set(k, v)
+		}
+	}
+
+	var res *http.Response
+	if res, err = c.HTTPClient().Do(req); err != nil {
+		return nil, myErr.ErrWrap(err)
+	}
+	defer func() {
+		err = errors.Join(err, res.Body.Close())
+	}()
+	if data, err = io.ReadAll(res.Body); err != nil {
+		return nil, myErr.ErrWrap(err)
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, myErr.ErrNoRemoteConfig
+	default:
+		return nil, fmt.Errorf("get config from %s: statusCode: %d; answer body: %s", req.URL, res.StatusCode, data)
+	}
+	if !checkSign(c.Key, data, res.Header.Get(constant.HeaderSignKey)) {
+		return nil, myErr.ErrBadSign
+	}
+	return
+}
+
+func (m *MetricsCollects) grpcRemoteConfig(ctx context.Context, c *config.Config) (data []byte, err error) {
+	var conn *grpc.ClientConn
+	if conn, err = dialGRPC(ctx, c); err != nil {
+		return nil, myErr.ErrWrap(err)
+	}
+	defer func() {
+		err = errors.Join(err, conn.Close())
+	}()
+
+	var callOpt []grpc.CallOption
+	ctx, callOpt = m.grpcMetadata(ctx, c)
+
+	req := &pb.GetAgentConfigRequest{
+		Id:    m.identity.ID,
+		Group: c.AgentGroup,
+	}
+	if c.Key != "" {
+		var b []byte
+		if b, err = (proto.MarshalOptions{Deterministic: true}).Marshal(req); err != nil {
+			return nil, myErr.ErrWrap(err)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, signMetadata(c,
+			sign.New(sign.MethodGRPC, constant.GRPCGetAgentConfigMethod, b))...)
+	}
+
+	var res *pb.GetAgentConfigResponse
+	res, err = pb.NewMetricsClient(conn).GetAgentConfig(ctx, req, callOpt...)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, myErr.ErrNoRemoteConfig
+		}
+		return nil, myErr.ErrWrap(err)
+	}
+	if !checkSign(c.Key, res.GetConfig(), res.GetSign()) {
+		return nil, myErr.ErrBadSign
+	}
+	return res.GetConfig(), nil
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-musthave-metrics/internal/agent/config"
+	"go-musthave-metrics/internal/agent/constant"
+	myErr "go-musthave-metrics/internal/agent/error"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsCollects_FetchRemoteConfig(t *testing.T) {
+	const key = "some-config-key"
+	body := []byte(`{"report_interval":1,"poll_interval":0,"gauges_list":["Alloc"]}`)
+	var (
+		code = http.StatusOK
+		sign = signData(key, body)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, constant.AgentConfigURL, r.URL.Path)
+		assert.Equal(t, "agent-1", r.Header.Get(constant.HeaderAgentID))
+		assert.Equal(t, "fast", r.Header.Get(constant.HeaderAgentGroup))
+		w.Header().Set(constant.HeaderSignKey, sign)
+		w.WriteHeader(code)
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	c := config.NewConfig()
+	c.Address = srv.URL
+	c.AgentGroup = "fast"
+	c.Key = key
+	m := NewMetricsCollects(c)
+	m.identity = &Identity{ID: "agent-1"}
+
+	t.Run("signed config", func(t *testing.T) {
+		rc, err := m.FetchRemoteConfig(context.TODO(), c)
+		require.NoError(t, err)
+		n := c.Apply(rc)
+		assert.Equal(t, 1, n.ReportInterval)
+		assert.Equal(t, c.PollInterval, n.PollInterval, "bad value is ignored")
+		assert.Equal(t, []string{"Alloc"}, n.GaugesList)
+		assert.Equal(t, c.CountersList, n.CountersList)
+		assert.Equal(t, 10, c.ReportInterval, "local config is not changed")
+	})
+	t.Run("bad sign", func(t *testing.T) {
+		sign = signData("other-key", body)
+		_, err := m.FetchRemoteConfig(context.TODO(), c)
+		assert.True(t, errors.Is(err, myErr.ErrBadSign))
+	})
+	t.Run("no config", func(t *testing.T) {
+		code = http.StatusNotFound
+		_, err := m.FetchRemoteConfig(context.TODO(), c)
+		assert.True(t, errors.Is(err, myErr.ErrNoRemoteConfig))
+	})
+}
+
+func TestApp_applyRemoteConfig(t *testing.T) {
+	code := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(`{"report_interval":1}`))
+	}))
+	defer srv.Close()
+
+	c := config.NewConfig()
+	c.Address = srv.URL
+	a := newApp(c, BuildMetadata{})
+
+	a.applyRemoteConfig(context.TODO())
+	assert.Equal(t, 1, a.m.Config().ReportInterval, "config from server")
+
+	code = http.StatusInternalServerError
+	a.applyRemoteConfig(context.TODO())
+	assert.Equal(t, 1, a.m.Config().ReportInterval, "last config from server is kept")
+
+	code = http.StatusNotFound
+	a.applyRemoteConfig(context.TODO())
+	assert.Equal(t, c.ReportInterval, a.m.Config().ReportInterval, "local config")
+}
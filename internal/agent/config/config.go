@@ -1,17 +1,22 @@
 package config
 
 import (
+	"crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"flag"
+	"io"
+	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"go-musthave-metrics/internal/envelope"
 	"go-musthave-metrics/pkg/structflag"
 
 	"github.com/caarlos0/env/v11"
@@ -20,22 +25,40 @@ import (
 var Backoff = [3]time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
 
 type PublicKey interface {
-	ecdsa.PublicKey | rsa.PublicKey
+	ecdsa.PublicKey | ecdh.PublicKey | rsa.PublicKey
 }
 
 type Config struct {
-	Address   string `json:"address" env:"ADDRESS" flag:"a" usage:"Provide the address of the metrics collection server"`
-	Key       string `json:"key" env:"KEY" flag:"k" usage:"Provide the key"`
-	CryptoKey string `json:"crypto_key" env:"CRYPTO_KEY" flag:"crypto-key" usage:"Provide the public server key for encryption"`
-	Config    string `json:"-" env:"CONFIG" flag:"config" usage:"Provide file with config"`
-	Config2   string `json:"-" env:"-" flag:"c" usage:"same as -config"`
+	Address    string `json:"address" env:"ADDRESS" flag:"a" usage:"Provide the address of the metrics collection server"`
+	Key        string `json:"key" env:"KEY" flag:"k" usage:"Provide the key"`
+	KeyID      string `json:"key_id" env:"KEY_ID" flag:"key-id" usage:"Provide the key id of agent key issued by server"`
+	APIToken   string `json:"api_token" env:"API_TOKEN" flag:"api-token" usage:"Provide the access token of server api"`
+	CryptoKey  string `json:"crypto_key" env:"CRYPTO_KEY" flag:"crypto-key" usage:"Provide the public server key for encryption"`
+	Config     string `json:"-" env:"CONFIG" flag:"config" usage:"Provide file with config"`
+	Config2    string `json:"-" env:"-" flag:"c" usage:"same as -config"`
+	AgentID    string `json:"agent_id" env:"AGENT_ID" flag:"agent-id" usage:"Provide the agent identifier. Hostname is used by default"`
+	AgentGroup string `json:"agent_group" env:"AGENT_GROUP" flag:"agent-group" usage:"Provide the agent group for config served by server"`
+	Tenant     string `json:"tenant" env:"TENANT" flag:"tenant" usage:"Provide the tenant of metrics at server. Tenant of api token is used if empty"`
 	GRPC
-	cryptoKey *rsa.PublicKey
+	TLS
+	cryptoKey crypto.PublicKey
 	MetricLists
-	ReportInterval int `json:"report_interval" env:"REPORT_INTERVAL" flag:"r" usage:"Provide the interval in seconds for send report metrics"`
-	PollInterval   int `json:"poll_interval" env:"POLL_INTERVAL" flag:"p" usage:"Provide the interval in seconds for update metrics"`
-	RateLimit      int `json:"rate_limit" env:"RATE_LIMIT" flag:"l" usage:"Provide the rate limit - number of concurrent outgoing requests"`
-	SendSize       int `json:"send_size" env:"SEND_SIZE" flag:"s" usage:"Provide the number of metrics send at once. 0 - send all"`
+	ReportInterval       int `json:"report_interval" env:"REPORT_INTERVAL" flag:"r" usage:"Provide the interval in seconds for send report metrics"`
+	PollInterval         int `json:"poll_interval" env:"POLL_INTERVAL" flag:"p" usage:"Provide the interval in seconds for update metrics"`
+	RateLimit            int `json:"rate_limit" env:"RATE_LIMIT" flag:"l" usage:"Provide the rate limit - number of concurrent outgoing requests"`
+	SendSize             int `json:"send_size" env:"SEND_SIZE" flag:"s" usage:"Provide the number of metrics send at once. 0 - send all"`
+	RemoteConfigInterval int `json:"remote_config_interval" env:"REMOTE_CONFIG_INTERVAL" flag:"remote-config" usage:"Provide the interval in seconds for fetch config from server. 0 - only on start, -1 - disabled"`
+}
+
+// RemoteConfig agent config served by server, only set fields override local config
+type RemoteConfig struct {
+	GaugesList     []string `json:"gauges_list,omitempty"`
+	CountersList   []string `json:"counters_list,omitempty"`
+	ReportInterval *int     `json:"report_interval,omitempty"`
+	PollInterval   *int     `json:"poll_interval,omitempty"`
+	RateLimit      *int     `json:"rate_limit,omitempty"`
+	SendSize       *int     `json:"send_size,omitempty"`
+	Group          string   `json:"group,omitempty"`
 }
 
 type GRPC struct {
@@ -43,6 +66,14 @@ type GRPC struct {
 	GRPCToken   string `env:"GRPC_TOKEN" json:"grpc_token"  flag:"token" usage:"Provide the grpc service token"`
 }
 
+type TLS struct {
+	tlsConfig  *tls.Config
+	httpClient *http.Client
+	TLSCA      string `env:"TLS_CA" json:"tls_ca" flag:"tls-ca" usage:"Provide the CA file for verify server certificate. System CA is used by default"`
+	TLSCert    string `env:"TLS_CERT" json:"tls_cert" flag:"tls-cert" usage:"Provide the agent certificate file for mutual tls"`
+	TLSKey     string `env:"TLS_KEY" json:"tls_key" flag:"tls-key" usage:"Provide the agent certificate key file"`
+}
+
 type MetricLists struct {
 	GaugesList   []string `type:"gauge" json:"gauges_list"`
 	CountersList []string `type:"counter" json:"counters_list"`
@@ -50,12 +81,13 @@ type MetricLists struct {
 
 func NewConfig() *Config {
 	c := &Config{
-		Address:        "localhost:8080",
-		ReportInterval: 10,
-		PollInterval:   2,
-		Key:            "",
-		RateLimit:      1,
-		SendSize:       10,
+		Address:              "localhost:8080",
+		ReportInterval:       10,
+		PollInterval:         2,
+		Key:                  "",
+		RateLimit:            1,
+		SendSize:             10,
+		RemoteConfigInterval: 60,
 	}
 	c.SetDefaultMetrics()
 	return c.CleanSchemes()
@@ -148,6 +180,26 @@ func (c *Config) maybeLoadConfig() (ok bool, err error) {
 // Init config from flags and env
 func (c *Config) Init() (err error) {
 	c.parseFlags()
+	return c.load()
+}
+
+// Reload load new config from flags, env and config file, current config is not changed
+func (c *Config) Reload() (n *Config, err error) {
+	n = NewConfig()
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	structflag.LoadTo(fs, "", n)
+	if err = fs.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+	if err = n.load(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// load config from env and config file, config file values is overridden by flags and env
+func (c *Config) load() (err error) {
 	err = env.Parse(c)
 	if ok, er := c.maybeLoadConfig(); ok && er == nil {
 		// reload flag and env after config file
@@ -160,38 +212,126 @@ func (c *Config) Init() (err error) {
 	}
 	c.CleanSchemes()
 	// get key to mem
-	err = errors.Join(err, c.LoadPublicKey())
+	err = errors.Join(err, c.LoadPublicKey(), c.LoadTLS())
+	return
+}
+
+// Apply return copy of config with remote config values set, bad values are ignored
+func (c *Config) Apply(r *RemoteConfig) *Config {
+	n := *c
+	if r == nil {
+		return &n
+	}
+	if r.GaugesList != nil {
+		n.GaugesList = append([]string(nil), r.GaugesList...)
+	}
+	if r.CountersList != nil {
+		n.CountersList = append([]string(nil), r.CountersList...)
+	}
+	if r.ReportInterval != nil && *r.ReportInterval > 0 {
+		n.ReportInterval = *r.ReportInterval
+	}
+	if r.PollInterval != nil && *r.PollInterval > 0 {
+		n.PollInterval = *r.PollInterval
+	}
+	if r.RateLimit != nil && *r.RateLimit > 0 {
+		n.RateLimit = *r.RateLimit
+	}
+	if r.SendSize != nil && *r.SendSize >= 0 {
+		n.SendSize = *r.SendSize
+	}
+	return &n
+}
+
+// ConfigModTime modification time of config file, zero if no config file
+func (c *Config) ConfigModTime() (t time.Time) {
+	if c.Config == "" {
+		return
+	}
+	if stat, err := os.Stat(c.Config); err == nil {
+		t = stat.ModTime()
+	}
 	return
 }
 
-// CleanSchemes check and repair config parameters
+// CleanSchemes check and repair config parameters, https is used if tls is configured
 func (c *Config) CleanSchemes() *Config {
+	if c.TLSEnabled() {
+		c.Address = strings.TrimPrefix(c.Address, "http://")
+	}
 	if !strings.HasPrefix(c.Address, "http://") && !strings.HasPrefix(c.Address, "https://") {
-		c.Address = "http://" + c.Address
+		if c.TLSEnabled() {
+			c.Address = "https://" + c.Address
+		} else {
+			c.Address = "http://" + c.Address
+		}
 	}
 	return c
 }
 
-func (c *Config) GetPublicKey() *rsa.PublicKey {
+// TLSEnabled is tls configured
+func (c *TLS) TLSEnabled() bool {
+	return c.TLSCA != "" || c.TLSCert != ""
+}
+
+// GetTLSConfig tls config for http and grpc clients, nil if tls is not configured
+func (c *TLS) GetTLSConfig() *tls.Config {
+	return c.tlsConfig
+}
+
+// HTTPClient http client with tls config
+func (c *TLS) HTTPClient() *http.Client {
+	if c.httpClient == nil {
+		return http.DefaultClient
+	}
+	return c.httpClient
+}
+
+// LoadTLS load CA and agent certificate
+func (c *TLS) LoadTLS() error {
+	c.tlsConfig, c.httpClient = nil, nil
+	if !c.TLSEnabled() {
+		return nil
+	}
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.TLSCA != "" {
+		b, err := os.ReadFile(c.TLSCA)
+		if err != nil {
+			return err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(b) {
+			return errors.New("no certificates at CA file")
+		}
+	}
+	if c.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tc
+	c.tlsConfig, c.httpClient = tc, &http.Client{Transport: transport}
+	return nil
+}
+
+// GetPublicKey server public key for encryption: *rsa.PublicKey, *ecdsa.PublicKey or *ecdh.PublicKey
+func (c *Config) GetPublicKey() crypto.PublicKey {
 	return c.cryptoKey
 }
 
+// LoadPublicKey load server public key, key type is detected from PEM block
 func (c *Config) LoadPublicKey() error {
 	if c.CryptoKey != "" {
 		b, err := os.ReadFile(c.CryptoKey)
 		if err != nil {
 			return err
 		}
-
-		spkiBlock, _ := pem.Decode(b)
-		cert, err := x509.ParseCertificate(spkiBlock.Bytes)
-		if err == nil && (cert == nil || cert.PublicKey == nil) {
-			err = errors.New("failed to load public key")
-		}
-		if err != nil {
+		if c.cryptoKey, err = envelope.ParsePublicKeyPEM(b); err != nil {
 			return err
 		}
-		c.cryptoKey = cert.PublicKey.(*rsa.PublicKey)
 	}
 	return nil
 }
@@ -1,10 +1,32 @@
 package constant
 
+import "time"
+
 const (
 	BaseURL     = "/updates"
 	GaugeType   = "gauge"
 	CounterType = "counter"
 
 	HeaderSignKey = "HashSHA256"
-	HeaderXRealIP = "X-Real-IP"
+	HeaderKeyID   = "Key-Id"
+
+	HeaderAuthorization = "Authorization"
+	BearerPrefix        = "Bearer "
+	HeaderRetryAfter    = "Retry-After"
+	HeaderTenant        = "X-Tenant"
+	HeaderXRealIP       = "X-Real-IP"
+
+	HeaderAgentID       = "X-Agent-Id"
+	HeaderAgentHostname = "X-Agent-Hostname"
+	HeaderAgentVersion  = "X-Agent-Version"
+	HeaderAgentConfig   = "X-Agent-Config"
+	HeaderAgentGroup    = "X-Agent-Group"
+
+	AgentConfigURL = "/api/v1/agents/config"
+
+	GRPCSetMetricsMethod     = "/service.Metrics/SetMetrics"
+	GRPCGetAgentConfigMethod = "/service.Metrics/GetAgentConfig"
+
+	// ConfigCheckInterval interval of config file modification check
+	ConfigCheckInterval = time.Second
 )
@@ -4,12 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"runtime"
+	"time"
 )
 
 var (
 	ErrBadGaugeValue   = errors.New("bad gauge value")
 	ErrBadCounterValue = errors.New("bad counter value")
 	ErrBadMetricType   = errors.New("unknown metric type")
+	ErrNoRemoteConfig  = errors.New("no config for agent at server")
+	ErrBadSign         = errors.New("bad sign")
 )
 
 // ErrWrap wrap error with debug info: line and file name where it happened
@@ -17,3 +20,12 @@ func ErrWrap(err error) error {
 	_, fn, line, _ := runtime.Caller(1)
 	return fmt.Errorf("%w at %s:%d", err, fn, line)
 }
+
+// RetryAfterError server rate limit is exceeded, request can be repeated after wait time
+type RetryAfterError struct {
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %v", e.After)
+}
@@ -0,0 +1,206 @@
+// Package envelope hybrid encryption of agent to server payloads.
+// Data is encrypted by fresh AES-256-GCM key. For rsa keys the AES key is encrypted by server public key,
+// for elliptic curve keys (ECIES) it is derived by HKDF-SHA256 from ECDH of ephemeral and server keys.
+//
+// Envelope format: 2 bytes big endian length of key part, key part (encrypted key or ephemeral public key),
+// GCM nonce, cipher text.
+package envelope
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// Header http header (grpc field) with scheme of encrypted payload
+	Header = "X-Encryption"
+
+	// SchemeRSA AES-256-GCM key encrypted by RSA-OAEP with SHA-256
+	SchemeRSA = "v1;rsa-oaep-sha256;aes-256-gcm"
+	// SchemeP256 AES-256-GCM key derived by HKDF-SHA256 from ECDH P-256
+	SchemeP256 = "v1;ecdh-p256-hkdf-sha256;aes-256-gcm"
+	// SchemeX25519 AES-256-GCM key derived by HKDF-SHA256 from ECDH X25519
+	SchemeX25519 = "v1;ecdh-x25519-hkdf-sha256;aes-256-gcm"
+
+	keySize = 32
+)
+
+var (
+	ErrUnknownScheme  = errors.New("unknown encryption scheme")
+	ErrUnsupportedKey = errors.New("unsupported key type")
+	ErrBadEnvelope    = errors.New("bad encrypted envelope")
+)
+
+// Seal encrypt data for owner of public key, return scheme of envelope and envelope
+func Seal(pub crypto.PublicKey, data []byte) (scheme string, out []byte, err error) {
+	var key, encKey []byte
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		scheme = SchemeRSA
+		key = make([]byte, keySize)
+		if _, err = io.ReadFull(rand.Reader, key); err != nil {
+			return
+		}
+		if encKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, []byte(scheme)); err != nil {
+			return
+		}
+	default:
+		var ecPub *ecdh.PublicKey
+		if ecPub, err = ecdhPublicKey(pub); err != nil {
+			return
+		}
+		if scheme, err = ecdhScheme(ecPub.Curve()); err != nil {
+			return
+		}
+		var ephemeral *ecdh.PrivateKey
+		if ephemeral, err = ecPub.Curve().GenerateKey(rand.Reader); err != nil {
+			return
+		}
+		encKey = ephemeral.PublicKey().Bytes()
+		if key, err = deriveKey(ephemeral, ecPub, encKey, scheme); err != nil {
+			return
+		}
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return
+	}
+	out = make([]byte, 2, 2+len(encKey)+gcm.NonceSize()+len(data)+gcm.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(encKey)))
+	out = append(out, encKey...)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, data, []byte(scheme))
+	return
+}
+
+// Open decrypt envelope of scheme by private key
+func Open(priv crypto.PrivateKey, scheme string, data []byte) (out []byte, err error) {
+	if len(data) < 2 {
+		return nil, ErrBadEnvelope
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return nil, ErrBadEnvelope
+	}
+	encKey, data := data[2:2+n], data[2+n:]
+
+	var key []byte
+	switch scheme {
+	case SchemeRSA:
+		rsaKey, ok := priv.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T for %s", ErrUnsupportedKey, priv, scheme)
+		}
+		if key, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, rsaKey, encKey, []byte(scheme)); err != nil {
+			return nil, errors.Join(ErrBadEnvelope, err)
+		}
+	case SchemeP256, SchemeX25519:
+		ecPriv, er := ecdhPrivateKey(priv)
+		if er != nil {
+			return nil, er
+		}
+		if curveScheme, _ := ecdhScheme(ecPriv.Curve()); curveScheme != scheme {
+			return nil, fmt.Errorf("%w: %T for %s", ErrUnsupportedKey, priv, scheme)
+		}
+		ephemeral, er := ecPriv.Curve().NewPublicKey(encKey)
+		if er != nil {
+			return nil, errors.Join(ErrBadEnvelope, er)
+		}
+		if key, err = deriveKey(ecPriv, ephemeral, encKey, scheme); err != nil {
+			return nil, errors.Join(ErrBadEnvelope, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownScheme, scheme)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, errors.Join(ErrBadEnvelope, err)
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrBadEnvelope
+	}
+	if out, err = gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(scheme)); err != nil {
+		return nil, errors.Join(ErrBadEnvelope, err)
+	}
+	return
+}
+
+// deriveKey AES key from ECDH shared secret, salted by ephemeral public key
+func deriveKey(priv *ecdh.PrivateKey, pub *ecdh.PublicKey, ephemeral []byte, scheme string) ([]byte, error) {
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, keySize)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, secret, ephemeral, []byte(scheme)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func ecdhScheme(curve ecdh.Curve) (string, error) {
+	switch curve {
+	case ecdh.P256():
+		return SchemeP256, nil
+	case ecdh.X25519():
+		return SchemeX25519, nil
+	}
+	return "", fmt.Errorf("%w: curve %s", ErrUnsupportedKey, curve)
+}
+
+func ecdhPublicKey(pub crypto.PublicKey) (*ecdh.PublicKey, error) {
+	switch pub := pub.(type) {
+	case *ecdh.PublicKey:
+		return pub, nil
+	case *ecdsa.PublicKey:
+		k, err := pub.ECDH()
+		if err != nil {
+			return nil, errors.Join(ErrUnsupportedKey, err)
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+}
+
+func ecdhPrivateKey(priv crypto.PrivateKey) (*ecdh.PrivateKey, error) {
+	switch priv := priv.(type) {
+	case *ecdh.PrivateKey:
+		return priv, nil
+	case *ecdsa.PrivateKey:
+		k, err := priv.ECDH()
+		if err != nil {
+			return nil, errors.Join(ErrUnsupportedKey, err)
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, priv)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, ErrBadEnvelope
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
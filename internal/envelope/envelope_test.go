@@ -0,0 +1,143 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	big := bytes.Repeat([]byte("some metrics data "), 10000)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		modify  func(scheme string, out []byte) (string, []byte)
+		priv    any
+		wantErr error
+	}{
+		{name: "empty", data: nil, priv: key},
+		{name: "small", data: []byte("data"), priv: key},
+		{name: "larger than key", data: big, priv: key},
+		{
+			name: "tampered cipher text",
+			data: big,
+			priv: key,
+			modify: func(scheme string, out []byte) (string, []byte) {
+				out[len(out)-1] ^= 0xff
+				return scheme, out
+			},
+			wantErr: ErrBadEnvelope,
+		},
+		{
+			name: "truncated",
+			data: big,
+			priv: key,
+			modify: func(scheme string, out []byte) (string, []byte) {
+				return scheme, out[:100]
+			},
+			wantErr: ErrBadEnvelope,
+		},
+		{
+			name: "unknown scheme",
+			data: big,
+			priv: key,
+			modify: func(_ string, out []byte) (string, []byte) {
+				return "v0;none", out
+			},
+			wantErr: ErrUnknownScheme,
+		},
+		{name: "other key", data: big, priv: other, wantErr: ErrBadEnvelope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme, out, err := Seal(&key.PublicKey, tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, SchemeRSA, scheme)
+			if tt.modify != nil {
+				scheme, out = tt.modify(scheme, out)
+			}
+			got, err := Open(tt.priv, scheme, out)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.data, got)
+		})
+	}
+}
+
+func TestSealOpenEC(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p256ECDH, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	x25519, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherX25519, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	big := bytes.Repeat([]byte("some metrics data "), 10000)
+
+	tests := []struct {
+		pub        crypto.PublicKey
+		priv       crypto.PrivateKey
+		wantErr    error
+		name       string
+		wantScheme string
+	}{
+		{name: "ecdsa p256", pub: &p256.PublicKey, priv: p256, wantScheme: SchemeP256},
+		{name: "ecdsa public, ecdh private", pub: &p256.PublicKey, priv: mustECDH(t, p256), wantScheme: SchemeP256},
+		{name: "ecdh p256", pub: p256ECDH.PublicKey(), priv: p256ECDH, wantScheme: SchemeP256},
+		{name: "x25519", pub: x25519.PublicKey(), priv: x25519, wantScheme: SchemeX25519},
+		{name: "other key", pub: x25519.PublicKey(), priv: otherX25519, wantScheme: SchemeX25519, wantErr: ErrBadEnvelope},
+		{name: "curve mismatch", pub: x25519.PublicKey(), priv: p256, wantScheme: SchemeX25519, wantErr: ErrUnsupportedKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme, out, err := Seal(tt.pub, big)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantScheme, scheme)
+			got, err := Open(tt.priv, scheme, out)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, big, got)
+		})
+	}
+}
+
+func TestSealUnsupportedKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, _, err = Seal(&key.PublicKey, []byte("data"))
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+
+	_, pub, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, _, err = Seal(pub, []byte("data"))
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+}
+
+func mustECDH(t *testing.T, k *ecdsa.PrivateKey) *ecdh.PrivateKey {
+	e, err := k.ECDH()
+	require.NoError(t, err)
+	return e
+}
@@ -0,0 +1,122 @@
+package envelope
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+var ErrNoPEM = errors.New("no PEM data found")
+
+// ParsePublicKeyPEM parse rsa or elliptic curve public key of certificate, PKIX or PKCS1 PEM block.
+// Key type is detected from the block type, other formats are tried if it does not match the content
+func ParsePublicKeyPEM(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrNoPEM
+	}
+	parsers := map[string]func([]byte) (crypto.PublicKey, error){
+		"CERTIFICATE": func(der []byte) (crypto.PublicKey, error) {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, err
+			}
+			return cert.PublicKey, nil
+		},
+		"PUBLIC KEY": func(der []byte) (crypto.PublicKey, error) {
+			return x509.ParsePKIXPublicKey(der)
+		},
+		"RSA PUBLIC KEY": func(der []byte) (crypto.PublicKey, error) {
+			return x509.ParsePKCS1PublicKey(der)
+		},
+	}
+	order := []string{block.Type, "CERTIFICATE", "PUBLIC KEY", "RSA PUBLIC KEY"}
+	var errs error
+	for _, t := range order {
+		parse, ok := parsers[t]
+		if !ok {
+			continue
+		}
+		key, err := parse(block.Bytes)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		return supportedPublicKey(key)
+	}
+	return nil, fmt.Errorf("failed to load public key from %q block: %w", block.Type, errs)
+}
+
+// ParsePrivateKeyPEM parse rsa or elliptic curve private key of PKCS1, SEC1 or PKCS8 PEM block.
+// Key type is detected from the block type, other formats are tried if it does not match the content
+func ParsePrivateKeyPEM(b []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrNoPEM
+	}
+	parsers := map[string]func([]byte) (crypto.PrivateKey, error){
+		"RSA PRIVATE KEY": func(der []byte) (crypto.PrivateKey, error) {
+			return x509.ParsePKCS1PrivateKey(der)
+		},
+		"EC PRIVATE KEY": func(der []byte) (crypto.PrivateKey, error) {
+			return x509.ParseECPrivateKey(der)
+		},
+		"PRIVATE KEY": func(der []byte) (crypto.PrivateKey, error) {
+			return x509.ParsePKCS8PrivateKey(der)
+		},
+	}
+	order := []string{block.Type, "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY"}
+	var errs error
+	for _, t := range order {
+		parse, ok := parsers[t]
+		if !ok {
+			continue
+		}
+		key, err := parse(block.Bytes)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		return supportedPrivateKey(key)
+	}
+	return nil, fmt.Errorf("failed to load private key from %q block: %w", block.Type, errs)
+}
+
+func supportedPublicKey(key crypto.PublicKey) (crypto.PublicKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey, *ecdh.PublicKey:
+		ec, err := ecdhPublicKey(k)
+		if err == nil {
+			_, err = ecdhScheme(ec.Curve())
+		}
+		if err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+}
+
+func supportedPrivateKey(key crypto.PrivateKey) (crypto.PrivateKey, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey, *ecdh.PrivateKey:
+		ec, err := ecdhPrivateKey(k)
+		if err == nil {
+			_, err = ecdhScheme(ec.Curve())
+		}
+		if err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+}
@@ -0,0 +1,131 @@
+package envelope
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pemBlock(t string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: t, Bytes: der})
+}
+
+func selfSigned(t *testing.T, pub crypto.PublicKey, priv crypto.Signer) []byte {
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, pub, priv)
+	require.NoError(t, err)
+	return der
+}
+
+func TestParseKeysPEM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	xKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	pkcs8 := func(k any) []byte {
+		der, er := x509.MarshalPKCS8PrivateKey(k)
+		require.NoError(t, er)
+		return der
+	}
+	pkixDER := func(k any) []byte {
+		der, er := x509.MarshalPKIXPublicKey(k)
+		require.NoError(t, er)
+		return der
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		private []byte
+		public  []byte
+	}{
+		{
+			name:    "rsa pkcs1, certificate",
+			private: pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+			public:  pemBlock("CERTIFICATE", selfSigned(t, &rsaKey.PublicKey, rsaKey)),
+		},
+		{
+			name:    "rsa pkcs8, certificate at RSA PUBLIC KEY block",
+			private: pemBlock("PRIVATE KEY", pkcs8(rsaKey)),
+			public:  pemBlock("RSA PUBLIC KEY", selfSigned(t, &rsaKey.PublicKey, rsaKey)),
+		},
+		{
+			name:    "rsa pkcs1 public",
+			private: pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+			public:  pemBlock("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)),
+		},
+		{
+			name:    "p256 sec1, certificate",
+			private: pemBlock("EC PRIVATE KEY", sec1),
+			public:  pemBlock("CERTIFICATE", selfSigned(t, &ecKey.PublicKey, ecKey)),
+		},
+		{
+			name:    "p256 pkcs8, pkix",
+			private: pemBlock("PRIVATE KEY", pkcs8(ecKey)),
+			public:  pemBlock("PUBLIC KEY", pkixDER(&ecKey.PublicKey)),
+		},
+		{
+			name:    "x25519 pkcs8, pkix",
+			private: pemBlock("PRIVATE KEY", pkcs8(xKey)),
+			public:  pemBlock("PUBLIC KEY", pkixDER(xKey.PublicKey())),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priv, err := ParsePrivateKeyPEM(tt.private)
+			require.NoError(t, err)
+			pub, err := ParsePublicKeyPEM(tt.public)
+			require.NoError(t, err)
+
+			scheme, out, err := Seal(pub, []byte("data"))
+			require.NoError(t, err)
+			got, err := Open(priv, scheme, out)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("data"), got)
+		})
+	}
+}
+
+func TestParseKeysPEMErrors(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edPKCS8, err := x509.MarshalPKCS8PrivateKey(edPriv)
+	require.NoError(t, err)
+	edPKIX, err := x509.MarshalPKIXPublicKey(edPub)
+	require.NoError(t, err)
+
+	_, err = ParsePrivateKeyPEM([]byte("not a pem"))
+	assert.ErrorIs(t, err, ErrNoPEM)
+	_, err = ParsePublicKeyPEM([]byte("not a pem"))
+	assert.ErrorIs(t, err, ErrNoPEM)
+
+	_, err = ParsePrivateKeyPEM(pemBlock("PRIVATE KEY", edPKCS8))
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+	_, err = ParsePublicKeyPEM(pemBlock("PUBLIC KEY", edPKIX))
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+
+	_, err = ParsePrivateKeyPEM(pemBlock("PRIVATE KEY", []byte("garbage")))
+	assert.Error(t, err)
+}
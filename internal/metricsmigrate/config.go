@@ -0,0 +1,90 @@
+package metricsmigrate
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/pkg/structflag"
+
+	"github.com/caarlos0/env/v11"
+)
+
+const (
+	// StrategySum counter of target is increased by source counter
+	StrategySum = "sum"
+	// StrategyOverwrite counter of target is set to source counter
+	StrategyOverwrite = "overwrite"
+	// StrategyKeepMax counter of target is set to greater of both counters
+	StrategyKeepMax = "keep-max"
+
+	// SchemeSnapshot storage file of memory store, snapshot:///path
+	SchemeSnapshot = "snapshot"
+
+	// Batch number of series written by one batch
+	Batch = 1000
+)
+
+// Config of migration
+type Config struct {
+	From           string `env:"MIGRATE_FROM" flag:"from" usage:"Provide the url of source storage: postgres://..., file:///path of embedded disk store directory, snapshot:///path of storage file"`
+	To             string `env:"MIGRATE_TO" flag:"to" usage:"Provide the url of target storage, format of source url"`
+	Strategy       string `env:"MIGRATE_STRATEGY" flag:"strategy" usage:"Provide the merge strategy of counters existing at target: sum, overwrite or keep-max. Gauges are overwritten"`
+	DryRun         bool   `env:"MIGRATE_DRY_RUN" flag:"dry-run" usage:"Compare storages and report changes without write"`
+	Verify         bool   `env:"MIGRATE_VERIFY" flag:"verify" usage:"Compare both storages after copy"`
+	Batch          int    `env:"MIGRATE_BATCH" flag:"batch" usage:"Provide the number of series written by one batch"`
+	StorageKey     string `env:"STORAGE_KEY" flag:"storage-key" usage:"Provide keys of storage file encryption, format of server storage key"`
+	StorageKeyFile string `env:"STORAGE_KEY_FILE" flag:"storage-key-file" usage:"Provide file with keys of storage file encryption"`
+}
+
+func NewConfig() *Config {
+	return &Config{
+		Strategy: StrategyOverwrite,
+		Verify:   true,
+		Batch:    Batch,
+	}
+}
+
+// Init config from flags and env
+func (c *Config) Init() (*Config, error) {
+	structflag.Load(c)
+	flag.Parse()
+	if err := env.Parse(c); err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+// Validate check storage urls and strategy
+func (c *Config) Validate() (err error) {
+	for _, side := range [][2]string{{"source", c.From}, {"target", c.To}} {
+		name, v := side[0], side[1]
+		if v == "" {
+			err = errors.Join(err, fmt.Errorf("no %s storage url provided", name))
+		} else if u, er := url.Parse(v); er != nil {
+			err = errors.Join(err, er)
+		} else if u.Scheme == "" {
+			err = errors.Join(err, fmt.Errorf("no driver scheme at %s storage url %q", name, v))
+		}
+	}
+	if c.From != "" && c.From == c.To {
+		err = errors.Join(err, errors.New("source and target storages are the same"))
+	}
+	switch c.Strategy {
+	case StrategySum, StrategyOverwrite, StrategyKeepMax:
+	default:
+		err = errors.Join(err, fmt.Errorf("unknown merge strategy %q", c.Strategy))
+	}
+	if c.Batch <= 0 {
+		err = errors.Join(err, fmt.Errorf("bad batch size %d", c.Batch))
+	}
+	return
+}
+
+// storageConfig config of storage with storage keys
+func (c *Config) storageConfig() (*config.StorageConfig, error) {
+	sc := &config.StorageConfig{StorageKey: c.StorageKey, StorageKeyFile: c.StorageKeyFile}
+	return sc, sc.LoadStorageKeys()
+}
@@ -0,0 +1,365 @@
+package metricsmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	"go-musthave-metrics/internal/server/repository"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Report result of migration
+type Report struct {
+	DryRun  bool `json:"dry_run"`
+	Tenants int  `json:"tenants"`
+	// Series number of series at source
+	Series int `json:"series"`
+	// New series, which are not at target
+	New int `json:"new"`
+	// Changed series, which have other value at target
+	Changed   int  `json:"changed"`
+	Unchanged int  `json:"unchanged"`
+	Written   int  `json:"written"`
+	Verified  bool `json:"verified"`
+	// Mismatches series, which values at target differ from expected after copy
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+// series metrics of tenant
+type series struct {
+	gauges   domain.Gauges
+	counters domain.Counters
+}
+
+// tenantPlan expected metrics of tenant at target and changes of target to write
+type tenantPlan struct {
+	tenant  string
+	want    series
+	changes []domain.Metric
+}
+
+// store opened storage
+type store struct {
+	repository.DataStorage
+	// save write memory store of snapshot to storage file
+	save  func() error
+	close func() error
+}
+
+// access of storage by migration
+type access int
+
+const (
+	// accessWrite storage is opened by driver, it is created and migrated
+	accessWrite access = iota
+	// accessRead storage must exist, it is not created, migrated or changed
+	accessRead
+	// accessDryRun storage is not created, migrated or changed, missing storage is empty
+	accessDryRun
+)
+
+// Migrator copy series between storages
+type Migrator struct {
+	c   *Config
+	log *zap.Logger
+}
+
+func New(c *Config, log *zap.Logger) *Migrator {
+	return &Migrator{c: c, log: log}
+}
+
+// RunApp migrate series by config of flags and env, report is logged
+func RunApp(ctx context.Context, cfg *Config, log *zap.Logger) (err error) {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+	if cfg == nil {
+		if cfg, err = NewConfig().Init(); err != nil {
+			return
+		}
+	}
+	if log == nil {
+		if log, err = zap.NewDevelopment(); err != nil {
+			return
+		}
+	}
+	var r Report
+	r, err = New(cfg, log).Run(ctx)
+	log.Info("Migration report", zap.Any("report", r))
+	if err == nil && len(r.Mismatches) > 0 {
+		err = fmt.Errorf("verification failed: %d series mismatched", len(r.Mismatches))
+	}
+	return
+}
+
+// Run copy series of all tenants from source to target storage by merge strategy of counters,
+// target is reopened and verified after copy. Series are not written on dry run
+func (m *Migrator) Run(ctx context.Context) (r Report, err error) {
+	r.DryRun = m.c.DryRun
+	var src, dst *store
+	if src, err = m.open(ctx, m.c.From, accessRead); err != nil {
+		return r, fmt.Errorf("open source: %w", err)
+	}
+	defer func() { err = errors.Join(err, src.close()) }()
+	mode := accessWrite
+	if m.c.DryRun {
+		mode = accessDryRun
+	}
+	if dst, err = m.open(ctx, m.c.To, mode); err != nil {
+		return r, fmt.Errorf("open target: %w", err)
+	}
+	var plans []tenantPlan
+	plans, err = m.plan(ctx, src, dst, &r)
+	if err == nil && !m.c.DryRun {
+		if err = m.write(ctx, dst, plans, &r); err == nil && dst.save != nil {
+			err = dst.save()
+		}
+	}
+	if err = errors.Join(err, dst.close()); err != nil || m.c.DryRun || !m.c.Verify {
+		return
+	}
+	if dst, err = m.open(ctx, m.c.To, accessWrite); err != nil {
+		return r, fmt.Errorf("open target: %w", err)
+	}
+	defer func() { err = errors.Join(err, dst.close()) }()
+	if r.Mismatches, err = verify(ctx, dst, plans); err == nil {
+		r.Verified = true
+	}
+	return
+}
+
+// open storage of url by registered driver, storage file of snapshot scheme is restored to memory store.
+// Storage of read access is opened without driver, so it is not created or migrated
+func (m *Migrator) open(ctx context.Context, storageURL string, mode access) (s *store, err error) {
+	var (
+		c *config.StorageConfig
+		u *url.URL
+	)
+	if c, err = m.c.storageConfig(); err != nil {
+		return
+	}
+	if u, err = url.Parse(storageURL); err != nil {
+		return
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if mode != accessWrite && scheme != SchemeSnapshot {
+		return openReader(ctx, scheme, storageURL, u.Path, c, mode)
+	}
+	if scheme != SchemeSnapshot {
+		var st *repository.Storage
+		if st, err = repository.Open(ctx, storageURL, c); err != nil {
+			return
+		}
+		return &store{DataStorage: st, close: st.Close}, nil
+	}
+	if u.Path == "" {
+		return nil, errors.New("no storage file provided")
+	}
+	if mode != accessWrite {
+		if _, err = os.Stat(u.Path); err != nil {
+			return missing(err, mode)
+		}
+	}
+	c.FileStoragePath = u.Path
+	mem := repository.NewMemRepository()
+	f := repository.NewFileStorageRepository(c)
+	if err = f.RestoreFromFile(mem); err != nil {
+		return
+	}
+	return &store{DataStorage: mem, save: func() error { return f.SaveToFile(mem) }, close: func() error { return nil }}, nil
+}
+
+// openReader open storage without creation or schema migration, storage must exist
+func openReader(ctx context.Context, scheme, storageURL, path string, c *config.StorageConfig, mode access) (s *store, err error) {
+	switch scheme {
+	case constant.StorageDriverFile:
+		if path == "" {
+			return nil, errors.New("no disk store directory provided")
+		}
+		if _, err = os.Stat(filepath.Join(path, constant.DiskStorageFile)); err != nil {
+			return missing(err, mode)
+		}
+		disk := repository.NewDiskStorageReader(path)
+		if err = disk.Ping(ctx); err != nil {
+			return nil, errors.Join(err, disk.Close())
+		}
+		return &store{DataStorage: disk, close: disk.Close}, nil
+	case constant.StorageDriverPostgres, "postgresql":
+		var db *sqlx.DB
+		if db, err = sqlx.ConnectContext(ctx, "postgres", storageURL); err != nil {
+			return
+		}
+		// tables are checked by catalog, migration of golang-migrate creates version table
+		var exists bool
+		if err = db.GetContext(ctx, &exists, "SELECT to_regclass($1) IS NOT NULL", constant.DBTableNameCounters); err != nil {
+			return nil, errors.Join(err, db.Close())
+		}
+		if !exists {
+			err = fmt.Errorf("database has no %s table: %w", constant.DBTableNameCounters, os.ErrNotExist)
+			return missing(errors.Join(err, db.Close()), mode)
+		}
+		return &store{DataStorage: repository.NewDBStorageRepository(db), close: db.Close}, nil
+	}
+	// storages of other drivers are not created on open
+	var st *repository.Storage
+	if st, err = repository.Open(ctx, storageURL, c); err != nil {
+		return
+	}
+	return &store{DataStorage: st, close: st.Close}, nil
+}
+
+// missing storage is error of read access, it is empty storage on dry run
+func missing(err error, mode access) (*store, error) {
+	if mode != accessDryRun || !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return &store{DataStorage: repository.NewMemRepository(), close: func() error { return nil }}, nil
+}
+
+// plan compare series of all tenants of storages, return expected series at target and changes to write
+func (m *Migrator) plan(ctx context.Context, src, dst *store, r *Report) (plans []tenantPlan, err error) {
+	var tenants []string
+	if tenants, err = src.GetTenants(ctx); err != nil {
+		return
+	}
+	for _, tenant := range tenants {
+		tCtx := domain.WithTenant(ctx, tenant)
+		var from, to series
+		if from, err = readSeries(tCtx, src); err != nil {
+			return
+		}
+		if len(from.gauges)+len(from.counters) == 0 {
+			continue
+		}
+		if to, err = readSeries(tCtx, dst); err != nil {
+			return
+		}
+		p := tenantPlan{tenant: tenant, want: series{gauges: domain.Gauges{}, counters: domain.Counters{}}}
+		for _, name := range sortedKeys(from.gauges) {
+			v := from.gauges[name]
+			p.want.gauges[name] = v
+			cur, ok := to.gauges[name]
+			if ok && cur == v {
+				r.Unchanged++
+				continue
+			}
+			if ok {
+				r.Changed++
+			} else {
+				r.New++
+			}
+			p.changes = append(p.changes, domain.Metric{ID: name, MType: constant.MetricTypeGauge, Value: &v})
+		}
+		for _, name := range sortedKeys(from.counters) {
+			cur, ok := to.counters[name]
+			v := merge(m.c.Strategy, cur, from.counters[name])
+			p.want.counters[name] = v
+			if ok && cur == v {
+				r.Unchanged++
+				continue
+			}
+			if ok {
+				r.Changed++
+			} else {
+				r.New++
+			}
+			// counters are increased by delta, value is set by delta to current value
+			delta := v - cur
+			p.changes = append(p.changes, domain.Metric{ID: name, MType: constant.MetricTypeCounter, Delta: &delta})
+		}
+		r.Tenants++
+		r.Series += len(from.gauges) + len(from.counters)
+		plans = append(plans, p)
+		m.log.Info("Compared", zap.String("tenant", tenant), zap.Int("series", len(from.gauges)+len(from.counters)),
+			zap.Int("changes", len(p.changes)))
+	}
+	return
+}
+
+// write changes of tenants to target by batches
+func (m *Migrator) write(ctx context.Context, dst *store, plans []tenantPlan, r *Report) (err error) {
+	var total int
+	for _, p := range plans {
+		total += len(p.changes)
+	}
+	for _, p := range plans {
+		tCtx := domain.WithTenant(ctx, p.tenant)
+		for i := 0; i < len(p.changes); i += m.c.Batch {
+			batch := p.changes[i:min(i+m.c.Batch, len(p.changes))]
+			if _, err = dst.SetMetrics(tCtx, batch); err != nil {
+				return fmt.Errorf("write tenant %q: %w", p.tenant, err)
+			}
+			r.Written += len(batch)
+			m.log.Info("Copied", zap.String("tenant", p.tenant), zap.Int("written", r.Written), zap.Int("total", total))
+		}
+	}
+	return
+}
+
+// verify compare series of target with expected, return mismatched series
+func verify(ctx context.Context, dst *store, plans []tenantPlan) (mismatches []string, err error) {
+	for _, p := range plans {
+		var got series
+		if got, err = readSeries(domain.WithTenant(ctx, p.tenant), dst); err != nil {
+			return
+		}
+		for _, name := range sortedKeys(p.want.gauges) {
+			if v, ok := got.gauges[name]; !ok || v != p.want.gauges[name] {
+				mismatches = append(mismatches, mismatch(p.tenant, constant.MetricTypeGauge, name, p.want.gauges[name], v, ok))
+			}
+		}
+		for _, name := range sortedKeys(p.want.counters) {
+			if v, ok := got.counters[name]; !ok || v != p.want.counters[name] {
+				mismatches = append(mismatches, mismatch(p.tenant, constant.MetricTypeCounter, name, p.want.counters[name], v, ok))
+			}
+		}
+	}
+	return
+}
+
+func mismatch(tenant, mType, name string, want, got any, ok bool) string {
+	if !ok {
+		return fmt.Sprintf("%q %s/%s: want %v, not exist", tenant, mType, name, want)
+	}
+	return fmt.Sprintf("%q %s/%s: want %v, got %v", tenant, mType, name, want, got)
+}
+
+// merge counter of target with source counter by strategy
+func merge(strategy string, cur, v domain.Counter) domain.Counter {
+	switch strategy {
+	case StrategySum:
+		return cur + v
+	case StrategyKeepMax:
+		return max(cur, v)
+	}
+	return v
+}
+
+func readSeries(ctx context.Context, s *store) (t series, err error) {
+	if t.gauges, err = s.GetAllGauges(ctx); err != nil {
+		return
+	}
+	t.counters, err = s.GetAllCounters(ctx)
+	return
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
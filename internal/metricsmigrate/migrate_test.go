@@ -0,0 +1,192 @@
+package metricsmigrate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	"go-musthave-metrics/internal/server/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	ctxA := domain.WithTenant(ctx, "teamA")
+
+	// snapshot storage file with gauge and counter of default tenant and counter of teamA
+	snapshot := func(t *testing.T) string {
+		path := filepath.Join(t.TempDir(), "store.json")
+		m := repository.NewMemRepository()
+		require.NoError(t, m.SetGauge(ctx, "gauge", 1.5))
+		require.NoError(t, m.SetCounter(ctx, "counter", 4))
+		require.NoError(t, m.SetCounter(ctxA, "counter", 7))
+		require.NoError(t, repository.NewFileStorageRepository(&config.StorageConfig{FileStoragePath: path}).SaveToFile(m))
+		return path
+	}
+	// disk store with counter of default tenant
+	disk := func(t *testing.T, counter domain.Counter) string {
+		dir := t.TempDir()
+		r := repository.NewDiskStorageRepository(dir)
+		require.NoError(t, r.SetCounter(ctx, "counter", counter))
+		require.NoError(t, r.Close())
+		return dir
+	}
+	run := func(t *testing.T, c *Config) Report {
+		require.NoError(t, c.Validate())
+		r, err := New(c, zap.NewNop()).Run(ctx)
+		require.NoError(t, err)
+		return r
+	}
+	counter := func(t *testing.T, dir string) domain.Counter {
+		r := repository.NewDiskStorageRepository(dir)
+		defer func() { require.NoError(t, r.Close()) }()
+		v, err := r.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		return v
+	}
+
+	t.Run("snapshot to disk", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "disk")
+		c := NewConfig()
+		c.From, c.To, c.Batch = "snapshot://"+snapshot(t), "file://"+dir, 2
+		r := run(t, c)
+		assert.Equal(t, Report{Tenants: 2, Series: 3, New: 3, Written: 3, Verified: true}, r)
+
+		s := repository.NewDiskStorageRepository(dir)
+		defer func() { require.NoError(t, s.Close()) }()
+		gauge, err := s.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(1.5), gauge)
+		v, err := s.GetCounter(ctxA, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(7), v, "tenant is kept")
+	})
+
+	t.Run("strategy", func(t *testing.T) {
+		for strategy, want := range map[string]domain.Counter{
+			StrategySum:       14,
+			StrategyOverwrite: 4,
+			StrategyKeepMax:   10,
+		} {
+			t.Run(strategy, func(t *testing.T) {
+				dir := disk(t, 10)
+				c := NewConfig()
+				c.From, c.To, c.Strategy = "snapshot://"+snapshot(t), "file://"+dir, strategy
+				r := run(t, c)
+				assert.True(t, r.Verified)
+				assert.Empty(t, r.Mismatches)
+				assert.Equal(t, want, counter(t, dir))
+			})
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		dir := disk(t, 10)
+		c := NewConfig()
+		c.From, c.To, c.DryRun = "snapshot://"+snapshot(t), "file://"+dir, true
+		r := run(t, c)
+		assert.Equal(t, Report{DryRun: true, Tenants: 2, Series: 3, New: 2, Changed: 1}, r)
+		assert.Equal(t, domain.Counter(10), counter(t, dir), "target is not changed")
+	})
+
+	t.Run("disk to snapshot", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "store.json")
+		c := NewConfig()
+		c.From, c.To = "file://"+disk(t, 10), "snapshot://"+path
+		r := run(t, c)
+		assert.Equal(t, 1, r.Written)
+		assert.True(t, r.Verified)
+
+		m := repository.NewMemRepository()
+		require.NoError(t, repository.NewFileStorageRepository(&config.StorageConfig{FileStoragePath: path}).RestoreFromFile(m))
+		v, err := m.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(10), v)
+
+		r = run(t, c)
+		assert.Equal(t, Report{Tenants: 1, Series: 1, Unchanged: 1, Verified: true}, r, "nothing to write twice")
+	})
+
+	t.Run("missing source", func(t *testing.T) {
+		tmp := t.TempDir()
+		for _, from := range []string{"snapshot://" + filepath.Join(tmp, "store.json"), "file://" + filepath.Join(tmp, "disk")} {
+			c := NewConfig()
+			c.From, c.To = from, "file://"+disk(t, 10)
+			_, err := New(c, zap.NewNop()).Run(ctx)
+			assert.ErrorIs(t, err, os.ErrNotExist, from)
+		}
+		_, err := os.Stat(filepath.Join(tmp, "disk"))
+		assert.ErrorIs(t, err, os.ErrNotExist, "source is not created")
+	})
+
+	t.Run("dry run to new storage", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "disk")
+		c := NewConfig()
+		c.From, c.To, c.DryRun = "file://"+disk(t, 10), "file://"+dir, true
+		r := run(t, c)
+		assert.Equal(t, Report{DryRun: true, Tenants: 1, Series: 1, New: 1}, r)
+		_, err := os.Stat(dir)
+		assert.ErrorIs(t, err, os.ErrNotExist, "target is not created")
+	})
+
+	t.Run("unknown driver", func(t *testing.T) {
+		c := NewConfig()
+		c.From, c.To = "redis://localhost", "mem://"
+		_, err := New(c, zap.NewNop()).Run(ctx)
+		assert.Error(t, err)
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{
+			name:   "Ok",
+			modify: func(c *Config) {},
+		},
+		{
+			name:    "No target",
+			modify:  func(c *Config) { c.To = "" },
+			wantErr: true,
+		},
+		{
+			name:    "No scheme",
+			modify:  func(c *Config) { c.From = "/tmp/store.json" },
+			wantErr: true,
+		},
+		{
+			name:    "Same storage",
+			modify:  func(c *Config) { c.To = c.From },
+			wantErr: true,
+		},
+		{
+			name:    "Unknown strategy",
+			modify:  func(c *Config) { c.Strategy = "min" },
+			wantErr: true,
+		},
+		{
+			name:    "Bad batch",
+			modify:  func(c *Config) { c.Batch = 0 },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConfig()
+			c.From, c.To = "snapshot:///tmp/store.json", "postgres://localhost/metrics"
+			tt.modify(c)
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
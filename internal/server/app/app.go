@@ -5,24 +5,26 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"go-musthave-metrics/internal/server/certs"
 	"go-musthave-metrics/internal/server/closer"
 	"go-musthave-metrics/internal/server/config"
 	"go-musthave-metrics/internal/server/constant"
+	myErr "go-musthave-metrics/internal/server/errors"
 	hgrpc "go-musthave-metrics/internal/server/handler/grpc"
 	"go-musthave-metrics/internal/server/handler/rest"
-	myMigrate "go-musthave-metrics/internal/server/migrate"
 	"go-musthave-metrics/internal/server/repository"
 	"go-musthave-metrics/internal/server/service"
 
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func buildInfo(s string) string {
@@ -39,17 +41,19 @@ type BuildMetadata struct {
 }
 
 type App struct {
-	stop       context.CancelFunc
-	cfg        *config.Config
-	eg         *errgroup.Group
-	http       *http.Server
-	grpc       *grpc.Server
-	srv        *service.Service
-	log        *zap.Logger
-	db         *sqlx.DB
-	closer     *closer.Closer
-	lockDB     chan struct{}
-	isNewStore bool
+	stop      context.CancelFunc
+	cfg       *config.Config
+	eg        *errgroup.Group
+	http      *http.Server
+	grpc      *grpc.Server
+	certs     *certs.Reloader
+	srv       *service.Service
+	log       *zap.Logger
+	store     *repository.Storage
+	closer    *closer.Closer
+	lockStore chan struct{}
+	reloaded  chan struct{}
+	reloadM   sync.Mutex
 }
 
 func RunApp(ctx context.Context, cfg *config.Config, log *zap.Logger, buildData BuildMetadata) {
@@ -57,7 +61,7 @@ func RunApp(ctx context.Context, cfg *config.Config, log *zap.Logger, buildData
 		err  error
 		stop context.CancelFunc
 	)
-	ctx, stop = signal.NotifyContext(ctx, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	ctx, stop = signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	defer stop()
 	if cfg == nil {
 		cfg, err = config.NewConfig().Init()
@@ -86,77 +90,122 @@ func RunApp(ctx context.Context, cfg *config.Config, log *zap.Logger, buildData
 func newApp(c context.Context, stop context.CancelFunc, cfg *config.Config, log *zap.Logger) *App {
 	eg, ctx := errgroup.WithContext(c)
 	a := App{
-		stop:       stop,
-		cfg:        cfg,
-		eg:         eg,
-		isNewStore: true,
-		closer:     &closer.Closer{},
-		log:        log,
-		lockDB:     make(chan struct{}),
+		stop:      stop,
+		cfg:       cfg,
+		eg:        eg,
+		closer:    &closer.Closer{},
+		log:       log,
+		lockStore: make(chan struct{}),
 	}
 
-	a.maybeConnectDB()
-
-	a.srv = service.NewService(repository.NewRepository(&a.cfg.StorageConfig, a.db), &a.cfg.StorageConfig)
+	a.runReloader(ctx)
+	a.openStore(ctx)
+	a.srv = service.NewService(a.store, &a.cfg.StorageConfig)
 
 	a.maybeRestoreStore(ctx)
 	a.maybeRunStoreSaver(ctx)
+	a.maybeRunAgentsWatcher(ctx)
 
 	h := rest.NewHandler(a.srv, a.cfg, a.log)
 	g := hgrpc.NewServer(a.srv, a.cfg, a.log)
 
+	var grpcOpts []grpc.ServerOption
+	if a.maybeLoadCerts(ctx) {
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(a.certs.TLSConfig())))
+	}
+
 	if a.cfg.Address != "" {
 		a.http = &http.Server{Addr: a.cfg.Address, Handler: h.Handler()}
+		if a.certs != nil {
+			a.http.TLSConfig = a.certs.TLSConfig()
+		}
 	}
 
 	if a.cfg.GRPCAddress != "" {
-		a.grpc = g.Handler()
+		a.grpc = g.Handler(grpcOpts...)
 	}
 
 	return &a
 }
 
-func (a *App) maybeConnectDB() {
-	if len(a.cfg.DatabaseDSN) > 0 {
-		var err error
-		if a.db, err = sqlx.Connect("postgres", a.cfg.DatabaseDSN); err != nil {
-			a.log.Fatal("cannot connect db", zap.Error(err))
-		}
-		a.isNewStore = false
-		a.log.Info("DB connected")
-		versions, errM := myMigrate.Migrate(a.db.DB)
-		switch {
-		case errors.Is(errM, migrate.ErrNoChange):
-			a.log.Info("DB migrate: ", zap.Any("info", errM), zap.Any("versions", versions))
-		case errM == nil:
-			a.log.Info("DB migrate: new applied ", zap.Any("versions", versions))
-			a.isNewStore = versions[0] == 0
-		default:
-			a.log.Fatal("DB migrate: ", zap.Any("versions", versions), zap.Error(errM))
-		}
+// openStore open storage by driver of storage url, or of database dsn, disk store directory or memory
+func (a *App) openStore(ctx context.Context) {
+	scheme, dsn := a.cfg.StorageDriver()
+	var err error
+	if a.store, err = repository.OpenDriver(ctx, scheme, dsn, &a.cfg.StorageConfig); err != nil {
+		a.log.Fatal("cannot open storage", zap.String("driver", scheme), zap.Error(err))
 	}
+	a.log.Info("Storage opened", zap.String("driver", scheme), zap.Bool("new", a.store.New))
+}
+
+// maybeLoadCerts load tls certificates and watch its changes, return true if tls is used
+func (a *App) maybeLoadCerts(ctx context.Context) bool {
+	if a.cfg.TLSCert == "" {
+		return false
+	}
+	var err error
+	if a.certs, err = certs.NewReloader(&a.cfg.TLS); err != nil {
+		a.log.Fatal("cannot load tls certificates", zap.Error(err))
+	}
+	a.log.Info("TLS certificates loaded", zap.Bool("client certificates required", a.cfg.TLSClientCA != ""))
+	a.eg.Go(func() error {
+		for {
+			select {
+			case <-time.After(constant.CertsCheckInterval * time.Second):
+				if ok, er := a.certs.MaybeReload(); er != nil {
+					a.log.Error("TLS certificates reload, current certificates are kept", zap.Error(er))
+				} else if ok {
+					a.log.Info("TLS certificates reloaded")
+				}
+			case <-ctx.Done():
+				a.log.Info("TLS certificates watcher finished")
+				return nil
+			}
+		}
+	})
+	return true
 }
 
 func (a *App) maybeRestoreStore(ctx context.Context) {
+	if a.walEnabled() && !a.cfg.StorageRestore {
+		// write-ahead log of previous run is not replayed over new store later
+		if _, er := a.srv.SaveToFile(ctx); er != nil {
+			a.log.Error("Write-ahead log truncate", zap.Error(er))
+		}
+	}
 	if a.cfg.FileStoragePath != "" && a.cfg.StorageRestore {
-		if a.isNewStore {
-			if n, er := a.srv.RestoreFromFile(ctx); er != nil {
+		if a.store.New {
+			if n, er := a.srv.RestoreFromFile(ctx); errors.Is(er, myErr.ErrSnapshotFallback) {
+				a.log.Warn("File storage restored from previous generation", zap.Any("records", n), zap.Error(er))
+			} else if er != nil {
 				a.log.Error("File storage restore", zap.Error(er))
 			} else {
 				a.log.Info("File storage restored success", zap.Any("records", n))
 			}
 		} else {
-			a.log.Info("Storage not restored - it is not new db store used")
+			a.log.Info("Storage not restored - it is not new store used")
 		}
 	}
 }
 
 func (a *App) maybeRunStoreSaver(ctx context.Context) {
-	if a.cfg.FileStoragePath != "" && a.cfg.FileStoreInterval > 0 {
+	if a.cfg.FileStoragePath != "" {
 		a.eg.Go(func() error {
 			for {
+				// interval can be changed on reload, zero interval - store is saved by service on every change,
+				// with write-ahead log store is saved as snapshot with default interval
+				var save <-chan time.Time
+				interval := a.cfg.GetFileStoreInterval()
+				if interval == 0 && a.walEnabled() {
+					interval = constant.StoreInterval
+				}
+				if interval > 0 {
+					save = time.After(time.Duration(interval) * time.Second)
+				}
+				reloaded := a.reloadedChan()
 				select {
-				case <-time.After(time.Duration(a.cfg.FileStoreInterval) * time.Second):
+				case <-reloaded:
+				case <-save:
 					if n, er := a.srv.SaveToFile(ctx); er != nil {
 						a.log.Error("Storage save", zap.Error(er))
 					} else {
@@ -171,8 +220,93 @@ func (a *App) maybeRunStoreSaver(ctx context.Context) {
 	}
 }
 
+// walEnabled is write-ahead log used: memory store with storage file
+func (a *App) walEnabled() bool {
+	return a.cfg.FileStoragePath != "" && a.store.WALEnabled()
+}
+
+func (a *App) maybeRunAgentsWatcher(ctx context.Context) {
+	if a.cfg.AgentMissingIntervals > 0 {
+		a.eg.Go(func() error {
+			for {
+				select {
+				case <-time.After(constant.AgentsCheckInterval * time.Second):
+					agents, er := a.srv.CheckMissingAgents(ctx, a.cfg.AgentMissingIntervals)
+					if er != nil {
+						a.log.Error("Agents check", zap.Error(er))
+					}
+					for _, agent := range agents {
+						a.log.Warn("Agent missing", zap.String("id", agent.ID),
+							zap.String("hostname", agent.Hostname), zap.String("ip", agent.IP),
+							zap.Time("last seen", agent.LastSeen))
+					}
+				case <-ctx.Done():
+					a.log.Info("Agents watcher finished")
+					return nil
+				}
+			}
+		})
+	}
+}
+
+// runReloader reload config on SIGHUP, listeners and db connection are kept
+func (a *App) runReloader(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	a.eg.Go(func() error {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				a.reload()
+			case <-ctx.Done():
+				a.log.Info("Config reloader finished")
+				return nil
+			}
+		}
+	})
+}
+
+func (a *App) reload() {
+	a.log.Info("SIGHUP received, reload config")
+	n, err := a.cfg.Reload()
+	if err != nil {
+		a.log.Error("Reload config, current config is kept", zap.Error(err))
+		return
+	}
+	changed, ignored := a.cfg.Apply(n)
+	if a.certs != nil {
+		if er := a.certs.Reload(); er != nil {
+			a.log.Error("TLS certificates reload, current certificates are kept", zap.Error(er))
+		} else {
+			changed = append(changed, "tls certificates")
+		}
+	}
+	if len(ignored) > 0 {
+		a.log.Warn("Config values are changed, but not applied without restart", zap.Strings("values", ignored))
+	}
+	a.log.Info("Config reloaded", zap.Strings("changed", changed))
+
+	a.reloadM.Lock()
+	defer a.reloadM.Unlock()
+	if a.reloaded != nil {
+		close(a.reloaded)
+		a.reloaded = nil
+	}
+}
+
+// reloadedChan return channel closed on next config reload
+func (a *App) reloadedChan() <-chan struct{} {
+	a.reloadM.Lock()
+	defer a.reloadM.Unlock()
+	if a.reloaded == nil {
+		a.reloaded = make(chan struct{})
+	}
+	return a.reloaded
+}
+
 func (a *App) shutdownFileStore(ctx context.Context) (err error) {
-	defer close(a.lockDB)
+	defer close(a.lockStore)
 	var n int64
 	if n, err = a.srv.SaveToFile(ctx); err == nil {
 		a.log.Info("Storage saved", zap.Any("records", n))
@@ -181,12 +315,10 @@ func (a *App) shutdownFileStore(ctx context.Context) (err error) {
 
 }
 
-func (a *App) shutdownDBStore(_ context.Context) (err error) {
-	if a.db != nil {
-		<-a.lockDB
-		if err = a.db.Close(); err == nil {
-			a.log.Info("Db Closed")
-		}
+func (a *App) shutdownStore(_ context.Context) (err error) {
+	<-a.lockStore
+	if err = a.store.Close(); err == nil {
+		a.log.Info("Storage closed")
 	}
 	return
 }
@@ -207,15 +339,19 @@ func (a *App) Run(ctx context.Context) {
 	if a.cfg.FileStoragePath != "" {
 		a.closer.Add("Storage save", a.shutdownFileStore)
 	} else {
-		close(a.lockDB)
+		close(a.lockStore)
 	}
 
-	if a.db != nil {
-		a.closer.Add("DB Close", a.shutdownDBStore)
-	}
+	a.closer.Add("Storage close", a.shutdownStore)
 
 	go func() {
-		if err := a.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if a.http.TLSConfig != nil {
+			err = a.http.ListenAndServeTLS("", "")
+		} else {
+			err = a.http.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			a.log.Error("http server", zap.Error(err))
 			a.stop()
 		}
@@ -0,0 +1,122 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+)
+
+// Reloader server certificate and client CA, reloaded without restart of listeners
+type Reloader struct {
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+	c        *config.TLS
+	m        sync.RWMutex
+}
+
+// NewReloader load certificate files of config
+func NewReloader(c *config.TLS) (r *Reloader, err error) {
+	r = &Reloader{c: c}
+	if err = r.Reload(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Reload load certificate files, current certificates are kept on error
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.c.TLSCert, r.c.TLSKey)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.c.TLSClientCA != "" {
+		var b []byte
+		if b, err = os.ReadFile(r.c.TLSClientCA); err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return errors.New("no certificates at client CA file")
+		}
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.cert, r.clientCA, r.modTimes = &cert, pool, r.files()
+	return nil
+}
+
+// MaybeReload reload certificate files if they are changed
+func (r *Reloader) MaybeReload() (ok bool, err error) {
+	r.m.RLock()
+	modTimes := r.modTimes
+	r.m.RUnlock()
+	files := r.files()
+	for name, t := range files {
+		if !modTimes[name].Equal(t) {
+			if err = r.Reload(); err != nil {
+				// do not retry until next change
+				r.m.Lock()
+				r.modTimes = files
+				r.m.Unlock()
+			}
+			return true, err
+		}
+	}
+	return
+}
+
+func (r *Reloader) files() map[string]time.Time {
+	m := make(map[string]time.Time, 3)
+	for _, name := range []string{r.c.TLSCert, r.c.TLSKey, r.c.TLSClientCA} {
+		if name == "" {
+			continue
+		}
+		if stat, err := os.Stat(name); err == nil {
+			m[name] = stat.ModTime()
+		}
+	}
+	return m
+}
+
+// TLSConfig config for listeners, current certificate and client CA are used for every connection.
+// Client certificate is required if client CA is set
+func (r *Reloader) TLSConfig() *tls.Config {
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		r.m.RLock()
+		defer r.m.RUnlock()
+		return r.cert, nil
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.m.RLock()
+			defer r.m.RUnlock()
+			c := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: getCertificate,
+				NextProtos:     []string{"h2", "http/1.1"},
+			}
+			if r.clientCA != nil {
+				c.ClientCAs = r.clientCA
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return c, nil
+		},
+	}
+}
+
+// PeerName common name of verified client certificate, empty if there is no client certificate
+func PeerName(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
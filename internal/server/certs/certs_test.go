@@ -0,0 +1,63 @@
+package certs
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	testhelpers "go-musthave-metrics/tests"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	f := testhelpers.CreateTLSCertificates(dir, "agent")
+	c := &config.TLS{TLSCert: f.ServerCert, TLSKey: f.ServerKey, TLSClientCA: f.CA}
+
+	r, err := NewReloader(c)
+	require.NoError(t, err)
+	cert := func() *tls.Certificate {
+		tc, er := r.TLSConfig().GetConfigForClient(nil)
+		require.NoError(t, er)
+		assert.Equal(t, tls.RequireAndVerifyClientCert, tc.ClientAuth)
+		crt, er := tc.GetCertificate(nil)
+		require.NoError(t, er)
+		return crt
+	}
+	first := cert()
+
+	t.Run("not changed", func(t *testing.T) {
+		ok, err := r.MaybeReload()
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+	t.Run("changed", func(t *testing.T) {
+		testhelpers.CreateTLSCertificates(dir, "agent")
+		require.NoError(t, os.Chtimes(f.ServerCert, time.Now(), time.Now().Add(time.Second)))
+		ok, err := r.MaybeReload()
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.NotEqual(t, first.Certificate[0], cert().Certificate[0])
+	})
+	t.Run("bad certificate, current is kept", func(t *testing.T) {
+		current := cert()
+		require.NoError(t, os.WriteFile(f.ServerKey, []byte("bad key"), 0o600))
+		require.NoError(t, os.Chtimes(f.ServerKey, time.Now(), time.Now().Add(2*time.Second)))
+		ok, err := r.MaybeReload()
+		assert.True(t, ok)
+		assert.Error(t, err)
+		assert.Equal(t, current.Certificate[0], cert().Certificate[0])
+
+		ok, err = r.MaybeReload()
+		assert.False(t, ok, "not retried until next change")
+		assert.NoError(t, err)
+	})
+	t.Run("bad files", func(t *testing.T) {
+		_, err := NewReloader(&config.TLS{TLSCert: f.ServerCert, TLSKey: f.ServerKey})
+		assert.Error(t, err)
+	})
+}
@@ -1,17 +1,25 @@
 package config
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
+	"crypto"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"net"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"go-musthave-metrics/internal/envelope"
 	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	"go-musthave-metrics/internal/server/keyring"
+	"go-musthave-metrics/internal/server/limiter"
 	"go-musthave-metrics/pkg/structflag"
 
 	"github.com/caarlos0/env/v11"
@@ -19,22 +27,79 @@ import (
 
 // StorageConfig file storage configs
 type StorageConfig struct {
+	StorageURL        string `env:"STORAGE_URL" json:"storage_url" flag:"storage-url" usage:"Provide the url of data storage, scheme selects driver: mem://, file:///path of embedded disk store, postgres://... Database dsn and disk store directory are used if empty"`
 	FileStoragePath   string `env:"FILE_STORAGE_PATH" json:"file_storage_path" flag:"f" usage:"Provide the file storage path"`
+	DiskStoragePath   string `env:"DISK_STORAGE_PATH" json:"disk_storage_path" flag:"disk-storage-path" usage:"Provide the directory of embedded disk store, metrics are stored at it instead of memory. Not used with database"`
 	StorageRestore    bool   `env:"RESTORE" json:"restore" flag:"r" usage:"Provide the file storage path"`
 	FileStoreInterval int    `env:"FILE_STORE_INTERVAL" json:"file_store_interval" flag:"i" usage:"Provide the interval in seconds"`
+	SnapshotKeep      int    `env:"SNAPSHOT_KEEP" json:"snapshot_keep" flag:"snapshot-keep" usage:"Provide the number of previous storage file generations to keep, restore falls back to them if storage file is corrupt"`
+	SnapshotGzip      bool   `env:"SNAPSHOT_GZIP" json:"snapshot_gzip" flag:"snapshot-gzip" usage:"Compress storage file with gzip"`
+	StorageKey        string `env:"STORAGE_KEY" json:"storage_key" flag:"storage-key" usage:"Provide keys of storage file and write-ahead log encryption, comma separated id:base64 key, first key encrypts, others decrypt files of previous keys"`
+	StorageKeyFile    string `env:"STORAGE_KEY_FILE" json:"storage_key_file" flag:"storage-key-file" usage:"Provide file with keys of storage encryption, format of storage key"`
+	storageKeys       *keyring.Keyring
+	WALPath           string `env:"WAL_FILE" json:"wal_file" flag:"wal-file" usage:"Provide the write-ahead log file of memory store, changes are appended to it and replayed on start, storage file is saved as snapshot with interval. Not used with database"`
+	WALSync           string `env:"WAL_SYNC" json:"wal_sync" flag:"wal-sync" usage:"Provide the fsync policy of write-ahead log: always, everysec or no"`
+	WriteBehind       bool   `env:"WRITE_BEHIND" json:"write_behind" flag:"write-behind" usage:"Buffer database writes in memory and write them by batches, reads are served from buffer and database"`
+	WriteBehindFlush  int    `env:"WRITE_BEHIND_FLUSH" json:"write_behind_flush" flag:"write-behind-flush" usage:"Provide the interval in seconds of write-behind buffer flush"`
+	WriteBehindMax    int    `env:"WRITE_BEHIND_MAX" json:"write_behind_max" flag:"write-behind-max" usage:"Provide the number of buffered series, at which write-behind buffer is flushed, writers wait flush above it"`
+	DBFallbackPath    string `env:"DB_FALLBACK_FILE" json:"db_fallback_file" flag:"db-fallback-file" usage:"Provide the file of database fallback buffer: writes are buffered at it while database is unavailable and replayed when it returns, reads are served from last known state"`
+	DBFallbackMaxSize int    `env:"DB_FALLBACK_MAX_SIZE" json:"db_fallback_max_size" flag:"db-fallback-max-size" usage:"Provide the size of database fallback buffer in megabytes, writes are rejected above it"`
+	BreakerFailures   int    `env:"BREAKER_FAILURES" json:"breaker_failures" flag:"breaker-failures" usage:"Provide the number of consecutive database failures, at which circuit breaker is opened and storage operations fail fast. 0 - no breaker"`
+	BreakerOpen       int    `env:"BREAKER_OPEN" json:"breaker_open" flag:"breaker-open" usage:"Provide the time in seconds, while circuit breaker is open, after it one probe operation is passed to database"`
+	BreakerTimeout    int    `env:"BREAKER_TIMEOUT" json:"breaker_timeout" flag:"breaker-timeout" usage:"Provide the timeout in seconds of database operation, slow operation is counted as failure of circuit breaker. 0 - request timeout"`
+	AgentsConfigPath  string `env:"AGENTS_CONFIG" json:"agents_config" flag:"agents-config" usage:"Provide file with agents configs, served to agents"`
+	CredentialsPath   string `env:"CREDENTIALS_FILE" json:"credentials_file" flag:"credentials-file" usage:"Provide file with agents sign keys, keys are stored at database if it is set"`
+	AccessPath        string `env:"ACCESS_FILE" json:"access_file" flag:"access-file" usage:"Provide file with roles of api tokens and client certificates. Access is not checked if empty"`
+	AuditPath         string `env:"AUDIT_FILE" json:"audit_file" flag:"audit-file" usage:"Provide the audit log file, json lines. Audit log is written to database if empty and database is set"`
+	AuditMaxSize      int    `env:"AUDIT_MAX_SIZE" json:"audit_max_size" flag:"audit-max-size" usage:"Provide the size of audit log file in megabytes, after which file is rotated"`
+	AuditMaxFiles     int    `env:"AUDIT_MAX_FILES" json:"audit_max_files" flag:"audit-max-files" usage:"Provide the number of rotated audit log files to keep"`
+	MaxSeries         int    `env:"MAX_SERIES" json:"max_series" flag:"max-series" usage:"Provide the limit of stored series, new series are rejected above it. 0 - no limit"`
+	MaxTenantSeries   int    `env:"MAX_TENANT_SERIES" json:"max_tenant_series" flag:"max-tenant-series" usage:"Provide the limit of series of one tenant. 0 - no limit"`
+	MaxAgentSeries    int    `env:"MAX_AGENT_SERIES" json:"max_agent_series" flag:"max-agent-series" usage:"Provide the limit of series written by one agent. 0 - no limit"`
+	MaxNameLength     int    `env:"MAX_NAME_LENGTH" json:"max_name_length" flag:"max-name-length" usage:"Provide the limit of metric name length. 0 - no limit"`
+	m                 sync.RWMutex
 }
 
 // WEB  config
 type WEB struct {
-	cryptoKey     *rsa.PrivateKey
+	cryptoKey     crypto.PrivateKey
 	Key           string `env:"KEY" json:"key" flag:"k" usage:"Private theKey"`
 	CryptoKey     string `env:"CRYPTO_KEY" json:"crypto_key" flag:"crypto-key" usage:"Provide the private server key for decryption"`
 	TrustedSubnet string `env:"TRUSTED_SUBNET" json:"trusted_subnet" flag:"t" usage:"Provide the trusted subnet"`
+	SignSkew      int    `env:"SIGN_SKEW" json:"sign_skew" flag:"sign-skew" usage:"Provide the allowed clock skew in seconds of signed requests timestamp"`
+	SignLegacy    bool   `env:"SIGN_LEGACY" json:"sign_legacy" flag:"sign-legacy" usage:"Allow signatures of body only from old agents, without replay protection"`
+	AdminToken    string `env:"ADMIN_TOKEN" json:"admin_token" flag:"admin-token" usage:"Provide the token of admin api, admin api is disabled if empty and no admin role at access file"`
+	m             sync.RWMutex
 }
 
 type GRPC struct {
 	GRPCAddress string `env:"GRPC_ADDRESS" json:"grpc_address"  flag:"g" usage:"Provide the grpc service address"`
 	GRPCToken   string `env:"GRPC_TOKEN" json:"grpc_token"  flag:"grpc_token" usage:"Provide the grpc service token"`
+	m           sync.RWMutex
+}
+
+// TLS listeners tls config
+type TLS struct {
+	TLSCert     string `env:"TLS_CERT" json:"tls_cert" flag:"tls-cert" usage:"Provide the server certificate file, enable https and tls for grpc"`
+	TLSKey      string `env:"TLS_KEY" json:"tls_key" flag:"tls-key" usage:"Provide the server certificate key file"`
+	TLSClientCA string `env:"TLS_CLIENT_CA" json:"tls_client_ca" flag:"tls-client-ca" usage:"Provide the CA file for verify agents certificates. Agents certificates are required if set"`
+}
+
+// Agents agents registry config
+type Agents struct {
+	AgentMissingIntervals int `env:"AGENT_MISSING_INTERVALS" json:"agent_missing_intervals" flag:"agent-missing" usage:"Provide the number of agent report intervals without reports, after which the agent is missing. 0 - do not check"`
+}
+
+// Limit ingestion rate limits of agents, agent is identified by verified agent identity or ip
+type Limit struct {
+	LimitAgentRequests int `env:"LIMIT_AGENT_RPS" json:"limit_agent_rps" flag:"limit-agent-rps" usage:"Provide the limit of update requests per second of agent. 0 - no limit"`
+	LimitAgentMetrics  int `env:"LIMIT_AGENT_MPS" json:"limit_agent_mps" flag:"limit-agent-mps" usage:"Provide the limit of received metrics per second of agent. 0 - no limit"`
+	LimitRequests      int `env:"LIMIT_RPS" json:"limit_rps" flag:"limit-rps" usage:"Provide the limit of update requests per second of all agents. 0 - no limit"`
+	LimitMetrics       int `env:"LIMIT_MPS" json:"limit_mps" flag:"limit-mps" usage:"Provide the limit of received metrics per second of all agents. 0 - no limit"`
+	LimitBurst         int `env:"LIMIT_BURST" json:"limit_burst" flag:"limit-burst" usage:"Provide the burst of limits in seconds of limit rate"`
+	// LimitRealIP agent ip is taken from X-Real-IP header, it is set only behind trusted proxy
+	LimitRealIP bool `env:"LIMIT_REAL_IP" json:"limit_real_ip" flag:"limit-real-ip" usage:"Identify agents of limits by X-Real-IP header of trusted proxy instead of connection address"`
+	m           sync.RWMutex
 }
 
 // Config all configs
@@ -45,7 +110,10 @@ type Config struct {
 	Config2     string `json:"-" env:"-" flag:"c" usage:"same as -config"` // ?
 	WEB
 	GRPC
+	TLS
 	StorageConfig
+	Agents
+	Limit
 }
 
 func NewConfig() *Config {
@@ -55,16 +123,221 @@ func NewConfig() *Config {
 			FileStoreInterval: constant.StoreInterval,
 			FileStoragePath:   constant.FileStoragePath,
 			StorageRestore:    constant.StorageRestore,
+			SnapshotKeep:      constant.SnapshotKeep,
+			WALSync:           constant.WALSyncEverySec,
+			WriteBehindFlush:  constant.WriteBehindFlush,
+			WriteBehindMax:    constant.WriteBehindMax,
+			DBFallbackMaxSize: constant.DBFallbackMaxSize,
+			BreakerFailures:   constant.BreakerFailures,
+			BreakerOpen:       constant.BreakerOpen,
+			BreakerTimeout:    constant.BreakerTimeout,
+			AuditMaxSize:      constant.AuditMaxSize,
+			AuditMaxFiles:     constant.AuditMaxFiles,
+		},
+		WEB: WEB{
+			SignSkew: constant.SignSkew,
 		},
 		GRPC: GRPC{
 			GRPCAddress: constant.GRPCAddress,
 		},
+		Agents: Agents{
+			AgentMissingIntervals: constant.AgentMissingIntervals,
+		},
+		Limit: Limit{
+			LimitBurst: constant.LimitBurst,
+		},
 	}
 }
 
 // Init all configs
 func (c *Config) Init() (*Config, error) {
 	c.parseFlags()
+	return c, c.load()
+}
+
+// Reload load new config from flags, env and config file, current config is not changed
+func (c *Config) Reload() (n *Config, err error) {
+	n = NewConfig()
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	structflag.LoadTo(fs, "", n)
+	if err = fs.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+	if err = n.load(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Apply set values of new config, which can be changed on the fly, return names of changed values
+// and names of changed values, which are not applied without restart
+func (c *Config) Apply(n *Config) (changed, ignored []string) {
+	for name, v := range map[string]bool{
+		"address":                 c.Address != n.Address,
+		"database_dsn":            c.DatabaseDSN != n.DatabaseDSN,
+		"grpc_address":            c.GRPCAddress != n.GRPCAddress,
+		"storage_url":             c.StorageURL != n.StorageURL,
+		"file_storage_path":       c.FileStoragePath != n.FileStoragePath,
+		"restore":                 c.StorageRestore != n.StorageRestore,
+		"wal_file":                c.WALPath != n.WALPath,
+		"disk_storage_path":       c.DiskStoragePath != n.DiskStoragePath,
+		"write_behind":            c.WriteBehind != n.WriteBehind,
+		"db_fallback_file":        c.DBFallbackPath != n.DBFallbackPath,
+		"agents_config":           c.AgentsConfigPath != n.AgentsConfigPath,
+		"credentials_file":        c.CredentialsPath != n.CredentialsPath,
+		"access_file":             c.AccessPath != n.AccessPath,
+		"audit_file":              c.AuditPath != n.AuditPath,
+		"agent_missing_intervals": c.AgentMissingIntervals != n.AgentMissingIntervals,
+		"tls_cert":                c.TLSCert != n.TLSCert,
+		"tls_key":                 c.TLSKey != n.TLSKey,
+		"tls_client_ca":           c.TLSClientCA != n.TLSClientCA,
+	} {
+		if v {
+			ignored = append(ignored, name)
+		}
+	}
+	sort.Strings(ignored)
+
+	c.WEB.m.Lock()
+	if c.Key != n.Key {
+		c.Key = n.Key
+		changed = append(changed, "key")
+	}
+	if c.CryptoKey != n.CryptoKey || !samePrivateKey(c.cryptoKey, n.GetPrivateKey()) {
+		c.CryptoKey = n.CryptoKey
+		c.cryptoKey = n.GetPrivateKey()
+		changed = append(changed, "crypto_key")
+	}
+	if c.TrustedSubnet != n.TrustedSubnet {
+		c.TrustedSubnet = n.TrustedSubnet
+		changed = append(changed, "trusted_subnet")
+	}
+	if c.SignSkew != n.SignSkew {
+		c.SignSkew = n.SignSkew
+		changed = append(changed, "sign_skew")
+	}
+	if c.SignLegacy != n.SignLegacy {
+		c.SignLegacy = n.SignLegacy
+		changed = append(changed, "sign_legacy")
+	}
+	if c.AdminToken != n.AdminToken {
+		c.AdminToken = n.AdminToken
+		changed = append(changed, "admin_token")
+	}
+	c.WEB.m.Unlock()
+
+	c.GRPC.m.Lock()
+	if c.GRPCToken != n.GRPCToken {
+		c.GRPCToken = n.GRPCToken
+		changed = append(changed, "grpc_token")
+	}
+	c.GRPC.m.Unlock()
+
+	c.Limit.m.Lock()
+	if c.LimitAgentRequests != n.LimitAgentRequests {
+		c.LimitAgentRequests = n.LimitAgentRequests
+		changed = append(changed, "limit_agent_rps")
+	}
+	if c.LimitAgentMetrics != n.LimitAgentMetrics {
+		c.LimitAgentMetrics = n.LimitAgentMetrics
+		changed = append(changed, "limit_agent_mps")
+	}
+	if c.LimitRequests != n.LimitRequests {
+		c.LimitRequests = n.LimitRequests
+		changed = append(changed, "limit_rps")
+	}
+	if c.LimitMetrics != n.LimitMetrics {
+		c.LimitMetrics = n.LimitMetrics
+		changed = append(changed, "limit_mps")
+	}
+	if c.LimitBurst != n.LimitBurst {
+		c.LimitBurst = n.LimitBurst
+		changed = append(changed, "limit_burst")
+	}
+	if c.LimitRealIP != n.LimitRealIP {
+		c.LimitRealIP = n.LimitRealIP
+		changed = append(changed, "limit_real_ip")
+	}
+	c.Limit.m.Unlock()
+
+	c.StorageConfig.m.Lock()
+	if c.FileStoreInterval != n.FileStoreInterval {
+		c.FileStoreInterval = n.FileStoreInterval
+		changed = append(changed, "file_store_interval")
+	}
+	if c.SnapshotKeep != n.SnapshotKeep {
+		c.SnapshotKeep = n.SnapshotKeep
+		changed = append(changed, "snapshot_keep")
+	}
+	if c.SnapshotGzip != n.SnapshotGzip {
+		c.SnapshotGzip = n.SnapshotGzip
+		changed = append(changed, "snapshot_gzip")
+	}
+	if c.StorageKey != n.StorageKey || c.StorageKeyFile != n.StorageKeyFile || !c.storageKeys.Equal(n.GetStorageKeys()) {
+		c.StorageKey = n.StorageKey
+		c.StorageKeyFile = n.StorageKeyFile
+		c.storageKeys = n.GetStorageKeys()
+		changed = append(changed, "storage_key")
+	}
+	if c.WALSync != n.WALSync {
+		c.WALSync = n.WALSync
+		changed = append(changed, "wal_sync")
+	}
+	if c.WriteBehindFlush != n.WriteBehindFlush {
+		c.WriteBehindFlush = n.WriteBehindFlush
+		changed = append(changed, "write_behind_flush")
+	}
+	if c.WriteBehindMax != n.WriteBehindMax {
+		c.WriteBehindMax = n.WriteBehindMax
+		changed = append(changed, "write_behind_max")
+	}
+	if c.DBFallbackMaxSize != n.DBFallbackMaxSize {
+		c.DBFallbackMaxSize = n.DBFallbackMaxSize
+		changed = append(changed, "db_fallback_max_size")
+	}
+	if c.BreakerFailures != n.BreakerFailures {
+		c.BreakerFailures = n.BreakerFailures
+		changed = append(changed, "breaker_failures")
+	}
+	if c.BreakerOpen != n.BreakerOpen {
+		c.BreakerOpen = n.BreakerOpen
+		changed = append(changed, "breaker_open")
+	}
+	if c.BreakerTimeout != n.BreakerTimeout {
+		c.BreakerTimeout = n.BreakerTimeout
+		changed = append(changed, "breaker_timeout")
+	}
+	if c.AuditMaxSize != n.AuditMaxSize {
+		c.AuditMaxSize = n.AuditMaxSize
+		changed = append(changed, "audit_max_size")
+	}
+	if c.AuditMaxFiles != n.AuditMaxFiles {
+		c.AuditMaxFiles = n.AuditMaxFiles
+		changed = append(changed, "audit_max_files")
+	}
+	if c.MaxSeries != n.MaxSeries {
+		c.MaxSeries = n.MaxSeries
+		changed = append(changed, "max_series")
+	}
+	if c.MaxTenantSeries != n.MaxTenantSeries {
+		c.MaxTenantSeries = n.MaxTenantSeries
+		changed = append(changed, "max_tenant_series")
+	}
+	if c.MaxAgentSeries != n.MaxAgentSeries {
+		c.MaxAgentSeries = n.MaxAgentSeries
+		changed = append(changed, "max_agent_series")
+	}
+	if c.MaxNameLength != n.MaxNameLength {
+		c.MaxNameLength = n.MaxNameLength
+		changed = append(changed, "max_name_length")
+	}
+	c.StorageConfig.m.Unlock()
+	return
+}
+
+// load config from env and config file, config file values is overridden by flags and env
+func (c *Config) load() error {
 	err := c.ParseEnv()
 	if ok, er := c.maybeLoadConfig(); ok && er == nil {
 		// reload flag and env after config file
@@ -82,11 +355,41 @@ func (c *Config) Init() (*Config, error) {
 			err = errors.Join(err, er)
 		}
 	}
+	switch c.WALSync {
+	case constant.WALSyncAlways, constant.WALSyncEverySec, constant.WALSyncNo:
+	default:
+		err = errors.Join(err, fmt.Errorf("unknown wal sync policy %q", c.WALSync))
+	}
+	if c.StorageURL != "" {
+		if u, er := url.Parse(c.StorageURL); er != nil {
+			err = errors.Join(err, er)
+		} else if u.Scheme == "" {
+			err = errors.Join(err, fmt.Errorf("no driver scheme at storage url %q", c.StorageURL))
+		}
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") || (c.TLSClientCA != "" && c.TLSCert == "") {
+		err = errors.Join(err, errors.New("tls certificate and key are required for tls"))
+	}
 
-	err = errors.Join(err, c.LoadPrivateKey())
+	err = errors.Join(err, c.LoadPrivateKey(), c.LoadStorageKeys())
 	c.CleanSchemes()
 
-	return c, err
+	return err
+}
+
+// StorageDriver scheme of storage driver and its data source name: storage url, database dsn,
+// directory of embedded disk store or memory store
+func (c *Config) StorageDriver() (scheme, dsn string) {
+	switch {
+	case c.StorageURL != "":
+		scheme, _, _ = strings.Cut(c.StorageURL, ":")
+		return scheme, c.StorageURL
+	case c.DatabaseDSN != "":
+		return constant.StorageDriverPostgres, c.DatabaseDSN
+	case c.DiskStoragePath != "":
+		return constant.StorageDriverFile, c.DiskStoragePath
+	}
+	return constant.StorageDriverMem, ""
 }
 
 // ParseEnv gets ENV configs
@@ -132,10 +435,172 @@ func (c *Config) CleanSchemes() *Config {
 	return c
 }
 
-func (c *WEB) GetPrivateKey() *rsa.PrivateKey {
+// GetPrivateKey private key for decryption: *rsa.PrivateKey, *ecdsa.PrivateKey or *ecdh.PrivateKey
+func (c *WEB) GetPrivateKey() crypto.PrivateKey {
+	c.m.RLock()
+	defer c.m.RUnlock()
 	return c.cryptoKey
 }
 
+func samePrivateKey(a, b crypto.PrivateKey) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	k, ok := a.(interface{ Equal(crypto.PrivateKey) bool })
+	return ok && k.Equal(b)
+}
+
+// GetKey sign key
+func (c *WEB) GetKey() string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.Key
+}
+
+// GetTrustedSubnet trusted subnet of agents
+func (c *WEB) GetTrustedSubnet() string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.TrustedSubnet
+}
+
+// GetLimits ingestion rate limits
+func (c *Limit) GetLimits() limiter.Limits {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return limiter.Limits{
+		KeyRequests: c.LimitAgentRequests,
+		KeyMetrics:  c.LimitAgentMetrics,
+		Requests:    c.LimitRequests,
+		Metrics:     c.LimitMetrics,
+		Burst:       c.LimitBurst,
+	}
+}
+
+// GetLimitRealIP is agent ip of limits taken from X-Real-IP header
+func (c *Limit) GetLimitRealIP() bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.LimitRealIP
+}
+
+// GetSignSkew allowed clock skew of signed requests
+func (c *WEB) GetSignSkew() time.Duration {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return time.Duration(c.SignSkew) * time.Second
+}
+
+// GetSignLegacy is body only signatures of old agents allowed
+func (c *WEB) GetSignLegacy() bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.SignLegacy
+}
+
+// GetAdminToken admin api token
+func (c *WEB) GetAdminToken() string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.AdminToken
+}
+
+// GetGRPCToken grpc service token
+func (c *GRPC) GetGRPCToken() string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.GRPCToken
+}
+
+// GetFileStoreInterval interval of store save to file
+func (c *StorageConfig) GetFileStoreInterval() int {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.FileStoreInterval
+}
+
+// GetSnapshot number of previous storage file generations and storage file compression
+func (c *StorageConfig) GetSnapshot() (keep int, gzip bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.SnapshotKeep, c.SnapshotGzip
+}
+
+// GetWriteBehind flush interval in seconds and number of series of write-behind buffer
+func (c *StorageConfig) GetWriteBehind() (flush, limit int) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.WriteBehindFlush, c.WriteBehindMax
+}
+
+// GetDBFallbackMaxSize size of database fallback buffer in megabytes
+func (c *StorageConfig) GetDBFallbackMaxSize() int {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.DBFallbackMaxSize
+}
+
+// GetBreaker number of failures, at which circuit breaker is opened, seconds of open state and of operation timeout
+func (c *StorageConfig) GetBreaker() (failures, open, timeout int) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.BreakerFailures, c.BreakerOpen, c.BreakerTimeout
+}
+
+// GetStorageKeys keys of storage encryption, nil if storage is not encrypted
+func (c *StorageConfig) GetStorageKeys() *keyring.Keyring {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.storageKeys
+}
+
+// LoadStorageKeys load keys of storage encryption from keys and keys file
+func (c *StorageConfig) LoadStorageKeys() (err error) {
+	keys := c.StorageKey
+	if c.StorageKeyFile != "" {
+		var b []byte
+		if b, err = os.ReadFile(c.StorageKeyFile); err != nil {
+			return
+		}
+		keys += "\n" + string(b)
+	}
+	var k *keyring.Keyring
+	if k, err = keyring.Parse(keys); err != nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.storageKeys = k
+	return
+}
+
+// GetWALSync fsync policy of write-ahead log
+func (c *StorageConfig) GetWALSync() string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.WALSync
+}
+
+// GetAuditRotation size of audit log file in bytes, after which file is rotated, and number of rotated files
+func (c *StorageConfig) GetAuditRotation() (size int64, files int) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return int64(c.AuditMaxSize) << 20, c.AuditMaxFiles
+}
+
+// GetQuotas series cardinality limits
+func (c *StorageConfig) GetQuotas() domain.Quotas {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return domain.Quotas{
+		Series:       c.MaxSeries,
+		TenantSeries: c.MaxTenantSeries,
+		AgentSeries:  c.MaxAgentSeries,
+		NameLength:   c.MaxNameLength,
+	}
+}
+
+// LoadPrivateKey load private key, key type is detected from PEM block
 func (c *WEB) LoadPrivateKey() error {
 	if c.CryptoKey != "" {
 		b, err := os.ReadFile(c.CryptoKey)
@@ -143,12 +608,9 @@ func (c *WEB) LoadPrivateKey() error {
 			return err
 		}
 
-		spkiBlock, _ := pem.Decode(b)
-		cert, err := x509.ParsePKCS1PrivateKey(spkiBlock.Bytes)
-		if err != nil {
+		if c.cryptoKey, err = envelope.ParsePrivateKeyPEM(b); err != nil {
 			return err
 		}
-		c.cryptoKey = cert
 	}
 	return nil
 }
@@ -16,21 +16,106 @@ const (
 
 	GRPCAddress = ":3200"
 
-	UpdateRoute      = "/update"
-	UpdatesRoute     = "/updates"
-	ValueRoute       = "/value"
-	MetricTypeParam  = "metricType"
-	MetricNameParam  = "metricName"
-	MetricValueParam = "metricValue"
+	AgentMissingIntervals = 3
+	AgentsCheckInterval   = 5
+
+	CertsCheckInterval = 5
+
+	SignSkew = 300
+
+	// KeyRotateOverlap seconds of old keys validity after rotation
+	KeyRotateOverlap = 3600
+
+	// LimitBurst seconds of rate limits, bucket capacity
+	LimitBurst = 1
+
+	// SnapshotFormat name of storage file format at header
+	SnapshotFormat = "metrics-snapshot"
+	// SnapshotVersion version of storage file format
+	SnapshotVersion = 1
+	// SnapshotKeep number of previous storage file generations
+	SnapshotKeep = 2
+
+	// WALSyncAlways fsync write-ahead log on every append
+	WALSyncAlways = "always"
+	// WALSyncEverySec fsync write-ahead log at most once a second
+	WALSyncEverySec = "everysec"
+	// WALSyncNo write-ahead log is synced by os
+	WALSyncNo = "no"
+
+	// StorageDriverMem url scheme of memory store driver
+	StorageDriverMem = "mem"
+	// StorageDriverFile url scheme of embedded disk store driver
+	StorageDriverFile = "file"
+	// StorageDriverPostgres url scheme of postgres database driver
+	StorageDriverPostgres = "postgres"
+
+	// WriteBehindFlush seconds of write-behind buffer flush interval
+	WriteBehindFlush = 1
+	// WriteBehindMax number of series of write-behind buffer, at which it is flushed
+	WriteBehindMax = 10000
+
+	// DBFallbackMaxSize megabytes of database fallback buffer
+	DBFallbackMaxSize = 100
+	// DBFallbackCheck seconds between database checks of degraded mode
+	DBFallbackCheck = 5
+
+	// BreakerFailures consecutive database failures, at which circuit breaker is opened
+	BreakerFailures = 5
+	// BreakerOpen seconds of circuit breaker open state
+	BreakerOpen = 10
+	// BreakerTimeout seconds of database operation timeout
+	BreakerTimeout = 5
+
+	// DiskStorageFile data log file name at embedded disk store directory
+	DiskStorageFile = "metrics.log"
+	// DiskCompactMin number of outdated records at data log of disk store, before which it is not compacted
+	DiskCompactMin = 1000
+
+	// AuditMaxSize megabytes of audit log file before rotation
+	AuditMaxSize = 10
+	// AuditMaxFiles number of rotated audit log files
+	AuditMaxFiles = 5
+	// AuditQueryLimit default number of audit records at query
+	AuditQueryLimit = 1000
+
+	UpdateRoute       = "/update"
+	UpdatesRoute      = "/updates"
+	ValueRoute        = "/value"
+	AgentsRoute       = "/api/v1/agents"
+	AgentConfigRoute  = "/config"
+	AdminKeysRoute    = "/api/v1/admin/keys"
+	AuditRoute        = "/api/v1/audit"
+	AdminStoreRoute   = "/api/v1/admin/store"
+	AdminBreakerRoute = "/api/v1/admin/store/breaker"
+	KeyIDParam        = "keyID"
+	MetricTypeParam   = "metricType"
+	MetricNameParam   = "metricName"
+	MetricValueParam  = "metricValue"
 
 	MetricTypeGauge   = "gauge"
 	MetricTypeCounter = "counter"
 
-	DBTableNameGauges   = "gauges"
-	DBTableNameCounters = "counters"
+	DBTableNameGauges      = "gauges"
+	DBTableNameCounters    = "counters"
+	DBTableNameCredentials = "credentials"
+	DBTableNameAudit       = "audit"
+	DBTableNameFallback    = "fallback_batches"
 
 	HeaderSignKey = "HashSHA256"
 	HeaderXRealIP = "X-Real-IP"
+	HeaderKeyID   = "Key-Id"
+
+	HeaderAuthorization = "Authorization"
+	BearerPrefix        = "Bearer "
+	HeaderRetryAfter    = "Retry-After"
+	HeaderTenant        = "X-Tenant"
+
+	HeaderAgentID       = "X-Agent-Id"
+	HeaderAgentHostname = "X-Agent-Hostname"
+	HeaderAgentVersion  = "X-Agent-Version"
+	HeaderAgentConfig   = "X-Agent-Config"
+	HeaderAgentGroup    = "X-Agent-Group"
 )
 
 var (
@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+)
+
+// Role access role, each role includes permissions of lower roles
+type Role string
+
+const (
+	RoleReader Role = "reader"
+	RoleWriter Role = "writer"
+	RoleAdmin  Role = "admin"
+)
+
+func (r Role) rank() int {
+	switch r {
+	case RoleReader:
+		return 1
+	case RoleWriter:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Principal api client bound to token or client certificate common name
+type Principal struct {
+	Name  string `json:"name"`
+	Token string `json:"token,omitempty"`
+	Cert  string `json:"cert,omitempty"`
+	Role  Role   `json:"role"`
+	// Prefixes metric names allowed to write, any name if empty
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Tenant namespace of principal requests, tenant is taken from request if empty
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Can is principal role includes role
+func (p *Principal) Can(role Role) bool {
+	return p.Role.rank() >= role.rank() && p.Role.rank() > 0
+}
+
+// CanWrite is principal allowed to write metric name
+func (p *Principal) CanWrite(name string) bool {
+	if !p.Can(RoleWriter) {
+		return false
+	}
+	if len(p.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range p.Prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessConfig access file: principals and role of requests without token and certificate
+type AccessConfig struct {
+	Anonymous  *Principal  `json:"anonymous,omitempty"`
+	Principals []Principal `json:"principals"`
+}
+
+// For resolve principal by token, then by certificate common name, then anonymous.
+// Unknown token is not resolved, principal without role is returned if anonymous is not set
+func (a AccessConfig) For(token, cert string) (p Principal, ok bool) {
+	if token != "" {
+		for _, v := range a.Principals {
+			if v.Token != "" && subtle.ConstantTimeCompare([]byte(v.Token), []byte(token)) == 1 {
+				p, ok = v, true
+			}
+		}
+		return
+	}
+	if cert != "" {
+		for _, v := range a.Principals {
+			if v.Cert == cert {
+				return v, true
+			}
+		}
+	}
+	if a.Anonymous != nil {
+		return *a.Anonymous, true
+	}
+	return Principal{Name: "anonymous"}, true
+}
+
+type principalKey struct{}
+
+// WithPrincipal put principal of request to context
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext get principal of request from context, nil if access is not checked
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
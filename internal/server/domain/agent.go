@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// AgentInfo agent identity received with agent request
+type AgentInfo struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	Version  string `json:"version"`
+	Config   string `json:"config"`
+	IP       string `json:"ip"`
+	Tenant   string `json:"tenant,omitempty"`
+}
+
+// ReportInterval agent report interval from config summary, zero if unknown
+func (a AgentInfo) ReportInterval() time.Duration {
+	v, err := url.ParseQuery(a.Config)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(v.Get("report_interval"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// Agent agents registry record
+type Agent struct {
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	LastError    string    `json:"last_error,omitempty"`
+	MetricsCount int64     `json:"metrics_count"`
+	Missing      bool      `json:"missing"`
+	AgentInfo
+}
+
+// IsMissing check is agent does not report more than intervals of its report interval
+func (a Agent) IsMissing(now time.Time, intervals int) bool {
+	interval := a.ReportInterval()
+	if intervals <= 0 || interval <= 0 {
+		return false
+	}
+	return now.Sub(a.LastSeen) > time.Duration(intervals)*interval
+}
+
+// AgentReport result of agent request for agents registry
+type AgentReport struct {
+	Err     error
+	Agent   AgentInfo
+	Metrics int
+}
+
+type agentReportKey struct{}
+
+// WithAgentReport put agent report to context, handlers fill it while serve request
+func WithAgentReport(ctx context.Context, r *AgentReport) context.Context {
+	return context.WithValue(ctx, agentReportKey{}, r)
+}
+
+// AgentReportFromContext get agent report from context, nil if request is not from agent
+func AgentReportFromContext(ctx context.Context) *AgentReport {
+	r, _ := ctx.Value(agentReportKey{}).(*AgentReport)
+	return r
+}
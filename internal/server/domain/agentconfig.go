@@ -0,0 +1,62 @@
+package domain
+
+// AgentConfig agent config served by server, only set fields override agent local config
+type AgentConfig struct {
+	GaugesList     []string `json:"gauges_list,omitempty"`
+	CountersList   []string `json:"counters_list,omitempty"`
+	ReportInterval *int     `json:"report_interval,omitempty"`
+	PollInterval   *int     `json:"poll_interval,omitempty"`
+	RateLimit      *int     `json:"rate_limit,omitempty"`
+	SendSize       *int     `json:"send_size,omitempty"`
+	Group          string   `json:"group,omitempty"`
+}
+
+// Merge return config with fields of o set over c
+func (c AgentConfig) Merge(o AgentConfig) AgentConfig {
+	if o.GaugesList != nil {
+		c.GaugesList = o.GaugesList
+	}
+	if o.CountersList != nil {
+		c.CountersList = o.CountersList
+	}
+	if o.ReportInterval != nil {
+		c.ReportInterval = o.ReportInterval
+	}
+	if o.PollInterval != nil {
+		c.PollInterval = o.PollInterval
+	}
+	if o.RateLimit != nil {
+		c.RateLimit = o.RateLimit
+	}
+	if o.SendSize != nil {
+		c.SendSize = o.SendSize
+	}
+	if o.Group != "" {
+		c.Group = o.Group
+	}
+	return c
+}
+
+// AgentsConfig agents configs file: default config, overridden by group config, overridden by agent config
+type AgentsConfig struct {
+	Groups  map[string]AgentConfig `json:"groups"`
+	Agents  map[string]AgentConfig `json:"agents"`
+	Default AgentConfig            `json:"default"`
+}
+
+// For resolve config for agent id, group from agent config wins over requested group
+func (a AgentsConfig) For(id, group string) (c AgentConfig) {
+	agent, ok := a.Agents[id]
+	if ok && agent.Group != "" {
+		group = agent.Group
+	}
+	c = a.Default
+	if g, ok := a.Groups[group]; ok {
+		c = c.Merge(g)
+	}
+	if ok {
+		c = c.Merge(agent)
+	}
+	c.Group = group
+	return
+}
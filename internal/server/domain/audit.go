@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// AuditRecord audit log record of write or admin operation
+type AuditRecord struct {
+	Time      time.Time `json:"time" db:"time"`
+	Tenant    string    `json:"tenant,omitempty" db:"tenant"`
+	Agent     string    `json:"agent,omitempty" db:"agent"`
+	IP        string    `json:"ip,omitempty" db:"ip"`
+	Principal string    `json:"principal,omitempty" db:"principal"`
+	// Action http method and route or rpc full method
+	Action string `json:"action" db:"action"`
+	// Metrics number of saved metrics
+	Metrics int `json:"metrics" db:"metrics"`
+	// Rejected number of rejected metrics
+	Rejected int `json:"rejected,omitempty" db:"rejected"`
+	// Reason of rejected request or metrics
+	Reason string `json:"reason,omitempty" db:"reason"`
+	// Status http status or grpc code name
+	Status string `json:"status" db:"status"`
+}
+
+// AuditFilter audit log query, zero values are not filtered
+type AuditFilter struct {
+	From  time.Time
+	To    time.Time
+	Agent string
+	Limit int
+}
+
+// Match is record matched by filter, limit is not checked
+func (f AuditFilter) Match(r AuditRecord) bool {
+	return (f.From.IsZero() || !r.Time.Before(f.From)) &&
+		(f.To.IsZero() || r.Time.Before(f.To)) &&
+		(f.Agent == "" || r.Agent == f.Agent)
+}
+
+type auditRecordKey struct{}
+
+// WithAuditRecord put audit record to context, handlers fill it while serve request
+func WithAuditRecord(ctx context.Context, r *AuditRecord) context.Context {
+	return context.WithValue(ctx, auditRecordKey{}, r)
+}
+
+// AuditRecordFromContext get audit record from context, nil if request is not audited
+func AuditRecordFromContext(ctx context.Context) *AuditRecord {
+	r, _ := ctx.Value(auditRecordKey{}).(*AuditRecord)
+	return r
+}
@@ -0,0 +1,43 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// Credential agent sign key, agent select it by Key-Id
+type Credential struct {
+	NotBefore time.Time  `json:"not_before" db:"not_before"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	NotAfter  *time.Time `json:"not_after,omitempty" db:"not_after"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	KeyID     string     `json:"key_id" db:"key_id"`
+	AgentID   string     `json:"agent_id" db:"agent_id"`
+	Secret    string     `json:"secret,omitempty" db:"secret"`
+}
+
+// Active is key not revoked and valid at now
+func (c Credential) Active(now time.Time) bool {
+	return c.RevokedAt == nil && !now.Before(c.NotBefore) && (c.NotAfter == nil || now.Before(*c.NotAfter))
+}
+
+// Public credential without secret
+func (c Credential) Public() Credential {
+	c.Secret = ""
+	return c
+}
+
+type signKeyKey struct{}
+
+// WithSignKey put sign key of request agent credential to context, responses are signed with it
+func WithSignKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, signKeyKey{}, key)
+}
+
+// SignKeyFromContext get sign key of agent credential from context, def if request is not signed by credential
+func SignKeyFromContext(ctx context.Context, def string) string {
+	if key, ok := ctx.Value(signKeyKey{}).(string); ok {
+		return key
+	}
+	return def
+}
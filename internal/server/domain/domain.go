@@ -18,6 +18,8 @@ type Metric struct {
 	Value *Gauge   `json:"value,omitempty" validate:"required_if=MType gauge,omitempty"`
 	ID    string   `json:"id" validate:"required"`
 	MType string   `json:"type" validate:"required,oneof=gauge counter"`
+	// Error reason of rejected metric at batch response
+	Error string `json:"error,omitempty" validate:"-"`
 }
 
 func (m Metric) String() (s string) {
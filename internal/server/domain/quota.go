@@ -0,0 +1,33 @@
+package domain
+
+import "context"
+
+// Quotas series cardinality limits, 0 - no limit
+type Quotas struct {
+	// Series total number of series
+	Series int
+	// TenantSeries number of series of one tenant
+	TenantSeries int
+	// AgentSeries number of series written by one agent
+	AgentSeries int
+	// NameLength max length of metric name
+	NameLength int
+}
+
+// Enabled is any quota set
+func (q Quotas) Enabled() bool {
+	return q.Series > 0 || q.TenantSeries > 0 || q.AgentSeries > 0 || q.NameLength > 0
+}
+
+type quotaOwnerKey struct{}
+
+// WithQuotaOwner put agent identity of series quotas to context
+func WithQuotaOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, quotaOwnerKey{}, owner)
+}
+
+// QuotaOwnerFromContext get agent identity of series quotas from context, empty if unknown
+func QuotaOwnerFromContext(ctx context.Context) string {
+	owner, _ := ctx.Value(quotaOwnerKey{}).(string)
+	return owner
+}
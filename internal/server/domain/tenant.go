@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"context"
+	"regexp"
+
+	myErr "go-musthave-metrics/internal/server/errors"
+)
+
+// DefaultTenant namespace of requests without tenant
+const DefaultTenant = ""
+
+var tenantName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// ResolveTenant tenant of request: tenant of principal or requested one.
+// Requested tenant must be empty or match tenant of principal if it is set
+func ResolveTenant(p *Principal, requested string) (string, error) {
+	if requested != "" && !tenantName.MatchString(requested) {
+		return "", myErr.ErrBadTenant
+	}
+	if p == nil || p.Tenant == "" {
+		return requested, nil
+	}
+	if requested != "" && requested != p.Tenant {
+		return "", myErr.ErrTenantDenied
+	}
+	return p.Tenant, nil
+}
+
+type tenantKey struct{}
+
+// WithTenant put tenant of request to context
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext get tenant of request from context, DefaultTenant if not set
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
@@ -1,7 +1,12 @@
 package errors
 
 import (
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/lib/pq"
@@ -11,8 +16,52 @@ var (
 	ErrNotExist      = errors.New("does not exist")
 	ErrNoDBConnected = errors.New("no DB connected")
 	ErrNotMemMode    = errors.New("no MemStore connected")
+	ErrKeyInactive   = errors.New("key is revoked or not valid at this time")
+	ErrUnknownToken  = errors.New("unknown access token")
+	ErrBadTenant     = errors.New("bad tenant name")
+	ErrTenantDenied  = errors.New("tenant is not allowed")
+
+	ErrUnknownDriver = errors.New("unknown storage driver")
+	ErrDBDegraded    = errors.New("database is unavailable, writes are buffered")
+	ErrFallbackFull  = errors.New("database is unavailable, fallback buffer is full")
+
+	ErrStoreUnavailable = errors.New("storage is unavailable")
+	ErrReadOnly         = errors.New("storage is opened read-only")
+	ErrCircuitOpen      = fmt.Errorf("%w: circuit breaker is open", ErrStoreUnavailable)
+
+	ErrSnapshotCorrupt  = errors.New("storage file is corrupt")
+	ErrSnapshotFallback = errors.New("storage file is corrupt, previous generation is restored")
+
+	ErrQuotaExceeded     = errors.New("quota exceeded")
+	ErrSeriesLimit       = fmt.Errorf("%w: series limit", ErrQuotaExceeded)
+	ErrAgentSeriesLimit  = fmt.Errorf("%w: agent series limit", ErrQuotaExceeded)
+	ErrTenantSeriesLimit = fmt.Errorf("%w: tenant series limit", ErrQuotaExceeded)
+	ErrNameTooLong       = fmt.Errorf("%w: metric name is too long", ErrQuotaExceeded)
 )
 
+// RetryError error of operation, which may be retried after time
+type RetryError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Err, e.After)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter time, after which failed operation may be retried
+func RetryAfter(err error) (after time.Duration, ok bool) {
+	var e *RetryError
+	if errors.As(err, &e) {
+		return e.After, true
+	}
+	return
+}
+
 func IsPQClass08Error(err error) (yes bool) {
 	if err == nil {
 		return
@@ -29,3 +78,16 @@ func IsPQClass08Error(err error) (yes bool) {
 	}
 	return
 }
+
+// IsDBUnavailable is error of lost or refused database connection or of open circuit breaker
+func IsDBUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var (
+		pqErr  *pq.Error
+		netErr net.Error
+	)
+	return errors.As(err, &pqErr) && IsPQClass08Error(pqErr) || errors.As(err, &netErr) ||
+		errors.Is(err, ErrStoreUnavailable) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
@@ -1,8 +1,13 @@
 package errors
 
 import (
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"net"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/lib/pq"
@@ -89,3 +94,85 @@ func TestIsPQClass08Error(t *testing.T) {
 		})
 	}
 }
+
+func TestIsDBUnavailable(t *testing.T) {
+	tests := []struct {
+		err     error
+		name    string
+		wantYes bool
+	}{
+		{
+			name:    "Nil",
+			err:     nil,
+			wantYes: false,
+		},
+		{
+			name:    "Class08",
+			err:     fmt.Errorf("query: %w", &pq.Error{Code: pgerrcode.ConnectionFailure}),
+			wantYes: true,
+		},
+		{
+			name:    "Connection refused",
+			err:     &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			wantYes: true,
+		},
+		{
+			name:    "Bad connection",
+			err:     driver.ErrBadConn,
+			wantYes: true,
+		},
+		{
+			name:    "Circuit open",
+			err:     &RetryError{Err: ErrCircuitOpen, After: time.Second},
+			wantYes: true,
+		},
+		{
+			name:    "Unique violation",
+			err:     &pq.Error{Code: pgerrcode.UniqueViolation},
+			wantYes: false,
+		},
+		{
+			name:    "some other error",
+			err:     errors.New("some other error"),
+			wantYes: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if gotYes := IsDBUnavailable(tt.err); gotYes != tt.wantYes {
+				t.Errorf("IsDBUnavailable() = %v, want %v", gotYes, tt.wantYes)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		err       error
+		name      string
+		wantAfter time.Duration
+		wantOk    bool
+	}{
+		{
+			name: "Nil",
+			err:  nil,
+		},
+		{
+			name:      "Wrapped",
+			err:       fmt.Errorf("get gauge: %w", &RetryError{Err: ErrCircuitOpen, After: 3 * time.Second}),
+			wantAfter: 3 * time.Second,
+			wantOk:    true,
+		},
+		{
+			name: "some other error",
+			err:  ErrCircuitOpen,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if gotAfter, gotOk := RetryAfter(tt.err); gotAfter != tt.wantAfter || gotOk != tt.wantOk {
+				t.Errorf("RetryAfter() = %v, %v, want %v, %v", gotAfter, gotOk, tt.wantAfter, tt.wantOk)
+			}
+		})
+	}
+}
@@ -2,17 +2,21 @@ package grpc
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	pb "go-musthave-metrics/internal/grpc/proto"
 	"go-musthave-metrics/internal/server/config"
 	"go-musthave-metrics/internal/server/constant"
 	"go-musthave-metrics/internal/server/domain"
 	myErr "go-musthave-metrics/internal/server/errors"
+	"go-musthave-metrics/internal/server/helper"
 	"go-musthave-metrics/internal/server/service"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type MetricsServer struct {
@@ -36,6 +40,7 @@ func pbSetFromMetric(metrics ...domain.Metric) (m []*pb.Metric) {
 		m[i] = &pb.Metric{
 			Id:    metrics[i].ID,
 			Mtype: metrics[i].MType,
+			Error: metrics[i].Error,
 		}
 		if metrics[i].Delta != nil {
 			m[i].Delta = int64(*metrics[i].Delta)
@@ -69,6 +74,8 @@ func (g *MetricsServer) GetMetric(ctx context.Context, in *pb.GetMetricRequest)
 	if err != nil {
 		if errors.Is(err, myErr.ErrNotExist) {
 			err = errors.Join(errors.New("metric not exist"), err)
+		} else if errors.Is(err, myErr.ErrStoreUnavailable) {
+			err = status.Error(codes.Unavailable, err.Error())
 		} else {
 			err = errors.Join(errors.New("server error"), err)
 			g.log.Error("Error get "+metric.MType, zap.Error(err))
@@ -88,9 +95,16 @@ func (g *MetricsServer) SetMetric(ctx context.Context, in *pb.SetMetricRequest)
 	request := in.GetMetric()
 	var metric domain.Metric
 	metricIn := metricSetFromPb(request)[0]
+	if err = canWrite(ctx, metricIn.ID); err != nil {
+		return
+	}
 	if metric, err = g.s.SetMetric(ctx, metricIn); err != nil {
 		if errors.As(err, &validator.ValidationErrors{}) {
 			err = errors.Join(errors.New("bad input data: "), err)
+		} else if errors.Is(err, myErr.ErrQuotaExceeded) {
+			err = status.Error(codes.FailedPrecondition, err.Error())
+		} else if errors.Is(err, myErr.ErrStoreUnavailable) {
+			err = status.Error(codes.Unavailable, err.Error())
 		} else {
 			err = errors.Join(errors.New("error set metric: "), err)
 			g.log.Error("Error set metric", zap.Error(err))
@@ -110,9 +124,20 @@ func (g *MetricsServer) SetMetrics(ctx context.Context, in *pb.SetMetricsRequest
 	request := in.GetMetric()
 	var metrics []domain.Metric
 	metricsIn := metricSetFromPb(request...)
+	names := make([]string, len(metricsIn))
+	for i, m := range metricsIn {
+		names[i] = m.ID
+	}
+	if err = canWrite(ctx, names...); err != nil {
+		return
+	}
 	if metrics, err = g.s.SetMetrics(ctx, metricsIn); err != nil {
 		if errors.As(err, &validator.ValidationErrors{}) {
 			err = errors.Join(errors.New("bad input data: "), err)
+		} else if errors.Is(err, myErr.ErrQuotaExceeded) {
+			err = status.Error(codes.FailedPrecondition, metrics[0].Error)
+		} else if errors.Is(err, myErr.ErrStoreUnavailable) {
+			err = status.Error(codes.Unavailable, err.Error())
 		} else {
 			err = errors.Join(errors.New("error set metrics: "), err)
 			g.log.Error("Error set metrics", zap.Error(err))
@@ -135,6 +160,8 @@ func (g *MetricsServer) GetMetrics(ctx context.Context, _ *pb.GetMetricsRequest)
 	if err != nil {
 		if errors.Is(err, myErr.ErrNotExist) {
 			err = errors.Join(errors.New("metrics not exist"), err)
+		} else if errors.Is(err, myErr.ErrStoreUnavailable) {
+			err = status.Error(codes.Unavailable, err.Error())
 		} else {
 			err = errors.Join(errors.New("server error"), err)
 			g.log.Error("Error get metrics", zap.Error(err))
@@ -148,3 +175,70 @@ func (g *MetricsServer) GetMetrics(ctx context.Context, _ *pb.GetMetricsRequest)
 
 	return
 }
+
+func (g *MetricsServer) GetAgents(ctx context.Context, _ *pb.GetAgentsRequest) (out *pb.GetAgentsResponse, err error) {
+	ctx, cancel := context.WithTimeout(ctx, constant.ServerOperationTimeout*time.Second)
+	defer cancel()
+	var agents []domain.Agent
+	if agents, err = g.s.GetAgents(ctx); err != nil {
+		err = errors.Join(errors.New("server error"), err)
+		g.log.Error("Error get agents", zap.Error(err))
+		return
+	}
+
+	out = &pb.GetAgentsResponse{
+		Agent: make([]*pb.Agent, len(agents)),
+	}
+	for i, a := range agents {
+		out.Agent[i] = &pb.Agent{
+			Id:           a.ID,
+			Hostname:     a.Hostname,
+			Version:      a.Version,
+			Config:       a.Config,
+			Ip:           a.IP,
+			FirstSeen:    a.FirstSeen.Unix(),
+			LastSeen:     a.LastSeen.Unix(),
+			LastError:    a.LastError,
+			MetricsCount: a.MetricsCount,
+			Missing:      a.Missing,
+		}
+	}
+
+	return
+}
+
+func (g *MetricsServer) GetAgentConfig(ctx context.Context, in *pb.GetAgentConfigRequest) (out *pb.GetAgentConfigResponse, err error) {
+	id := in.GetId()
+	if name := peerName(ctx); name != "" {
+		id = name
+	}
+	if id == "" {
+		err = errors.New("bad input data: agent id required")
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, constant.ServerOperationTimeout*time.Second)
+	defer cancel()
+	var cfg domain.AgentConfig
+	if cfg, err = g.s.GetAgentConfig(ctx, id, in.GetGroup()); err != nil {
+		if errors.Is(err, myErr.ErrNotExist) {
+			err = status.Error(codes.NotFound, "agent config not exist")
+		} else {
+			err = errors.Join(errors.New("server error"), err)
+			g.log.Error("Error get agent config", zap.Error(err))
+		}
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(cfg); err != nil {
+		err = errors.Join(errors.New("server error"), err)
+		g.log.Error("Error marshal agent config", zap.Error(err))
+		return
+	}
+
+	out = &pb.GetAgentConfigResponse{
+		Config: b,
+		Sign:   helper.SignData(domain.SignKeyFromContext(ctx, g.c.GetKey()), b),
+	}
+
+	return
+}
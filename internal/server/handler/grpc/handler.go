@@ -2,50 +2,76 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"go-musthave-metrics/internal/envelope"
 	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/server/certs"
 	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+	"go-musthave-metrics/internal/server/helper"
 	"go-musthave-metrics/internal/server/service"
+	"go-musthave-metrics/internal/sign"
+	"math"
+	"net"
+	"strconv"
+	"strings"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type Handler struct {
 	s   *service.Service
 	log *zap.Logger
 	c   *config.Config
+	v   *sign.Verifier
 }
 
 func NewServer(s *service.Service, c *config.Config, log *zap.Logger) *Handler {
 	return &Handler{
 		s:   s,
 		c:   c,
+		v:   sign.NewVerifier(),
 		log: log}
 }
 
-func (h *Handler) Handler() (s *grpc.Server) {
+func (h *Handler) Handler(serverOpts ...grpc.ServerOption) (s *grpc.Server) {
 
 	opts := []logging.Option{
 		logging.WithLogOnEvents(logging.FinishCall),
 	}
 
-	s = grpc.NewServer(grpc.ChainUnaryInterceptor(
+	s = grpc.NewServer(append(serverOpts, grpc.ChainUnaryInterceptor(
 		logging.UnaryServerInterceptor(h.interceptorLogger(h.log), opts...),
 		h.unaryInterceptor,
-	))
+		h.networkInterceptor,
+		h.accessInterceptor,
+		h.tenantInterceptor,
+		h.decryptInterceptor,
+		// metrics of decrypted request are audited
+		h.auditInterceptor,
+		h.signInterceptor,
+		h.limitInterceptor,
+		h.agentInterceptor,
+	))...)
 	pb.RegisterMetricsServer(s, NewMetricsServer(h.s, h.c, h.log))
 
 	return
 }
 
 func (h *Handler) unaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	if len(h.c.GRPCToken) > 0 {
+	if len(h.c.GetGRPCToken()) > 0 {
 		var token string
 		if md, ok := metadata.FromIncomingContext(ctx); ok {
 			if values := md.Get("token"); len(values) > 0 {
@@ -55,7 +81,7 @@ func (h *Handler) unaryInterceptor(ctx context.Context, req interface{}, _ *grpc
 		if len(token) == 0 {
 			return nil, status.Error(codes.Unauthenticated, `missing token`)
 		}
-		if token != h.c.GRPCToken {
+		if token != h.c.GetGRPCToken() {
 			return nil, status.Error(codes.Unauthenticated, `invalid token`)
 		}
 	}
@@ -63,6 +89,370 @@ func (h *Handler) unaryInterceptor(ctx context.Context, req interface{}, _ *grpc
 	return handler(ctx, req)
 }
 
+// networkInterceptor check peer address is at trusted subnet, client ip from x-real-ip metadata is checked too if it is sent
+func (h *Handler) networkInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if subnet := h.c.GetTrustedSubnet(); subnet != "" {
+		_, addr, err := net.ParseCIDR(subnet)
+		if err != nil {
+			h.log.Error("Error parseCIDR", zap.Error(err))
+			return nil, status.Error(codes.Internal, `bad trusted subnet`)
+		}
+		if ip := net.ParseIP(peerAddr(ctx)); ip == nil || !addr.Contains(ip) {
+			return nil, status.Error(codes.PermissionDenied, `ip is not trusted`)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		if realIP := metaValue(md, constant.HeaderXRealIP); realIP != "" {
+			if ip := net.ParseIP(realIP); ip == nil || !addr.Contains(ip) {
+				return nil, status.Error(codes.PermissionDenied, `ip is not trusted`)
+			}
+		}
+	}
+	return handler(ctx, req)
+}
+
+// methodRoles roles required by rpc methods, admin role is required by not listed methods
+var methodRoles = map[string]domain.Role{
+	"/service.Metrics/GetMetric":      domain.RoleReader,
+	"/service.Metrics/GetMetrics":     domain.RoleReader,
+	"/service.Metrics/GetAgents":      domain.RoleReader,
+	"/service.Metrics/SetMetric":      domain.RoleWriter,
+	"/service.Metrics/SetMetrics":     domain.RoleWriter,
+	"/service.Metrics/GetAgentConfig": domain.RoleWriter,
+}
+
+// accessInterceptor resolve principal by bearer token of authorization metadata or client certificate
+// and check role required by method if access is configured
+func (h *Handler) accessInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token, _ := strings.CutPrefix(metaValue(md, strings.ToLower(constant.HeaderAuthorization)), constant.BearerPrefix)
+	p, err := h.s.Principal(ctx, token, peerName(ctx))
+	switch {
+	case errors.Is(err, myErr.ErrUnknownToken):
+		return nil, status.Error(codes.Unauthenticated, `invalid access token`)
+	case err != nil:
+		h.log.Error("Error get principal", zap.Error(err))
+		return nil, status.Error(codes.Internal, `access check error`)
+	case p == nil:
+		return handler(ctx, req)
+	}
+	role, ok := methodRoles[info.FullMethod]
+	if !ok {
+		role = domain.RoleAdmin
+	}
+	if !p.Can(role) {
+		if p.Role == "" {
+			return nil, status.Error(codes.Unauthenticated, `missing access token`)
+		}
+		return nil, status.Error(codes.PermissionDenied, `method is not allowed`)
+	}
+	return handler(domain.WithPrincipal(ctx, p), req)
+}
+
+// tenantInterceptor resolve tenant of request: tenant of principal or x-tenant metadata
+func (h *Handler) tenantInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tenant, err := domain.ResolveTenant(domain.PrincipalFromContext(ctx), metaValue(md, strings.ToLower(constant.HeaderTenant)))
+	switch {
+	case errors.Is(err, myErr.ErrBadTenant):
+		return nil, status.Error(codes.InvalidArgument, `bad tenant`)
+	case err != nil:
+		return nil, status.Error(codes.PermissionDenied, `tenant is not allowed`)
+	}
+	return handler(domain.WithTenant(ctx, tenant), req)
+}
+
+// auditInterceptor write set metrics requests to audit log: agent, number of saved and rejected metrics and result
+func (h *Handler) auditInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	var n int
+	switch in := req.(type) {
+	case *pb.SetMetricRequest:
+		n = 1
+	case *pb.SetMetricsRequest:
+		n = len(in.GetMetric())
+	default:
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	rec := domain.AuditRecord{
+		Tenant: domain.TenantFromContext(ctx),
+		Agent:  agentID(ctx, md),
+		IP:     peerIP(ctx, md),
+		Action: info.FullMethod,
+	}
+	if p := domain.PrincipalFromContext(ctx); p != nil {
+		rec.Principal = p.Name
+	}
+	resp, err = handler(ctx, req)
+	st := status.Convert(err)
+	rec.Status = st.Code().String()
+	switch out, _ := resp.(*pb.SetMetricsResponse); {
+	case err != nil:
+		rec.Reason = st.Message()
+		if st.Code() == codes.FailedPrecondition {
+			// all metrics are rejected by quotas
+			rec.Rejected = n
+		}
+	case out != nil:
+		for _, m := range out.GetMetric() {
+			if m.GetError() == "" {
+				rec.Metrics++
+				continue
+			}
+			if rec.Rejected++; rec.Reason == "" {
+				rec.Reason = m.GetError()
+			}
+		}
+	default:
+		rec.Metrics = n
+	}
+	if er := h.s.WriteAudit(ctx, rec); er != nil {
+		h.log.Error("Error write audit", zap.Error(er))
+	}
+	return
+}
+
+// limitInterceptor limit set metrics requests of agent, agent is identified by verified certificate name,
+// agent of verified sign key or peer address, agent id and x-real-ip metadata are not trusted alone.
+// X-real-ip is used if it is enabled by config behind trusted proxy.
+// Seconds to wait are sent at retry-after trailer. Agent identity is put to context as owner of series quotas
+func (h *Handler) limitInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var n int
+	switch in := req.(type) {
+	case *pb.SetMetricRequest:
+		n = 1
+	case *pb.SetMetricsRequest:
+		n = len(in.GetMetric())
+	default:
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	key := peerName(ctx)
+	if key == "" && domain.SignKeyFromContext(ctx, "") != "" {
+		// key of agent credential is checked to belong to agent id
+		key = metaValue(md, constant.HeaderAgentID)
+	}
+	if ip := metaValue(md, constant.HeaderXRealIP); key == "" && ip != "" && h.c.GetLimitRealIP() {
+		key = ip
+	}
+	if key == "" {
+		key = peerAddr(ctx)
+	}
+	if wait := h.s.Allow(h.c.GetLimits(), key, 1, n); wait > 0 {
+		if err := grpc.SetTrailer(ctx, metadata.Pairs(strings.ToLower(constant.HeaderRetryAfter),
+			strconv.Itoa(int(math.Ceil(wait.Seconds()))))); err != nil {
+			h.log.Error("Error set retry-after", zap.Error(err))
+		}
+		return nil, status.Error(codes.ResourceExhausted, `rate limit exceeded`)
+	}
+	return handler(domain.WithQuotaOwner(ctx, key), req)
+}
+
+// canWrite check request principal is allowed to write metrics names
+func canWrite(ctx context.Context, names ...string) error {
+	p := domain.PrincipalFromContext(ctx)
+	if p == nil {
+		return nil
+	}
+	for _, name := range names {
+		if !p.CanWrite(name) {
+			return status.Error(codes.PermissionDenied, "metric is not allowed: "+name)
+		}
+	}
+	return nil
+}
+
+// signInterceptor check sign of signed requests and sign responses if config key present, not signed set metrics
+// requests are rejected.
+// Sign covers deterministic serialized request, full method name, timestamp and nonce.
+// Agent key is selected by key-id metadata, the key must be active and belong to the agent of request
+func (h *Handler) signInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	key := h.c.GetKey()
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keyID := metaValue(md, constant.HeaderKeyID); keyID != "" {
+		cred, err := h.s.ActiveCredential(ctx, keyID)
+		if err == nil && cred.AgentID != agentID(ctx, md) {
+			err = myErr.ErrKeyInactive
+		}
+		if err != nil {
+			h.log.Debug("Check sign key", zap.String("key_id", keyID), zap.Error(err))
+			return nil, status.Error(codes.Unauthenticated, `invalid key`)
+		}
+		if metaValue(md, constant.HeaderSignKey) == "" {
+			return nil, status.Error(codes.InvalidArgument, `missing sign`)
+		}
+		key = cred.Secret
+		ctx = domain.WithSignKey(ctx, key)
+	}
+	if key == "" {
+		return handler(ctx, req)
+	}
+	signature := metaValue(md, constant.HeaderSignKey)
+	if signature == "" && isWrite(req) {
+		h.log.Debug("Check sign", zap.Error(sign.ErrNoSign))
+		return nil, status.Error(codes.InvalidArgument, `missing sign`)
+	}
+	if signature != "" {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, `bad sign`)
+		}
+		data, err := (proto.MarshalOptions{Deterministic: true}).Marshal(msg)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, `bad sign`)
+		}
+		p := sign.Params{Method: sign.MethodGRPC, Path: info.FullMethod, Nonce: metaValue(md, sign.HeaderNonce), Body: data}
+		if p.Timestamp, err = sign.ParseTimestamp(metaValue(md, sign.HeaderTimestamp)); err == nil {
+			err = h.v.Verify(key, p, signature, h.c.GetSignSkew())
+		}
+		if err != nil {
+			h.log.Debug("Check sign", zap.Error(err))
+			return nil, status.Error(codes.InvalidArgument, `bad sign`)
+		}
+	}
+
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	if msg, ok := resp.(proto.Message); ok {
+		data, er := (proto.MarshalOptions{Deterministic: true}).Marshal(msg)
+		if er == nil {
+			er = grpc.SetHeader(ctx, metadata.Pairs(constant.HeaderSignKey, helper.SignData(key, data)))
+		}
+		if er != nil {
+			h.log.Error("Error sign response", zap.Error(er))
+		}
+	}
+	return resp, nil
+}
+
+// isWrite request sets metrics
+func isWrite(req interface{}) bool {
+	switch req.(type) {
+	case *pb.SetMetricRequest, *pb.SetMetricsRequest:
+		return true
+	}
+	return false
+}
+
+// decryptInterceptor open encrypted set metrics requests if config private key present.
+// Not encrypted set requests are rejected
+func (h *Handler) decryptInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	key := h.c.GetPrivateKey()
+	if key == nil {
+		return handler(ctx, req)
+	}
+	var (
+		in interface {
+			proto.Message
+			GetEncrypted() []byte
+			GetEncryption() string
+		}
+		out proto.Message
+	)
+	switch r := req.(type) {
+	case *pb.SetMetricRequest:
+		in, out = r, &pb.SetMetricRequest{}
+	case *pb.SetMetricsRequest:
+		in, out = r, &pb.SetMetricsRequest{}
+	default:
+		return handler(ctx, req)
+	}
+	if len(in.GetEncrypted()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, `request must be encrypted`)
+	}
+	data, err := envelope.Open(key, in.GetEncryption(), in.GetEncrypted())
+	if err != nil {
+		h.log.Debug("Decrypt request", zap.Error(err))
+		return nil, status.Error(codes.InvalidArgument, `can not decrypt request`)
+	}
+	if err = proto.Unmarshal(data, out); err != nil {
+		return nil, status.Error(codes.InvalidArgument, `can not unmarshal decrypted request`)
+	}
+	return handler(ctx, out)
+}
+
+// agentInterceptor register agents requests at agents registry
+func (h *Handler) agentInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	var n int
+	switch r := req.(type) {
+	case *pb.SetMetricRequest:
+		n = 1
+	case *pb.SetMetricsRequest:
+		n = len(r.GetMetric())
+	default:
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	report := domain.AgentReport{
+		Agent: domain.AgentInfo{
+			ID:       agentID(ctx, md),
+			Tenant:   domain.TenantFromContext(ctx),
+			Hostname: metaValue(md, constant.HeaderAgentHostname),
+			Version:  metaValue(md, constant.HeaderAgentVersion),
+			Config:   metaValue(md, constant.HeaderAgentConfig),
+			IP:       peerIP(ctx, md),
+		},
+	}
+	if report.Agent.ID == "" {
+		return handler(ctx, req)
+	}
+	if resp, err = handler(ctx, req); err != nil {
+		report.Err = err
+	} else {
+		report.Metrics = n
+	}
+	if er := h.s.AgentReport(ctx, report); er != nil {
+		h.log.Error("Error save agent report", zap.Error(er))
+	}
+	return
+}
+
+// metaValue first metadata value by key
+func metaValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// agentID agent identity: common name of agent certificate or agent id from metadata
+func agentID(ctx context.Context, md metadata.MD) string {
+	if name := peerName(ctx); name != "" {
+		return name
+	}
+	return metaValue(md, constant.HeaderAgentID)
+}
+
+// peerName common name of verified agent certificate, empty without mutual tls
+func peerName(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			return certs.PeerName(&info.State)
+		}
+	}
+	return ""
+}
+
+// peerIP ip of client, from x-real-ip metadata or peer address
+func peerIP(ctx context.Context, md metadata.MD) string {
+	if ip := metaValue(md, constant.HeaderXRealIP); ip != "" {
+		return ip
+	}
+	return peerAddr(ctx)
+}
+
+// peerAddr ip of peer address of connection
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
 // interceptorLogger adapts zap logger to interceptor logger.
 // This code is simple enough to be copied and not imported.
 func (h *Handler) interceptorLogger(l *zap.Logger) logging.Logger {
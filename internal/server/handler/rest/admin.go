@@ -0,0 +1,231 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// KeyRequest admin api request of agent key
+type KeyRequest struct {
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	// Overlap seconds of old keys validity after rotation
+	Overlap *int   `json:"overlap,omitempty"`
+	AgentID string `json:"agent_id"`
+}
+
+// GetKeys
+// get agents keys without secrets
+//
+//	GET http://server:port/api/v1/admin/keys?agent_id=agentID
+//	HEADERS Authorization: Bearer AdminToken
+func (h *Handler) GetKeys() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
+		defer cancel()
+
+		list, err := h.s.GetCredentials(ctx, r.URL.Query().Get("agent_id"))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			h.log.Error("Error get keys", zap.Error(err))
+			return
+		}
+		h.writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// CreateKey
+// create new agent key, the secret is returned only at this answer
+//
+//	POST http://server:port/api/v1/admin/keys
+//	HEADERS Authorization: Bearer AdminToken
+//	BODY {"agent_id": agentID, "not_before": time, "not_after": time}
+func (h *Handler) CreateKey() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req KeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AgentID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
+		defer cancel()
+
+		var notBefore time.Time
+		if req.NotBefore != nil {
+			notBefore = *req.NotBefore
+		}
+		c, err := h.s.CreateCredential(ctx, req.AgentID, notBefore, req.NotAfter)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			h.log.Error("Error create key", zap.Error(err))
+			return
+		}
+		h.log.Info("Agent key created", zap.String("agent", c.AgentID), zap.String("key_id", c.KeyID))
+		h.writeJSON(w, http.StatusCreated, c)
+	}
+}
+
+// RotateKey
+// create new agent key, other active keys of agent are valid for overlap seconds
+//
+//	POST http://server:port/api/v1/admin/keys/rotate
+//	HEADERS Authorization: Bearer AdminToken
+//	BODY {"agent_id": agentID, "overlap": seconds}
+func (h *Handler) RotateKey() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req KeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AgentID == "" ||
+			req.Overlap != nil && *req.Overlap < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
+		defer cancel()
+
+		overlap := constant.KeyRotateOverlap
+		if req.Overlap != nil {
+			overlap = *req.Overlap
+		}
+		c, err := h.s.RotateCredential(ctx, req.AgentID, time.Duration(overlap)*time.Second)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			h.log.Error("Error rotate key", zap.Error(err))
+			return
+		}
+		h.log.Info("Agent key rotated", zap.String("agent", c.AgentID), zap.String("key_id", c.KeyID))
+		h.writeJSON(w, http.StatusCreated, c)
+	}
+}
+
+// RevokeKey
+// revoke agent key
+//
+//	POST http://server:port/api/v1/admin/keys/{keyID}/revoke
+//	HEADERS Authorization: Bearer AdminToken
+func (h *Handler) RevokeKey() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
+		defer cancel()
+
+		c, err := h.s.RevokeCredential(ctx, chi.URLParam(r, constant.KeyIDParam))
+		if err != nil {
+			if errors.Is(err, myErr.ErrNotExist) {
+				w.WriteHeader(http.StatusNotFound)
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
+				h.log.Error("Error revoke key", zap.Error(err))
+			}
+			return
+		}
+		h.log.Info("Agent key revoked", zap.String("agent", c.AgentID), zap.String("key_id", c.KeyID))
+		h.writeJSON(w, http.StatusOK, c)
+	}
+}
+
+// GetAudit
+// get audit log records, last records if limit is exceeded
+//
+//	GET http://server:port/api/v1/audit?from=RFC3339&to=RFC3339&agent=agentID&limit=100
+//	HEADERS Authorization: Bearer AdminToken
+func (h *Handler) GetAudit() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			f   = domain.AuditFilter{Agent: r.URL.Query().Get("agent")}
+			err error
+		)
+		for name, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+			if v := r.URL.Query().Get(name); v != "" {
+				if *t, err = time.Parse(time.RFC3339, v); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
+		defer cancel()
+
+		list, err := h.s.GetAudit(ctx, f)
+		if err != nil {
+			if errors.Is(err, myErr.ErrNotExist) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			h.log.Error("Error get audit", zap.Error(err))
+			return
+		}
+		h.writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// GetStoreStats
+// state of write-behind buffer of store: pending series, lag in seconds of oldest not written change, flushes
+//
+//	GET http://server:port/api/v1/admin/store
+//	HEADERS Authorization: Bearer AdminToken
+func (h *Handler) GetStoreStats() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := h.s.WriteBehindStats()
+		if err != nil {
+			if errors.Is(err, myErr.ErrNotExist) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			h.log.Error("Error get store stats", zap.Error(err))
+			return
+		}
+		h.writeJSON(w, http.StatusOK, stats)
+	}
+}
+
+// GetBreakerStats
+// state of circuit breaker of database store: state, consecutive failures, seconds before probe, opens and rejected operations
+//
+//	GET http://server:port/api/v1/admin/store/breaker
+//	HEADERS Authorization: Bearer AdminToken
+func (h *Handler) GetBreakerStats() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := h.s.BreakerStats()
+		if err != nil {
+			if errors.Is(err, myErr.ErrNotExist) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			h.log.Error("Error get breaker stats", zap.Error(err))
+			return
+		}
+		h.writeJSON(w, http.StatusOK, stats)
+	}
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, code int, data any) {
+	out, err := json.Marshal(data)
+	if err != nil {
+		h.log.Error("Error marshal answer", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(code)
+	if _, err = w.Write(out); err != nil {
+		h.log.Error("Error return answer", zap.Error(err))
+	}
+}
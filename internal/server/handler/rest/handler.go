@@ -2,15 +2,16 @@ package rest
 
 import (
 	"compress/gzip"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 
 	"go-musthave-metrics/internal/server/config"
 	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+	"go-musthave-metrics/internal/server/helper"
 	"go-musthave-metrics/internal/server/service"
 
 	"github.com/go-chi/chi/v5"
@@ -36,13 +37,14 @@ func NewHandler(s *service.Service, c *config.Config, log *zap.Logger) *Handler
 		log: log}
 }
 
+// SignData hmac sha256 sign of data as hex string, empty if no key
 func SignData(key string, data []byte) string {
-	if key == "" {
-		return ""
-	}
-	h := hmac.New(sha256.New, []byte(key))
-	h.Write(data)
-	return hex.EncodeToString(h.Sum(nil))
+	return helper.SignData(key, data)
+}
+
+// signKey key of responses sign: key of request agent credential or config key
+func (h *Handler) signKey(r *http.Request) string {
+	return domain.SignKeyFromContext(r.Context(), h.c.GetKey())
 }
 
 func setHeaderSHA(r http.ResponseWriter, key string, data []byte) {
@@ -53,24 +55,108 @@ func setHeaderSHA(r http.ResponseWriter, key string, data []byte) {
 	r.Header().Set(constant.HeaderSignKey, sign)
 }
 
+// reportMetrics set number of received metrics to agent report if request is from agent and to audit record
+func reportMetrics(r *http.Request, n int) {
+	if report := domain.AgentReportFromContext(r.Context()); report != nil {
+		report.Metrics = n
+	}
+	if rec := domain.AuditRecordFromContext(r.Context()); rec != nil {
+		rec.Metrics = n
+	}
+}
+
+// reportError set request error to agent report if request is from agent and to audit record
+func reportError(r *http.Request, err error) {
+	if report := domain.AgentReportFromContext(r.Context()); report != nil {
+		report.Err = err
+	}
+	if rec := domain.AuditRecordFromContext(r.Context()); rec != nil {
+		rec.Reason = err.Error()
+	}
+}
+
+// reportRejected set number of metrics rejected by quotas and reason to audit record
+func reportRejected(r *http.Request, n int, reason string) {
+	if rec := domain.AuditRecordFromContext(r.Context()); rec != nil {
+		rec.Rejected, rec.Reason = n, reason
+	}
+}
+
+// canWrite check request principal is allowed to write metrics names, answer forbidden if not
+func (h *Handler) canWrite(w http.ResponseWriter, r *http.Request, names ...string) bool {
+	p := domain.PrincipalFromContext(r.Context())
+	if p == nil {
+		return true
+	}
+	for _, name := range names {
+		if !p.CanWrite(name) {
+			reportError(r, fmt.Errorf("metric %s is not allowed for %s", name, p.Name))
+			w.WriteHeader(http.StatusForbidden)
+			if _, err := w.Write([]byte("Metric is not allowed: " + name)); err != nil {
+				h.log.Error("Error return answer", zap.Error(err))
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// allowMetrics check metrics rate limit of agent, answer too many requests if exceeded
+func (h *Handler) allowMetrics(w http.ResponseWriter, r *http.Request, n int) bool {
+	if wait := h.s.Allow(h.c.GetLimits(), limitKey(r, h.c.GetLimitRealIP()), 0, n); wait > 0 {
+		reportError(r, fmt.Errorf("metrics rate limit exceeded, retry after %v", wait))
+		tooManyRequests(w, wait)
+		return false
+	}
+	return true
+}
+
+// quotaExceeded answer unprocessable entity with reason if metric is rejected by quotas
+func (h *Handler) quotaExceeded(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, myErr.ErrQuotaExceeded) {
+		return false
+	}
+	reportError(r, err)
+	reportRejected(r, 1, err.Error())
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	if _, er := w.Write([]byte(err.Error())); er != nil {
+		h.log.Error("Error return answer", zap.Error(er))
+	}
+	return true
+}
+
+// storeUnavailable answer service unavailable with retry time if storage operations fail fast by circuit breaker
+func (h *Handler) storeUnavailable(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, myErr.ErrStoreUnavailable) {
+		return false
+	}
+	reportError(r, err)
+	wait, _ := myErr.RetryAfter(err)
+	serviceUnavailable(w, wait)
+	return true
+}
+
 // Handler
 // init app routes
 func (h *Handler) Handler() http.Handler {
 	h.app.Use(Logger(h.log))
 	h.app.Use(middleware.Compress(gzip.DefaultCompression, "application/json", "text/html"))
-	h.app.Use(Decrypt(h.c.GetPrivateKey(), h.log))
+	h.app.Use(Decrypt(&h.c.WEB, h.log))
 	h.app.Use(Decompress(h.log))
-	h.app.Use(CheckSign(&h.c.WEB, h.log))
+	h.app.Use(CheckSign(&h.c.WEB, h.s, h.log))
 	h.app.Use(CheckNetwork(&h.c.WEB, h.log))
+	h.app.Use(Authenticate(&h.c.WEB, h.s, h.log))
+	h.app.Use(Tenant())
 
-	h.app.Mount("/debug", middleware.Profiler())
+	h.app.With(Authorize(domain.RoleAdmin)).Mount("/debug", middleware.Profiler())
 
 	h.app.With(TextHeader()).Route("/", func(r chi.Router) {
-		r.Get("/", h.GetListMetrics())
+		r.With(Authorize(domain.RoleReader)).Get("/", h.GetListMetrics())
 		r.Get("/ping", h.GetDBPing())
 	})
 
 	h.app.Route(constant.UpdateRoute, func(r chi.Router) {
+		r.Use(Audit(h.s, h.log), Authorize(domain.RoleWriter), RateLimit(&h.c.Limit, h.s), AgentTrack(h.s, h.log))
 		r.With(TextHeader()).Post(fmt.Sprintf("/{%s}/{%s}/{%s}",
 			constant.MetricTypeParam, constant.MetricNameParam, constant.MetricValueParam),
 			h.UpdateMetric())
@@ -79,15 +165,34 @@ func (h *Handler) Handler() http.Handler {
 	})
 
 	h.app.Route(constant.UpdatesRoute, func(r chi.Router) {
+		r.Use(Audit(h.s, h.log), Authorize(domain.RoleWriter), RateLimit(&h.c.Limit, h.s), AgentTrack(h.s, h.log))
 		r.With(JSONHeader()).Post("/", h.UpdateMetrics())
 	})
 
 	h.app.Route(constant.ValueRoute, func(r chi.Router) {
+		r.Use(Authorize(domain.RoleReader))
 		r.With(TextHeader()).Get(fmt.Sprintf("/{%s}/{%s}",
 			constant.MetricTypeParam, constant.MetricNameParam), h.GetMetric())
 
 		r.With(JSONHeader()).Post("/", h.GetMetricJSON())
 	})
 
+	h.app.Route(constant.AgentsRoute, func(r chi.Router) {
+		r.With(Authorize(domain.RoleReader), JSONHeader()).Get("/", h.GetAgents())
+		r.With(Authorize(domain.RoleWriter), JSONHeader()).Get(constant.AgentConfigRoute, h.GetAgentConfig())
+	})
+
+	h.app.Route(constant.AdminKeysRoute, func(r chi.Router) {
+		r.Use(Audit(h.s, h.log), AdminAuth(&h.c.WEB), JSONHeader())
+		r.Get("/", h.GetKeys())
+		r.Post("/", h.CreateKey())
+		r.Post("/rotate", h.RotateKey())
+		r.Post(fmt.Sprintf("/{%s}/revoke", constant.KeyIDParam), h.RevokeKey())
+	})
+
+	h.app.With(AdminAuth(&h.c.WEB), JSONHeader()).Get(constant.AuditRoute, h.GetAudit())
+	h.app.With(AdminAuth(&h.c.WEB), JSONHeader()).Get(constant.AdminStoreRoute, h.GetStoreStats())
+	h.app.With(AdminAuth(&h.c.WEB), JSONHeader()).Get(constant.AdminBreakerRoute, h.GetBreakerStats())
+
 	return h.app
 }
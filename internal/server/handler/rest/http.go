@@ -29,14 +29,14 @@ func (h *Handler) GetMetric() func(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			if errors.Is(err, myErr.ErrNotExist) {
 				w.WriteHeader(http.StatusNotFound)
-			} else {
+			} else if !h.storeUnavailable(w, r, err) {
 				w.WriteHeader(http.StatusInternalServerError)
 				h.log.Error("Error get "+metric.MType, zap.Error(err))
 			}
 			return
 		}
 
-		setHeaderSHA(w, h.c.Key, []byte(metric.String()))
+		setHeaderSHA(w, h.signKey(r), []byte(metric.String()))
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write([]byte(metric.String())); err != nil {
 			h.log.Error("Error return answer", zap.Error(err))
@@ -63,7 +63,7 @@ func (h *Handler) GetMetricJSON() func(w http.ResponseWriter, r *http.Request) {
 		if metric, err = h.s.GetMetric(ctx, metric.MType, metric.ID); err != nil {
 			if errors.Is(err, myErr.ErrNotExist) {
 				w.WriteHeader(http.StatusNotFound)
-			} else {
+			} else if !h.storeUnavailable(w, r, err) {
 				w.WriteHeader(http.StatusInternalServerError)
 				h.log.Error("Error get "+metric.MType, zap.Error(err))
 			}
@@ -76,7 +76,7 @@ func (h *Handler) GetMetricJSON() func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		setHeaderSHA(w, h.c.Key, out)
+		setHeaderSHA(w, h.signKey(r), out)
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write(out); err != nil {
 			h.log.Error("Error return answer", zap.Error(err))
@@ -94,12 +94,15 @@ func (h *Handler) GetListMetrics() func(w http.ResponseWriter, r *http.Request)
 		defer cancel()
 
 		html, err := h.s.GetMetricsHTMLPage(ctx)
+		if h.storeUnavailable(w, r, err) {
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			h.log.Error("Error get html page", zap.Error(err))
 		}
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		setHeaderSHA(w, h.c.Key, html)
+		setHeaderSHA(w, h.signKey(r), html)
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write(html); err != nil {
 			h.log.Error("Error return answer", zap.Error(err))
@@ -108,20 +111,33 @@ func (h *Handler) GetListMetrics() func(w http.ResponseWriter, r *http.Request)
 }
 
 // GetDBPing
-// check is db ready
+// check is db ready, degraded status is answered while writes are buffered locally,
+// service unavailable while circuit breaker is open
 //
 //	GET http://server:port/ping
 func (h *Handler) GetDBPing() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
 		defer cancel()
-		if err := h.s.CheckDB(ctx); err != nil {
+		out := []byte("Status: ok")
+		if err := h.s.CheckDB(ctx); errors.Is(err, myErr.ErrDBDegraded) {
+			// writes are buffered until database is available
+			h.log.Warn("Ping", zap.Error(err))
+			out = []byte("Status: degraded")
+		} else if wait, ok := myErr.RetryAfter(err); ok && errors.Is(err, myErr.ErrCircuitOpen) {
+			// database operations fail fast until probe
+			h.log.Warn("Ping", zap.Error(err))
+			serviceUnavailable(w, wait)
+			if _, err = w.Write([]byte("Status: circuit open")); err != nil {
+				h.log.Error("Error return answer", zap.Error(err))
+			}
+			return
+		} else if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			h.log.Error("Error ping", zap.Error(err))
 			return
 		}
-		out := []byte("Status: ok")
-		setHeaderSHA(w, h.c.Key, out)
+		setHeaderSHA(w, h.signKey(r), out)
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write(out); err != nil {
 			h.log.Error("Error return answer", zap.Error(err))
@@ -136,6 +152,9 @@ func (h *Handler) GetDBPing() func(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) UpdateMetric() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		action, metricKey, metricValStr := chi.URLParam(r, constant.MetricTypeParam), chi.URLParam(r, constant.MetricNameParam), chi.URLParam(r, constant.MetricValueParam)
+		if !h.canWrite(w, r, metricKey) || !h.allowMetrics(w, r, 1) {
+			return
+		}
 		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
 		defer cancel()
 		var err error
@@ -150,6 +169,9 @@ func (h *Handler) UpdateMetric() func(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			if err = h.s.SetGauge(ctx, metricKey, v); err != nil {
+				if h.quotaExceeded(w, r, err) || h.storeUnavailable(w, r, err) {
+					return
+				}
 				w.WriteHeader(http.StatusInternalServerError)
 				h.log.Error("Error set gauge", zap.Error(err))
 				return
@@ -164,6 +186,9 @@ func (h *Handler) UpdateMetric() func(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			if err = h.s.IncreaseCounter(ctx, metricKey, v); err != nil {
+				if h.quotaExceeded(w, r, err) || h.storeUnavailable(w, r, err) {
+					return
+				}
 				w.WriteHeader(http.StatusInternalServerError)
 				h.log.Error("Error set counter", zap.Error(err))
 				return
@@ -175,8 +200,9 @@ func (h *Handler) UpdateMetric() func(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
+		reportMetrics(r, 1)
 		out := []byte("Saved: Ok")
-		setHeaderSHA(w, h.c.Key, out)
+		setHeaderSHA(w, h.signKey(r), out)
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write(out); err != nil {
 			h.log.Error("Error return answer", zap.Error(err))
@@ -200,28 +226,33 @@ func (h *Handler) UpdateMetricJSON() func(w http.ResponseWriter, r *http.Request
 			}
 			return
 		}
+		if !h.canWrite(w, r, metric.ID) || !h.allowMetrics(w, r, 1) {
+			return
+		}
 		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
 		defer cancel()
 
 		if metric, err = h.s.SetMetric(ctx, metric); err != nil {
+			reportError(r, err)
 			if errors.As(err, &validator.ValidationErrors{}) {
 				w.WriteHeader(http.StatusBadRequest)
 				if _, err = w.Write([]byte("Bad input data: " + err.Error())); err != nil {
 					h.log.Error("Error return answer", zap.Error(err))
 				}
-			} else {
+			} else if !h.quotaExceeded(w, r, err) && !h.storeUnavailable(w, r, err) {
 				h.log.Error("Error set metric", zap.Error(err))
 				w.WriteHeader(http.StatusInternalServerError)
 			}
 			return
 		}
+		reportMetrics(r, 1)
 		var out []byte
 		if out, err = json.Marshal(metric); err != nil {
 			h.log.Error("Error marshal metric", zap.Error(err))
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		setHeaderSHA(w, h.c.Key, out)
+		setHeaderSHA(w, h.signKey(r), out)
 		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write(out); err != nil {
 			h.log.Error("Error return answer", zap.Error(err))
@@ -245,20 +276,53 @@ func (h *Handler) UpdateMetrics() func(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
+		names := make([]string, len(metrics))
+		for i, m := range metrics {
+			names[i] = m.ID
+		}
+		if !h.canWrite(w, r, names...) || !h.allowMetrics(w, r, len(metrics)) {
+			return
+		}
 		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
 		defer cancel()
 
+		code := http.StatusOK
 		if metrics, err = h.s.SetMetrics(ctx, metrics); err != nil {
+			reportError(r, err)
 			if errors.As(err, &validator.ValidationErrors{}) {
 				w.WriteHeader(http.StatusBadRequest)
 				if _, err = w.Write([]byte("Bad input data: " + err.Error())); err != nil {
 					h.log.Error("Error return answer", zap.Error(err))
 				}
-			} else {
+				return
+			}
+			if h.storeUnavailable(w, r, err) {
+				return
+			}
+			if !errors.Is(err, myErr.ErrQuotaExceeded) {
 				h.log.Error("Error set metric", zap.Error(err))
 				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			// all metrics are rejected, answer with reasons
+			code = http.StatusUnprocessableEntity
+		}
+		var (
+			saved, rejected int
+			reason          string
+		)
+		for _, m := range metrics {
+			if m.Error == "" {
+				saved++
+				continue
+			}
+			if rejected++; reason == "" {
+				reason = m.Error
 			}
-			return
+		}
+		reportMetrics(r, saved)
+		if rejected > 0 {
+			reportRejected(r, rejected, reason)
 		}
 		var out []byte
 		if out, err = json.Marshal(metrics); err != nil {
@@ -266,10 +330,78 @@ func (h *Handler) UpdateMetrics() func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		setHeaderSHA(w, h.c.Key, out)
-		w.WriteHeader(http.StatusOK)
+		setHeaderSHA(w, h.signKey(r), out)
+		w.WriteHeader(code)
 		if _, er := w.Write(out); er != nil {
 			h.log.Error("Error return answer", zap.Error(er))
 		}
 	}
 }
+
+// GetAgents
+// get list of known agents
+//
+//	GET http://server:port/api/v1/agents
+func (h *Handler) GetAgents() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
+		defer cancel()
+
+		agents, err := h.s.GetAgents(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			h.log.Error("Error get agents", zap.Error(err))
+			return
+		}
+		var out []byte
+		if out, err = json.Marshal(agents); err != nil {
+			h.log.Error("Error marshal agents", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		setHeaderSHA(w, h.signKey(r), out)
+		w.WriteHeader(http.StatusOK)
+		if _, err = w.Write(out); err != nil {
+			h.log.Error("Error return answer", zap.Error(err))
+		}
+	}
+}
+
+// GetAgentConfig
+// get config for requested agent, signed with key
+//
+//	GET http://server:port/api/v1/agents/config
+//	HEADERS X-Agent-Id, X-Agent-Group
+func (h *Handler) GetAgentConfig() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := agentID(r)
+		if id == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), constant.ServerOperationTimeout*time.Second)
+		defer cancel()
+
+		cfg, err := h.s.GetAgentConfig(ctx, id, r.Header.Get(constant.HeaderAgentGroup))
+		if err != nil {
+			if errors.Is(err, myErr.ErrNotExist) {
+				w.WriteHeader(http.StatusNotFound)
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
+				h.log.Error("Error get agent config", zap.Error(err))
+			}
+			return
+		}
+		var out []byte
+		if out, err = json.Marshal(cfg); err != nil {
+			h.log.Error("Error marshal agent config", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		setHeaderSHA(w, h.signKey(r), out)
+		w.WriteHeader(http.StatusOK)
+		if _, err = w.Write(out); err != nil {
+			h.log.Error("Error return answer", zap.Error(err))
+		}
+	}
+}
@@ -3,39 +3,63 @@ package rest
 import (
 	"bytes"
 	"compress/gzip"
-	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
-	"encoding/hex"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"go-musthave-metrics/internal/envelope"
+	"go-musthave-metrics/internal/server/certs"
 	"go-musthave-metrics/internal/server/config"
 	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+	"go-musthave-metrics/internal/server/service"
+	"go-musthave-metrics/internal/sign"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 )
 
-// Decrypt request content if config private key present
-func Decrypt(key *rsa.PrivateKey, l *zap.Logger) func(next http.Handler) http.Handler {
+// Decrypt request content if config private key present.
+// Body with envelope.Header is opened as envelope, without it as legacy rsa encrypted body (rsa keys only).
+// Not decryptable body is rejected
+func Decrypt(conf *config.WEB, l *zap.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-			if key != nil {
+			if key := conf.GetPrivateKey(); key != nil {
 				body, err := io.ReadAll(r.Body)
 				if err != nil {
 					rw.WriteHeader(http.StatusInternalServerError)
 					l.Error(err.Error())
 					return
 				}
-				decryptBody, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, body, nil)
-				if err == nil {
-					r.Body = io.NopCloser(bytes.NewReader(decryptBody))
+				if len(body) > 0 {
+					var decryptBody []byte
+					if scheme := r.Header.Get(envelope.Header); scheme != "" {
+						decryptBody, err = envelope.Open(key, scheme, body)
+					} else if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+						decryptBody, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, rsaKey, body, nil)
+					} else {
+						err = envelope.ErrUnknownScheme
+					}
+					if err != nil {
+						l.Debug("Decrypt body", zap.Error(err))
+						http.Error(rw, "can not decrypt body", http.StatusBadRequest)
+						return
+					}
+					body = decryptBody
 				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
 			}
 			next.ServeHTTP(rw, r)
 		})
@@ -88,20 +112,37 @@ func TextHeader() func(next http.Handler) http.Handler {
 	}
 }
 
-// CheckSign check sign header request
-func CheckSign(conf *config.WEB, l *zap.Logger) func(next http.Handler) http.Handler {
+// CheckSign check request sign if config key present and request is signed, unsigned writes of metrics are rejected.
+// Sign must cover method, path, timestamp and nonce, body only sign is allowed with legacy config switch.
+// Agent key is selected by Key-Id header, the key must be active and belong to the agent of request
+func CheckSign(conf *config.WEB, s service.Credentials, l *zap.Logger) func(next http.Handler) http.Handler {
+	v := sign.NewVerifier()
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-			if conf != nil && conf.Key != "" && r.Header.Get(constant.HeaderSignKey) != "" {
-				getSha, err := hex.DecodeString(r.Header.Get(constant.HeaderSignKey))
-				if len(getSha) == 0 || err != nil {
+			key := conf.GetKey()
+			if keyID := r.Header.Get(constant.HeaderKeyID); keyID != "" {
+				cred, err := s.ActiveCredential(r.Context(), keyID)
+				if err == nil && cred.AgentID != agentID(r) {
+					err = myErr.ErrKeyInactive
+				}
+				if err != nil {
+					l.Debug("Check sign key", zap.String("key_id", keyID), zap.Error(err))
+					rw.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if r.Header.Get(constant.HeaderSignKey) == "" {
 					rw.WriteHeader(http.StatusBadRequest)
-					if _, err = rw.Write([]byte("Bad HashKey")); err != nil {
-						l.Error("Error return answer", zap.Error(err))
-					}
 					return
 				}
-				h := hmac.New(sha256.New, []byte(conf.Key))
+				key = cred.Secret
+				r = r.WithContext(domain.WithSignKey(r.Context(), key))
+			}
+			if key != "" && r.Header.Get(constant.HeaderSignKey) == "" && isWriteRoute(r.URL.Path) {
+				l.Debug("Check sign", zap.Error(sign.ErrNoSign))
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if key != "" && r.Header.Get(constant.HeaderSignKey) != "" {
 				body, err := io.ReadAll(r.Body)
 				if err != nil {
 					rw.WriteHeader(http.StatusInternalServerError)
@@ -109,8 +150,21 @@ func CheckSign(conf *config.WEB, l *zap.Logger) func(next http.Handler) http.Han
 					return
 				}
 				r.Body = io.NopCloser(bytes.NewReader(body))
-				h.Write(body)
-				if !bytes.Equal(getSha, h.Sum(nil)) {
+
+				signature := r.Header.Get(constant.HeaderSignKey)
+				if tsHeader := r.Header.Get(sign.HeaderTimestamp); tsHeader != "" {
+					var p sign.Params
+					if p.Timestamp, err = sign.ParseTimestamp(tsHeader); err == nil {
+						p.Method, p.Path, p.Nonce, p.Body = r.Method, r.URL.Path, r.Header.Get(sign.HeaderNonce), body
+						err = v.Verify(key, p, signature, conf.GetSignSkew())
+					}
+				} else if conf.GetSignLegacy() {
+					err = v.VerifyLegacy(key, body, signature)
+				} else {
+					err = sign.ErrLegacy
+				}
+				if err != nil {
+					l.Debug("Check sign", zap.Error(err))
 					rw.WriteHeader(http.StatusBadRequest)
 					if _, err = rw.Write([]byte("Bad HashKey")); err != nil {
 						l.Error("Error return answer", zap.Error(err))
@@ -123,16 +177,148 @@ func CheckSign(conf *config.WEB, l *zap.Logger) func(next http.Handler) http.Han
 	}
 }
 
+// Authenticate resolve principal of request by bearer token or client certificate if access is configured.
+// Admin api token is admin principal
+func Authenticate(conf *config.WEB, s service.Access, l *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			token := bearerToken(r)
+			if admin := conf.GetAdminToken(); admin != "" && token != "" &&
+				subtle.ConstantTimeCompare([]byte(token), []byte(admin)) == 1 {
+				next.ServeHTTP(rw, r.WithContext(domain.WithPrincipal(r.Context(),
+					&domain.Principal{Name: "admin", Role: domain.RoleAdmin})))
+				return
+			}
+			p, err := s.Principal(r.Context(), token, certs.PeerName(r.TLS))
+			switch {
+			case errors.Is(err, myErr.ErrUnknownToken):
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			case err != nil:
+				l.Error("Error get principal", zap.Error(err))
+				rw.WriteHeader(http.StatusInternalServerError)
+				return
+			case p != nil:
+				r = r.WithContext(domain.WithPrincipal(r.Context(), p))
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// Tenant resolve tenant of request: tenant of principal or X-Tenant header
+func Tenant() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			tenant, err := domain.ResolveTenant(domain.PrincipalFromContext(r.Context()), r.Header.Get(constant.HeaderTenant))
+			switch {
+			case errors.Is(err, myErr.ErrBadTenant):
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			case err != nil:
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(rw, r.WithContext(domain.WithTenant(r.Context(), tenant)))
+		})
+	}
+}
+
+// Authorize check role of request principal, any request is allowed if access is not configured
+func Authorize(role domain.Role) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if p := domain.PrincipalFromContext(r.Context()); p != nil && !p.Can(role) {
+				if p.Role == "" {
+					rw.WriteHeader(http.StatusUnauthorized)
+				} else {
+					rw.WriteHeader(http.StatusForbidden)
+				}
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// AdminAuth check admin role of request principal, or admin api bearer token if access is not configured.
+// Admin api is disabled if token is not configured
+func AdminAuth(conf *config.WEB) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			switch p := domain.PrincipalFromContext(r.Context()); {
+			case p != nil:
+				Authorize(domain.RoleAdmin)(next).ServeHTTP(rw, r)
+			case conf.GetAdminToken() == "":
+				rw.WriteHeader(http.StatusNotFound)
+			default:
+				rw.WriteHeader(http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+// bearerToken token from authorization header
+func bearerToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get(constant.HeaderAuthorization), constant.BearerPrefix); ok {
+		return token
+	}
+	return ""
+}
+
+// RateLimit limit update requests of agent, agent is identified by agent id or ip.
+// Agent identity is put to context as owner of series quotas
+func RateLimit(conf *config.Limit, s service.Limits) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			key := limitKey(r, conf.GetLimitRealIP())
+			if wait := s.Allow(conf.GetLimits(), key, 1, 0); wait > 0 {
+				tooManyRequests(rw, wait)
+				return
+			}
+			next.ServeHTTP(rw, r.WithContext(domain.WithQuotaOwner(r.Context(), key)))
+		})
+	}
+}
+
+// limitKey agent identity of rate limits: verified certificate name, agent of verified sign key or ip of connection.
+// Agent id and X-Real-IP headers are not trusted alone, agent could change them to pass limits or take limits
+// of other agent. X-Real-IP is used if realIP is set behind trusted proxy
+func limitKey(r *http.Request, realIP bool) string {
+	if name := certs.PeerName(r.TLS); name != "" {
+		return name
+	}
+	if domain.SignKeyFromContext(r.Context(), "") != "" {
+		// key of agent credential is checked to belong to agent id
+		return r.Header.Get(constant.HeaderAgentID)
+	}
+	if ip := r.Header.Get(constant.HeaderXRealIP); realIP && ip != "" {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// tooManyRequests answer rate limit exceeded with seconds to wait
+func tooManyRequests(rw http.ResponseWriter, wait time.Duration) {
+	rw.Header().Set(constant.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	rw.WriteHeader(http.StatusTooManyRequests)
+}
+
+func serviceUnavailable(rw http.ResponseWriter, wait time.Duration) {
+	rw.Header().Set(constant.HeaderRetryAfter, strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+	rw.WriteHeader(http.StatusServiceUnavailable)
+}
+
 // CheckNetwork check allowed network
 func CheckNetwork(conf *config.WEB, l *zap.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-			if conf != nil && conf.TrustedSubnet != "" {
+			if subnet := conf.GetTrustedSubnet(); subnet != "" {
 				if r.Header.Get(constant.HeaderXRealIP) == "" {
 					rw.WriteHeader(http.StatusForbidden)
 				}
 				ip := net.ParseIP(r.Header.Get(constant.HeaderXRealIP))
-				_, addr, err := net.ParseCIDR(conf.TrustedSubnet)
+				_, addr, err := net.ParseCIDR(subnet)
 				if err != nil {
 					l.Error("Error parseCIDR", zap.Error(err))
 					rw.WriteHeader(http.StatusInternalServerError)
@@ -148,6 +334,103 @@ func CheckNetwork(conf *config.WEB, l *zap.Logger) func(next http.Handler) http.
 	}
 }
 
+// AgentTrack register agents requests at agents registry
+func AgentTrack(s service.Agents, l *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			id := agentID(r)
+			if id == "" {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			report := &domain.AgentReport{
+				Agent: domain.AgentInfo{
+					ID:       id,
+					Tenant:   domain.TenantFromContext(r.Context()),
+					Hostname: r.Header.Get(constant.HeaderAgentHostname),
+					Version:  r.Header.Get(constant.HeaderAgentVersion),
+					Config:   r.Header.Get(constant.HeaderAgentConfig),
+					IP:       requestIP(r),
+				},
+			}
+			ww := middleware.NewWrapResponseWriter(rw, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(domain.WithAgentReport(r.Context(), report)))
+			if report.Err == nil && ww.Status() >= http.StatusBadRequest {
+				report.Err = fmt.Errorf("status %d: %s", ww.Status(), http.StatusText(ww.Status()))
+			}
+			if err := s.AgentReport(r.Context(), *report); err != nil {
+				l.Error("Error save agent report", zap.Error(err))
+			}
+		})
+	}
+}
+
+// Audit write request to audit log: agent, principal, route, number of saved and rejected metrics and result.
+// Handlers set numbers and reason to audit record of request context
+func Audit(s service.Audit, l *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rec := &domain.AuditRecord{
+				Tenant: domain.TenantFromContext(r.Context()),
+				Agent:  agentID(r),
+				IP:     requestIP(r),
+				Action: r.Method + " " + r.URL.Path,
+			}
+			if p := domain.PrincipalFromContext(r.Context()); p != nil {
+				rec.Principal = p.Name
+			}
+			ww := middleware.NewWrapResponseWriter(rw, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(domain.WithAuditRecord(r.Context(), rec)))
+			code := ww.Status()
+			if code == 0 {
+				code = http.StatusOK
+			}
+			rec.Status = strconv.Itoa(code)
+			if rec.Reason == "" && code >= http.StatusBadRequest {
+				rec.Reason = http.StatusText(code)
+			}
+			if err := s.WriteAudit(r.Context(), *rec); err != nil {
+				l.Error("Error write audit", zap.Error(err))
+			}
+		})
+	}
+}
+
+// agentID agent identity: common name of verified agent certificate or agent id header
+func agentID(r *http.Request) string {
+	if name := certs.PeerName(r.TLS); name != "" {
+		return name
+	}
+	return r.Header.Get(constant.HeaderAgentID)
+}
+
+// isWriteRoute request writes metrics, write must be signed if key is configured
+func isWriteRoute(path string) bool {
+	for _, route := range []string{constant.UpdateRoute, constant.UpdatesRoute} {
+		if path == route || strings.HasPrefix(path, route+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIP ip of client, from X-Real-IP header or remote address
+func requestIP(r *http.Request) string {
+	if ip := r.Header.Get(constant.HeaderXRealIP); ip != "" {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// remoteIP ip of connection
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // Logger
 // middleware logger
 func Logger(l *zap.Logger) func(next http.Handler) http.Handler {
@@ -0,0 +1,133 @@
+// Package keyring AES-GCM encryption of storage files with rotated keys.
+//
+// Keys are set as comma or line separated list of "id:base64 key", key is 16, 24 or 32 bytes.
+// First key encrypts, all keys decrypt, so data encrypted by previous keys are readable after rotation.
+// Sealed data is GCM nonce followed by cipher text, key id is kept by caller next to it.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNoKey      = errors.New("data is encrypted, storage key is not set")
+	ErrUnknownKey = errors.New("unknown storage key id")
+	ErrWrongKey   = errors.New("wrong storage key or corrupt data")
+	ErrBadKey     = errors.New("bad storage key")
+)
+
+// Keyring encryption keys by id
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// Parse keys list, nil keyring is returned for empty list
+func Parse(s string) (*Keyring, error) {
+	entries := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	k := &Keyring{keys: make(map[string][]byte, len(entries))}
+	for _, entry := range entries {
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("%w: expected id:base64 key", ErrBadKey)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %w", ErrBadKey, id, err)
+		}
+		if _, err = aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("%w %s: %w", ErrBadKey, id, err)
+		}
+		if _, ok = k.keys[id]; ok {
+			return nil, fmt.Errorf("%w: duplicate key id %s", ErrBadKey, id)
+		}
+		if k.current == "" {
+			k.current = id
+		}
+		k.keys[id] = key
+	}
+	return k, nil
+}
+
+// Current id of encryption key, empty for nil keyring
+func (k *Keyring) Current() string {
+	if k == nil {
+		return ""
+	}
+	return k.current
+}
+
+// Equal keyrings have same keys and current key
+func (k *Keyring) Equal(o *Keyring) bool {
+	if k == nil || o == nil {
+		return k == o
+	}
+	if k.current != o.current || len(k.keys) != len(o.keys) {
+		return false
+	}
+	for id, key := range k.keys {
+		if string(o.keys[id]) != string(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// Seal encrypt data by current key, additional data is authenticated, but not encrypted
+func (k *Keyring) Seal(data, ad []byte) (id string, out []byte, err error) {
+	if k == nil {
+		return "", nil, ErrNoKey
+	}
+	var gcm cipher.AEAD
+	if gcm, err = newGCM(k.keys[k.current]); err != nil {
+		return
+	}
+	out = make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(data)+gcm.Overhead())
+	if _, err = io.ReadFull(rand.Reader, out); err != nil {
+		return
+	}
+	return k.current, gcm.Seal(out, out, data, ad), nil
+}
+
+// Open decrypt data sealed by key id
+func (k *Keyring) Open(id string, data, ad []byte) ([]byte, error) {
+	if k == nil {
+		return nil, ErrNoKey
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrWrongKey
+	}
+	out, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], ad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %s", ErrWrongKey, id)
+	}
+	return out, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
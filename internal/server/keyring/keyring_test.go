@@ -0,0 +1,94 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte, size int) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), size)))
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    string
+		current string
+		wantErr bool
+	}{
+		{name: "Empty", keys: " \n"},
+		{name: "One key", keys: "k1:" + testKey('a', 32), current: "k1"},
+		{name: "Rotated keys", keys: "k2:" + testKey('b', 16) + ",\nk1:" + testKey('a', 32), current: "k2"},
+		{name: "No id", keys: testKey('a', 32), wantErr: true},
+		{name: "Bad base64", keys: "k1:###", wantErr: true},
+		{name: "Bad key size", keys: "k1:" + testKey('a', 20), wantErr: true},
+		{name: "Duplicate id", keys: "k1:" + testKey('a', 32) + ",k1:" + testKey('b', 32), wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k, err := Parse(test.keys)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrBadKey)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.current, k.Current())
+		})
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	old, err := Parse("k1:" + testKey('a', 32))
+	require.NoError(t, err)
+	rotated, err := Parse("k2:" + testKey('b', 32) + ",k1:" + testKey('a', 32))
+	require.NoError(t, err)
+	other, err := Parse("k1:" + testKey('c', 32))
+	require.NoError(t, err)
+
+	data, ad := []byte("metrics"), []byte("test")
+	id, sealed, err := old.Seal(data, ad)
+	require.NoError(t, err)
+	assert.Equal(t, "k1", id)
+	assert.NotContains(t, string(sealed), "metrics")
+
+	t.Run("Open by rotated keys", func(t *testing.T) {
+		out, err := rotated.Open(id, sealed, ad)
+		require.NoError(t, err)
+		assert.Equal(t, data, out)
+
+		id2, sealed2, err := rotated.Seal(data, ad)
+		require.NoError(t, err)
+		assert.Equal(t, "k2", id2)
+		_, err = old.Open(id2, sealed2, ad)
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	})
+
+	t.Run("Wrong key", func(t *testing.T) {
+		_, err := other.Open(id, sealed, ad)
+		assert.ErrorIs(t, err, ErrWrongKey)
+		_, err = old.Open(id, sealed, []byte("other"))
+		assert.ErrorIs(t, err, ErrWrongKey)
+	})
+
+	t.Run("No key", func(t *testing.T) {
+		var k *Keyring
+		_, err := k.Open(id, sealed, ad)
+		assert.ErrorIs(t, err, ErrNoKey)
+		_, _, err = k.Seal(data, ad)
+		assert.ErrorIs(t, err, ErrNoKey)
+	})
+
+	t.Run("Equal", func(t *testing.T) {
+		same, err := Parse("k1:" + testKey('a', 32))
+		require.NoError(t, err)
+		assert.True(t, old.Equal(same))
+		assert.False(t, old.Equal(rotated))
+		assert.False(t, old.Equal(other))
+		assert.False(t, old.Equal(nil))
+		assert.True(t, (*Keyring)(nil).Equal(nil))
+	})
+}
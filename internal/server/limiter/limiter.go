@@ -0,0 +1,115 @@
+// Package limiter token bucket rate limits of requests and metrics, per key (agent id or ip) and global
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// purgeInterval interval of removing idle keys buckets
+const purgeInterval = time.Minute
+
+// Limits rates per second, 0 - no limit. Burst is bucket capacity in seconds of rate
+type Limits struct {
+	KeyRequests int
+	KeyMetrics  int
+	Requests    int
+	Metrics     int
+	Burst       int
+}
+
+type bucket struct {
+	last   time.Time
+	tokens float64
+}
+
+// fill add tokens of rate since last fill, new bucket is full
+func (b *bucket) fill(now time.Time, capacity, rate float64) {
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else {
+		b.tokens = min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+type buckets struct {
+	requests bucket
+	metrics  bucket
+}
+
+// Limiter token buckets of keys and global
+type Limiter struct {
+	lastPurge time.Time
+	now       func() time.Time
+	keys      map[string]*buckets
+	all       buckets
+	m         sync.Mutex
+}
+
+func New() *Limiter {
+	return &Limiter{
+		now:  time.Now,
+		keys: make(map[string]*buckets),
+	}
+}
+
+// Allow take requests and metrics tokens from key and global buckets.
+// Tokens are not taken if any bucket has not enough tokens, the wait time until it has is returned.
+// Cost more than bucket capacity is allowed from full bucket
+func (l *Limiter) Allow(lim Limits, key string, requests, metrics int) (wait time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	now := l.now()
+	l.purge(now, lim)
+	k, ok := l.keys[key]
+	if !ok {
+		k = new(buckets)
+		l.keys[key] = k
+	}
+	burst := float64(max(lim.Burst, 1))
+	checks := []struct {
+		b    *bucket
+		rate float64
+		cost float64
+	}{
+		{&k.requests, float64(lim.KeyRequests), float64(requests)},
+		{&k.metrics, float64(lim.KeyMetrics), float64(metrics)},
+		{&l.all.requests, float64(lim.Requests), float64(requests)},
+		{&l.all.metrics, float64(lim.Metrics), float64(metrics)},
+	}
+	for i, c := range checks {
+		if c.rate <= 0 {
+			continue
+		}
+		capacity := c.rate * burst
+		c.b.fill(now, capacity, c.rate)
+		checks[i].cost = min(c.cost, capacity)
+		if lack := checks[i].cost - c.b.tokens; lack > 0 {
+			wait = max(wait, time.Duration(lack/c.rate*float64(time.Second)))
+		}
+	}
+	if wait > 0 {
+		return
+	}
+	for _, c := range checks {
+		if c.rate > 0 {
+			c.b.tokens -= c.cost
+		}
+	}
+	return
+}
+
+// purge remove buckets of keys idle longer than they are refilled
+func (l *Limiter) purge(now time.Time, lim Limits) {
+	if now.Sub(l.lastPurge) < purgeInterval {
+		return
+	}
+	l.lastPurge = now
+	idle := max(time.Duration(lim.Burst)*time.Second, purgeInterval)
+	for key, k := range l.keys {
+		if now.Sub(k.requests.last) > idle && now.Sub(k.metrics.last) > idle {
+			delete(l.keys, key)
+		}
+	}
+}
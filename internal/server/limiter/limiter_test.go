@@ -0,0 +1,55 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := New()
+	l.now = func() time.Time { return now }
+
+	t.Run("no limits", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			assert.Zero(t, l.Allow(Limits{}, "agent-1", 1, 1000))
+		}
+	})
+
+	lim := Limits{KeyRequests: 2, KeyMetrics: 10, Burst: 1}
+	t.Run("key requests", func(t *testing.T) {
+		assert.Zero(t, l.Allow(lim, "agent-2", 1, 0))
+		assert.Zero(t, l.Allow(lim, "agent-2", 1, 0))
+		assert.Equal(t, 500*time.Millisecond, l.Allow(lim, "agent-2", 1, 0))
+		assert.Zero(t, l.Allow(lim, "agent-3", 1, 0), "other key has own bucket")
+		now = now.Add(500 * time.Millisecond)
+		assert.Zero(t, l.Allow(lim, "agent-2", 1, 0))
+	})
+
+	t.Run("key metrics, tokens are not taken if denied", func(t *testing.T) {
+		assert.Zero(t, l.Allow(lim, "agent-4", 0, 8))
+		assert.Equal(t, 600*time.Millisecond, l.Allow(lim, "agent-4", 0, 8))
+		assert.Zero(t, l.Allow(lim, "agent-4", 0, 2))
+	})
+
+	t.Run("cost over capacity is allowed from full bucket", func(t *testing.T) {
+		assert.Zero(t, l.Allow(lim, "agent-5", 1, 100))
+		assert.Equal(t, time.Second, l.Allow(lim, "agent-5", 0, 100))
+	})
+
+	t.Run("global", func(t *testing.T) {
+		g := Limits{Requests: 3, Burst: 2}
+		for i := 0; i < 6; i++ {
+			assert.Zero(t, l.Allow(g, "agent-"+string(rune('a'+i)), 1, 0))
+		}
+		assert.Greater(t, l.Allow(g, "agent-z", 1, 0), time.Duration(0))
+	})
+
+	t.Run("idle keys are purged", func(t *testing.T) {
+		now = now.Add(2 * purgeInterval)
+		l.Allow(lim, "agent-2", 1, 0)
+		assert.Len(t, l.keys, 1)
+	})
+}
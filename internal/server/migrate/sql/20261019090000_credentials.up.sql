@@ -0,0 +1,14 @@
+create table credentials
+(
+ key_id     varchar(64)  not null
+  constraint credentials_key_id
+   primary key,
+ agent_id   varchar(255) not null,
+ secret     varchar(255) not null,
+ not_before timestamptz  not null,
+ not_after  timestamptz,
+ revoked_at timestamptz,
+ created_at timestamptz  not null
+);
+
+create index credentials_agent_id on credentials (agent_id);
@@ -0,0 +1,15 @@
+delete from gauges where tenant <> '';
+alter table gauges
+ drop constraint gauges_name;
+alter table gauges
+ drop column tenant;
+alter table gauges
+ add constraint gauges_name primary key (name);
+
+delete from counters where tenant <> '';
+alter table counters
+ drop constraint counters_name;
+alter table counters
+ drop column tenant;
+alter table counters
+ add constraint counters_name primary key (name);
@@ -0,0 +1,13 @@
+alter table gauges
+ add column tenant varchar(64) not null default '';
+alter table gauges
+ drop constraint gauges_name;
+alter table gauges
+ add constraint gauges_name primary key (tenant, name);
+
+alter table counters
+ add column tenant varchar(64) not null default '';
+alter table counters
+ drop constraint counters_name;
+alter table counters
+ add constraint counters_name primary key (tenant, name);
@@ -0,0 +1,19 @@
+create table audit
+(
+ id        bigserial    not null
+  constraint audit_id
+   primary key,
+ time      timestamptz  not null,
+ tenant    varchar(64)  not null default '',
+ agent     varchar(255) not null default '',
+ ip        varchar(64)  not null default '',
+ principal varchar(255) not null default '',
+ action    varchar(255) not null,
+ metrics   integer      not null default 0,
+ rejected  integer      not null default 0,
+ reason    text         not null default '',
+ status    varchar(32)  not null
+);
+
+create index audit_time on audit (time);
+create index audit_agent_time on audit (agent, time);
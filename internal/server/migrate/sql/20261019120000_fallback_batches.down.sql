@@ -0,0 +1 @@
+drop table fallback_batches;
@@ -0,0 +1,7 @@
+create table fallback_batches
+(
+ buffer varchar(64) not null
+  constraint fallback_batches_buffer
+   primary key,
+ seq    bigint      not null
+);
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+)
+
+// AccessStorage access roles methods
+type AccessStorage interface {
+	// GetAccessConfig get access roles, ErrNotExist if access is not configured
+	GetAccessConfig(ctx context.Context) (domain.AccessConfig, error)
+}
+
+// AccessFileRepo access roles from json file, file is reread on change
+type AccessFileRepo struct {
+	modTime time.Time
+	c       *config.StorageConfig
+	data    domain.AccessConfig
+	path    string
+	size    int64
+	m       sync.Mutex
+}
+
+func NewAccessFileRepository(c *config.StorageConfig) *AccessFileRepo {
+	return &AccessFileRepo{c: c}
+}
+
+// GetAccessConfig get access roles, ErrNotExist if access is not configured
+func (r *AccessFileRepo) GetAccessConfig(_ context.Context) (c domain.AccessConfig, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err = r.load(); err != nil {
+		return
+	}
+	c = r.data
+	return
+}
+
+func (r *AccessFileRepo) load() (err error) {
+	if r.c.AccessPath == "" {
+		return myErr.ErrNotExist
+	}
+	var stat os.FileInfo
+	if stat, err = os.Stat(r.c.AccessPath); err != nil {
+		return
+	}
+	if r.path == r.c.AccessPath && stat.ModTime().Equal(r.modTime) && stat.Size() == r.size {
+		return
+	}
+	var (
+		b    []byte
+		data domain.AccessConfig
+	)
+	if b, err = os.ReadFile(r.c.AccessPath); err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, &data); err != nil {
+		return errors.Join(errors.New("access file"), err)
+	}
+	r.data = data
+	r.path, r.modTime, r.size = r.c.AccessPath, stat.ModTime(), stat.Size()
+	return
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessFileRepo(t *testing.T) {
+	ctx := context.Background()
+	c := &config.StorageConfig{}
+	r := NewAccessFileRepository(c)
+
+	t.Run("not configured", func(t *testing.T) {
+		_, err := r.GetAccessConfig(ctx)
+		assert.True(t, errors.Is(err, myErr.ErrNotExist))
+	})
+
+	c.AccessPath = filepath.Join(t.TempDir(), "access.json")
+	t.Run("file not exist is error", func(t *testing.T) {
+		_, err := r.GetAccessConfig(ctx)
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, myErr.ErrNotExist))
+	})
+
+	require.NoError(t, os.WriteFile(c.AccessPath, []byte(`{
+		"principals": [
+			{"name": "team-a", "token": "a-token", "role": "writer", "prefixes": ["teamA_"]},
+			{"name": "agent-1", "cert": "agent-1", "role": "writer"}
+		]
+	}`), 0o600))
+
+	a, err := r.GetAccessConfig(ctx)
+	require.NoError(t, err)
+
+	t.Run("by token", func(t *testing.T) {
+		p, ok := a.For("a-token", "agent-1")
+		require.True(t, ok)
+		assert.Equal(t, "team-a", p.Name)
+		assert.True(t, p.CanWrite("teamA_counter"))
+		assert.False(t, p.CanWrite("teamB_counter"))
+		assert.False(t, p.Can(domain.RoleAdmin))
+	})
+	t.Run("unknown token", func(t *testing.T) {
+		_, ok := a.For("b-token", "agent-1")
+		assert.False(t, ok)
+	})
+	t.Run("by certificate", func(t *testing.T) {
+		p, ok := a.For("", "agent-1")
+		require.True(t, ok)
+		assert.True(t, p.CanWrite("any"))
+		assert.True(t, p.Can(domain.RoleReader))
+	})
+	t.Run("without anonymous role", func(t *testing.T) {
+		p, ok := a.For("", "")
+		require.True(t, ok)
+		assert.False(t, p.Can(domain.RoleReader))
+	})
+
+	t.Run("bad file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(c.AccessPath, []byte(`{"principals": `), 0o600))
+		_, err := r.GetAccessConfig(ctx)
+		assert.Error(t, err)
+	})
+}
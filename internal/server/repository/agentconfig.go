@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+)
+
+// AgentConfigStorage agents configs methods
+type AgentConfigStorage interface {
+	// GetAgentConfig get config for agent id and group
+	GetAgentConfig(ctx context.Context, id, group string) (domain.AgentConfig, error)
+}
+
+// AgentConfigFileRepo agents configs from json file, file is reread on change
+type AgentConfigFileRepo struct {
+	modTime time.Time
+	c       *config.StorageConfig
+	data    domain.AgentsConfig
+	path    string
+	size    int64
+	m       sync.Mutex
+}
+
+func NewAgentConfigFileRepository(c *config.StorageConfig) *AgentConfigFileRepo {
+	return &AgentConfigFileRepo{c: c}
+}
+
+// GetAgentConfig get config for agent id and group
+func (r *AgentConfigFileRepo) GetAgentConfig(_ context.Context, id, group string) (c domain.AgentConfig, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err = r.load(); err != nil {
+		return
+	}
+	c = r.data.For(id, group)
+	return
+}
+
+func (r *AgentConfigFileRepo) load() (err error) {
+	if r.c.AgentsConfigPath == "" {
+		return myErr.ErrNotExist
+	}
+	var stat os.FileInfo
+	if stat, err = os.Stat(r.c.AgentsConfigPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = errors.Join(myErr.ErrNotExist, err)
+		}
+		return
+	}
+	if r.path == r.c.AgentsConfigPath && stat.ModTime().Equal(r.modTime) && stat.Size() == r.size {
+		return
+	}
+	var (
+		b    []byte
+		data domain.AgentsConfig
+	)
+	if b, err = os.ReadFile(r.c.AgentsConfigPath); err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, &data); err != nil {
+		return
+	}
+	r.data = data
+	r.path, r.modTime, r.size = r.c.AgentsConfigPath, stat.ModTime(), stat.Size()
+	return
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentConfigFileRepo(t *testing.T) {
+	ctx := context.Background()
+	c := &config.StorageConfig{}
+	r := NewAgentConfigFileRepository(c)
+
+	t.Run("not configured", func(t *testing.T) {
+		_, err := r.GetAgentConfig(ctx, "agent-1", "")
+		assert.True(t, errors.Is(err, myErr.ErrNotExist))
+	})
+
+	c.AgentsConfigPath = filepath.Join(t.TempDir(), "agents.json")
+	t.Run("file not exist", func(t *testing.T) {
+		_, err := r.GetAgentConfig(ctx, "agent-1", "")
+		assert.True(t, errors.Is(err, myErr.ErrNotExist))
+	})
+
+	require.NoError(t, os.WriteFile(c.AgentsConfigPath, []byte(`{
+		"default": {"report_interval": 10, "poll_interval": 2},
+		"groups": {"fast": {"report_interval": 1, "gauges_list": ["Alloc"]}},
+		"agents": {"agent-1": {"group": "fast", "rate_limit": 5}, "agent-2": {"poll_interval": 1}}
+	}`), 0o600))
+
+	t.Run("default, group of agent, agent", func(t *testing.T) {
+		cfg, err := r.GetAgentConfig(ctx, "agent-1", "")
+		require.NoError(t, err)
+		assert.Equal(t, "fast", cfg.Group)
+		assert.Equal(t, 1, *cfg.ReportInterval)
+		assert.Equal(t, 2, *cfg.PollInterval)
+		assert.Equal(t, 5, *cfg.RateLimit)
+		assert.Nil(t, cfg.SendSize)
+		assert.Equal(t, []string{"Alloc"}, cfg.GaugesList)
+	})
+	t.Run("requested group", func(t *testing.T) {
+		cfg, err := r.GetAgentConfig(ctx, "agent-2", "fast")
+		require.NoError(t, err)
+		assert.Equal(t, 1, *cfg.ReportInterval)
+		assert.Equal(t, 1, *cfg.PollInterval)
+	})
+	t.Run("unknown agent get default", func(t *testing.T) {
+		cfg, err := r.GetAgentConfig(ctx, "agent-3", "")
+		require.NoError(t, err)
+		assert.Equal(t, 10, *cfg.ReportInterval)
+		assert.Nil(t, cfg.GaugesList)
+	})
+
+	t.Run("file changed", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(c.AgentsConfigPath, []byte(`{"default": {"report_interval": 20}}`), 0o600))
+		require.NoError(t, os.Chtimes(c.AgentsConfigPath, time.Now(), time.Now().Add(time.Second)))
+		cfg, err := r.GetAgentConfig(ctx, "agent-1", "")
+		require.NoError(t, err)
+		assert.Equal(t, 20, *cfg.ReportInterval)
+		assert.Nil(t, cfg.RateLimit)
+	})
+
+	t.Run("bad file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(c.AgentsConfigPath, []byte(`{"default": `), 0o600))
+		require.NoError(t, os.Chtimes(c.AgentsConfigPath, time.Now(), time.Now().Add(2*time.Second)))
+		_, err := r.GetAgentConfig(ctx, "agent-1", "")
+		assert.Error(t, err)
+	})
+}
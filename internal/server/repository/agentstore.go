@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go-musthave-metrics/internal/server/domain"
+)
+
+// AgentStorage agents registry methods
+type AgentStorage interface {
+	// SaveAgentReport add or update agent at registry
+	SaveAgentReport(ctx context.Context, report domain.AgentReport, t time.Time) error
+	// GetAgents get all known agents of request tenant
+	GetAgents(ctx context.Context) ([]domain.Agent, error)
+	// MarkMissingAgents mark agents without reports as missing, return only newly marked
+	MarkMissingAgents(ctx context.Context, now time.Time, intervals int) ([]domain.Agent, error)
+}
+
+// AgentMemRepo is memory agents registry, agents are identified by tenant and id
+type AgentMemRepo struct {
+	agents map[string]*domain.Agent
+	m      sync.RWMutex
+}
+
+func agentKey(a domain.AgentInfo) string {
+	return a.Tenant + "/" + a.ID
+}
+
+func NewAgentMemRepository() *AgentMemRepo {
+	return &AgentMemRepo{
+		agents: make(map[string]*domain.Agent),
+	}
+}
+
+// SaveAgentReport add or update agent at registry
+func (r *AgentMemRepo) SaveAgentReport(_ context.Context, report domain.AgentReport, t time.Time) (err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	key := agentKey(report.Agent)
+	a, ok := r.agents[key]
+	if !ok {
+		a = &domain.Agent{FirstSeen: t}
+		r.agents[key] = a
+	}
+	a.AgentInfo = report.Agent
+	a.LastSeen = t
+	a.Missing = false
+	a.MetricsCount += int64(report.Metrics)
+	if report.Err != nil {
+		a.LastError = report.Err.Error()
+	}
+	return
+}
+
+// GetAgents get all known agents of request tenant sorted by id
+func (r *AgentMemRepo) GetAgents(ctx context.Context) (agents []domain.Agent, err error) {
+	tenant := domain.TenantFromContext(ctx)
+	r.m.RLock()
+	defer r.m.RUnlock()
+	agents = make([]domain.Agent, 0, len(r.agents))
+	for _, a := range r.agents {
+		if a.Tenant == tenant {
+			agents = append(agents, *a)
+		}
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].ID < agents[j].ID
+	})
+	return
+}
+
+// MarkMissingAgents mark agents without reports as missing, return only newly marked
+func (r *AgentMemRepo) MarkMissingAgents(_ context.Context, now time.Time, intervals int) (missing []domain.Agent, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for _, a := range r.agents {
+		if !a.Missing && a.IsMissing(now, intervals) {
+			a.Missing = true
+			missing = append(missing, *a)
+		}
+	}
+	return
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-musthave-metrics/internal/server/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentMemRepo(t *testing.T) {
+	ctx := context.Background()
+	r := NewAgentMemRepository()
+	start := time.Now()
+	info := domain.AgentInfo{ID: "agent-1", Hostname: "host", Config: "report_interval=10"}
+
+	require.NoError(t, r.SaveAgentReport(ctx, domain.AgentReport{Agent: info, Metrics: 10}, start))
+	require.NoError(t, r.SaveAgentReport(ctx, domain.AgentReport{Agent: info, Metrics: 5, Err: errors.New("some error")}, start.Add(time.Second)))
+	require.NoError(t, r.SaveAgentReport(ctx, domain.AgentReport{Agent: domain.AgentInfo{ID: "agent-0"}}, start))
+
+	agents, err := r.GetAgents(ctx)
+	require.NoError(t, err)
+	require.Len(t, agents, 2)
+	assert.Equal(t, "agent-0", agents[0].ID)
+	assert.Equal(t, "agent-1", agents[1].ID)
+	assert.Equal(t, int64(15), agents[1].MetricsCount)
+	assert.Equal(t, "some error", agents[1].LastError)
+	assert.Equal(t, start, agents[1].FirstSeen)
+	assert.Equal(t, start.Add(time.Second), agents[1].LastSeen)
+
+	t.Run("not missing yet", func(t *testing.T) {
+		missing, err := r.MarkMissingAgents(ctx, start.Add(20*time.Second), 3)
+		require.NoError(t, err)
+		assert.Empty(t, missing)
+	})
+	t.Run("missing, agent without report interval is never missing", func(t *testing.T) {
+		missing, err := r.MarkMissingAgents(ctx, start.Add(time.Minute), 3)
+		require.NoError(t, err)
+		require.Len(t, missing, 1)
+		assert.Equal(t, "agent-1", missing[0].ID)
+	})
+	t.Run("missing reported once", func(t *testing.T) {
+		missing, err := r.MarkMissingAgents(ctx, start.Add(2*time.Minute), 3)
+		require.NoError(t, err)
+		assert.Empty(t, missing)
+	})
+	t.Run("report reset missing", func(t *testing.T) {
+		require.NoError(t, r.SaveAgentReport(ctx, domain.AgentReport{Agent: info}, start.Add(2*time.Minute)))
+		agents, err := r.GetAgents(ctx)
+		require.NoError(t, err)
+		assert.False(t, agents[1].Missing)
+	})
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+)
+
+// AuditStorage audit log methods
+type AuditStorage interface {
+	// SaveAudit append record to audit log
+	SaveAudit(ctx context.Context, r domain.AuditRecord) error
+	// GetAudit get records matched by filter sorted by time, last records if limit is exceeded.
+	// ErrNotExist if audit log is not configured
+	GetAudit(ctx context.Context, f domain.AuditFilter) ([]domain.AuditRecord, error)
+}
+
+// AuditFileRepo audit log at json lines file. File is rotated by size, rotated files have number suffix,
+// greater number is older file. Audit log is disabled if file path is not set
+type AuditFileRepo struct {
+	c    *config.StorageConfig
+	f    *os.File
+	path string
+	size int64
+	m    sync.Mutex
+}
+
+func NewAuditFileRepository(c *config.StorageConfig) *AuditFileRepo {
+	return &AuditFileRepo{c: c}
+}
+
+// SaveAudit append record to audit log file, file is rotated if it exceeds max size
+func (r *AuditFileRepo) SaveAudit(_ context.Context, rec domain.AuditRecord) (err error) {
+	if r.c.AuditPath == "" {
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(rec); err != nil {
+		return
+	}
+	b = append(b, '\n')
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err = r.open(); err != nil {
+		return
+	}
+	if maxSize, _ := r.c.GetAuditRotation(); maxSize > 0 && r.size > 0 && r.size+int64(len(b)) > maxSize {
+		if err = r.rotate(); err != nil {
+			return
+		}
+	}
+	var n int
+	n, err = r.f.Write(b)
+	r.size += int64(n)
+	return
+}
+
+// GetAudit get records of current and rotated files
+func (r *AuditFileRepo) GetAudit(_ context.Context, f domain.AuditFilter) (list []domain.AuditRecord, err error) {
+	if r.c.AuditPath == "" {
+		return nil, myErr.ErrNotExist
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	_, files := r.c.GetAuditRotation()
+	list = make([]domain.AuditRecord, 0)
+	for i := files; i >= 0; i-- {
+		if err = readAuditFile(r.rotated(i), f, &list); err != nil {
+			return
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	if f.Limit > 0 && len(list) > f.Limit {
+		list = list[len(list)-f.Limit:]
+	}
+	return
+}
+
+// rotated path of rotated file number n, current file if n is 0
+func (r *AuditFileRepo) rotated(n int) string {
+	if n == 0 {
+		return r.c.AuditPath
+	}
+	return fmt.Sprintf("%s.%d", r.c.AuditPath, n)
+}
+
+func (r *AuditFileRepo) open() (err error) {
+	if r.f != nil && r.path == r.c.AuditPath {
+		return
+	}
+	if err = r.close(); err != nil {
+		return
+	}
+	if r.f, err = os.OpenFile(r.c.AuditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600); err != nil {
+		return
+	}
+	r.path = r.c.AuditPath
+	var stat os.FileInfo
+	if stat, err = r.f.Stat(); err != nil {
+		return errors.Join(err, r.close())
+	}
+	r.size = stat.Size()
+	return
+}
+
+func (r *AuditFileRepo) close() (err error) {
+	if r.f != nil {
+		err = r.f.Close()
+		r.f, r.size = nil, 0
+	}
+	return
+}
+
+// rotate shift rotated files, oldest file over max files is removed, current file becomes first rotated.
+// Current file is removed if rotated files are not kept
+func (r *AuditFileRepo) rotate() (err error) {
+	if err = r.close(); err != nil {
+		return
+	}
+	_, files := r.c.GetAuditRotation()
+	if err = os.Remove(r.rotated(files)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	for i := files - 1; i >= 0; i-- {
+		if err = os.Rename(r.rotated(i), r.rotated(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+	}
+	return r.open()
+}
+
+func readAuditFile(path string, f domain.AuditFilter, list *[]domain.AuditRecord) (err error) {
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	defer func() { err = errors.Join(err, file.Close()) }()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec domain.AuditRecord
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return
+		}
+		if f.Match(rec) {
+			*list = append(*list, rec)
+		}
+	}
+	return scanner.Err()
+}
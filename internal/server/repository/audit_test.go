@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditFileRepo(t *testing.T) {
+	ctx := context.Background()
+	c := &config.StorageConfig{}
+	r := NewAuditFileRepository(c)
+
+	t.Run("Not configured", func(t *testing.T) {
+		require.NoError(t, r.SaveAudit(ctx, domain.AuditRecord{Action: "test"}))
+		_, err := r.GetAudit(ctx, domain.AuditFilter{})
+		assert.ErrorIs(t, err, myErr.ErrNotExist)
+	})
+
+	c.AuditPath = filepath.Join(t.TempDir(), "audit.log")
+	c.AuditMaxSize, c.AuditMaxFiles = 1, 2
+	start := time.Now().Truncate(time.Second)
+	// about 1.5 MB of records, current file and one rotated file
+	for i := 0; i < 8000; i++ {
+		require.NoError(t, r.SaveAudit(ctx, domain.AuditRecord{
+			Time:   start.Add(time.Duration(i) * time.Millisecond),
+			Agent:  fmt.Sprintf("agent-%d", i%2),
+			Action: "POST /updates/",
+			Reason: fmt.Sprintf("%060d", i),
+			Status: "200",
+		}))
+	}
+
+	t.Run("Rotated", func(t *testing.T) {
+		stat, err := os.Stat(c.AuditPath)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, stat.Size(), int64(1<<20))
+		_, err = os.Stat(c.AuditPath + ".1")
+		assert.NoError(t, err)
+		_, err = os.Stat(c.AuditPath + ".2")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		list, err := r.GetAudit(ctx, domain.AuditFilter{})
+		require.NoError(t, err)
+		assert.Len(t, list, 8000)
+		assert.True(t, list[0].Time.Equal(start))
+
+		list, err = r.GetAudit(ctx, domain.AuditFilter{Agent: "agent-1", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, list, 10)
+		assert.Equal(t, "agent-1", list[9].Agent)
+		assert.True(t, list[9].Time.Equal(start.Add(7999*time.Millisecond)), "last records are kept")
+
+		list, err = r.GetAudit(ctx, domain.AuditFilter{From: start.Add(time.Second), To: start.Add(2 * time.Second)})
+		require.NoError(t, err)
+		assert.Len(t, list, 1000)
+	})
+
+	t.Run("No rotated files", func(t *testing.T) {
+		c.AuditMaxFiles = 0
+		for i := 0; i < 15000; i++ {
+			require.NoError(t, r.SaveAudit(ctx, domain.AuditRecord{Action: "test", Reason: fmt.Sprintf("%080d", i)}))
+		}
+		list, err := r.GetAudit(ctx, domain.AuditFilter{})
+		require.NoError(t, err)
+		assert.Less(t, len(list), 15000)
+	})
+}
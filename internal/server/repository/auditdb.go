@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AuditDBRepo audit log at database
+type AuditDBRepo struct {
+	db *sqlx.DB
+}
+
+func NewAuditDBRepository(db *sqlx.DB) *AuditDBRepo {
+	return &AuditDBRepo{db: db}
+}
+
+const auditFields = `time, tenant, agent, ip, principal, action, metrics, rejected, reason, status`
+
+// SaveAudit append record to audit log
+func (r *AuditDBRepo) SaveAudit(ctx context.Context, rec domain.AuditRecord) (err error) {
+	err = retryFunc(ctx, func() (err error) {
+		_, err = r.db.NamedExecContext(ctx, `INSERT INTO `+constant.DBTableNameAudit+` (`+auditFields+`)
+ VALUES (:time, :tenant, :agent, :ip, :principal, :action, :metrics, :rejected, :reason, :status)`, rec)
+		return
+	})
+	return
+}
+
+// GetAudit get records matched by filter sorted by time, last records if limit is exceeded
+func (r *AuditDBRepo) GetAudit(ctx context.Context, f domain.AuditFilter) (list []domain.AuditRecord, err error) {
+	err = retryFunc(ctx, func() (err error) {
+		list = make([]domain.AuditRecord, 0)
+		var from, to interface{}
+		if !f.From.IsZero() {
+			from = f.From
+		}
+		if !f.To.IsZero() {
+			to = f.To
+		}
+		var limit interface{}
+		if f.Limit > 0 {
+			limit = f.Limit
+		}
+		err = r.db.SelectContext(ctx, &list, `SELECT `+auditFields+` FROM (SELECT id, `+auditFields+
+			` FROM `+constant.DBTableNameAudit+
+			` WHERE ($1::timestamptz IS NULL OR time >= $1) AND ($2::timestamptz IS NULL OR time < $2)`+
+			` AND ($3 = '' OR agent = $3) ORDER BY time DESC, id DESC LIMIT $4) a ORDER BY time, id`,
+			from, to, f.Agent, limit)
+		return
+	})
+	return
+}
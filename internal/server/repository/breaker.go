@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// breakerVars state of circuit breaker of store, opens and rejected operations. Served at /debug/vars
+var (
+	breakerVars  = expvar.NewMap("store_breaker")
+	breakerState = new(expvar.String)
+)
+
+func init() {
+	breakerState.Set(BreakerClosed)
+	breakerVars.Set("state", breakerState)
+}
+
+// BreakerStats state of circuit breaker of store
+type BreakerStats struct {
+	State string `json:"state"`
+	// Failures consecutive failures of closed breaker
+	Failures int `json:"failures"`
+	// RetryAfter seconds before probe of open breaker
+	RetryAfter float64   `json:"retry_after"`
+	Opens      int64     `json:"opens"`
+	Rejected   int64     `json:"rejected"`
+	LastOpen   time.Time `json:"last_open,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// BreakerRepo circuit breaker of store. Breaker is opened after number of consecutive failures:
+// lost connection or timeout of operation, then operations fail fast with ErrCircuitOpen until open time is passed.
+// After it one probe operation is passed to store, breaker is closed if it is succeeded, else it is opened again
+type BreakerRepo struct {
+	r  DataStorage
+	c  *config.StorageConfig
+	m  sync.Mutex
+	st BreakerStats
+	// until end of open state
+	until   time.Time
+	probing bool
+	now     func() time.Time
+}
+
+// NewBreakerRepository circuit breaker of store r
+func NewBreakerRepository(r DataStorage, c *config.StorageConfig) *BreakerRepo {
+	return &BreakerRepo{r: r, c: c, st: BreakerStats{State: BreakerClosed}, now: time.Now}
+}
+
+// Stats state of breaker
+func (b *BreakerRepo) Stats() BreakerStats {
+	b.m.Lock()
+	defer b.m.Unlock()
+	st := b.st
+	if st.State == BreakerOpen {
+		st.RetryAfter = b.until.Sub(b.now()).Seconds()
+	}
+	return st
+}
+
+// setState change state of breaker, it is called under lock
+func (b *BreakerRepo) setState(state string) {
+	b.st.State = state
+	breakerState.Set(state)
+}
+
+// reject count operation rejected by breaker, it is called under lock
+func (b *BreakerRepo) reject() {
+	b.st.Rejected++
+	breakerVars.Add("rejected", 1)
+}
+
+// allow check is operation passed to store, true is returned for probe of half-open breaker
+func (b *BreakerRepo) allow() (probe bool, err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	switch b.st.State {
+	case BreakerClosed:
+		return
+	case BreakerOpen:
+		if now := b.now(); now.Before(b.until) {
+			b.reject()
+			return false, &myErr.RetryError{Err: myErr.ErrCircuitOpen, After: b.until.Sub(now)}
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	if b.probing {
+		// result of probe is expected soon
+		b.reject()
+		return false, &myErr.RetryError{Err: myErr.ErrCircuitOpen, After: time.Second}
+	}
+	b.probing = true
+	return true, nil
+}
+
+// done count result of operation
+func (b *BreakerRepo) done(probe bool, err error, failures, open int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if probe {
+		b.probing = false
+	}
+	if err == nil {
+		if probe {
+			b.setState(BreakerClosed)
+		}
+		b.st.Failures = 0
+		return
+	}
+	b.st.LastError = err.Error()
+	if probe || b.st.State == BreakerClosed && b.st.Failures+1 >= failures {
+		b.setState(BreakerOpen)
+		b.st.Failures = 0
+		b.st.Opens++
+		breakerVars.Add("opens", 1)
+		b.st.LastOpen = b.now()
+		b.until = b.st.LastOpen.Add(time.Duration(open) * time.Second)
+		return
+	}
+	if b.st.State == BreakerClosed {
+		b.st.Failures++
+	}
+}
+
+// do run operation of store through breaker, operation is failed, if store is unavailable or operation is timed out
+func (b *BreakerRepo) do(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	failures, open, timeout := b.c.GetBreaker()
+	if failures <= 0 {
+		return fn(ctx)
+	}
+	var probe bool
+	if probe, err = b.allow(); err != nil {
+		return
+	}
+	opCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		opCtx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+	err = fn(opCtx)
+	switch {
+	case myErr.IsDBUnavailable(err), err != nil && errors.Is(opCtx.Err(), context.DeadlineExceeded):
+		b.done(probe, err, failures, open)
+	case err != nil && ctx.Err() != nil:
+		// request is canceled, state of store is unknown
+		if probe {
+			b.m.Lock()
+			b.probing = false
+			b.m.Unlock()
+		}
+	default:
+		b.done(probe, nil, failures, open)
+	}
+	return
+}
+
+// SetGauge save gauge through breaker
+func (b *BreakerRepo) SetGauge(ctx context.Context, k string, v domain.Gauge) error {
+	return b.do(ctx, func(ctx context.Context) error {
+		return b.r.SetGauge(ctx, k, v)
+	})
+}
+
+// SetCounter save counter through breaker
+func (b *BreakerRepo) SetCounter(ctx context.Context, k string, v domain.Counter) error {
+	return b.do(ctx, func(ctx context.Context) error {
+		return b.r.SetCounter(ctx, k, v)
+	})
+}
+
+// GetGauge get gauge through breaker
+func (b *BreakerRepo) GetGauge(ctx context.Context, k string) (v domain.Gauge, err error) {
+	err = b.do(ctx, func(ctx context.Context) (err error) {
+		v, err = b.r.GetGauge(ctx, k)
+		return
+	})
+	return
+}
+
+// GetCounter get counter through breaker
+func (b *BreakerRepo) GetCounter(ctx context.Context, k string) (v domain.Counter, err error) {
+	err = b.do(ctx, func(ctx context.Context) (err error) {
+		v, err = b.r.GetCounter(ctx, k)
+		return
+	})
+	return
+}
+
+// GetAllCounters get counters through breaker
+func (b *BreakerRepo) GetAllCounters(ctx context.Context) (v domain.Counters, err error) {
+	err = b.do(ctx, func(ctx context.Context) (err error) {
+		v, err = b.r.GetAllCounters(ctx)
+		return
+	})
+	return
+}
+
+// GetAllGauges get gauges through breaker
+func (b *BreakerRepo) GetAllGauges(ctx context.Context) (v domain.Gauges, err error) {
+	err = b.do(ctx, func(ctx context.Context) (err error) {
+		v, err = b.r.GetAllGauges(ctx)
+		return
+	})
+	return
+}
+
+// GetTenants get tenants through breaker
+func (b *BreakerRepo) GetTenants(ctx context.Context) (v []string, err error) {
+	err = b.do(ctx, func(ctx context.Context) (err error) {
+		v, err = b.r.GetTenants(ctx)
+		return
+	})
+	return
+}
+
+// SetMetrics save several metrics through breaker
+func (b *BreakerRepo) SetMetrics(ctx context.Context, metrics []domain.Metric) (v []domain.Metric, err error) {
+	err = b.do(ctx, func(ctx context.Context) (err error) {
+		v, err = b.r.SetMetrics(ctx, metrics)
+		return
+	})
+	return
+}
+
+// SetBatch save batch of fallback buffer through breaker, batch is written once if store implements BatchStore
+func (b *BreakerRepo) SetBatch(ctx context.Context, buffer string, seq int64, metrics []domain.Metric, set bool) error {
+	return b.do(ctx, func(ctx context.Context) error {
+		return setBatch(ctx, b.r, fallbackBatch{Metrics: metrics, Set: set, Buffer: buffer, Seq: seq})
+	})
+}
+
+// Ping check store through breaker, ping of half-open breaker is probe
+func (b *BreakerRepo) Ping(ctx context.Context) error {
+	return b.do(ctx, b.r.Ping)
+}
+
+// MemStore return memory store of all metrics through breaker
+func (b *BreakerRepo) MemStore(ctx context.Context) (m *MemStorageRepo, err error) {
+	err = b.do(ctx, func(ctx context.Context) (err error) {
+		m, err = b.r.MemStore(ctx)
+		return
+	})
+	return
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"expvar"
+	"testing"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowStore store, which answers after context is done
+type slowStore struct {
+	*MemStorageRepo
+}
+
+func (s *slowStore) GetGauge(ctx context.Context, _ string) (domain.Gauge, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestBreakerRepo(t *testing.T) {
+	ctx := context.Background()
+	newRepo := func(r DataStorage, failures, timeout int) (*BreakerRepo, *time.Time) {
+		now := time.Now()
+		b := NewBreakerRepository(r, &config.StorageConfig{BreakerFailures: failures, BreakerOpen: 10, BreakerTimeout: timeout})
+		b.now = func() time.Time { return now }
+		return b, &now
+	}
+
+	breakerVar := func(name string) int64 {
+		if v, ok := breakerVars.Get(name).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+
+	t.Run("open and probe", func(t *testing.T) {
+		opens, rejected := breakerVar("opens"), breakerVar("rejected")
+		s := &unavailableStore{MemStorageRepo: NewMemRepository()}
+		b, now := newRepo(s, 2, 0)
+		_, err := b.GetGauge(ctx, "gauge")
+		assert.ErrorIs(t, err, myErr.ErrNotExist, "result of store")
+		require.NoError(t, b.SetGauge(ctx, "gauge", 1))
+
+		s.down.Store(true)
+		assert.ErrorIs(t, b.SetGauge(ctx, "gauge", 2), driver.ErrBadConn)
+		assert.Equal(t, BreakerStats{State: BreakerClosed, Failures: 1, LastError: driver.ErrBadConn.Error()}, b.Stats())
+		assert.ErrorIs(t, b.SetGauge(ctx, "gauge", 2), driver.ErrBadConn)
+		assert.Equal(t, BreakerOpen, b.Stats().State)
+
+		s.down.Store(false)
+		_, err = b.GetGauge(ctx, "gauge")
+		assert.ErrorIs(t, err, myErr.ErrCircuitOpen, "fail fast")
+		assert.True(t, myErr.IsDBUnavailable(err))
+		after, ok := myErr.RetryAfter(err)
+		assert.True(t, ok)
+		assert.Equal(t, 10*time.Second, after)
+		stats := b.Stats()
+		assert.Equal(t, int64(1), stats.Opens)
+		assert.Equal(t, int64(1), stats.Rejected)
+		assert.Equal(t, 10.0, stats.RetryAfter)
+		assert.Equal(t, opens+1, breakerVar("opens"), "self metrics")
+		assert.Equal(t, rejected+1, breakerVar("rejected"))
+		assert.Equal(t, BreakerOpen, breakerVars.Get("state").(*expvar.String).Value())
+
+		s.down.Store(true)
+		*now = now.Add(10 * time.Second)
+		assert.ErrorIs(t, b.Ping(ctx), driver.ErrBadConn, "probe")
+		assert.Equal(t, BreakerOpen, b.Stats().State, "failed probe opens breaker")
+		assert.Equal(t, int64(2), b.Stats().Opens)
+
+		s.down.Store(false)
+		*now = now.Add(10 * time.Second)
+		require.NoError(t, b.Ping(ctx))
+		assert.Equal(t, BreakerClosed, b.Stats().State)
+		assert.Equal(t, BreakerClosed, breakerVars.Get("state").(*expvar.String).Value())
+		gauge, err := b.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(1), gauge)
+	})
+
+	t.Run("half-open", func(t *testing.T) {
+		b, now := newRepo(NewMemRepository(), 1, 0)
+		b.done(false, driver.ErrBadConn, 1, 10)
+		*now = now.Add(10 * time.Second)
+		probe, err := b.allow()
+		require.NoError(t, err)
+		assert.True(t, probe)
+		_, err = b.allow()
+		assert.ErrorIs(t, err, myErr.ErrCircuitOpen, "one probe at once")
+		assert.Equal(t, BreakerHalfOpen, b.Stats().State)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		b, _ := newRepo(&slowStore{MemStorageRepo: NewMemRepository()}, 1, 1)
+		cCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := b.GetGauge(cCtx, "gauge")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, BreakerClosed, b.Stats().State, "canceled request is not failure")
+
+		_, err = b.GetGauge(ctx, "gauge")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, BreakerOpen, b.Stats().State, "slow operation is failure")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s := &unavailableStore{MemStorageRepo: NewMemRepository()}
+		s.down.Store(true)
+		b, _ := newRepo(s, 0, 0)
+		for i := 0; i < 3; i++ {
+			assert.ErrorIs(t, b.Ping(ctx), driver.ErrBadConn)
+		}
+		assert.Equal(t, BreakerClosed, b.Stats().State)
+	})
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+)
+
+// CredentialStorage agents sign keys methods
+type CredentialStorage interface {
+	// GetCredential get key by key id
+	GetCredential(ctx context.Context, keyID string) (domain.Credential, error)
+	// GetCredentials get keys of agent, all keys if agent id is empty
+	GetCredentials(ctx context.Context, agentID string) ([]domain.Credential, error)
+	// SaveCredential create or update key
+	SaveCredential(ctx context.Context, c domain.Credential) error
+}
+
+// CredentialFileRepo agents keys at json file, file is reread on change.
+// Keys are kept in memory only if file path is not set
+type CredentialFileRepo struct {
+	modTime time.Time
+	c       *config.StorageConfig
+	data    map[string]domain.Credential
+	size    int64
+	m       sync.Mutex
+}
+
+func NewCredentialFileRepository(c *config.StorageConfig) *CredentialFileRepo {
+	return &CredentialFileRepo{
+		c:    c,
+		data: make(map[string]domain.Credential),
+	}
+}
+
+// GetCredential get key by key id
+func (r *CredentialFileRepo) GetCredential(_ context.Context, keyID string) (c domain.Credential, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err = r.load(); err != nil {
+		return
+	}
+	var ok bool
+	if c, ok = r.data[keyID]; !ok {
+		err = myErr.ErrNotExist
+	}
+	return
+}
+
+// GetCredentials get keys of agent, all keys if agent id is empty
+func (r *CredentialFileRepo) GetCredentials(_ context.Context, agentID string) (list []domain.Credential, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err = r.load(); err != nil {
+		return
+	}
+	list = make([]domain.Credential, 0)
+	for _, c := range r.data {
+		if agentID == "" || c.AgentID == agentID {
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt) ||
+			list[i].CreatedAt.Equal(list[j].CreatedAt) && list[i].KeyID < list[j].KeyID
+	})
+	return
+}
+
+// SaveCredential create or update key, file is rewritten
+func (r *CredentialFileRepo) SaveCredential(_ context.Context, c domain.Credential) (err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err = r.load(); err != nil {
+		return
+	}
+	prev, existed := r.data[c.KeyID]
+	r.data[c.KeyID] = c
+	if err = r.save(); err != nil {
+		if existed {
+			r.data[c.KeyID] = prev
+		} else {
+			delete(r.data, c.KeyID)
+		}
+	}
+	return
+}
+
+func (r *CredentialFileRepo) load() (err error) {
+	if r.c.CredentialsPath == "" {
+		return
+	}
+	var stat os.FileInfo
+	if stat, err = os.Stat(r.c.CredentialsPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	if stat.ModTime().Equal(r.modTime) && stat.Size() == r.size {
+		return
+	}
+	var (
+		b    []byte
+		list []domain.Credential
+	)
+	if b, err = os.ReadFile(r.c.CredentialsPath); err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, &list); err != nil {
+		return
+	}
+	r.data = make(map[string]domain.Credential, len(list))
+	for _, c := range list {
+		r.data[c.KeyID] = c
+	}
+	r.modTime, r.size = stat.ModTime(), stat.Size()
+	return
+}
+
+// save write keys to temp file and rename it to keys file
+func (r *CredentialFileRepo) save() (err error) {
+	if r.c.CredentialsPath == "" {
+		return
+	}
+	list := make([]domain.Credential, 0, len(r.data))
+	for _, c := range r.data {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].KeyID < list[j].KeyID })
+	var b []byte
+	if b, err = json.MarshalIndent(list, "", "  "); err != nil {
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(r.c.CredentialsPath), "."+filepath.Base(r.c.CredentialsPath)+".tmp")
+	if err = os.WriteFile(tmp, b, 0o600); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, r.c.CredentialsPath); err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+	var stat os.FileInfo
+	if stat, err = os.Stat(r.c.CredentialsPath); err != nil {
+		return
+	}
+	r.modTime, r.size = stat.ModTime(), stat.Size()
+	return
+}
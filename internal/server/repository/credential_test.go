@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialFileRepo(t *testing.T) {
+	ctx := context.Background()
+	c := &config.StorageConfig{CredentialsPath: filepath.Join(t.TempDir(), "credentials.json")}
+	r := NewCredentialFileRepository(c)
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	t.Run("file not exist", func(t *testing.T) {
+		_, err := r.GetCredential(ctx, "k1")
+		assert.True(t, errors.Is(err, myErr.ErrNotExist))
+		list, err := r.GetCredentials(ctx, "")
+		require.NoError(t, err)
+		assert.Empty(t, list)
+	})
+
+	k1 := domain.Credential{KeyID: "k1", AgentID: "agent-1", Secret: "secret-1", NotBefore: now, CreatedAt: now}
+	k2 := domain.Credential{KeyID: "k2", AgentID: "agent-2", Secret: "secret-2", NotBefore: now, CreatedAt: now.Add(time.Second)}
+	require.NoError(t, r.SaveCredential(ctx, k1))
+	require.NoError(t, r.SaveCredential(ctx, k2))
+
+	t.Run("saved", func(t *testing.T) {
+		got, err := r.GetCredential(ctx, "k1")
+		require.NoError(t, err)
+		assert.Equal(t, k1, got)
+		list, err := r.GetCredentials(ctx, "agent-2")
+		require.NoError(t, err)
+		assert.Equal(t, []domain.Credential{k2}, list)
+		stat, err := os.Stat(c.CredentialsPath)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), stat.Mode().Perm())
+	})
+
+	t.Run("update", func(t *testing.T) {
+		k1.RevokedAt = &now
+		require.NoError(t, r.SaveCredential(ctx, k1))
+		got, err := r.GetCredential(ctx, "k1")
+		require.NoError(t, err)
+		assert.False(t, got.Active(now))
+	})
+
+	t.Run("read by other instance", func(t *testing.T) {
+		list, err := NewCredentialFileRepository(c).GetCredentials(ctx, "")
+		require.NoError(t, err)
+		assert.Equal(t, []domain.Credential{k1, k2}, list)
+	})
+
+	t.Run("reload on file change", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(c.CredentialsPath, []byte(`[{"key_id": "k3", "agent_id": "agent-3", "secret": "s"}]`), 0o600))
+		_, err := r.GetCredential(ctx, "k1")
+		assert.True(t, errors.Is(err, myErr.ErrNotExist))
+		got, err := r.GetCredential(ctx, "k3")
+		require.NoError(t, err)
+		assert.Equal(t, "agent-3", got.AgentID)
+	})
+
+	t.Run("memory only without file", func(t *testing.T) {
+		m := NewCredentialFileRepository(&config.StorageConfig{})
+		require.NoError(t, m.SaveCredential(ctx, k2))
+		got, err := m.GetCredential(ctx, "k2")
+		require.NoError(t, err)
+		assert.Equal(t, k2, got)
+	})
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CredentialDBRepo agents keys at database
+type CredentialDBRepo struct {
+	db *sqlx.DB
+}
+
+func NewCredentialDBRepository(db *sqlx.DB) *CredentialDBRepo {
+	return &CredentialDBRepo{db: db}
+}
+
+const credentialFields = `key_id, agent_id, secret, not_before, not_after, revoked_at, created_at`
+
+// GetCredential get key by key id
+func (r *CredentialDBRepo) GetCredential(ctx context.Context, keyID string) (c domain.Credential, err error) {
+	err = retryFunc(ctx, func() (err error) {
+		err = r.db.GetContext(ctx, &c, `SELECT `+credentialFields+` FROM `+constant.DBTableNameCredentials+
+			` WHERE key_id = $1`, keyID)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = myErr.ErrNotExist
+		}
+		return
+	})
+	return
+}
+
+// GetCredentials get keys of agent, all keys if agent id is empty
+func (r *CredentialDBRepo) GetCredentials(ctx context.Context, agentID string) (list []domain.Credential, err error) {
+	err = retryFunc(ctx, func() (err error) {
+		list = make([]domain.Credential, 0)
+		err = r.db.SelectContext(ctx, &list, `SELECT `+credentialFields+` FROM `+constant.DBTableNameCredentials+
+			` WHERE $1 = '' OR agent_id = $1 ORDER BY created_at, key_id`, agentID)
+		return
+	})
+	return
+}
+
+// SaveCredential create or update key
+func (r *CredentialDBRepo) SaveCredential(ctx context.Context, c domain.Credential) (err error) {
+	err = retryFunc(ctx, func() (err error) {
+		_, err = r.db.NamedExecContext(ctx, `INSERT INTO `+constant.DBTableNameCredentials+` (`+credentialFields+`)
+ VALUES (:key_id, :agent_id, :secret, :not_before, :not_after, :revoked_at, :created_at)
+ ON CONFLICT (key_id) DO UPDATE SET agent_id = EXCLUDED.agent_id, secret = EXCLUDED.secret,
+ not_before = EXCLUDED.not_before, not_after = EXCLUDED.not_after, revoked_at = EXCLUDED.revoked_at`, c)
+		return
+	})
+	return
+}
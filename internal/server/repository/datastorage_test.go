@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+	myMigrate "go-musthave-metrics/internal/server/migrate"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDataStorage same repository tests for all data stores, database store is tested if DatabaseDSN is set
+func TestDataStorage(t *testing.T) {
+	t.Run("mem", func(t *testing.T) {
+		testDataStorage(t, NewMemRepository())
+	})
+	t.Run("disk", func(t *testing.T) {
+		r := NewDiskStorageRepository(t.TempDir())
+		defer func() { require.NoError(t, r.Close()) }()
+		testDataStorage(t, r)
+	})
+	t.Run("write-behind", func(t *testing.T) {
+		r := NewWriteBehindRepository(NewMemRepository(), &config.StorageConfig{WriteBehindFlush: 3600, WriteBehindMax: 4})
+		defer func() { require.NoError(t, r.Close()) }()
+		testDataStorage(t, r)
+	})
+	t.Run("fallback", func(t *testing.T) {
+		r := NewFallbackRepository(NewMemRepository(), &config.StorageConfig{DBFallbackPath: filepath.Join(t.TempDir(), "fallback")})
+		defer func() { require.NoError(t, r.Close()) }()
+		testDataStorage(t, r)
+	})
+	t.Run("db", func(t *testing.T) {
+		if dbTest == nil {
+			t.Skip("DatabaseDSN required")
+		}
+		if _, err := myMigrate.Migrate(dbTest.DB); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			require.NoError(t, err)
+		}
+		testDataStorage(t, NewDBStorageRepository(dbTest))
+	})
+}
+
+// testDataStorage tenant of test is unique, so store can have metrics of other tests
+func testDataStorage(t *testing.T, r DataStorage) {
+	ctx := context.Background()
+	tenant := fmt.Sprintf("conformance%d", time.Now().UnixNano())
+	ctxT := domain.WithTenant(ctx, tenant)
+
+	require.NoError(t, r.Ping(ctx))
+
+	_, err := r.GetGauge(ctxT, "gauge")
+	assert.ErrorIs(t, err, myErr.ErrNotExist, "no gauge")
+	_, err = r.GetCounter(ctxT, "counter")
+	assert.ErrorIs(t, err, myErr.ErrNotExist, "no counter")
+
+	require.NoError(t, r.SetGauge(ctxT, "gauge", 1.5))
+	require.NoError(t, r.SetGauge(ctxT, "gauge", 2.5))
+	gauge, err := r.GetGauge(ctxT, "gauge")
+	require.NoError(t, err)
+	assert.Equal(t, domain.Gauge(2.5), gauge, "gauge is replaced")
+
+	require.NoError(t, r.SetCounter(ctxT, "counter", 3))
+	require.NoError(t, r.SetCounter(ctxT, "counter", 4))
+	counter, err := r.GetCounter(ctxT, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, domain.Counter(4), counter, "counter is set, not increased")
+
+	_, err = r.GetGauge(ctxT, "counter")
+	assert.ErrorIs(t, err, myErr.ErrNotExist, "types are separate")
+
+	metrics, err := r.SetMetrics(ctxT, []domain.Metric{
+		{ID: "counter", MType: constant.MetricTypeCounter, Delta: &[]domain.Counter{5}[0]},
+		{ID: "gauge2", MType: constant.MetricTypeGauge, Value: &[]domain.Gauge{7}[0]},
+		{ID: "counter2", MType: constant.MetricTypeCounter, Delta: &[]domain.Counter{1}[0]},
+	})
+	require.NoError(t, err)
+	require.Len(t, metrics, 3)
+	assert.Equal(t, domain.Counter(9), *metrics[0].Delta, "counter is increased")
+	assert.Equal(t, domain.Gauge(7), *metrics[1].Value)
+	assert.Equal(t, domain.Counter(1), *metrics[2].Delta)
+
+	_, err = r.SetMetrics(ctxT, []domain.Metric{
+		{ID: "counter2", MType: constant.MetricTypeCounter, Delta: &[]domain.Counter{2}[0]},
+		{ID: "gauge2", MType: constant.MetricTypeGauge, Value: &[]domain.Gauge{8}[0]},
+		{ID: "counter2", MType: constant.MetricTypeCounter, Delta: &[]domain.Counter{3}[0]},
+		{ID: "gauge2", MType: constant.MetricTypeGauge, Value: &[]domain.Gauge{7}[0]},
+	})
+	require.NoError(t, err, "same names at batch")
+
+	counters, err := r.GetAllCounters(ctxT)
+	require.NoError(t, err)
+	assert.Equal(t, domain.Counters{"counter": 9, "counter2": 6}, counters, "deltas of same name are summed")
+	gauges, err := r.GetAllGauges(ctxT)
+	require.NoError(t, err)
+	assert.Equal(t, domain.Gauges{"gauge": 2.5, "gauge2": 7}, gauges)
+
+	counters["counter"] = 100
+	counter, err = r.GetCounter(ctxT, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, domain.Counter(9), counter, "all counters are copy")
+
+	_, err = r.GetGauge(ctx, "gauge2")
+	assert.ErrorIs(t, err, myErr.ErrNotExist, "metric of other tenant")
+
+	tenants, err := r.GetTenants(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, tenants)
+	assert.Equal(t, domain.DefaultTenant, tenants[0], "default tenant is first")
+	assert.Contains(t, tenants, tenant)
+
+	m, err := r.MemStore(ctx)
+	require.NoError(t, err)
+	gauge, err = m.GetGauge(ctxT, "gauge2")
+	require.NoError(t, err)
+	assert.Equal(t, domain.Gauge(7), gauge, "memory store has metrics of tenants")
+}
+
+func TestDiskStorageRepo(t *testing.T) {
+	ctx := context.Background()
+	ctxA := domain.WithTenant(ctx, "teamA")
+	dir := t.TempDir()
+	path := filepath.Join(dir, constant.DiskStorageFile)
+
+	r := NewDiskStorageRepository(dir)
+	require.NoError(t, r.SetGauge(ctx, "gauge", 1))
+	require.NoError(t, r.SetCounter(ctxA, "counter", 2))
+	require.NoError(t, r.Close())
+
+	t.Run("reopen", func(t *testing.T) {
+		r = NewDiskStorageRepository(dir)
+		gauge, err := r.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(1), gauge)
+		counter, err := r.GetCounter(ctxA, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(2), counter)
+		require.NoError(t, r.Close())
+	})
+
+	t.Run("partial record", func(t *testing.T) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"k":"gauge","id":"gau`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		r = NewDiskStorageRepository(dir)
+		require.NoError(t, r.SetGauge(ctx, "gauge", 3))
+		require.NoError(t, r.Close())
+
+		r = NewDiskStorageRepository(dir)
+		gauge, err := r.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(3), gauge, "partial record is cut, next one is appended after last full record")
+		require.NoError(t, r.Close())
+	})
+
+	t.Run("corrupt record", func(t *testing.T) {
+		corrupt := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(corrupt, constant.DiskStorageFile), []byte("not json\n"), 0o600))
+		assert.Error(t, NewDiskStorageRepository(corrupt).Ping(ctx))
+	})
+
+	t.Run("compaction", func(t *testing.T) {
+		r = NewDiskStorageRepository(dir)
+		for i := 0; i < 3*constant.DiskCompactMin; i++ {
+			require.NoError(t, r.SetCounter(ctxA, "counter", domain.Counter(i)))
+		}
+		require.Eventually(t, func() bool { return !r.compacting.Load() }, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, r.Compact())
+		require.NoError(t, r.Ping(ctx))
+		require.NoError(t, r.Close())
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Less(t, len(data), 200, "data log has last records only")
+
+		r = NewDiskStorageRepository(dir)
+		defer func() { require.NoError(t, r.Close()) }()
+		counter, err := r.GetCounter(ctxA, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(3*constant.DiskCompactMin-1), counter)
+		gauge, err := r.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(3), gauge)
+		require.NoError(t, r.SetGauge(ctx, "gauge", 4))
+		gauge, err = r.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(4), gauge, "write after compaction")
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		missing := filepath.Join(t.TempDir(), "missing")
+		assert.ErrorIs(t, NewDiskStorageReader(missing).Ping(ctx), os.ErrNotExist)
+		_, err := os.Stat(missing)
+		assert.ErrorIs(t, err, os.ErrNotExist, "directory is not created")
+
+		r := NewDiskStorageReader(dir)
+		defer func() { require.NoError(t, r.Close()) }()
+		gauge, err := r.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(4), gauge)
+		assert.ErrorIs(t, r.SetGauge(ctx, "gauge", 5), myErr.ErrReadOnly)
+		_, err = r.SetMetrics(ctx, []domain.Metric{{ID: "gauge", MType: constant.MetricTypeGauge, Value: &gauge}})
+		assert.ErrorIs(t, err, myErr.ErrReadOnly)
+	})
+
+	t.Run("read while closed", func(t *testing.T) {
+		r := NewDiskStorageRepository(dir)
+		defer func() { require.NoError(t, r.Close()) }()
+		var (
+			wg   sync.WaitGroup
+			stop atomic.Bool
+		)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 2000; j++ {
+					_, err := r.GetGauge(ctx, "gauge")
+					assert.NoError(t, err)
+					_, err = r.GetAllCounters(ctxA)
+					assert.NoError(t, err)
+				}
+			}()
+		}
+		go func() {
+			for !stop.Load() {
+				assert.NoError(t, r.Close())
+			}
+		}()
+		wg.Wait()
+		stop.Store(true)
+	})
+
+	t.Run("concurrent increments", func(t *testing.T) {
+		r := NewDiskStorageRepository(t.TempDir())
+		defer func() { require.NoError(t, r.Close()) }()
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				delta := domain.Counter(1)
+				_, err := r.SetMetrics(ctx, []domain.Metric{{ID: "counter", MType: constant.MetricTypeCounter, Delta: &delta}})
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		counter, err := r.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(20), counter, "increments are not lost")
+	})
+}
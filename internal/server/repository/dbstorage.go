@@ -11,6 +11,7 @@ import (
 	myErr "go-musthave-metrics/internal/server/errors"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 // DBStorageRepo is database repository
@@ -36,14 +37,18 @@ type DBStorageCounter struct {
 	Value domain.Counter
 }
 
-func retryFunc(fn func() error) (err error) {
+// retryFunc retry fn on connection error by backoff intervals, wait is stopped when ctx is done,
+// so timeout of operation is not exceeded by retries
+func retryFunc(ctx context.Context, fn func() error) (err error) {
 	for i := 0; i <= len(constant.Backoff); i++ {
 		err = fn()
-		if err == nil || !myErr.IsPQClass08Error(err) {
+		if err == nil || !myErr.IsPQClass08Error(err) || i == len(constant.Backoff) {
 			break
 		}
-		if i < len(constant.Backoff) {
-			time.Sleep(constant.Backoff[i])
+		select {
+		case <-time.After(constant.Backoff[i]):
+		case <-ctx.Done():
+			return
 		}
 	}
 	return
@@ -54,38 +59,40 @@ func (r *DBStorageRepo) Ping(ctx context.Context) (err error) {
 	if r.db == nil {
 		return myErr.ErrNoDBConnected
 	}
-	err = retryFunc(func() (err error) {
+	err = retryFunc(ctx, func() (err error) {
 		err = r.db.PingContext(ctx)
 		return
 	})
 	return
 }
 
-// SetGauge save gauge to db
+// SetGauge save gauge of request tenant to db
 func (r *DBStorageRepo) SetGauge(ctx context.Context, k string, v domain.Gauge) (err error) {
-	err = retryFunc(func() (err error) {
+	err = retryFunc(ctx, func() (err error) {
 		_, err = r.db.ExecContext(ctx, `INSERT into `+constant.DBTableNameGauges+
-			` (name, value) values ($1, $2) ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value`, k, v)
+			` (tenant, name, value) values ($1, $2, $3) ON CONFLICT (tenant, name) DO UPDATE SET value = EXCLUDED.value`,
+			domain.TenantFromContext(ctx), k, v)
 		return
 	})
 	return
 }
 
-// SetCounter save counter to db
+// SetCounter save counter of request tenant to db
 func (r *DBStorageRepo) SetCounter(ctx context.Context, k string, v domain.Counter) (err error) {
-	err = retryFunc(func() (err error) {
+	err = retryFunc(ctx, func() (err error) {
 		_, err = r.db.ExecContext(ctx, `INSERT into `+constant.DBTableNameCounters+
-			` (name, value) values ($1, $2) ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value`, k, v)
+			` (tenant, name, value) values ($1, $2, $3) ON CONFLICT (tenant, name) DO UPDATE SET value = EXCLUDED.value`,
+			domain.TenantFromContext(ctx), k, v)
 		return
 	})
 	return
 }
 
-// GetGauge get gauge from db
+// GetGauge get gauge of request tenant from db
 func (r *DBStorageRepo) GetGauge(ctx context.Context, k string) (v domain.Gauge, err error) {
-	err = retryFunc(func() (err error) {
+	err = retryFunc(ctx, func() (err error) {
 		err = r.db.GetContext(ctx, &v, `SELECT value FROM `+constant.DBTableNameGauges+
-			` WHERE name = $1`, k)
+			` WHERE tenant = $1 AND name = $2`, domain.TenantFromContext(ctx), k)
 		if errors.Is(err, sql.ErrNoRows) {
 			err = myErr.ErrNotExist
 		}
@@ -94,10 +101,11 @@ func (r *DBStorageRepo) GetGauge(ctx context.Context, k string) (v domain.Gauge,
 	return
 }
 
-// GetCounter get counter from db
+// GetCounter get counter of request tenant from db
 func (r *DBStorageRepo) GetCounter(ctx context.Context, k string) (v domain.Counter, err error) {
-	err = retryFunc(func() (err error) {
-		err = r.db.GetContext(ctx, &v, `SELECT value FROM `+constant.DBTableNameCounters+` WHERE name = $1 LIMIT 1`, k)
+	err = retryFunc(ctx, func() (err error) {
+		err = r.db.GetContext(ctx, &v, `SELECT value FROM `+constant.DBTableNameCounters+
+			` WHERE tenant = $1 AND name = $2 LIMIT 1`, domain.TenantFromContext(ctx), k)
 		if errors.Is(err, sql.ErrNoRows) {
 			err = myErr.ErrNotExist
 		}
@@ -106,11 +114,12 @@ func (r *DBStorageRepo) GetCounter(ctx context.Context, k string) (v domain.Coun
 	return
 }
 
-// GetAllCounters get all counters from db
+// GetAllCounters get all counters of request tenant from db
 func (r *DBStorageRepo) GetAllCounters(ctx context.Context) (data domain.Counters, err error) {
-	err = retryFunc(func() (err error) {
+	err = retryFunc(ctx, func() (err error) {
 		var rows *sql.Rows
-		if rows, err = r.db.QueryContext(ctx, `SELECT name, value FROM `+constant.DBTableNameCounters); err != nil {
+		if rows, err = r.db.QueryContext(ctx, `SELECT name, value FROM `+constant.DBTableNameCounters+
+			` WHERE tenant = $1`, domain.TenantFromContext(ctx)); err != nil {
 			return
 		}
 		if err = rows.Err(); err != nil {
@@ -132,11 +141,12 @@ func (r *DBStorageRepo) GetAllCounters(ctx context.Context) (data domain.Counter
 	return
 }
 
-// GetAllGauges get all gauges from db
+// GetAllGauges get all gauges of request tenant from db
 func (r *DBStorageRepo) GetAllGauges(ctx context.Context) (data domain.Gauges, err error) {
-	err = retryFunc(func() (err error) {
+	err = retryFunc(ctx, func() (err error) {
 		var rows *sql.Rows
-		if rows, err = r.db.QueryContext(ctx, `SELECT name, value FROM `+constant.DBTableNameGauges); err != nil {
+		if rows, err = r.db.QueryContext(ctx, `SELECT name, value FROM `+constant.DBTableNameGauges+
+			` WHERE tenant = $1`, domain.TenantFromContext(ctx)); err != nil {
 			return
 		}
 		if err = rows.Err(); err != nil {
@@ -158,13 +168,14 @@ func (r *DBStorageRepo) GetAllGauges(ctx context.Context) (data domain.Gauges, e
 	return
 }
 
-// SetMetrics save several metrics to db
+// SetMetrics save several metrics of request tenant to db by one statement for gauges and one for counters.
+// Metrics of same name are merged before write: last gauge, sum of counter deltas.
+// Return metrics with stored values, counters have total value after batch
 func (r *DBStorageRepo) SetMetrics(ctx context.Context, metrics []domain.Metric) (newMetrics []domain.Metric, err error) {
-	newMetrics = make([]domain.Metric, len(metrics))
-	err = retryFunc(func() (err error) {
+	var totals map[string]domain.Counter
+	err = retryFunc(ctx, func() (err error) {
 		var tx *sqlx.Tx
-		tx, err = r.db.Beginx()
-		if err != nil {
+		if tx, err = r.db.BeginTxx(ctx, nil); err != nil {
 			return
 		}
 		defer func() {
@@ -173,57 +184,159 @@ func (r *DBStorageRepo) SetMetrics(ctx context.Context, metrics []domain.Metric)
 				err = errors.Join(err, rErr)
 			}
 		}()
-		var stmtG, stmtC *sqlx.Stmt
-		if stmtG, err = tx.PreparexContext(ctx, "INSERT INTO "+constant.DBTableNameGauges+
-			" (name, value) VALUES($1, $2) ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value"); err != nil {
+		if totals, err = bulkWrite(ctx, tx, domain.TenantFromContext(ctx), metrics, false); err != nil {
 			return
 		}
-		if stmtC, err = tx.PreparexContext(ctx, "INSERT INTO "+constant.DBTableNameCounters+" as c "+
-			" (name, value) VALUES($1, $2) "+
-			"ON CONFLICT (name) DO UPDATE SET value = c.value + EXCLUDED.value "+
-			"RETURNING c.value"); err != nil {
+		return tx.Commit()
+	})
+	if err != nil {
+		return
+	}
+	newMetrics = make([]domain.Metric, len(metrics))
+	for i, metric := range metrics {
+		switch metric.MType {
+		case constant.MetricTypeGauge:
+			v := *metric.Value
+			metric.Value = &v
+		case constant.MetricTypeCounter:
+			v := totals[metric.ID]
+			metric.Delta = &v
+		}
+		newMetrics[i] = metric
+	}
+	return
+}
+
+// SetBatch save batch of fallback buffer and its sequence number in one transaction,
+// batch is skipped if sequence number of buffer is recorded already, so replayed batch is written once
+func (r *DBStorageRepo) SetBatch(ctx context.Context, buffer string, seq int64, metrics []domain.Metric, set bool) (err error) {
+	err = retryFunc(ctx, func() (err error) {
+		var tx *sqlx.Tx
+		if tx, err = r.db.BeginTxx(ctx, nil); err != nil {
 			return
 		}
 		defer func() {
-			err = errors.Join(err, stmtG.Close())
-			err = errors.Join(err, stmtC.Close())
+			rErr := tx.Rollback()
+			if rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+				err = errors.Join(err, rErr)
+			}
 		}()
+		var (
+			res sql.Result
+			n   int64
+		)
+		if res, err = tx.ExecContext(ctx, "INSERT INTO "+constant.DBTableNameFallback+" AS b (buffer, seq) VALUES ($1, $2) "+
+			"ON CONFLICT (buffer) DO UPDATE SET seq = EXCLUDED.seq WHERE b.seq < EXCLUDED.seq", buffer, seq); err != nil {
+			return
+		}
+		if n, err = res.RowsAffected(); err != nil || n == 0 {
+			// batch is written already
+			return
+		}
+		if _, err = bulkWrite(ctx, tx, domain.TenantFromContext(ctx), metrics, set); err != nil {
+			return
+		}
+		return tx.Commit()
+	})
+	return
+}
 
-		for i, metric := range metrics {
-			switch metric.MType {
-			case constant.MetricTypeGauge:
-				if _, err = stmtG.ExecContext(ctx, metric.ID, *metric.Value); err != nil {
-					return
-				}
-			case constant.MetricTypeCounter:
-				if err = stmtC.GetContext(ctx, metric.Delta, metric.ID, *metric.Delta); err != nil {
-					return
-				}
+// bulkWrite write metrics of tenant by one statement for gauges and one for counters. Metrics of same name are merged
+// before write: last gauge, sum of counter deltas or last counter value if counters are set. Return counter totals
+func bulkWrite(ctx context.Context, tx *sqlx.Tx, tenant string, metrics []domain.Metric, set bool) (totals map[string]domain.Counter, err error) {
+	var (
+		gauges   = make(map[string]domain.Gauge)
+		counters = make(map[string]domain.Counter)
+		gNames   []string
+		gValues  []float64
+		cNames   []string
+		cValues  []int64
+	)
+	totals = make(map[string]domain.Counter)
+	for _, metric := range metrics {
+		switch metric.MType {
+		case constant.MetricTypeGauge:
+			if _, ok := gauges[metric.ID]; !ok {
+				gNames = append(gNames, metric.ID)
 			}
-			newMetrics[i] = metric
+			gauges[metric.ID] = *metric.Value
+		case constant.MetricTypeCounter:
+			if _, ok := counters[metric.ID]; !ok {
+				cNames = append(cNames, metric.ID)
+			}
+			if set {
+				counters[metric.ID] = *metric.Delta
+			} else {
+				counters[metric.ID] += *metric.Delta
+			}
+		}
+	}
+	for _, name := range gNames {
+		gValues = append(gValues, float64(gauges[name]))
+	}
+	for _, name := range cNames {
+		cValues = append(cValues, int64(counters[name]))
+	}
+	if len(gNames) > 0 {
+		if _, err = tx.ExecContext(ctx, "INSERT INTO "+constant.DBTableNameGauges+" (tenant, name, value) "+
+			"SELECT $1, name, value FROM unnest($2::text[], $3::double precision[]) AS m(name, value) "+
+			"ON CONFLICT (tenant, name) DO UPDATE SET value = EXCLUDED.value",
+			tenant, pq.Array(gNames), pq.Array(gValues)); err != nil {
+			return
 		}
-		err = tx.Commit()
+	}
+	if len(cNames) > 0 {
+		update := "c.value + EXCLUDED.value"
+		if set {
+			update = "EXCLUDED.value"
+		}
+		var rows []DBStorageCounter
+		if err = tx.SelectContext(ctx, &rows, "INSERT INTO "+constant.DBTableNameCounters+" AS c (tenant, name, value) "+
+			"SELECT $1, name, value FROM unnest($2::text[], $3::bigint[]) AS m(name, value) "+
+			"ON CONFLICT (tenant, name) DO UPDATE SET value = "+update+" "+
+			"RETURNING c.name, c.value",
+			tenant, pq.Array(cNames), pq.Array(cValues)); err != nil {
+			return
+		}
+		for _, row := range rows {
+			totals[row.Name] = row.Value
+		}
+	}
+	return
+}
+
+// GetTenants get names of tenants with metrics, default tenant is first
+func (r *DBStorageRepo) GetTenants(ctx context.Context) (tenants []string, err error) {
+	err = retryFunc(ctx, func() (err error) {
+		tenants = []string{domain.DefaultTenant}
+		var list []string
+		if err = r.db.SelectContext(ctx, &list, `SELECT tenant FROM `+constant.DBTableNameGauges+
+			` WHERE tenant <> '' UNION SELECT tenant FROM `+constant.DBTableNameCounters+
+			` WHERE tenant <> '' ORDER BY tenant`); err != nil {
+			return
+		}
+		tenants = append(tenants, list...)
 		return
 	})
 	return
 }
 
-// MemStore return memory store off all metrics
+// MemStore return memory store off all metrics of all tenants
 func (r *DBStorageRepo) MemStore(ctx context.Context) (m *MemStorageRepo, err error) {
-	var (
-		counters domain.Counters
-		gauges   domain.Gauges
-	)
-	if counters, err = r.GetAllCounters(ctx); err != nil {
+	var tenants []string
+	if tenants, err = r.GetTenants(ctx); err != nil {
 		return
 	}
-	if gauges, err = r.GetAllGauges(ctx); err != nil {
-		return
-	}
-	m = &MemStorageRepo{
-		MemStorageCounter: MemStorageCounter{Counter: counters},
-		MemStorageGauge:   MemStorageGauge{Gauge: gauges},
+	m = NewMemRepository()
+	for _, tenant := range tenants {
+		tCtx := domain.WithTenant(ctx, tenant)
+		t := m.tenant(tCtx, true)
+		if t.Counter, err = r.GetAllCounters(tCtx); err != nil {
+			return
+		}
+		if t.Gauge, err = r.GetAllGauges(tCtx); err != nil {
+			return
+		}
 	}
-
 	return
 }
@@ -2,14 +2,24 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"testing"
+	"time"
 
 	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
 	"go-musthave-metrics/internal/server/domain"
+	myMigrate "go-musthave-metrics/internal/server/migrate"
 
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/jackc/pgerrcode"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func NewConfigGetTest() (c *config.Config) {
@@ -38,29 +48,137 @@ var (
 	dbTest *sqlx.DB
 )
 
+func TestRetryFunc(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var calls int
+	start := time.Now()
+	err := retryFunc(ctx, func() error {
+		calls++
+		return &pq.Error{Code: pgerrcode.ConnectionFailure}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Less(t, time.Since(start), constant.Backoff[0], "backoff wait is stopped by context")
+}
+
+func TestDBStorageRepo_SetBatch(t *testing.T) {
+	if dbTest == nil {
+		t.Skip("DatabaseDSN required")
+	}
+	if _, err := myMigrate.Migrate(dbTest.DB); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		require.NoError(t, err)
+	}
+	r := NewDBStorageRepository(dbTest)
+	ctx := domain.WithTenant(context.Background(), fmt.Sprintf("batch%d", time.Now().UnixNano()))
+	buffer := fmt.Sprintf("test%d", time.Now().UnixNano())
+	delta := domain.Counter(2)
+	metrics := []domain.Metric{{ID: "counter", MType: constant.MetricTypeCounter, Delta: &delta}}
+
+	for seq := int64(1); seq <= 2; seq++ {
+		require.NoError(t, r.SetBatch(ctx, buffer, seq, metrics, false))
+		require.NoError(t, r.SetBatch(ctx, buffer, seq, metrics, false), "replayed batch is skipped")
+	}
+	counter, err := r.GetCounter(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, domain.Counter(4), counter)
+
+	require.NoError(t, r.SetBatch(ctx, buffer, 3, metrics, true))
+	counter, err = r.GetCounter(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, domain.Counter(2), counter, "counter is set")
+}
+
 func BenchmarkDbStorageRepo_SetMetrics(b *testing.B) {
 	if dbTest == nil {
 		fmt.Println("DatabaseDSN required")
 		return
 	}
 	r := NewDBStorageRepository(dbTest)
-	const size = 100
-	metrics := make([]domain.Metric, size)
-	for i := 0; i < size; i += 2 {
-		metrics[i] = domain.Metric{
-			ID:    fmt.Sprintf("testCount%d", i),
-			MType: "counter",
-			Delta: &[]domain.Counter{domain.Counter(i) + 10}[0],
+	ctx := domain.WithTenant(context.Background(), "bench")
+	for _, size := range []int{100, 1000, 10000} {
+		metrics := make([]domain.Metric, size)
+		for i := 0; i < size; i += 2 {
+			metrics[i] = domain.Metric{
+				ID:    fmt.Sprintf("testCount%d", i),
+				MType: "counter",
+				Delta: &[]domain.Counter{domain.Counter(i) + 10}[0],
+			}
+			metrics[i+1] = domain.Metric{
+				ID:    fmt.Sprintf("testGauge%d", i+1),
+				MType: "gauge",
+				Value: &[]domain.Gauge{domain.Gauge(i+1) + 110}[0],
+			}
 		}
-		metrics[i+1] = domain.Metric{
-			ID:    fmt.Sprintf("testGauge%d", i+1),
-			MType: "gauge",
-			Value: &[]domain.Gauge{domain.Gauge(i+1) + 110}[0],
-		}
-	}
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		nM, _ := r.SetMetrics(context.Background(), metrics)
-		_ = len(nM)
+		// metrics are copied for every call, writes set counter totals to deltas of metrics
+		b.Run(fmt.Sprintf("bulk-%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				batch := copyMetrics(metrics)
+				b.StartTimer()
+				nM, _ := r.SetMetrics(ctx, batch)
+				_ = len(nM)
+			}
+		})
+		b.Run(fmt.Sprintf("each-%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				batch := copyMetrics(metrics)
+				b.StartTimer()
+				nM, _ := setMetricsEach(ctx, r, batch)
+				_ = len(nM)
+			}
+		})
 	}
 }
+
+// setMetricsEach previous implementation of SetMetrics, statement per metric, for comparison with bulk write
+func setMetricsEach(ctx context.Context, r *DBStorageRepo, metrics []domain.Metric) (newMetrics []domain.Metric, err error) {
+	newMetrics = make([]domain.Metric, len(metrics))
+	tenant := domain.TenantFromContext(ctx)
+	err = retryFunc(ctx, func() (err error) {
+		var tx *sqlx.Tx
+		tx, err = r.db.Beginx()
+		if err != nil {
+			return
+		}
+		defer func() {
+			rErr := tx.Rollback()
+			if rErr != nil && !errors.Is(rErr, sql.ErrTxDone) {
+				err = errors.Join(err, rErr)
+			}
+		}()
+		var stmtG, stmtC *sqlx.Stmt
+		if stmtG, err = tx.PreparexContext(ctx, "INSERT INTO "+constant.DBTableNameGauges+
+			" (tenant, name, value) VALUES($1, $2, $3) ON CONFLICT (tenant, name) DO UPDATE SET value = EXCLUDED.value"); err != nil {
+			return
+		}
+		if stmtC, err = tx.PreparexContext(ctx, "INSERT INTO "+constant.DBTableNameCounters+" as c "+
+			" (tenant, name, value) VALUES($1, $2, $3) "+
+			"ON CONFLICT (tenant, name) DO UPDATE SET value = c.value + EXCLUDED.value "+
+			"RETURNING c.value"); err != nil {
+			return
+		}
+		defer func() {
+			err = errors.Join(err, stmtG.Close())
+			err = errors.Join(err, stmtC.Close())
+		}()
+
+		for i, metric := range metrics {
+			switch metric.MType {
+			case constant.MetricTypeGauge:
+				if _, err = stmtG.ExecContext(ctx, tenant, metric.ID, *metric.Value); err != nil {
+					return
+				}
+			case constant.MetricTypeCounter:
+				if err = stmtC.GetContext(ctx, metric.Delta, tenant, metric.ID, *metric.Delta); err != nil {
+					return
+				}
+			}
+			newMetrics[i] = metric
+		}
+		err = tx.Commit()
+		return
+	})
+	return
+}
@@ -0,0 +1,468 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+)
+
+// diskRecord data log line, stored value of one series
+type diskRecord struct {
+	Tenant string          `json:"t,omitempty"`
+	MType  string          `json:"k"`
+	ID     string          `json:"id"`
+	Value  *domain.Gauge   `json:"v,omitempty"`
+	Delta  *domain.Counter `json:"d,omitempty"`
+}
+
+// diskEntry position of last record of series at data log
+type diskEntry struct {
+	off  int64
+	size int
+}
+
+// DiskStorageRepo embedded disk store: append-only data log of json lines and in-memory index of last record
+// of every series by tenant. Values are read from data log. Log is compacted at background, when it has more
+// outdated records than actual. Store is opened on first use, open error is returned by every method
+type DiskStorageRepo struct {
+	dir        string
+	readOnly   bool
+	f          *os.File
+	index      map[string]map[string]diskEntry
+	size       int64
+	records    int
+	m          sync.RWMutex
+	compacting atomic.Bool
+	compactErr error
+}
+
+// NewDiskStorageRepository disk store at directory dir, directory is created on open
+func NewDiskStorageRepository(dir string) *DiskStorageRepo {
+	return &DiskStorageRepo{dir: dir}
+}
+
+// NewDiskStorageReader read-only disk store at directory dir, data log must exist. Data log is not created,
+// cut or compacted, writes return ErrReadOnly
+func NewDiskStorageReader(dir string) *DiskStorageRepo {
+	return &DiskStorageRepo{dir: dir, readOnly: true}
+}
+
+func (r *DiskStorageRepo) path() string {
+	return filepath.Join(r.dir, constant.DiskStorageFile)
+}
+
+// open data log and build index, last record without line end is written partially on crash, it is cut.
+// Partial record of read-only store is skipped
+func (r *DiskStorageRepo) open() (err error) {
+	if r.f != nil {
+		return
+	}
+	var f *os.File
+	if r.readOnly {
+		if f, err = os.Open(r.path()); err != nil {
+			return
+		}
+	} else {
+		if err = os.MkdirAll(r.dir, 0o700); err != nil {
+			return
+		}
+		if f, err = os.OpenFile(r.path(), os.O_RDWR|os.O_CREATE, 0o600); err != nil {
+			return
+		}
+	}
+	index := make(map[string]map[string]diskEntry)
+	var (
+		reader  = bufio.NewReader(f)
+		off     int64
+		records int
+	)
+	for {
+		line, er := reader.ReadBytes('\n')
+		if er != nil {
+			if !errors.Is(er, io.EOF) {
+				return errors.Join(er, f.Close())
+			}
+			if len(line) > 0 && !r.readOnly {
+				if er = f.Truncate(off); er != nil {
+					return errors.Join(er, f.Close())
+				}
+			}
+			break
+		}
+		var rec diskRecord
+		if er = json.Unmarshal(line, &rec); er != nil {
+			return errors.Join(fmt.Errorf("%s: offset %d: %w", r.path(), off, er), f.Close())
+		}
+		if index[rec.Tenant] == nil {
+			index[rec.Tenant] = make(map[string]diskEntry)
+		}
+		index[rec.Tenant][rec.MType+"/"+rec.ID] = diskEntry{off: off, size: len(line)}
+		off += int64(len(line))
+		records++
+	}
+	if _, err = f.Seek(off, io.SeekStart); err != nil {
+		return errors.Join(err, f.Close())
+	}
+	r.f, r.index, r.size, r.records = f, index, off, records
+	return
+}
+
+// write append records to data log, sync it and update index
+func (r *DiskStorageRepo) write(recs ...diskRecord) (err error) {
+	if r.readOnly {
+		return myErr.ErrReadOnly
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err = r.open(); err != nil {
+		return
+	}
+	return r.append(recs...)
+}
+
+// append records to opened data log, sync it and update index, lock of store is held by caller
+func (r *DiskStorageRepo) append(recs ...diskRecord) (err error) {
+	var (
+		buf     bytes.Buffer
+		entries = make([]diskEntry, len(recs))
+	)
+	for i, rec := range recs {
+		var b []byte
+		if b, err = json.Marshal(rec); err != nil {
+			return
+		}
+		entries[i] = diskEntry{off: r.size + int64(buf.Len()), size: len(b) + 1}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	if _, err = r.f.Write(buf.Bytes()); err != nil {
+		return
+	}
+	if err = r.f.Sync(); err != nil {
+		return
+	}
+	r.size += int64(buf.Len())
+	for i, rec := range recs {
+		if r.index[rec.Tenant] == nil {
+			r.index[rec.Tenant] = make(map[string]diskEntry)
+		}
+		r.index[rec.Tenant][rec.MType+"/"+rec.ID] = entries[i]
+	}
+	r.records += len(recs)
+	r.maybeCompact()
+	return
+}
+
+// read last record of series
+func (r *DiskStorageRepo) read(e diskEntry) (rec diskRecord, err error) {
+	b := make([]byte, e.size)
+	if _, err = r.f.ReadAt(b, e.off); err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &rec)
+	return
+}
+
+// get last record of series of request tenant
+func (r *DiskStorageRepo) get(ctx context.Context, mType, k string) (rec diskRecord, err error) {
+	if err = r.rlock(); err != nil {
+		return
+	}
+	defer r.m.RUnlock()
+	e, ok := r.index[domain.TenantFromContext(ctx)][mType+"/"+k]
+	if !ok {
+		return rec, myErr.ErrNotExist
+	}
+	return r.read(e)
+}
+
+// rlock take read lock of opened data log, data log is opened if it is not opened yet or store is closed
+// before read lock is taken. Lock is released by caller
+func (r *DiskStorageRepo) rlock() error {
+	for {
+		r.m.RLock()
+		if r.f != nil {
+			return nil
+		}
+		r.m.RUnlock()
+		r.m.Lock()
+		err := r.open()
+		r.m.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// all records of request tenant
+func (r *DiskStorageRepo) all(ctx context.Context, mType string) (recs []diskRecord, err error) {
+	if err = r.rlock(); err != nil {
+		return
+	}
+	defer r.m.RUnlock()
+	for key, e := range r.index[domain.TenantFromContext(ctx)] {
+		if !strings.HasPrefix(key, mType+"/") {
+			continue
+		}
+		var rec diskRecord
+		if rec, err = r.read(e); err != nil {
+			return
+		}
+		recs = append(recs, rec)
+	}
+	return
+}
+
+// maybeCompact run compaction at background if data log has more outdated records than actual
+func (r *DiskStorageRepo) maybeCompact() {
+	live := 0
+	for _, t := range r.index {
+		live += len(t)
+	}
+	if dead := r.records - live; dead < constant.DiskCompactMin || dead <= live {
+		return
+	}
+	if !r.compacting.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer r.compacting.Store(false)
+		err := r.Compact()
+		r.m.Lock()
+		r.compactErr = err
+		r.m.Unlock()
+	}()
+}
+
+// Compact rewrite data log with last records of series only. New log is written to temporary file
+// and renamed over data log, so crash during compaction keeps old log. Closed or read-only store is not compacted
+func (r *DiskStorageRepo) Compact() (err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.f == nil || r.readOnly {
+		return
+	}
+	tmp := r.path() + ".compact"
+	var f *os.File
+	if f, err = os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil && r.f != f {
+			err = errors.Join(err, f.Close(), os.Remove(tmp))
+		}
+	}()
+	var (
+		w       = bufio.NewWriter(f)
+		index   = make(map[string]map[string]diskEntry, len(r.index))
+		off     int64
+		records int
+	)
+	for tenant, series := range r.index {
+		index[tenant] = make(map[string]diskEntry, len(series))
+		for key, e := range series {
+			b := make([]byte, e.size)
+			if _, err = r.f.ReadAt(b, e.off); err != nil {
+				return
+			}
+			if _, err = w.Write(b); err != nil {
+				return
+			}
+			index[tenant][key] = diskEntry{off: off, size: e.size}
+			off += int64(e.size)
+			records++
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return
+	}
+	if err = f.Sync(); err != nil {
+		return
+	}
+	if _, err = f.Seek(off, io.SeekStart); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, r.path()); err != nil {
+		return
+	}
+	old := r.f
+	r.f, r.index, r.size, r.records = f, index, off, records
+	return errors.Join(old.Close(), syncDir(r.dir))
+}
+
+// Close sync and close data log
+func (r *DiskStorageRepo) Close() (err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.f == nil {
+		return
+	}
+	err = errors.Join(r.f.Sync(), r.f.Close())
+	r.f = nil
+	return
+}
+
+// SetGauge save gauge to disk store
+func (r *DiskStorageRepo) SetGauge(ctx context.Context, k string, v domain.Gauge) error {
+	return r.write(diskRecord{Tenant: domain.TenantFromContext(ctx), MType: constant.MetricTypeGauge, ID: k, Value: &v})
+}
+
+// SetCounter save counter to disk store
+func (r *DiskStorageRepo) SetCounter(ctx context.Context, k string, v domain.Counter) error {
+	return r.write(diskRecord{Tenant: domain.TenantFromContext(ctx), MType: constant.MetricTypeCounter, ID: k, Delta: &v})
+}
+
+// GetGauge get gauge from disk store
+func (r *DiskStorageRepo) GetGauge(ctx context.Context, k string) (v domain.Gauge, err error) {
+	var rec diskRecord
+	if rec, err = r.get(ctx, constant.MetricTypeGauge, k); err == nil && rec.Value != nil {
+		v = *rec.Value
+	}
+	return
+}
+
+// GetCounter get counter from disk store
+func (r *DiskStorageRepo) GetCounter(ctx context.Context, k string) (v domain.Counter, err error) {
+	var rec diskRecord
+	if rec, err = r.get(ctx, constant.MetricTypeCounter, k); err == nil && rec.Delta != nil {
+		v = *rec.Delta
+	}
+	return
+}
+
+// GetAllCounters get all counters of request tenant
+func (r *DiskStorageRepo) GetAllCounters(ctx context.Context) (counters domain.Counters, err error) {
+	var recs []diskRecord
+	if recs, err = r.all(ctx, constant.MetricTypeCounter); err != nil {
+		return
+	}
+	counters = make(domain.Counters, len(recs))
+	for _, rec := range recs {
+		if rec.Delta != nil {
+			counters[rec.ID] = *rec.Delta
+		}
+	}
+	return
+}
+
+// GetAllGauges get all gauges of request tenant
+func (r *DiskStorageRepo) GetAllGauges(ctx context.Context) (gauges domain.Gauges, err error) {
+	var recs []diskRecord
+	if recs, err = r.all(ctx, constant.MetricTypeGauge); err != nil {
+		return
+	}
+	gauges = make(domain.Gauges, len(recs))
+	for _, rec := range recs {
+		if rec.Value != nil {
+			gauges[rec.ID] = *rec.Value
+		}
+	}
+	return
+}
+
+// GetTenants get names of tenants with metrics, default tenant is first
+func (r *DiskStorageRepo) GetTenants(_ context.Context) (tenants []string, err error) {
+	if err = r.rlock(); err != nil {
+		return
+	}
+	defer r.m.RUnlock()
+	tenants = make([]string, 0, len(r.index)+1)
+	for name, series := range r.index {
+		if name != domain.DefaultTenant && len(series) > 0 {
+			tenants = append(tenants, name)
+		}
+	}
+	sort.Strings(tenants)
+	tenants = append([]string{domain.DefaultTenant}, tenants...)
+	return
+}
+
+// SetMetrics save several metrics by one synced write, counters are increased by delta.
+// Return metrics with stored values
+func (r *DiskStorageRepo) SetMetrics(ctx context.Context, metrics []domain.Metric) (newMetrics []domain.Metric, err error) {
+	if r.readOnly {
+		return nil, myErr.ErrReadOnly
+	}
+	tenant := domain.TenantFromContext(ctx)
+	newMetrics = make([]domain.Metric, len(metrics))
+	recs := make([]diskRecord, 0, len(metrics))
+	counters := make(map[string]domain.Counter)
+	// current counters are read and new values are appended under one lock, so concurrent increments are not lost
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err = r.open(); err != nil {
+		return
+	}
+	for i, metric := range metrics {
+		rec := diskRecord{Tenant: tenant, MType: metric.MType, ID: metric.ID}
+		switch metric.MType {
+		case constant.MetricTypeGauge:
+			v := *metric.Value
+			rec.Value, metric.Value = &v, &v
+		case constant.MetricTypeCounter:
+			current, ok := counters[metric.ID]
+			if !ok {
+				if e, found := r.index[tenant][constant.MetricTypeCounter+"/"+metric.ID]; found {
+					var last diskRecord
+					if last, err = r.read(e); err != nil {
+						return
+					}
+					if last.Delta != nil {
+						current = *last.Delta
+					}
+				}
+			}
+			v := current + *metric.Delta
+			counters[metric.ID] = v
+			rec.Delta, metric.Delta = &v, &v
+		}
+		recs = append(recs, rec)
+		newMetrics[i] = metric
+	}
+	err = r.append(recs...)
+	return
+}
+
+// Ping check data log is opened, return last background compaction error
+func (r *DiskStorageRepo) Ping(_ context.Context) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err := r.open(); err != nil {
+		return err
+	}
+	return r.compactErr
+}
+
+// MemStore return memory store of all metrics of all tenants
+func (r *DiskStorageRepo) MemStore(ctx context.Context) (m *MemStorageRepo, err error) {
+	var tenants []string
+	if tenants, err = r.GetTenants(ctx); err != nil {
+		return
+	}
+	m = NewMemRepository()
+	for _, tenant := range tenants {
+		tCtx := domain.WithTenant(ctx, tenant)
+		t := m.tenant(tCtx, true)
+		if t.Counter, err = r.GetAllCounters(tCtx); err != nil {
+			return
+		}
+		if t.Gauge, err = r.GetAllGauges(tCtx); err != nil {
+			return
+		}
+	}
+	return
+}
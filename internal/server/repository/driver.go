@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	myErr "go-musthave-metrics/internal/server/errors"
+	myMigrate "go-musthave-metrics/internal/server/migrate"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/jmoiron/sqlx"
+)
+
+// Driver open storage of data source name, drivers are registered by url scheme.
+// Connection and files of storage are released by its Close, driver sets them by WithClose option of NewStorage
+type Driver interface {
+	Open(ctx context.Context, dsn string, c *config.StorageConfig) (*Storage, error)
+}
+
+// DriverFunc function as driver
+type DriverFunc func(ctx context.Context, dsn string, c *config.StorageConfig) (*Storage, error)
+
+func (f DriverFunc) Open(ctx context.Context, dsn string, c *config.StorageConfig) (*Storage, error) {
+	return f(ctx, dsn, c)
+}
+
+var drivers = struct {
+	m sync.RWMutex
+	d map[string]Driver
+}{d: make(map[string]Driver)}
+
+func init() {
+	Register(constant.StorageDriverMem, DriverFunc(openMem))
+	Register(constant.StorageDriverFile, DriverFunc(openDisk))
+	Register(constant.StorageDriverPostgres, DriverFunc(openDB))
+	Register("postgresql", DriverFunc(openDB))
+}
+
+// Register make driver available by url scheme, it panics if driver is nil or scheme is already registered.
+// It is called from init of driver package
+func Register(scheme string, d Driver) {
+	drivers.m.Lock()
+	defer drivers.m.Unlock()
+	scheme = strings.ToLower(scheme)
+	if d == nil {
+		panic("repository: Register driver is nil")
+	}
+	if _, ok := drivers.d[scheme]; ok {
+		panic("repository: Register called twice for driver " + scheme)
+	}
+	drivers.d[scheme] = d
+}
+
+// Drivers sorted schemes of registered drivers
+func Drivers() []string {
+	drivers.m.RLock()
+	defer drivers.m.RUnlock()
+	schemes := make([]string, 0, len(drivers.d))
+	for scheme := range drivers.d {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open open storage of url by driver of url scheme
+func Open(ctx context.Context, storageURL string, c *config.StorageConfig) (*Storage, error) {
+	u, err := url.Parse(storageURL)
+	if err != nil {
+		return nil, err
+	}
+	return OpenDriver(ctx, u.Scheme, storageURL, c)
+}
+
+// OpenDriver open storage of data source name by driver of scheme
+func OpenDriver(ctx context.Context, scheme, dsn string, c *config.StorageConfig) (*Storage, error) {
+	drivers.m.RLock()
+	d, ok := drivers.d[strings.ToLower(scheme)]
+	drivers.m.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q, registered: %s", myErr.ErrUnknownDriver, scheme, strings.Join(Drivers(), ", "))
+	}
+	return d.Open(ctx, dsn, c)
+}
+
+// openMem memory store, mem://
+func openMem(_ context.Context, _ string, c *config.StorageConfig) (*Storage, error) {
+	return newMemStorage(c), nil
+}
+
+// openDisk embedded disk store at directory of url path, file:///path, or directory path
+func openDisk(ctx context.Context, dsn string, c *config.StorageConfig) (s *Storage, err error) {
+	dir := dsn
+	if strings.Contains(dsn, "://") {
+		var u *url.URL
+		if u, err = url.Parse(dsn); err != nil {
+			return
+		}
+		dir = u.Path
+	}
+	if dir == "" {
+		return nil, errors.New("no disk store directory provided")
+	}
+	disk := NewDiskStorageRepository(dir)
+	if err = disk.Ping(ctx); err != nil {
+		return
+	}
+	return newDiskStorage(disk, c), nil
+}
+
+// openDB connect to postgres database and migrate it, store is new if database had no tables
+func openDB(ctx context.Context, dsn string, c *config.StorageConfig) (s *Storage, err error) {
+	var db *sqlx.DB
+	if db, err = sqlx.ConnectContext(ctx, "postgres", dsn); err != nil {
+		return
+	}
+	versions, err := myMigrate.Migrate(db.DB)
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return nil, errors.Join(fmt.Errorf("migrate: %w", err), db.Close())
+	}
+	// buffers are flushed before database is closed
+	s = newDBStorage(c, db, WithClose(db.Close))
+	s.New = err == nil && versions[0] == 0
+	return s, nil
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver(t *testing.T) {
+	ctx := context.Background()
+	c := &config.StorageConfig{}
+
+	t.Run("mem", func(t *testing.T) {
+		s, err := Open(ctx, "mem://", c)
+		require.NoError(t, err)
+		assert.IsType(t, &MemStorageRepo{}, s.DataStorage)
+		assert.True(t, s.New, "memory store is new")
+		assert.NoError(t, s.Close())
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "disk")
+		s, err := Open(ctx, "file://"+dir, c)
+		require.NoError(t, err)
+		assert.IsType(t, &DiskStorageRepo{}, s.DataStorage)
+		assert.False(t, s.New)
+		assert.False(t, s.WALEnabled(), "write-ahead log is memory store only")
+		require.NoError(t, s.SetGauge(ctx, "gauge", 1))
+		require.NoError(t, s.Close())
+
+		s, err = OpenDriver(ctx, constant.StorageDriverFile, dir, c)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, s.Close()) }()
+		gauge, err := s.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, float64(gauge), "directory path as data source name")
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := Open(ctx, "redis://localhost", c)
+		assert.ErrorIs(t, err, myErr.ErrUnknownDriver)
+	})
+
+	t.Run("register", func(t *testing.T) {
+		var (
+			opened string
+			closed bool
+		)
+		Register("test", DriverFunc(func(_ context.Context, dsn string, c *config.StorageConfig) (*Storage, error) {
+			opened = dsn
+			return NewStorage(c, NewMemRepository(), WithClose(func() error {
+				closed = true
+				return nil
+			})), nil
+		}))
+		assert.Contains(t, Drivers(), "test")
+		s, err := Open(ctx, "TEST://host/db", c)
+		require.NoError(t, err)
+		assert.Equal(t, "TEST://host/db", opened)
+		assert.NoError(t, s.Ping(ctx))
+		require.NoError(t, s.Close())
+		assert.True(t, closed, "driver resources are released")
+
+		assert.Panics(t, func() { Register("test", DriverFunc(nil)) }, "twice")
+		assert.Panics(t, func() { Register("test2", nil) }, "nil driver")
+	})
+}
@@ -0,0 +1,528 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+)
+
+// fallbackBatch buffered write of tenant
+type fallbackBatch struct {
+	Tenant  string          `json:"tenant,omitempty"`
+	Metrics []domain.Metric `json:"metrics"`
+	// Set counters have values, else counters have deltas
+	Set bool `json:"set,omitempty"`
+	// Buffer id of buffer, it is new for every degraded period of process
+	Buffer string `json:"buffer,omitempty"`
+	// Seq number of batch at buffer
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// BatchStore store, which writes batch of fallback buffer with its sequence number in one transaction.
+// Batch is skipped if its number is recorded already, so batch replayed again after crash is not written twice
+type BatchStore interface {
+	SetBatch(ctx context.Context, buffer string, seq int64, metrics []domain.Metric, set bool) error
+}
+
+// FallbackRepo degraded mode of database store. While database is unavailable writes are appended
+// to synced buffer file and applied to last known state of store, reads are served from it.
+// Reconciler checks database and replays buffer by batches in order of writes: counter deltas are added,
+// counter values are set. Replayed offset is kept at file near buffer, so replay is continued after restart.
+// Store, which implements BatchStore, skips batch replayed before crash, but not marked at offset file
+type FallbackRepo struct {
+	r DataStorage
+	c *config.StorageConfig
+	// m buffer lock, writes to store take read lock, buffer writes and replay take lock
+	m        sync.RWMutex
+	opened   bool
+	degraded bool
+	cache    *MemStorageRepo
+	f        *os.File
+	size     int64
+	batches  int
+	buffer   string
+	seq      int64
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewFallbackRepository degraded mode of store r, last known state is loaded and buffer is read on first use.
+// Reconciler is run until Close
+func NewFallbackRepository(r DataStorage, c *config.StorageConfig) *FallbackRepo {
+	f := &FallbackRepo{r: r, c: c, cache: NewMemRepository(), done: make(chan struct{})}
+	f.wg.Add(1)
+	go f.run()
+	return f
+}
+
+func (f *FallbackRepo) offsetPath() string {
+	return f.c.DBFallbackPath + ".offset"
+}
+
+// run replay buffer, when database is available
+func (f *FallbackRepo) run() {
+	defer f.wg.Done()
+	for {
+		select {
+		case <-time.After(constant.DBFallbackCheck * time.Second):
+		case <-f.done:
+			return
+		}
+		// buffer is kept on error until next check
+		_, _ = f.Reconcile(context.Background())
+	}
+}
+
+// Close stop reconciler and close buffer file
+func (f *FallbackRepo) Close() (err error) {
+	select {
+	case <-f.done:
+		return
+	default:
+	}
+	close(f.done)
+	f.wg.Wait()
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.f != nil {
+		err = f.f.Close()
+		f.f = nil
+	}
+	return
+}
+
+// open load last known state from store and apply not replayed batches of buffer to it,
+// store is degraded if buffer is not empty. It is called under lock
+func (f *FallbackRepo) open(ctx context.Context) (err error) {
+	if f.opened {
+		return
+	}
+	if m, er := memStoreOf(ctx, f.r); er == nil {
+		f.cache.replace(m)
+	} else if !myErr.IsDBUnavailable(er) {
+		return er
+	}
+	err = f.read(func(_ int64, batch fallbackBatch) error {
+		_, er := f.apply(batch)
+		f.batches++
+		return er
+	})
+	if err != nil {
+		return
+	}
+	f.degraded = f.batches > 0
+	f.opened = true
+	return
+}
+
+// ensureOpen open store if it is not opened yet
+func (f *FallbackRepo) ensureOpen(ctx context.Context) (err error) {
+	f.m.RLock()
+	opened := f.opened
+	f.m.RUnlock()
+	if opened {
+		return
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.open(ctx)
+}
+
+// read not replayed batches of buffer, last batch without line end is written partially on crash, it is cut
+func (f *FallbackRepo) read(fn func(end int64, batch fallbackBatch) error) (err error) {
+	var file *os.File
+	if file, err = os.OpenFile(f.c.DBFallbackPath, os.O_RDWR, 0o600); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	defer func() { err = errors.Join(err, file.Close()) }()
+	var offset int64
+	if b, er := os.ReadFile(f.offsetPath()); er == nil {
+		if offset, err = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return fmt.Errorf("%s: %w", f.offsetPath(), err)
+		}
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+	reader := bufio.NewReader(file)
+	for {
+		var line []byte
+		if line, err = reader.ReadBytes('\n'); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+				if len(line) > 0 {
+					err = file.Truncate(offset)
+				}
+				f.size = offset
+			}
+			return
+		}
+		offset += int64(len(line))
+		var batch fallbackBatch
+		if err = json.Unmarshal(line, &batch); err != nil {
+			return fmt.Errorf("%s: %w", f.c.DBFallbackPath, err)
+		}
+		if err = fn(offset, batch); err != nil {
+			return
+		}
+	}
+}
+
+// append write batch to buffer and sync it, batch is numbered at buffer
+func (f *FallbackRepo) append(batch fallbackBatch) (err error) {
+	if f.buffer == "" {
+		id := make([]byte, 16)
+		if _, err = rand.Read(id); err != nil {
+			return
+		}
+		f.buffer, f.seq = hex.EncodeToString(id), 0
+	}
+	batch.Buffer, batch.Seq = f.buffer, f.seq+1
+	var b []byte
+	if b, err = json.Marshal(batch); err != nil {
+		return
+	}
+	b = append(b, '\n')
+	if f.size+int64(len(b)) > int64(f.c.GetDBFallbackMaxSize())<<20 {
+		return myErr.ErrFallbackFull
+	}
+	if f.f == nil {
+		if f.f, err = os.OpenFile(f.c.DBFallbackPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600); err != nil {
+			return
+		}
+	}
+	if _, err = f.f.Write(b); err != nil {
+		return
+	}
+	if err = f.f.Sync(); err != nil {
+		return
+	}
+	f.size += int64(len(b))
+	f.batches++
+	f.seq = batch.Seq
+	return
+}
+
+// apply batch to last known state, return stored values
+func (f *FallbackRepo) apply(batch fallbackBatch) (stored []domain.Metric, err error) {
+	ctx := domain.WithTenant(context.Background(), batch.Tenant)
+	metrics := copyMetrics(batch.Metrics)
+	if !batch.Set {
+		return f.cache.SetMetrics(ctx, metrics)
+	}
+	for _, metric := range metrics {
+		switch metric.MType {
+		case constant.MetricTypeGauge:
+			err = f.cache.SetGauge(ctx, metric.ID, *metric.Value)
+		case constant.MetricTypeCounter:
+			err = f.cache.SetCounter(ctx, metric.ID, *metric.Delta)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return metrics, nil
+}
+
+// replay write batch to store
+func (f *FallbackRepo) replay(batch fallbackBatch) error {
+	return setBatch(domain.WithTenant(context.Background(), batch.Tenant), f.r, batch)
+}
+
+// setBatch write batch to store by BatchStore, if store implements it and batch is numbered.
+// Otherwise metrics are written one by one, so replayed batch would be written again
+func setBatch(ctx context.Context, r DataStorage, batch fallbackBatch) (err error) {
+	metrics := copyMetrics(batch.Metrics)
+	if s, ok := r.(BatchStore); ok && batch.Buffer != "" {
+		return s.SetBatch(ctx, batch.Buffer, batch.Seq, metrics, batch.Set)
+	}
+	if !batch.Set {
+		_, err = r.SetMetrics(ctx, metrics)
+		return
+	}
+	for _, metric := range metrics {
+		switch metric.MType {
+		case constant.MetricTypeGauge:
+			err = r.SetGauge(ctx, metric.ID, *metric.Value)
+		case constant.MetricTypeCounter:
+			err = r.SetCounter(ctx, metric.ID, *metric.Delta)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Reconcile replay buffer to store, if store is degraded and database is available.
+// Replayed offset is synced after every batch, store leaves degraded mode after whole buffer is replayed.
+// Batch replayed before crash or sync error of offset is replayed again, it is skipped by BatchStore
+func (f *FallbackRepo) Reconcile(ctx context.Context) (n int, err error) {
+	if err = f.ensureOpen(ctx); err != nil {
+		return
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if !f.degraded {
+		return
+	}
+	if err = f.r.Ping(ctx); err != nil {
+		return
+	}
+	if err = f.read(func(end int64, batch fallbackBatch) error {
+		if er := f.replay(batch); er != nil {
+			return er
+		}
+		n++
+		f.batches--
+		return writeSynced(f.offsetPath(), []byte(strconv.FormatInt(end, 10)))
+	}); err != nil {
+		return
+	}
+	if f.f != nil {
+		err = f.f.Close()
+		f.f = nil
+	}
+	for _, path := range []string{f.c.DBFallbackPath, f.offsetPath()} {
+		if er := os.Remove(path); er != nil && !errors.Is(er, os.ErrNotExist) {
+			err = errors.Join(err, er)
+		}
+	}
+	if err != nil {
+		return
+	}
+	f.size, f.batches, f.buffer = 0, 0, ""
+	var m *MemStorageRepo
+	if m, err = memStoreOf(ctx, f.r); err != nil {
+		return
+	}
+	f.cache.replace(m)
+	f.degraded = false
+	return
+}
+
+// write make write to store, write is buffered if database is unavailable
+func (f *FallbackRepo) write(ctx context.Context, batch fallbackBatch, direct func() ([]domain.Metric, error)) (stored []domain.Metric, err error) {
+	if err = f.ensureOpen(ctx); err != nil {
+		return
+	}
+	batch.Tenant = domain.TenantFromContext(ctx)
+	f.m.RLock()
+	if !f.degraded {
+		stored, err = direct()
+		f.m.RUnlock()
+		if err == nil {
+			_, err = f.apply(fallbackBatch{Tenant: batch.Tenant, Metrics: stored, Set: true})
+			return
+		}
+		if !myErr.IsDBUnavailable(err) {
+			return
+		}
+	} else {
+		f.m.RUnlock()
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.degraded = true
+	if err = f.append(batch); err != nil {
+		return
+	}
+	return f.apply(batch)
+}
+
+// state return true if reads are served from last known state, database is marked unavailable on error
+func (f *FallbackRepo) state(ctx context.Context, read func() error) (degraded bool, err error) {
+	if err = f.ensureOpen(ctx); err != nil {
+		return
+	}
+	f.m.RLock()
+	degraded = f.degraded
+	f.m.RUnlock()
+	if degraded {
+		return
+	}
+	if err = read(); myErr.IsDBUnavailable(err) {
+		f.m.Lock()
+		f.degraded = true
+		f.m.Unlock()
+		return true, nil
+	}
+	return
+}
+
+// SetGauge save gauge to store or buffer
+func (f *FallbackRepo) SetGauge(ctx context.Context, k string, v domain.Gauge) (err error) {
+	metrics := []domain.Metric{{ID: k, MType: constant.MetricTypeGauge, Value: &v}}
+	_, err = f.write(ctx, fallbackBatch{Metrics: metrics, Set: true}, func() ([]domain.Metric, error) {
+		return metrics, f.r.SetGauge(ctx, k, v)
+	})
+	return
+}
+
+// SetCounter save counter value to store or buffer
+func (f *FallbackRepo) SetCounter(ctx context.Context, k string, v domain.Counter) (err error) {
+	metrics := []domain.Metric{{ID: k, MType: constant.MetricTypeCounter, Delta: &v}}
+	_, err = f.write(ctx, fallbackBatch{Metrics: metrics, Set: true}, func() ([]domain.Metric, error) {
+		return metrics, f.r.SetCounter(ctx, k, v)
+	})
+	return
+}
+
+// SetMetrics save several metrics to store or buffer, counters are increased by delta
+func (f *FallbackRepo) SetMetrics(ctx context.Context, metrics []domain.Metric) ([]domain.Metric, error) {
+	return f.write(ctx, fallbackBatch{Metrics: copyMetrics(metrics)}, func() ([]domain.Metric, error) {
+		return f.r.SetMetrics(ctx, metrics)
+	})
+}
+
+// GetGauge get gauge from store or from last known state
+func (f *FallbackRepo) GetGauge(ctx context.Context, k string) (v domain.Gauge, err error) {
+	var degraded bool
+	if degraded, err = f.state(ctx, func() (err error) {
+		v, err = f.r.GetGauge(ctx, k)
+		return
+	}); degraded {
+		return f.cache.GetGauge(ctx, k)
+	}
+	return
+}
+
+// GetCounter get counter from store or from last known state
+func (f *FallbackRepo) GetCounter(ctx context.Context, k string) (v domain.Counter, err error) {
+	var degraded bool
+	if degraded, err = f.state(ctx, func() (err error) {
+		v, err = f.r.GetCounter(ctx, k)
+		return
+	}); degraded {
+		return f.cache.GetCounter(ctx, k)
+	}
+	return
+}
+
+// GetAllCounters get counters from store or from last known state
+func (f *FallbackRepo) GetAllCounters(ctx context.Context) (v domain.Counters, err error) {
+	var degraded bool
+	if degraded, err = f.state(ctx, func() (err error) {
+		v, err = f.r.GetAllCounters(ctx)
+		return
+	}); degraded {
+		return f.cache.GetAllCounters(ctx)
+	}
+	return
+}
+
+// GetAllGauges get gauges from store or from last known state
+func (f *FallbackRepo) GetAllGauges(ctx context.Context) (v domain.Gauges, err error) {
+	var degraded bool
+	if degraded, err = f.state(ctx, func() (err error) {
+		v, err = f.r.GetAllGauges(ctx)
+		return
+	}); degraded {
+		return f.cache.GetAllGauges(ctx)
+	}
+	return
+}
+
+// GetTenants get tenants from store or from last known state
+func (f *FallbackRepo) GetTenants(ctx context.Context) (v []string, err error) {
+	var degraded bool
+	if degraded, err = f.state(ctx, func() (err error) {
+		v, err = f.r.GetTenants(ctx)
+		return
+	}); degraded {
+		return f.cache.GetTenants(ctx)
+	}
+	return
+}
+
+// Ping check store, ErrDBDegraded is returned while database is unavailable
+func (f *FallbackRepo) Ping(ctx context.Context) (err error) {
+	var degraded bool
+	if degraded, err = f.state(ctx, func() error {
+		return f.r.Ping(ctx)
+	}); degraded {
+		f.m.RLock()
+		defer f.m.RUnlock()
+		return fmt.Errorf("%w: %d batches", myErr.ErrDBDegraded, f.batches)
+	}
+	return
+}
+
+// MemStore return memory store of all metrics from store or from last known state
+func (f *FallbackRepo) MemStore(ctx context.Context) (m *MemStorageRepo, err error) {
+	var degraded bool
+	if degraded, err = f.state(ctx, func() (err error) {
+		m, err = f.r.MemStore(ctx)
+		return
+	}); degraded {
+		return memStoreOf(ctx, f.cache)
+	}
+	return
+}
+
+// memStoreOf copy of all metrics of all tenants of store
+func memStoreOf(ctx context.Context, r DataStorage) (m *MemStorageRepo, err error) {
+	var tenants []string
+	if tenants, err = r.GetTenants(ctx); err != nil {
+		return
+	}
+	m = NewMemRepository()
+	for _, tenant := range tenants {
+		tCtx := domain.WithTenant(ctx, tenant)
+		t := m.tenant(tCtx, true)
+		if t.Counter, err = r.GetAllCounters(tCtx); err != nil {
+			return
+		}
+		if t.Gauge, err = r.GetAllGauges(tCtx); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// copyMetrics copy of metrics with own values
+func copyMetrics(metrics []domain.Metric) []domain.Metric {
+	c := make([]domain.Metric, len(metrics))
+	for i, metric := range metrics {
+		if metric.Value != nil {
+			v := *metric.Value
+			metric.Value = &v
+		}
+		if metric.Delta != nil {
+			v := *metric.Delta
+			metric.Delta = &v
+		}
+		c[i] = metric
+	}
+	return c
+}
+
+// writeSynced write data to file and sync it
+func writeSynced(path string, data []byte) (err error) {
+	var file *os.File
+	if file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600); err != nil {
+		return
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	return errors.Join(err, file.Close())
+}
@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unavailableStore store, which connection is lost while down
+type unavailableStore struct {
+	*MemStorageRepo
+	down atomic.Bool
+}
+
+func (s *unavailableStore) err() error {
+	if s.down.Load() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (s *unavailableStore) SetGauge(ctx context.Context, k string, v domain.Gauge) error {
+	if err := s.err(); err != nil {
+		return err
+	}
+	return s.MemStorageRepo.SetGauge(ctx, k, v)
+}
+
+func (s *unavailableStore) SetCounter(ctx context.Context, k string, v domain.Counter) error {
+	if err := s.err(); err != nil {
+		return err
+	}
+	return s.MemStorageRepo.SetCounter(ctx, k, v)
+}
+
+func (s *unavailableStore) SetMetrics(ctx context.Context, metrics []domain.Metric) ([]domain.Metric, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.MemStorageRepo.SetMetrics(ctx, metrics)
+}
+
+func (s *unavailableStore) GetGauge(ctx context.Context, k string) (domain.Gauge, error) {
+	if err := s.err(); err != nil {
+		return 0, err
+	}
+	return s.MemStorageRepo.GetGauge(ctx, k)
+}
+
+func (s *unavailableStore) GetCounter(ctx context.Context, k string) (domain.Counter, error) {
+	if err := s.err(); err != nil {
+		return 0, err
+	}
+	return s.MemStorageRepo.GetCounter(ctx, k)
+}
+
+func (s *unavailableStore) GetAllCounters(ctx context.Context) (domain.Counters, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.MemStorageRepo.GetAllCounters(ctx)
+}
+
+func (s *unavailableStore) GetAllGauges(ctx context.Context) (domain.Gauges, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.MemStorageRepo.GetAllGauges(ctx)
+}
+
+func (s *unavailableStore) GetTenants(ctx context.Context) ([]string, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.MemStorageRepo.GetTenants(ctx)
+}
+
+func (s *unavailableStore) Ping(ctx context.Context) error {
+	if err := s.err(); err != nil {
+		return err
+	}
+	return s.MemStorageRepo.Ping(ctx)
+}
+
+// batchStore unavailable store, which records numbers of written batches as database store
+type batchStore struct {
+	*unavailableStore
+	m    sync.Mutex
+	seqs map[string]int64
+}
+
+func (s *batchStore) SetBatch(ctx context.Context, buffer string, seq int64, metrics []domain.Metric, set bool) error {
+	if err := s.err(); err != nil {
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.seqs[buffer] >= seq {
+		return nil
+	}
+	if err := setBatch(ctx, s.MemStorageRepo, fallbackBatch{Metrics: metrics, Set: set}); err != nil {
+		return err
+	}
+	s.seqs[buffer] = seq
+	return nil
+}
+
+func TestFallbackRepo(t *testing.T) {
+	ctx := context.Background()
+	ctxA := domain.WithTenant(ctx, "teamA")
+	delta := func(v domain.Counter) []domain.Metric {
+		return []domain.Metric{{ID: "counter", MType: constant.MetricTypeCounter, Delta: &v}}
+	}
+	newRepo := func(s DataStorage, path string) *FallbackRepo {
+		f := NewFallbackRepository(s, &config.StorageConfig{DBFallbackPath: path, DBFallbackMaxSize: 1})
+		t.Cleanup(func() { _ = f.Close() })
+		return f
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		s := &unavailableStore{MemStorageRepo: NewMemRepository()}
+		f := newRepo(s, filepath.Join(t.TempDir(), "fallback"))
+		_, err := f.SetMetrics(ctxA, delta(10))
+		require.NoError(t, err)
+		require.NoError(t, f.SetGauge(ctx, "gauge", 1))
+		require.NoError(t, f.Ping(ctx))
+
+		s.down.Store(true)
+		m, err := f.SetMetrics(ctxA, delta(5))
+		require.NoError(t, err, "write is buffered")
+		assert.Equal(t, domain.Counter(15), *m[0].Delta, "last known value is returned")
+		require.NoError(t, f.SetGauge(ctx, "gauge", 2))
+		require.NoError(t, f.SetCounter(ctx, "counter", 7))
+		_, err = f.SetMetrics(ctx, delta(1))
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, f.Ping(ctx), myErr.ErrDBDegraded)
+		counter, err := f.GetCounter(ctxA, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(15), counter, "read from last known state")
+		gauges, err := f.GetAllGauges(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauges{"gauge": 2}, gauges)
+		tenants, err := f.GetTenants(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"", "teamA"}, tenants)
+
+		n, err := f.Reconcile(ctx)
+		assert.ErrorIs(t, err, driver.ErrBadConn, "database is still down")
+		assert.Zero(t, n)
+
+		s.down.Store(false)
+		require.NoError(t, s.MemStorageRepo.SetCounter(ctxA, "counter", 100), "changed by other writer")
+		n, err = f.Reconcile(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+		require.NoError(t, f.Ping(ctx))
+		counter, err = s.GetCounter(ctxA, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(105), counter, "delta is added to stored value")
+		counter, err = s.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(8), counter, "value is set, delta is added after it")
+		gauge, err := s.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(2), gauge)
+		_, err = os.Stat(f.c.DBFallbackPath)
+		assert.ErrorIs(t, err, os.ErrNotExist, "buffer is removed")
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "fallback")
+		s := &unavailableStore{MemStorageRepo: NewMemRepository()}
+		s.down.Store(true)
+		f := newRepo(s, path)
+		for i := 0; i < 3; i++ {
+			_, err := f.SetMetrics(ctx, delta(1))
+			require.NoError(t, err)
+		}
+		require.NoError(t, f.Close())
+
+		s.down.Store(false)
+		f = newRepo(s, path)
+		assert.ErrorIs(t, f.Ping(ctx), myErr.ErrDBDegraded, "buffer is not replayed yet")
+		counter, err := f.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(3), counter, "buffer is applied to last known state")
+
+		require.NoError(t, os.WriteFile(path+".offset", []byte("0"), 0o600))
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"metrics":[{"id":"cou`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+		n, err := f.Reconcile(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n, "partial batch is cut")
+		counter, err = s.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(3), counter)
+	})
+
+	t.Run("offset", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "fallback")
+		s := &unavailableStore{MemStorageRepo: NewMemRepository()}
+		s.down.Store(true)
+		f := newRepo(s, path)
+		for i := 0; i < 2; i++ {
+			_, err := f.SetMetrics(ctx, delta(1))
+			require.NoError(t, err)
+		}
+		require.NoError(t, f.Close())
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path+".offset", []byte(strconv.Itoa(len(b)/2)), 0o600))
+		require.NoError(t, s.MemStorageRepo.SetCounter(ctx, "counter", 1), "first batch is replayed before crash")
+
+		s.down.Store(false)
+		f = newRepo(s, path)
+		n, err := f.Reconcile(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n, "replay is continued from offset")
+		counter, err := s.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(2), counter, "delta is written once")
+	})
+
+	t.Run("replay once", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "fallback")
+		s := &batchStore{unavailableStore: &unavailableStore{MemStorageRepo: NewMemRepository()}, seqs: map[string]int64{}}
+		s.down.Store(true)
+		// batches are written through breaker as database store
+		f := newRepo(NewBreakerRepository(s, &config.StorageConfig{}), path)
+		for i := 0; i < 2; i++ {
+			_, err := f.SetMetrics(ctx, delta(1))
+			require.NoError(t, err)
+		}
+		require.NoError(t, f.Close())
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		s.down.Store(false)
+		f = newRepo(NewBreakerRepository(s, &config.StorageConfig{}), path)
+		n, err := f.Reconcile(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		// crash before offset is synced, buffer is replayed again after restart
+		require.NoError(t, os.WriteFile(path, b, 0o600))
+		f = newRepo(NewBreakerRepository(s, &config.StorageConfig{}), path)
+		_, err = f.Reconcile(ctx)
+		require.NoError(t, err)
+		counter, err := s.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(2), counter, "batches are written once")
+	})
+
+	t.Run("full", func(t *testing.T) {
+		s := &unavailableStore{MemStorageRepo: NewMemRepository()}
+		s.down.Store(true)
+		f := newRepo(s, filepath.Join(t.TempDir(), "fallback"))
+		metrics := make([]domain.Metric, 50000)
+		for i := range metrics {
+			v := domain.Gauge(i)
+			metrics[i] = domain.Metric{ID: "gauge", MType: constant.MetricTypeGauge, Value: &v}
+		}
+		_, err := f.SetMetrics(ctx, metrics)
+		assert.ErrorIs(t, err, myErr.ErrFallbackFull)
+		_, err = f.SetMetrics(ctx, delta(1))
+		assert.NoError(t, err, "small batch fits")
+	})
+
+	t.Run("not available", func(t *testing.T) {
+		s := &unavailableStore{MemStorageRepo: NewMemRepository()}
+		f := newRepo(s, filepath.Join(t.TempDir(), "fallback"))
+		_, err := f.GetGauge(ctx, "gauge")
+		assert.ErrorIs(t, err, myErr.ErrNotExist)
+		require.NoError(t, f.Ping(ctx))
+		s.down.Store(true)
+		_, err = f.GetGauge(ctx, "gauge")
+		assert.ErrorIs(t, err, myErr.ErrNotExist, "read from last known state")
+		assert.ErrorIs(t, f.Ping(ctx), myErr.ErrDBDegraded)
+	})
+}
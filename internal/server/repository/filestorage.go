@@ -1,12 +1,23 @@
 package repository
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"time"
 
 	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+	"go-musthave-metrics/internal/server/keyring"
 )
 
 // FileStorage handle file storage methods
@@ -15,6 +26,18 @@ type FileStorage interface {
 	RestoreFromFile(m *MemStorageRepo) error
 }
 
+// snapshotHeader first line of storage file, store json follows it
+type snapshotHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Checksum sha256 of store data as it is written, after compression and encryption
+	Checksum string `json:"checksum"`
+	Gzip     bool   `json:"gzip,omitempty"`
+	// KeyID id of storage key, store data is encrypted by it, empty if not encrypted
+	KeyID string `json:"key_id,omitempty"`
+}
+
 type FileStorageRepo struct {
 	c *config.StorageConfig
 }
@@ -25,32 +48,221 @@ func NewFileStorageRepository(c *config.StorageConfig) *FileStorageRepo {
 	}
 }
 
-// RestoreFromFile restore storage from file
+// RestoreFromFile restore storage from newest valid generation of storage file.
+// ErrSnapshotFallback is returned with restored previous generation if storage file is corrupt
 func (f *FileStorageRepo) RestoreFromFile(m *MemStorageRepo) (err error) {
 	if f.c.FileStoragePath == "" {
 		return fmt.Errorf("no storage file provided")
 	}
-	var data []byte
-	if data, err = os.ReadFile(f.c.FileStoragePath); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil
+	keep, _ := f.c.GetSnapshot()
+	var errs []error
+	for i := 0; i <= keep; i++ {
+		var s *MemStorageRepo
+		if s, err = readSnapshot(generation(f.c.FileStoragePath, i), f.c.GetStorageKeys()); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if s == nil {
+			continue
 		}
-		return err
+		m.replace(s)
+		if len(errs) > 0 {
+			return errors.Join(append([]error{myErr.ErrSnapshotFallback}, errs...)...)
+		}
+		return nil
 	}
-
-	return json.Unmarshal(data, &m)
+	return errors.Join(errs...)
 }
 
-// SaveToFile save storage to file
-func (f *FileStorageRepo) SaveToFile(m *MemStorageRepo) error {
+// SaveToFile save storage to temporary file and rename it to storage file, previous storage files are kept
+// as generations with number suffix, greater number is older file. Metrics of tenants are saved at tenants section
+func (f *FileStorageRepo) SaveToFile(m *MemStorageRepo) (err error) {
 	if f.c.FileStoragePath == "" {
 		return fmt.Errorf("no storage file provided")
 	}
-	m.mc.Lock()
-	defer m.mc.Unlock()
-	data, err := json.Marshal(m)
+	keep, compress := f.c.GetSnapshot()
+	var data []byte
+	if data, err = m.marshal(); err != nil {
+		return
+	}
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(data); err != nil {
+			return
+		}
+		if err = zw.Close(); err != nil {
+			return
+		}
+		data = buf.Bytes()
+	}
+	header := snapshotHeader{
+		Format:  constant.SnapshotFormat,
+		Version: constant.SnapshotVersion,
+		Created: time.Now(),
+		Gzip:    compress,
+	}
+	if keys := f.c.GetStorageKeys(); keys != nil {
+		if header.KeyID, data, err = keys.Seal(data, []byte(constant.SnapshotFormat)); err != nil {
+			return
+		}
+	}
+	sum := sha256.Sum256(data)
+	header.Checksum = hex.EncodeToString(sum[:])
+	var headerData []byte
+	if headerData, err = json.Marshal(header); err != nil {
+		return
+	}
+
+	var tmp string
+	if tmp, err = writeTemp(f.c.FileStoragePath, append(append(headerData, '\n'), data...)); err != nil {
+		return
+	}
+	if err = rotateGenerations(f.c.FileStoragePath, keep); err == nil {
+		err = os.Rename(tmp, f.c.FileStoragePath)
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+	return syncDir(filepath.Dir(f.c.FileStoragePath))
+}
+
+// marshal store json, copy of store is marshaled, stores are changed concurrently
+func (r *MemStorageRepo) marshal() ([]byte, error) {
+	return json.Marshal(r.clone())
+}
+
+// replace metrics of store by metrics of restored store
+func (r *MemStorageRepo) replace(s *MemStorageRepo) {
+	for _, t := range s.Tenants {
+		if t.Counter == nil {
+			t.Counter = domain.Counters{}
+		}
+		if t.Gauge == nil {
+			t.Gauge = domain.Gauges{}
+		}
+	}
+	r.mt.Lock()
+	r.Tenants = s.Tenants
+	r.mt.Unlock()
+	r.mc.Lock()
+	r.Counter = s.Counter
+	if r.Counter == nil {
+		r.Counter = domain.Counters{}
+	}
+	r.mc.Unlock()
+	r.mg.Lock()
+	r.Gauge = s.Gauge
+	if r.Gauge == nil {
+		r.Gauge = domain.Gauges{}
+	}
+	r.mg.Unlock()
+}
+
+// generation path of storage file generation n, storage file if n is 0
+func generation(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// readSnapshot read, check and decrypt storage file. File without header is storage file of previous format,
+// plain store json. Nil store without error is returned for empty file
+func readSnapshot(path string, keys *keyring.Keyring) (m *MemStorageRepo, err error) {
+	var data []byte
+	if data, err = os.ReadFile(path); err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return
+	}
+	var header snapshotHeader
+	if i := bytes.IndexByte(data, '\n'); i >= 0 && json.Unmarshal(data[:i], &header) == nil &&
+		header.Format == constant.SnapshotFormat {
+		if data, err = header.payload(data[i+1:], keys); err != nil {
+			if isKeyError(err) {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return nil, fmt.Errorf("%w: %s: %w", myErr.ErrSnapshotCorrupt, path, err)
+		}
+	}
+	m = &MemStorageRepo{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", myErr.ErrSnapshotCorrupt, path, err)
+	}
+	return
+}
+
+// payload check version and checksum of store data, decrypt and decompress it
+func (h snapshotHeader) payload(data []byte, keys *keyring.Keyring) (_ []byte, err error) {
+	if h.Version > constant.SnapshotVersion {
+		return nil, fmt.Errorf("unknown format version %d", h.Version)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != h.Checksum {
+		return nil, errors.New("checksum mismatch")
+	}
+	if h.KeyID != "" {
+		if data, err = keys.Open(h.KeyID, data, []byte(constant.SnapshotFormat)); err != nil {
+			return
+		}
+	}
+	if !h.Gzip {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
+
+// isKeyError is error of storage key: key is not set, unknown or wrong
+func isKeyError(err error) bool {
+	return errors.Is(err, keyring.ErrNoKey) || errors.Is(err, keyring.ErrUnknownKey) || errors.Is(err, keyring.ErrWrongKey)
+}
+
+// writeTemp write data to synced temporary file at directory of path
+func writeTemp(path string, data []byte) (name string, err error) {
+	var file *os.File
+	if file, err = os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*"); err != nil {
+		return
+	}
+	name = file.Name()
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if err = errors.Join(err, file.Close()); err == nil {
+		err = os.Chmod(name, 0644)
+	}
 	if err != nil {
-		return err
+		err = errors.Join(err, os.Remove(name))
+	}
+	return
+}
+
+// rotateGenerations shift storage file generations, oldest generation over keep is removed,
+// storage file becomes first generation
+func rotateGenerations(path string, keep int) (err error) {
+	if keep <= 0 {
+		return
+	}
+	if err = os.Remove(generation(path, keep)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	for i := keep - 1; i >= 0; i-- {
+		if err = os.Rename(generation(path, i), generation(path, i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+	}
+	return nil
+}
+
+// syncDir sync directory, so renames are durable
+func syncDir(dir string) (err error) {
+	var d *os.File
+	if d, err = os.Open(dir); err != nil {
+		return
 	}
-	return os.WriteFile(f.c.FileStoragePath, data, 0644)
+	return errors.Join(d.Sync(), d.Close())
 }
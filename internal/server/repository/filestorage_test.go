@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+	"go-musthave-metrics/internal/server/keyring"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorageRepo(t *testing.T) {
+	ctx := context.Background()
+	c := &config.StorageConfig{FileStoragePath: filepath.Join(t.TempDir(), "store.json"), SnapshotKeep: 2}
+	f := NewFileStorageRepository(c)
+
+	store := func(v domain.Gauge) *MemStorageRepo {
+		m := NewMemRepository()
+		require.NoError(t, m.SetGauge(ctx, "g", v))
+		require.NoError(t, m.SetCounter(domain.WithTenant(ctx, "team"), "c", domain.Counter(v)))
+		return m
+	}
+	restore := func(t *testing.T) (*MemStorageRepo, error) {
+		m := NewMemRepository()
+		err := f.RestoreFromFile(m)
+		return m, err
+	}
+
+	for _, gzip := range []bool{false, true} {
+		c.SnapshotGzip = gzip
+		for v := 1; v <= 4; v++ {
+			require.NoError(t, f.SaveToFile(store(domain.Gauge(v))))
+		}
+	}
+
+	t.Run("Generations", func(t *testing.T) {
+		for _, name := range []string{"store.json", "store.json.1", "store.json.2"} {
+			_, err := os.Stat(filepath.Join(filepath.Dir(c.FileStoragePath), name))
+			assert.NoError(t, err, name)
+		}
+		_, err := os.Stat(c.FileStoragePath + ".3")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		tmp, err := filepath.Glob(c.FileStoragePath + ".tmp*")
+		require.NoError(t, err)
+		assert.Empty(t, tmp)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		m, err := restore(t)
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauges{"g": 4}, m.Gauge)
+		assert.Equal(t, domain.Counters{"c": 4}, m.Tenants["team"].Counter)
+	})
+
+	t.Run("Restore previous generation", func(t *testing.T) {
+		data, err := os.ReadFile(c.FileStoragePath)
+		require.NoError(t, err)
+		data[len(data)-1] ^= 0xff
+		require.NoError(t, os.WriteFile(c.FileStoragePath, data, 0o644))
+
+		m, err := restore(t)
+		assert.ErrorIs(t, err, myErr.ErrSnapshotFallback)
+		assert.ErrorIs(t, err, myErr.ErrSnapshotCorrupt)
+		assert.Equal(t, domain.Gauges{"g": 3}, m.Gauge)
+	})
+
+	t.Run("All generations are corrupt", func(t *testing.T) {
+		for i := 1; i <= 2; i++ {
+			require.NoError(t, os.WriteFile(generation(c.FileStoragePath, i), []byte("{\"format\":\"metrics-snapshot\"}\n{}"), 0o644))
+		}
+		_, err := restore(t)
+		assert.ErrorIs(t, err, myErr.ErrSnapshotCorrupt)
+		assert.NotErrorIs(t, err, myErr.ErrSnapshotFallback)
+	})
+
+	t.Run("Previous format", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(c.FileStoragePath, []byte(`{"counter":{"c":5},"gauge":{"g":5}}`), 0o644))
+		m, err := restore(t)
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauges{"g": 5}, m.Gauge)
+		assert.Equal(t, domain.Counters{"c": 5}, m.Counter)
+	})
+
+	t.Run("No generations", func(t *testing.T) {
+		c.SnapshotKeep = 0
+		require.NoError(t, f.SaveToFile(store(6)))
+		m, err := restore(t)
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauges{"g": 6}, m.Gauge)
+	})
+
+	t.Run("Save while metrics are written", func(t *testing.T) {
+		m := store(7)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 1000; i++ {
+				_ = m.SetGauge(ctx, fmt.Sprintf("g%d", i), 1)
+				_ = m.SetCounter(domain.WithTenant(ctx, "team"), fmt.Sprintf("c%d", i), 1)
+			}
+		}()
+		for i := 0; i < 10; i++ {
+			require.NoError(t, f.SaveToFile(m))
+		}
+		<-done
+	})
+}
+
+func TestFileStorageRepo_Encryption(t *testing.T) {
+	ctx := context.Background()
+	key := func(id string, b byte) string {
+		return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+	}
+	c := &config.StorageConfig{FileStoragePath: filepath.Join(t.TempDir(), "store.json"), SnapshotKeep: 1}
+	c.StorageKey = key("k1", 'a')
+	require.NoError(t, c.LoadStorageKeys())
+	f := NewFileStorageRepository(c)
+
+	m := NewMemRepository()
+	require.NoError(t, m.SetGauge(ctx, "secretGauge", 1))
+	for _, gzip := range []bool{false, true} {
+		c.SnapshotGzip = gzip
+		require.NoError(t, f.SaveToFile(m))
+		data, err := os.ReadFile(c.FileStoragePath)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"key_id":"k1"`)
+		assert.NotContains(t, string(data), "secretGauge")
+	}
+
+	restore := func(t *testing.T, keys string) (*MemStorageRepo, error) {
+		c.StorageKey = keys
+		require.NoError(t, c.LoadStorageKeys())
+		m := NewMemRepository()
+		return m, f.RestoreFromFile(m)
+	}
+
+	t.Run("Restore", func(t *testing.T) {
+		m, err := restore(t, key("k1", 'a'))
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauges{"secretGauge": 1}, m.Gauge)
+	})
+
+	t.Run("Restore after key rotation", func(t *testing.T) {
+		m, err := restore(t, key("k2", 'b')+","+key("k1", 'a'))
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauges{"secretGauge": 1}, m.Gauge)
+	})
+
+	t.Run("No key", func(t *testing.T) {
+		_, err := restore(t, "")
+		assert.ErrorIs(t, err, keyring.ErrNoKey)
+		assert.NotErrorIs(t, err, myErr.ErrSnapshotCorrupt)
+	})
+
+	t.Run("Unknown key", func(t *testing.T) {
+		_, err := restore(t, key("k2", 'b'))
+		assert.ErrorIs(t, err, keyring.ErrUnknownKey)
+	})
+
+	t.Run("Wrong key", func(t *testing.T) {
+		_, err := restore(t, key("k1", 'b'))
+		assert.ErrorIs(t, err, keyring.ErrWrongKey)
+	})
+}
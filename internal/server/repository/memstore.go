@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
 
 	"go-musthave-metrics/internal/server/constant"
@@ -22,9 +23,12 @@ type MemStorageGauge struct {
 	mg    sync.RWMutex
 }
 
+// MemStorageRepo memory store, metrics of default tenant are at root, other tenants have own stores
 type MemStorageRepo struct {
 	MemStorageCounter
 	MemStorageGauge
+	Tenants map[string]*MemStorageRepo `json:"tenants,omitempty"`
+	mt      sync.RWMutex
 }
 
 func NewMemRepository() *MemStorageRepo {
@@ -34,6 +38,83 @@ func NewMemRepository() *MemStorageRepo {
 	}
 }
 
+// tenant store of request tenant, nil if tenant has no metrics and create is not set
+func (r *MemStorageRepo) tenant(ctx context.Context, create bool) *MemStorageRepo {
+	name := domain.TenantFromContext(ctx)
+	if name == domain.DefaultTenant {
+		return r
+	}
+	r.mt.RLock()
+	t, ok := r.Tenants[name]
+	r.mt.RUnlock()
+	if ok || !create {
+		return t
+	}
+	r.mt.Lock()
+	defer r.mt.Unlock()
+	if t, ok = r.Tenants[name]; !ok {
+		if r.Tenants == nil {
+			r.Tenants = make(map[string]*MemStorageRepo)
+		}
+		t = NewMemRepository()
+		r.Tenants[name] = t
+	}
+	return t
+}
+
+// clone copy of metrics of all tenants, metrics of each store are copied under its locks
+func (r *MemStorageRepo) clone() *MemStorageRepo {
+	c := NewMemRepository()
+	r.mc.RLock()
+	for k, v := range r.Counter {
+		c.Counter[k] = v
+	}
+	r.mc.RUnlock()
+	r.mg.RLock()
+	for k, v := range r.Gauge {
+		c.Gauge[k] = v
+	}
+	r.mg.RUnlock()
+	r.mt.RLock()
+	defer r.mt.RUnlock()
+	if r.Tenants != nil {
+		c.Tenants = make(map[string]*MemStorageRepo, len(r.Tenants))
+		for name, t := range r.Tenants {
+			c.Tenants[name] = t.clone()
+		}
+	}
+	return c
+}
+
+// Count number of metrics of all tenants
+func (r *MemStorageRepo) Count() (n int) {
+	r.mt.RLock()
+	defer r.mt.RUnlock()
+	for _, t := range r.Tenants {
+		n += t.Count()
+	}
+	r.mc.RLock()
+	n += len(r.Counter)
+	r.mc.RUnlock()
+	r.mg.RLock()
+	n += len(r.Gauge)
+	r.mg.RUnlock()
+	return
+}
+
+// GetTenants names of tenants with metrics, default tenant is always first
+func (r *MemStorageRepo) GetTenants(_ context.Context) (tenants []string, err error) {
+	r.mt.RLock()
+	defer r.mt.RUnlock()
+	tenants = make([]string, 0, len(r.Tenants)+1)
+	for name := range r.Tenants {
+		tenants = append(tenants, name)
+	}
+	sort.Strings(tenants)
+	tenants = append([]string{domain.DefaultTenant}, tenants...)
+	return
+}
+
 // Ping for memory storage it always true
 func (r *MemStorageRepo) Ping(_ context.Context) (err error) {
 	return
@@ -45,53 +126,81 @@ func (r *MemStorageRepo) MemStore(_ context.Context) (*MemStorageRepo, error) {
 }
 
 // SetGauge save gauge to memory store
-func (r *MemStorageRepo) SetGauge(_ context.Context, k string, v domain.Gauge) (err error) {
-	r.mg.Lock()
-	defer r.mg.Unlock()
-	r.Gauge[k] = v
+func (r *MemStorageRepo) SetGauge(ctx context.Context, k string, v domain.Gauge) (err error) {
+	t := r.tenant(ctx, true)
+	t.mg.Lock()
+	defer t.mg.Unlock()
+	t.Gauge[k] = v
 	return
 }
 
 // SetCounter save counter cot memory store
-func (r *MemStorageRepo) SetCounter(_ context.Context, k string, v domain.Counter) (err error) {
-	r.mc.Lock()
-	defer r.mc.Unlock()
-	r.Counter[k] = v
+func (r *MemStorageRepo) SetCounter(ctx context.Context, k string, v domain.Counter) (err error) {
+	t := r.tenant(ctx, true)
+	t.mc.Lock()
+	defer t.mc.Unlock()
+	t.Counter[k] = v
 	return
 }
 
 // GetGauge get gauge from memory store
-func (r *MemStorageRepo) GetGauge(_ context.Context, k string) (v domain.Gauge, err error) {
+func (r *MemStorageRepo) GetGauge(ctx context.Context, k string) (v domain.Gauge, err error) {
 	var ok bool
-	r.mg.RLock()
-	defer r.mg.RUnlock()
-	if v, ok = r.Gauge[k]; !ok {
+	t := r.tenant(ctx, false)
+	if t == nil {
+		return v, myErr.ErrNotExist
+	}
+	t.mg.RLock()
+	defer t.mg.RUnlock()
+	if v, ok = t.Gauge[k]; !ok {
 		err = myErr.ErrNotExist
 	}
 	return
 }
 
 // GetCounter get counter from memory store
-func (r *MemStorageRepo) GetCounter(_ context.Context, k string) (v domain.Counter, err error) {
+func (r *MemStorageRepo) GetCounter(ctx context.Context, k string) (v domain.Counter, err error) {
 	var ok bool
-	r.mc.RLock()
-	defer r.mc.RUnlock()
-	if v, ok = r.Counter[k]; !ok {
+	t := r.tenant(ctx, false)
+	if t == nil {
+		return v, myErr.ErrNotExist
+	}
+	t.mc.RLock()
+	defer t.mc.RUnlock()
+	if v, ok = t.Counter[k]; !ok {
 		err = myErr.ErrNotExist
 	}
 	return
 }
 
-// GetAllGauges get all gauges from memory store
-func (r *MemStorageRepo) GetAllGauges(_ context.Context) (domain.Gauges, error) {
-	var err error
-	return r.Gauge, err
+// GetAllGauges get copy of all gauges of tenant from memory store
+func (r *MemStorageRepo) GetAllGauges(ctx context.Context) (data domain.Gauges, err error) {
+	data = make(domain.Gauges)
+	t := r.tenant(ctx, false)
+	if t == nil {
+		return
+	}
+	t.mg.RLock()
+	defer t.mg.RUnlock()
+	for k, v := range t.Gauge {
+		data[k] = v
+	}
+	return
 }
 
-// GetAllCounters get all counter from memory store
-func (r *MemStorageRepo) GetAllCounters(_ context.Context) (domain.Counters, error) {
-	var err error
-	return r.Counter, err
+// GetAllCounters get copy of all counter of tenant from memory store
+func (r *MemStorageRepo) GetAllCounters(ctx context.Context) (data domain.Counters, err error) {
+	data = make(domain.Counters)
+	t := r.tenant(ctx, false)
+	if t == nil {
+		return
+	}
+	t.mc.RLock()
+	defer t.mc.RUnlock()
+	for k, v := range t.Counter {
+		data[k] = v
+	}
+	return
 }
 
 // SetMetrics save several metrics to memory store
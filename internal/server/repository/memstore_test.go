@@ -3,9 +3,15 @@ package repository
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"testing"
 
+	"go-musthave-metrics/internal/server/config"
 	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func BenchmarkMemStorageRepo_SetMetrics(b *testing.B) {
@@ -30,3 +36,37 @@ func BenchmarkMemStorageRepo_SetMetrics(b *testing.B) {
 		_ = len(nM)
 	}
 }
+
+func TestMemStorageRepo_Tenants(t *testing.T) {
+	ctx := context.Background()
+	ctxA := domain.WithTenant(ctx, "teamA")
+	r := NewMemRepository()
+	require.NoError(t, r.SetGauge(ctx, "gauge", 1))
+	require.NoError(t, r.SetGauge(ctxA, "gauge", 2))
+	_, err := r.SetMetrics(ctxA, []domain.Metric{{ID: "counter", MType: "counter", Delta: &[]domain.Counter{5}[0]}})
+	require.NoError(t, err)
+
+	_, err = r.GetCounter(ctx, "counter")
+	assert.ErrorIs(t, err, myErr.ErrNotExist, "metric of other tenant")
+	_, err = r.GetGauge(domain.WithTenant(ctx, "teamB"), "gauge")
+	assert.ErrorIs(t, err, myErr.ErrNotExist, "unknown tenant")
+	tenants, err := r.GetTenants(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "teamA"}, tenants)
+	assert.Equal(t, 3, r.Count())
+
+	f := NewFileStorageRepository(&config.StorageConfig{FileStoragePath: filepath.Join(t.TempDir(), "metrics.json")})
+	require.NoError(t, f.SaveToFile(r))
+	restored := NewMemRepository()
+	require.NoError(t, f.RestoreFromFile(restored))
+
+	v, err := restored.GetGauge(ctx, "gauge")
+	require.NoError(t, err)
+	assert.Equal(t, domain.Gauge(1), v)
+	v, err = restored.GetGauge(ctxA, "gauge")
+	require.NoError(t, err)
+	assert.Equal(t, domain.Gauge(2), v)
+	c, err := restored.GetCounter(ctxA, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, domain.Counter(5), c)
+}
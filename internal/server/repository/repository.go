@@ -2,9 +2,11 @@ package repository
 
 import (
 	"context"
+	"errors"
 
 	"go-musthave-metrics/internal/server/config"
 	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -23,35 +25,152 @@ type DataStorage interface {
 	GetAllCounters(ctx context.Context) (domain.Counters, error)
 	// GetAllGauges get all gauges from store
 	GetAllGauges(ctx context.Context) (domain.Gauges, error)
+	// GetTenants get names of tenants with metrics, default tenant is first
+	GetTenants(ctx context.Context) ([]string, error)
 	// SetMetrics save several metrics to store
 	SetMetrics(ctx context.Context, metrics []domain.Metric) ([]domain.Metric, error)
 	Ping(ctx context.Context) error
-	// MemStore return all metrics
+	// MemStore return all metrics of all tenants
 	MemStore(ctx context.Context) (*MemStorageRepo, error)
 }
 
 type Repository interface {
 	DataStorage
 	FileStorage
+	WALStorage
+	AgentStorage
+	AgentConfigStorage
+	CredentialStorage
+	AccessStorage
+	AuditStorage
+	// WriteBehindStats state of write-behind buffer of store, ErrNotExist if it is not used
+	WriteBehindStats() (WriteBehindStats, error)
+	// BreakerStats state of circuit breaker of store, ErrNotExist if it is not used
+	BreakerStats() (BreakerStats, error)
 }
 
 type Storage struct {
 	DataStorage
 	FileStorage
+	WALStorage
+	AgentStorage
+	AgentConfigStorage
+	CredentialStorage
+	AccessStorage
+	AuditStorage
+	// New store is empty on open, storage file is restored into it
+	New     bool
+	close   func() error
+	breaker *BreakerRepo
 }
 
-// NewRepository return repository of database or memory if no db set
-func NewRepository(c *config.StorageConfig, db *sqlx.DB) (s *Storage) {
-	if db != nil {
-		s = &Storage{
-			DataStorage: NewDBStorageRepository(db),
-			FileStorage: NewFileStorageRepository(c),
-		}
-	} else {
-		s = &Storage{
-			DataStorage: NewMemRepository(),
-			FileStorage: NewFileStorageRepository(c),
+// StorageOption option of storage of driver
+type StorageOption func(s *Storage)
+
+// WithClose set function, which releases resources of storage driver: connection, files. It is called by Close
+func WithClose(close func() error) StorageOption {
+	return func(s *Storage) {
+		s.close = close
+	}
+}
+
+// NewStorage return repository of data storage with file repositories of other data, write-ahead log is disabled
+func NewStorage(c *config.StorageConfig, data DataStorage, opts ...StorageOption) *Storage {
+	s := &Storage{
+		DataStorage:        data,
+		FileStorage:        NewFileStorageRepository(c),
+		WALStorage:         NewWALFileRepository(nil),
+		AgentStorage:       NewAgentMemRepository(),
+		AgentConfigStorage: NewAgentConfigFileRepository(c),
+		CredentialStorage:  NewCredentialFileRepository(c),
+		AccessStorage:      NewAccessFileRepository(c),
+		AuditStorage:       NewAuditFileRepository(c),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Close release resources of storage driver
+func (s *Storage) Close() error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close()
+}
+
+// closeFirst run close before close of storage, buffers are flushed before storage driver is closed
+func (s *Storage) closeFirst(close func() error) {
+	next := s.close
+	s.close = func() error {
+		if next == nil {
+			return close()
 		}
+		return errors.Join(close(), next())
 	}
+}
+
+// NewRepository return repository of database, embedded disk store if its directory is set, or memory
+func NewRepository(c *config.StorageConfig, db *sqlx.DB) *Storage {
+	switch {
+	case db != nil:
+		return newDBStorage(c, db)
+	case c.DiskStoragePath != "":
+		return newDiskStorage(NewDiskStorageRepository(c.DiskStoragePath), c)
+	default:
+		return newMemStorage(c)
+	}
+}
+
+func newMemStorage(c *config.StorageConfig) (s *Storage) {
+	wal := NewWALFileRepository(c)
+	s = NewStorage(c, NewMemRepository(), WithClose(wal.Close))
+	s.WALStorage = wal
+	s.New = true
 	return
 }
+
+func newDiskStorage(disk *DiskStorageRepo, c *config.StorageConfig) (s *Storage) {
+	return NewStorage(c, disk, WithClose(disk.Close))
+}
+
+// newDBStorage database storage, database operations are passed through circuit breaker,
+// database writes are buffered by write-behind buffer if it is enabled
+func newDBStorage(c *config.StorageConfig, db *sqlx.DB, opts ...StorageOption) (s *Storage) {
+	s = NewStorage(c, NewDBStorageRepository(db), opts...)
+	s.breaker = NewBreakerRepository(s.DataStorage, c)
+	s.DataStorage = s.breaker
+	if c.DBFallbackPath != "" {
+		fb := NewFallbackRepository(s.DataStorage, c)
+		s.DataStorage = fb
+		s.closeFirst(fb.Close)
+	}
+	if c.WriteBehind {
+		wb := NewWriteBehindRepository(s.DataStorage, c)
+		// buffer is flushed before fallback is closed
+		s.DataStorage = wb
+		s.closeFirst(wb.Close)
+	}
+	s.CredentialStorage = NewCredentialDBRepository(db)
+	if c.AuditPath == "" {
+		s.AuditStorage = NewAuditDBRepository(db)
+	}
+	return
+}
+
+// WriteBehindStats state of write-behind buffer of store, ErrNotExist if it is not used
+func (s *Storage) WriteBehindStats() (WriteBehindStats, error) {
+	if wb, ok := s.DataStorage.(*WriteBehindRepo); ok {
+		return wb.Stats(), nil
+	}
+	return WriteBehindStats{}, myErr.ErrNotExist
+}
+
+// BreakerStats state of circuit breaker of database store, ErrNotExist if it is not used
+func (s *Storage) BreakerStats() (BreakerStats, error) {
+	if s.breaker == nil {
+		return BreakerStats{}, myErr.ErrNotExist
+	}
+	return s.breaker.Stats(), nil
+}
@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+)
+
+// WALStorage write-ahead log of memory store
+type WALStorage interface {
+	// WALEnabled is write-ahead log used
+	WALEnabled() bool
+	// AppendWAL append written metrics of request tenant to log, metrics have stored values
+	AppendWAL(ctx context.Context, metrics []domain.Metric) error
+	// ReplayWAL apply logged metrics to store, return number of replayed records
+	ReplayWAL(m *MemStorageRepo) (int, error)
+	// TruncateWAL clear log, it is called after store snapshot is saved
+	TruncateWAL() error
+}
+
+// walAD additional authenticated data of encrypted log records
+var walAD = []byte("wal")
+
+// walRecord one accepted batch of tenant
+type walRecord struct {
+	Tenant  string          `json:"tenant,omitempty"`
+	Metrics []domain.Metric `json:"metrics,omitempty"`
+}
+
+// walLine log line: record or record json encrypted by storage key
+type walLine struct {
+	walRecord
+	KeyID string `json:"key_id,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// WALFileRepo write-ahead log at json lines file. Records have stored values of metrics,
+// not deltas, so replay of records which are already at snapshot is harmless.
+// Records are encrypted if storage keys are set. With everysec policy records, which are not synced by append,
+// are synced by timer within a second, sync error of timer is returned by next append
+type WALFileRepo struct {
+	c       *config.StorageConfig
+	f       *os.File
+	synced  time.Time
+	timer   *time.Timer
+	syncErr error
+	m       sync.Mutex
+}
+
+// NewWALFileRepository write-ahead log repository, log is disabled with nil config or empty file path
+func NewWALFileRepository(c *config.StorageConfig) *WALFileRepo {
+	return &WALFileRepo{c: c}
+}
+
+func (r *WALFileRepo) WALEnabled() bool {
+	return r.c != nil && r.c.WALPath != ""
+}
+
+// AppendWAL append record to log and sync file by sync policy
+func (r *WALFileRepo) AppendWAL(ctx context.Context, metrics []domain.Metric) (err error) {
+	if !r.WALEnabled() || len(metrics) == 0 {
+		return
+	}
+	line := walLine{walRecord: walRecord{Tenant: domain.TenantFromContext(ctx), Metrics: metrics}}
+	var b []byte
+	if b, err = json.Marshal(line.walRecord); err != nil {
+		return
+	}
+	if keys := r.c.GetStorageKeys(); keys != nil {
+		line = walLine{}
+		if line.KeyID, line.Data, err = keys.Seal(b, walAD); err != nil {
+			return
+		}
+		if b, err = json.Marshal(line); err != nil {
+			return
+		}
+	}
+	b = append(b, '\n')
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.f == nil {
+		if r.f, err = os.OpenFile(r.c.WALPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600); err != nil {
+			return
+		}
+	}
+	if err, r.syncErr = r.syncErr, nil; err != nil {
+		return
+	}
+	if _, err = r.f.Write(b); err != nil {
+		return
+	}
+	switch r.c.GetWALSync() {
+	case constant.WALSyncAlways:
+		err = r.f.Sync()
+	case constant.WALSyncEverySec:
+		if wait := time.Second - time.Since(r.synced); wait <= 0 {
+			err = r.f.Sync()
+			r.synced = time.Now()
+		} else if r.timer == nil {
+			r.timer = time.AfterFunc(wait, r.syncPending)
+		}
+	}
+	return
+}
+
+// syncPending sync records appended after last sync, it is called by timer of everysec policy
+func (r *WALFileRepo) syncPending() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.timer = nil
+	if r.f == nil {
+		return
+	}
+	r.syncErr = r.f.Sync()
+	r.synced = time.Now()
+}
+
+// stopSync stop timer of pending sync, lock is held by caller
+func (r *WALFileRepo) stopSync() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
+
+// ReplayWAL apply records of log to store. Last record without line end is written partially on crash,
+// it is skipped and cut from log
+func (r *WALFileRepo) ReplayWAL(m *MemStorageRepo) (n int, err error) {
+	if !r.WALEnabled() {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	var file *os.File
+	if file, err = os.Open(r.c.WALPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	defer func() { err = errors.Join(err, file.Close()) }()
+	var (
+		reader = bufio.NewReader(file)
+		offset int64
+	)
+	for {
+		var line []byte
+		if line, err = reader.ReadBytes('\n'); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+				if len(line) > 0 {
+					// cut partial record, so next records are appended after last full one
+					err = os.Truncate(r.c.WALPath, offset)
+				}
+			}
+			return
+		}
+		offset += int64(len(line))
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		var rec walRecord
+		if rec, err = r.record(line); err != nil {
+			return
+		}
+		if err = rec.apply(m); err != nil {
+			return
+		}
+		n++
+	}
+}
+
+// record parse log line, decrypt record if it is encrypted
+func (r *WALFileRepo) record(b []byte) (rec walRecord, err error) {
+	var line walLine
+	if err = json.Unmarshal(b, &line); err != nil || line.KeyID == "" {
+		return line.walRecord, err
+	}
+	if b, err = r.c.GetStorageKeys().Open(line.KeyID, line.Data, walAD); err != nil {
+		return rec, fmt.Errorf("%s: %w", r.c.WALPath, err)
+	}
+	err = json.Unmarshal(b, &rec)
+	return
+}
+
+// TruncateWAL remove log file, next record creates new one
+func (r *WALFileRepo) TruncateWAL() (err error) {
+	if !r.WALEnabled() {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.stopSync()
+	if r.f != nil {
+		err = r.f.Close()
+		r.f = nil
+	}
+	if er := os.Remove(r.c.WALPath); er != nil && !errors.Is(er, os.ErrNotExist) {
+		err = errors.Join(err, er)
+	}
+	return
+}
+
+// Close sync and close log file, stop timer of pending sync
+func (r *WALFileRepo) Close() (err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.stopSync()
+	if r.f == nil {
+		return
+	}
+	err = errors.Join(r.f.Sync(), r.f.Close())
+	r.f = nil
+	return
+}
+
+// apply set stored values of record metrics to store of record tenant
+func (rec walRecord) apply(m *MemStorageRepo) (err error) {
+	ctx := domain.WithTenant(context.Background(), rec.Tenant)
+	for _, metric := range rec.Metrics {
+		switch {
+		case metric.MType == constant.MetricTypeGauge && metric.Value != nil:
+			err = m.SetGauge(ctx, metric.ID, *metric.Value)
+		case metric.MType == constant.MetricTypeCounter && metric.Delta != nil:
+			err = m.SetCounter(ctx, metric.ID, *metric.Delta)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	"go-musthave-metrics/internal/server/keyring"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALFileRepo(t *testing.T) {
+	ctx := context.Background()
+	gauge := func(id string, v domain.Gauge) domain.Metric {
+		return domain.Metric{ID: id, MType: constant.MetricTypeGauge, Value: &v}
+	}
+	counter := func(id string, v domain.Counter) domain.Metric {
+		return domain.Metric{ID: id, MType: constant.MetricTypeCounter, Delta: &v}
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		r := NewWALFileRepository(nil)
+		assert.False(t, r.WALEnabled())
+		require.NoError(t, r.AppendWAL(ctx, []domain.Metric{gauge("g", 1)}))
+		n, err := r.ReplayWAL(NewMemRepository())
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	for _, sync := range []string{constant.WALSyncAlways, constant.WALSyncEverySec, constant.WALSyncNo} {
+		t.Run("Replay, sync "+sync, func(t *testing.T) {
+			c := &config.StorageConfig{WALPath: filepath.Join(t.TempDir(), "wal.log"), WALSync: sync}
+			r := NewWALFileRepository(c)
+			require.True(t, r.WALEnabled())
+			require.NoError(t, r.AppendWAL(ctx, []domain.Metric{gauge("g", 1), counter("c", 2)}))
+			require.NoError(t, r.AppendWAL(domain.WithTenant(ctx, "team"), []domain.Metric{counter("c", 5)}))
+			require.NoError(t, r.AppendWAL(ctx, []domain.Metric{gauge("g", 3), counter("c", 4)}))
+
+			m := NewMemRepository()
+			n, err := r.ReplayWAL(m)
+			require.NoError(t, err)
+			assert.Equal(t, 3, n)
+			assert.Equal(t, domain.Gauges{"g": 3}, m.Gauge)
+			assert.Equal(t, domain.Counters{"c": 4}, m.Counter, "stored values are replayed")
+			assert.Equal(t, domain.Counters{"c": 5}, m.Tenants["team"].Counter)
+
+			require.NoError(t, r.TruncateWAL())
+			n, err = r.ReplayWAL(NewMemRepository())
+			require.NoError(t, err)
+			assert.Zero(t, n)
+		})
+	}
+
+	t.Run("Partial record", func(t *testing.T) {
+		c := &config.StorageConfig{WALPath: filepath.Join(t.TempDir(), "wal.log"), WALSync: constant.WALSyncAlways}
+		r := NewWALFileRepository(c)
+		require.NoError(t, r.AppendWAL(ctx, []domain.Metric{gauge("g", 1)}))
+		f, err := os.OpenFile(c.WALPath, os.O_APPEND|os.O_WRONLY, 0o600)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"metrics":[{"id":"g","type":"gauge","val`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		m := NewMemRepository()
+		n, err := r.ReplayWAL(m)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, domain.Gauges{"g": 1}, m.Gauge)
+
+		// partial record is cut, next record is readable
+		require.NoError(t, r.AppendWAL(ctx, []domain.Metric{gauge("g", 2)}))
+		n, err = r.ReplayWAL(m)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, domain.Gauges{"g": 2}, m.Gauge)
+	})
+
+	t.Run("Everysec sync of last record", func(t *testing.T) {
+		c := &config.StorageConfig{WALPath: filepath.Join(t.TempDir(), "wal.log"), WALSync: constant.WALSyncEverySec}
+		r := NewWALFileRepository(c)
+		defer func() { require.NoError(t, r.Close()) }()
+		require.NoError(t, r.AppendWAL(ctx, []domain.Metric{gauge("g", 1)}))
+		synced := r.synced
+		require.NoError(t, r.AppendWAL(ctx, []domain.Metric{gauge("g", 2)}))
+		r.m.Lock()
+		assert.NotNil(t, r.timer, "sync of last record is pending")
+		r.m.Unlock()
+		require.Eventually(t, func() bool {
+			r.m.Lock()
+			defer r.m.Unlock()
+			return r.timer == nil && r.synced.After(synced)
+		}, 3*time.Second, 10*time.Millisecond, "last record is synced without next append")
+
+		require.NoError(t, r.AppendWAL(ctx, []domain.Metric{gauge("g", 3)}))
+		require.NoError(t, r.TruncateWAL())
+		r.m.Lock()
+		assert.Nil(t, r.timer, "pending sync is stopped")
+		r.m.Unlock()
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		c := &config.StorageConfig{WALPath: filepath.Join(t.TempDir(), "wal.log"), WALSync: constant.WALSyncNo,
+			StorageKey: "k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{'a'}, 32))}
+		require.NoError(t, c.LoadStorageKeys())
+		r := NewWALFileRepository(c)
+		require.NoError(t, r.AppendWAL(domain.WithTenant(ctx, "team"), []domain.Metric{gauge("secretGauge", 1)}))
+		data, err := os.ReadFile(c.WALPath)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "secretGauge")
+		assert.NotContains(t, string(data), "team")
+
+		m := NewMemRepository()
+		n, err := r.ReplayWAL(m)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, domain.Gauges{"secretGauge": 1}, m.Tenants["team"].Gauge)
+
+		c.StorageKey = ""
+		require.NoError(t, c.LoadStorageKeys())
+		_, err = r.ReplayWAL(NewMemRepository())
+		assert.ErrorIs(t, err, keyring.ErrNoKey)
+	})
+
+	t.Run("Broken record", func(t *testing.T) {
+		c := &config.StorageConfig{WALPath: filepath.Join(t.TempDir(), "wal.log")}
+		require.NoError(t, os.WriteFile(c.WALPath, []byte("not a record\n"), 0o600))
+		_, err := NewWALFileRepository(c).ReplayWAL(NewMemRepository())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,507 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+)
+
+// WriteBehindStats state of write-behind buffer
+type WriteBehindStats struct {
+	// Pending buffered series, not written to store
+	Pending int `json:"pending"`
+	// Flushing series of flush in progress
+	Flushing int `json:"flushing"`
+	// Lag seconds since oldest not written change
+	Lag       float64   `json:"lag"`
+	Flushes   int64     `json:"flushes"`
+	Flushed   int64     `json:"flushed"`
+	Errors    int64     `json:"errors"`
+	LastFlush time.Time `json:"last_flush,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// pendingCounter buffered counter change
+type pendingCounter struct {
+	v domain.Counter
+	// set v is value of counter, else v is delta to stored value
+	set bool
+}
+
+// then change of counter by older change p and newer change n
+func (p pendingCounter) then(n pendingCounter) pendingCounter {
+	if n.set {
+		return n
+	}
+	return pendingCounter{v: p.v + n.v, set: p.set}
+}
+
+// wbBuffer buffered changes of tenant
+type wbBuffer struct {
+	gauges   map[string]domain.Gauge
+	counters map[string]pendingCounter
+}
+
+func (b *wbBuffer) len() int {
+	return len(b.gauges) + len(b.counters)
+}
+
+// write buffered changes to store. Values of counters are set before deltas are added by one batch,
+// so failed write is repeated without double deltas
+func (b *wbBuffer) write(ctx context.Context, r DataStorage) (err error) {
+	metrics := make([]domain.Metric, 0, b.len())
+	for k, c := range b.counters {
+		if c.set {
+			if err = r.SetCounter(ctx, k, c.v); err != nil {
+				return
+			}
+			continue
+		}
+		v := c.v
+		metrics = append(metrics, domain.Metric{ID: k, MType: constant.MetricTypeCounter, Delta: &v})
+	}
+	for k, g := range b.gauges {
+		v := g
+		metrics = append(metrics, domain.Metric{ID: k, MType: constant.MetricTypeGauge, Value: &v})
+	}
+	if len(metrics) > 0 {
+		_, err = r.SetMetrics(ctx, metrics)
+	}
+	return
+}
+
+// wbBuffers buffered changes by tenant
+type wbBuffers map[string]*wbBuffer
+
+func (bs wbBuffers) tenant(name string) *wbBuffer {
+	b, ok := bs[name]
+	if !ok {
+		b = &wbBuffer{gauges: make(domain.Gauges), counters: make(map[string]pendingCounter)}
+		bs[name] = b
+	}
+	return b
+}
+
+// WriteBehindRepo write-behind buffer in front of store: writes are buffered, gauges are replaced by last value,
+// counter deltas are summed. Reads are served from buffer merged with store. Buffer is written to store by batches
+// with flush interval, when number of buffered series reaches limit and on close
+type WriteBehindRepo struct {
+	r DataStorage
+	c *config.StorageConfig
+	// m buffers lock
+	m        sync.RWMutex
+	pending  wbBuffers
+	inflight wbBuffers
+	size     int
+	since    time.Time
+	flushing time.Time
+	closed   bool
+	stats    WriteBehindStats
+	// view reads of store and buffer are not mixed with write of flush
+	view   sync.RWMutex
+	flushM sync.Mutex
+	kick   chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewWriteBehindRepository buffer of store r, flusher is run until Close
+func NewWriteBehindRepository(r DataStorage, c *config.StorageConfig) *WriteBehindRepo {
+	w := &WriteBehindRepo{
+		r:       r,
+		c:       c,
+		pending: make(wbBuffers),
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// run flush buffer with interval or when it is full
+func (w *WriteBehindRepo) run() {
+	defer w.wg.Done()
+	for {
+		interval, _ := w.c.GetWriteBehind()
+		if interval <= 0 {
+			interval = constant.WriteBehindFlush
+		}
+		select {
+		case <-time.After(time.Duration(interval) * time.Second):
+		case <-w.kick:
+		case <-w.done:
+			return
+		}
+		// error is kept at stats, changes are kept at buffer until next flush
+		_ = w.Flush(context.Background())
+	}
+}
+
+// Close stop flusher and flush buffer, next writes are made to store directly
+func (w *WriteBehindRepo) Close() error {
+	w.m.Lock()
+	if w.closed {
+		w.m.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.m.Unlock()
+	close(w.done)
+	w.wg.Wait()
+	return w.Flush(context.Background())
+}
+
+// Flush write buffered changes to store. Changes of tenants, which are not written on error, are returned to buffer
+func (w *WriteBehindRepo) Flush(ctx context.Context) (err error) {
+	w.flushM.Lock()
+	defer w.flushM.Unlock()
+	w.m.Lock()
+	if w.size == 0 {
+		w.m.Unlock()
+		return
+	}
+	w.inflight, w.pending = w.pending, make(wbBuffers)
+	w.flushing, w.since = w.since, time.Time{}
+	w.size = 0
+	tenants := make([]string, 0, len(w.inflight))
+	for tenant := range w.inflight {
+		tenants = append(tenants, tenant)
+	}
+	w.m.Unlock()
+
+	for _, tenant := range tenants {
+		if err = w.flushTenant(ctx, tenant); err != nil {
+			break
+		}
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.stats.Flushes++
+	w.stats.LastFlush = time.Now()
+	if err != nil {
+		w.stats.Errors++
+		w.stats.LastError = err.Error()
+		w.requeue()
+	}
+	w.inflight = nil
+	w.flushing = time.Time{}
+	return
+}
+
+// flushTenant write buffered changes of tenant, write and removal from buffer are not seen by reads apart
+func (w *WriteBehindRepo) flushTenant(ctx context.Context, tenant string) (err error) {
+	w.view.Lock()
+	defer w.view.Unlock()
+	b := w.inflight[tenant]
+	if err = b.write(domain.WithTenant(ctx, tenant), w.r); err != nil {
+		return
+	}
+	w.m.Lock()
+	delete(w.inflight, tenant)
+	w.stats.Flushed += int64(b.len())
+	w.m.Unlock()
+	return
+}
+
+// requeue return not written changes to buffer under newer changes
+func (w *WriteBehindRepo) requeue() {
+	for tenant, old := range w.inflight {
+		b := w.pending.tenant(tenant)
+		for k, v := range old.gauges {
+			if _, ok := b.gauges[k]; !ok {
+				b.gauges[k] = v
+			}
+		}
+		for k, c := range old.counters {
+			if n, ok := b.counters[k]; ok {
+				c = c.then(n)
+			}
+			b.counters[k] = c
+		}
+	}
+	w.size = 0
+	for _, b := range w.pending {
+		w.size += b.len()
+	}
+	if !w.flushing.IsZero() {
+		w.since = w.flushing
+	}
+}
+
+// buffer apply change to buffer of request tenant, change is written by direct to store after close.
+// Buffer is flushed by writer, when it is full
+func (w *WriteBehindRepo) buffer(ctx context.Context, apply func(b *wbBuffer), direct func() error) error {
+	tenant := domain.TenantFromContext(ctx)
+	for {
+		_, limit := w.c.GetWriteBehind()
+		w.m.Lock()
+		if w.closed {
+			w.m.Unlock()
+			return direct()
+		}
+		if limit > 0 && w.size >= limit {
+			w.m.Unlock()
+			if err := w.Flush(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		b := w.pending.tenant(tenant)
+		n := b.len()
+		apply(b)
+		w.size += b.len() - n
+		if w.since.IsZero() {
+			w.since = time.Now()
+		}
+		full := limit > 0 && w.size >= limit
+		w.m.Unlock()
+		if full {
+			select {
+			case w.kick <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	}
+}
+
+// counter buffered change of counter, flush and buffer order
+func (w *WriteBehindRepo) counter(tenant, k string) (c pendingCounter, ok bool) {
+	w.m.RLock()
+	defer w.m.RUnlock()
+	if b, in := w.inflight[tenant]; in {
+		c, ok = b.counters[k]
+	}
+	if b, in := w.pending[tenant]; in {
+		if n, is := b.counters[k]; is {
+			if ok {
+				n = c.then(n)
+			}
+			c, ok = n, true
+		}
+	}
+	return
+}
+
+// SetGauge buffer gauge
+func (w *WriteBehindRepo) SetGauge(ctx context.Context, k string, v domain.Gauge) error {
+	return w.buffer(ctx, func(b *wbBuffer) {
+		b.gauges[k] = v
+	}, func() error {
+		return w.r.SetGauge(ctx, k, v)
+	})
+}
+
+// SetCounter buffer value of counter
+func (w *WriteBehindRepo) SetCounter(ctx context.Context, k string, v domain.Counter) error {
+	return w.buffer(ctx, func(b *wbBuffer) {
+		b.counters[k] = pendingCounter{v: v, set: true}
+	}, func() error {
+		return w.r.SetCounter(ctx, k, v)
+	})
+}
+
+// GetGauge get buffered gauge or gauge of store
+func (w *WriteBehindRepo) GetGauge(ctx context.Context, k string) (domain.Gauge, error) {
+	tenant := domain.TenantFromContext(ctx)
+	w.view.RLock()
+	defer w.view.RUnlock()
+	w.m.RLock()
+	for _, bs := range []wbBuffers{w.pending, w.inflight} {
+		if v, ok := bs[tenant].gaugeOk(k); ok {
+			w.m.RUnlock()
+			return v, nil
+		}
+	}
+	w.m.RUnlock()
+	return w.r.GetGauge(ctx, k)
+}
+
+func (b *wbBuffer) gaugeOk(k string) (v domain.Gauge, ok bool) {
+	if b == nil {
+		return
+	}
+	v, ok = b.gauges[k]
+	return
+}
+
+// GetCounter get counter of store with buffered changes
+func (w *WriteBehindRepo) GetCounter(ctx context.Context, k string) (v domain.Counter, err error) {
+	w.view.RLock()
+	defer w.view.RUnlock()
+	c, ok := w.counter(domain.TenantFromContext(ctx), k)
+	if ok && c.set {
+		return c.v, nil
+	}
+	if v, err = w.r.GetCounter(ctx, k); ok && errors.Is(err, myErr.ErrNotExist) {
+		err = nil
+	}
+	return v + c.v, err
+}
+
+// GetAllCounters get counters of store with buffered changes
+func (w *WriteBehindRepo) GetAllCounters(ctx context.Context) (counters domain.Counters, err error) {
+	tenant := domain.TenantFromContext(ctx)
+	w.view.RLock()
+	defer w.view.RUnlock()
+	if counters, err = w.r.GetAllCounters(ctx); err != nil {
+		return
+	}
+	if counters == nil {
+		counters = make(domain.Counters)
+	}
+	w.m.RLock()
+	defer w.m.RUnlock()
+	for _, bs := range []wbBuffers{w.inflight, w.pending} {
+		if b, ok := bs[tenant]; ok {
+			for k, c := range b.counters {
+				if c.set {
+					counters[k] = c.v
+				} else {
+					counters[k] += c.v
+				}
+			}
+		}
+	}
+	return
+}
+
+// GetAllGauges get gauges of store with buffered ones
+func (w *WriteBehindRepo) GetAllGauges(ctx context.Context) (gauges domain.Gauges, err error) {
+	tenant := domain.TenantFromContext(ctx)
+	w.view.RLock()
+	defer w.view.RUnlock()
+	if gauges, err = w.r.GetAllGauges(ctx); err != nil {
+		return
+	}
+	if gauges == nil {
+		gauges = make(domain.Gauges)
+	}
+	w.m.RLock()
+	defer w.m.RUnlock()
+	for _, bs := range []wbBuffers{w.inflight, w.pending} {
+		if b, ok := bs[tenant]; ok {
+			for k, v := range b.gauges {
+				gauges[k] = v
+			}
+		}
+	}
+	return
+}
+
+// GetTenants get tenants of store and buffer, default tenant is first
+func (w *WriteBehindRepo) GetTenants(ctx context.Context) (tenants []string, err error) {
+	if tenants, err = w.r.GetTenants(ctx); err != nil {
+		return
+	}
+	names := make(map[string]struct{}, len(tenants))
+	for _, name := range tenants {
+		names[name] = struct{}{}
+	}
+	w.m.RLock()
+	for _, bs := range []wbBuffers{w.inflight, w.pending} {
+		for name, b := range bs {
+			if b.len() > 0 {
+				names[name] = struct{}{}
+			}
+		}
+	}
+	w.m.RUnlock()
+	delete(names, domain.DefaultTenant)
+	tenants = make([]string, 0, len(names)+1)
+	for name := range names {
+		tenants = append(tenants, name)
+	}
+	sort.Strings(tenants)
+	tenants = append([]string{domain.DefaultTenant}, tenants...)
+	return
+}
+
+// SetMetrics buffer several metrics, counters are increased by delta. Return metrics with values,
+// merged with store
+func (w *WriteBehindRepo) SetMetrics(ctx context.Context, metrics []domain.Metric) (newMetrics []domain.Metric, err error) {
+	if err = w.buffer(ctx, func(b *wbBuffer) {
+		for _, metric := range metrics {
+			switch metric.MType {
+			case constant.MetricTypeGauge:
+				b.gauges[metric.ID] = *metric.Value
+			case constant.MetricTypeCounter:
+				b.counters[metric.ID] = b.counters[metric.ID].then(pendingCounter{v: *metric.Delta})
+			}
+		}
+	}, func() (err error) {
+		newMetrics, err = w.r.SetMetrics(ctx, metrics)
+		return
+	}); err != nil || newMetrics != nil {
+		return
+	}
+	newMetrics = make([]domain.Metric, len(metrics))
+	for i, metric := range metrics {
+		switch metric.MType {
+		case constant.MetricTypeGauge:
+			v := *metric.Value
+			metric.Value = &v
+		case constant.MetricTypeCounter:
+			var v domain.Counter
+			if v, err = w.GetCounter(ctx, metric.ID); err != nil {
+				return
+			}
+			metric.Delta = &v
+		}
+		newMetrics[i] = metric
+	}
+	return
+}
+
+// Ping check store
+func (w *WriteBehindRepo) Ping(ctx context.Context) error {
+	return w.r.Ping(ctx)
+}
+
+// MemStore return memory store of all metrics of all tenants with buffered changes
+func (w *WriteBehindRepo) MemStore(ctx context.Context) (m *MemStorageRepo, err error) {
+	var tenants []string
+	if tenants, err = w.GetTenants(ctx); err != nil {
+		return
+	}
+	m = NewMemRepository()
+	for _, tenant := range tenants {
+		tCtx := domain.WithTenant(ctx, tenant)
+		t := m.tenant(tCtx, true)
+		if t.Counter, err = w.GetAllCounters(tCtx); err != nil {
+			return
+		}
+		if t.Gauge, err = w.GetAllGauges(tCtx); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Stats state of buffer
+func (w *WriteBehindRepo) Stats() (s WriteBehindStats) {
+	w.m.RLock()
+	defer w.m.RUnlock()
+	s = w.stats
+	s.Pending = w.size
+	for _, b := range w.inflight {
+		s.Flushing += b.len()
+	}
+	oldest := w.since
+	if !w.flushing.IsZero() {
+		oldest = w.flushing
+	}
+	if !oldest.IsZero() {
+		s.Lag = time.Since(oldest).Seconds()
+	}
+	return
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStore store, which counts batches and fails writes while down
+type flakyStore struct {
+	*MemStorageRepo
+	batches atomic.Int32
+	down    atomic.Bool
+}
+
+var errDown = errors.New("store is down")
+
+func (s *flakyStore) SetCounter(ctx context.Context, k string, v domain.Counter) error {
+	if s.down.Load() {
+		return errDown
+	}
+	return s.MemStorageRepo.SetCounter(ctx, k, v)
+}
+
+func (s *flakyStore) SetMetrics(ctx context.Context, metrics []domain.Metric) ([]domain.Metric, error) {
+	if s.down.Load() {
+		return nil, errDown
+	}
+	s.batches.Add(1)
+	return s.MemStorageRepo.SetMetrics(ctx, metrics)
+}
+
+func TestWriteBehindRepo(t *testing.T) {
+	ctx := context.Background()
+	ctxA := domain.WithTenant(ctx, "teamA")
+	delta := func(v domain.Counter) []domain.Metric {
+		return []domain.Metric{{ID: "counter", MType: constant.MetricTypeCounter, Delta: &v}}
+	}
+	newRepo := func(limit int) (*WriteBehindRepo, *flakyStore) {
+		s := &flakyStore{MemStorageRepo: NewMemRepository()}
+		w := NewWriteBehindRepository(s, &config.StorageConfig{WriteBehindFlush: 3600, WriteBehindMax: limit})
+		t.Cleanup(func() { _ = w.Close() })
+		return w, s
+	}
+
+	t.Run("coalesce", func(t *testing.T) {
+		w, s := newRepo(100)
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, w.SetGauge(ctx, "gauge", domain.Gauge(i)))
+			m, err := w.SetMetrics(ctxA, delta(2))
+			require.NoError(t, err)
+			assert.Equal(t, domain.Counter(2*i), *m[0].Delta, "stored value is returned")
+		}
+		_, err := s.GetGauge(ctx, "gauge")
+		assert.ErrorIs(t, err, myErr.ErrNotExist, "not written before flush")
+		gauge, err := w.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(3), gauge, "last gauge is read from buffer")
+		counters, err := w.GetAllCounters(ctxA)
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counters{"counter": 6}, counters)
+		tenants, err := w.GetTenants(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"", "teamA"}, tenants, "buffered tenants")
+		assert.Equal(t, 2, w.Stats().Pending)
+
+		require.NoError(t, w.Flush(ctx))
+		assert.Equal(t, int32(2), s.batches.Load(), "one batch of tenant")
+		counter, err := s.GetCounter(ctxA, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(6), counter, "deltas are summed")
+		stats := w.Stats()
+		assert.Equal(t, 0, stats.Pending)
+		assert.Equal(t, int64(2), stats.Flushed)
+		assert.Zero(t, stats.Lag)
+	})
+
+	t.Run("merge with store", func(t *testing.T) {
+		w, s := newRepo(100)
+		require.NoError(t, s.SetCounter(ctx, "counter", 100))
+		_, err := w.SetMetrics(ctx, delta(3))
+		require.NoError(t, err)
+		counter, err := w.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(103), counter, "delta is added to stored value")
+
+		require.NoError(t, w.SetCounter(ctx, "counter", 10))
+		_, err = w.SetMetrics(ctx, delta(2))
+		require.NoError(t, err)
+		require.NoError(t, w.Flush(ctx))
+		counter, err = s.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(12), counter, "value is set, delta is added after it")
+	})
+
+	t.Run("flush error", func(t *testing.T) {
+		w, s := newRepo(100)
+		_, err := w.SetMetrics(ctx, delta(1))
+		require.NoError(t, err)
+		s.down.Store(true)
+		assert.ErrorIs(t, w.Flush(ctx), errDown)
+		_, err = w.SetMetrics(ctx, delta(2))
+		require.NoError(t, err)
+		stats := w.Stats()
+		assert.Equal(t, 1, stats.Pending, "changes are kept")
+		assert.Equal(t, int64(1), stats.Errors)
+		assert.Equal(t, errDown.Error(), stats.LastError)
+		assert.Greater(t, stats.Lag, 0.0)
+		counter, err := w.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(3), counter)
+
+		s.down.Store(false)
+		require.NoError(t, w.Flush(ctx))
+		counter, err = s.GetCounter(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Counter(3), counter, "deltas are written once")
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		w, s := newRepo(2)
+		require.NoError(t, w.SetGauge(ctx, "gauge1", 1))
+		require.NoError(t, w.SetGauge(ctx, "gauge2", 2))
+		require.Eventually(t, func() bool { return w.Stats().Pending == 0 }, 5*time.Second, 10*time.Millisecond,
+			"full buffer is flushed")
+		gauge, err := s.GetGauge(ctx, "gauge2")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(2), gauge)
+
+		s.down.Store(true)
+		require.NoError(t, w.SetGauge(ctx, "gauge3", 3))
+		require.NoError(t, w.SetGauge(ctx, "gauge4", 4))
+		require.Eventually(t, func() bool { return w.Stats().Errors > 0 }, 5*time.Second, 10*time.Millisecond)
+		assert.ErrorIs(t, w.SetGauge(ctx, "gauge5", 5), errDown, "writer waits flush of full buffer")
+	})
+
+	t.Run("close", func(t *testing.T) {
+		w, s := newRepo(100)
+		require.NoError(t, w.SetGauge(ctx, "gauge", 1))
+		require.NoError(t, w.Close())
+		gauge, err := s.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(1), gauge, "buffer is flushed on close")
+
+		require.NoError(t, w.SetGauge(ctx, "gauge", 2))
+		gauge, err = s.GetGauge(ctx, "gauge")
+		require.NoError(t, err)
+		assert.Equal(t, domain.Gauge(2), gauge, "write to store after close")
+		assert.NoError(t, w.Close())
+	})
+}
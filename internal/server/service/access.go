@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+	"go-musthave-metrics/internal/server/repository"
+)
+
+type Access interface {
+	// Principal resolve principal by token or client certificate common name, nil if access is not checked
+	Principal(ctx context.Context, token, cert string) (*domain.Principal, error)
+}
+
+type AccessService struct {
+	r repository.Repository
+}
+
+func NewAccessService(r repository.Repository) *AccessService {
+	return &AccessService{r: r}
+}
+
+// Principal resolve principal by token or client certificate common name, nil if access is not checked.
+// Principal without role is returned for requests without credentials if anonymous role is not set
+func (s *AccessService) Principal(ctx context.Context, token, cert string) (*domain.Principal, error) {
+	a, err := s.r.GetAccessConfig(ctx)
+	if err != nil {
+		if errors.Is(err, myErr.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p, ok := a.For(token, cert)
+	if !ok {
+		return nil, myErr.ErrUnknownToken
+	}
+	return &p, nil
+}
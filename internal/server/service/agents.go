@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go-musthave-metrics/internal/server/domain"
+	"go-musthave-metrics/internal/server/repository"
+)
+
+type Agents interface {
+	// AgentReport register agent request at agents registry
+	AgentReport(ctx context.Context, report domain.AgentReport) error
+	// GetAgents get all known agents
+	GetAgents(ctx context.Context) ([]domain.Agent, error)
+	// CheckMissingAgents mark agents without reports for intervals of its report interval, return newly missing
+	CheckMissingAgents(ctx context.Context, intervals int) ([]domain.Agent, error)
+	// GetAgentConfig get config served to agent
+	GetAgentConfig(ctx context.Context, id, group string) (domain.AgentConfig, error)
+}
+
+type AgentsService struct {
+	r repository.Repository
+}
+
+func NewAgentsService(r repository.Repository) *AgentsService {
+	return &AgentsService{r: r}
+}
+
+// AgentReport register agent request at agents registry
+func (s *AgentsService) AgentReport(ctx context.Context, report domain.AgentReport) error {
+	return s.r.SaveAgentReport(ctx, report, time.Now())
+}
+
+// GetAgents get all known agents
+func (s *AgentsService) GetAgents(ctx context.Context) ([]domain.Agent, error) {
+	return s.r.GetAgents(ctx)
+}
+
+// CheckMissingAgents mark agents without reports for intervals of its report interval, return newly missing
+func (s *AgentsService) CheckMissingAgents(ctx context.Context, intervals int) ([]domain.Agent, error) {
+	return s.r.MarkMissingAgents(ctx, time.Now(), intervals)
+}
+
+// GetAgentConfig get config served to agent
+func (s *AgentsService) GetAgentConfig(ctx context.Context, id, group string) (domain.AgentConfig, error) {
+	return s.r.GetAgentConfig(ctx, id, group)
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	"go-musthave-metrics/internal/server/repository"
+)
+
+// Audit audit log of write and admin operations
+type Audit interface {
+	// WriteAudit append record to audit log, record time is set to now if empty
+	WriteAudit(ctx context.Context, rec domain.AuditRecord) error
+	// GetAudit query audit log, ErrNotExist if audit log is not configured
+	GetAudit(ctx context.Context, f domain.AuditFilter) ([]domain.AuditRecord, error)
+}
+
+type AuditService struct {
+	r repository.Repository
+}
+
+func NewAuditService(r repository.Repository) *AuditService {
+	return &AuditService{r: r}
+}
+
+// WriteAudit append record to audit log, record time is set to now if empty
+func (s *AuditService) WriteAudit(ctx context.Context, rec domain.AuditRecord) error {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	return s.r.SaveAudit(ctx, rec)
+}
+
+// GetAudit query audit log, number of records is limited by default limit if not set
+func (s *AuditService) GetAudit(ctx context.Context, f domain.AuditFilter) ([]domain.AuditRecord, error) {
+	if f.Limit <= 0 {
+		f.Limit = constant.AuditQueryLimit
+	}
+	return s.r.GetAudit(ctx, f)
+}
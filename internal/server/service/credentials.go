@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+	"go-musthave-metrics/internal/server/repository"
+)
+
+type Credentials interface {
+	// ActiveCredential get key by key id, key must be valid at now and not revoked
+	ActiveCredential(ctx context.Context, keyID string) (domain.Credential, error)
+	// GetCredentials get keys of agent without secrets, all keys if agent id is empty
+	GetCredentials(ctx context.Context, agentID string) ([]domain.Credential, error)
+	// CreateCredential create new key of agent
+	CreateCredential(ctx context.Context, agentID string, notBefore time.Time, notAfter *time.Time) (domain.Credential, error)
+	// RotateCredential create new key of agent, other active keys of agent are valid for overlap
+	RotateCredential(ctx context.Context, agentID string, overlap time.Duration) (domain.Credential, error)
+	// RevokeCredential revoke key
+	RevokeCredential(ctx context.Context, keyID string) (domain.Credential, error)
+}
+
+type CredentialsService struct {
+	r repository.Repository
+}
+
+func NewCredentialsService(r repository.Repository) *CredentialsService {
+	return &CredentialsService{r: r}
+}
+
+// ActiveCredential get key by key id, key must be valid at now and not revoked
+func (s *CredentialsService) ActiveCredential(ctx context.Context, keyID string) (c domain.Credential, err error) {
+	if c, err = s.r.GetCredential(ctx, keyID); err != nil {
+		return
+	}
+	if !c.Active(time.Now()) {
+		return domain.Credential{}, myErr.ErrKeyInactive
+	}
+	return
+}
+
+// GetCredentials get keys of agent without secrets, all keys if agent id is empty
+func (s *CredentialsService) GetCredentials(ctx context.Context, agentID string) (list []domain.Credential, err error) {
+	if list, err = s.r.GetCredentials(ctx, agentID); err != nil {
+		return
+	}
+	for i := range list {
+		list[i] = list[i].Public()
+	}
+	return
+}
+
+// CreateCredential create new key of agent
+func (s *CredentialsService) CreateCredential(ctx context.Context, agentID string, notBefore time.Time, notAfter *time.Time) (c domain.Credential, err error) {
+	if agentID == "" {
+		return c, errors.New("agent id is required")
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if notBefore.IsZero() {
+		notBefore = now
+	}
+	c = domain.Credential{
+		KeyID:     "k" + randomHex(8),
+		AgentID:   agentID,
+		Secret:    randomHex(32),
+		NotBefore: notBefore.UTC().Truncate(time.Microsecond),
+		CreatedAt: now,
+	}
+	if notAfter != nil {
+		t := notAfter.UTC().Truncate(time.Microsecond)
+		c.NotAfter = &t
+	}
+	err = s.r.SaveCredential(ctx, c)
+	return
+}
+
+// RotateCredential create new key of agent, other active keys of agent are valid for overlap
+func (s *CredentialsService) RotateCredential(ctx context.Context, agentID string, overlap time.Duration) (c domain.Credential, err error) {
+	if c, err = s.CreateCredential(ctx, agentID, time.Time{}, nil); err != nil {
+		return
+	}
+	var list []domain.Credential
+	if list, err = s.r.GetCredentials(ctx, agentID); err != nil {
+		return
+	}
+	expire := c.CreatedAt.Add(overlap)
+	for _, old := range list {
+		if old.KeyID == c.KeyID || !old.Active(c.CreatedAt) || old.NotAfter != nil && !old.NotAfter.After(expire) {
+			continue
+		}
+		old.NotAfter = &expire
+		if err = s.r.SaveCredential(ctx, old); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// RevokeCredential revoke key
+func (s *CredentialsService) RevokeCredential(ctx context.Context, keyID string) (c domain.Credential, err error) {
+	if c, err = s.r.GetCredential(ctx, keyID); err != nil {
+		return
+	}
+	if c.RevokedAt == nil {
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		c.RevokedAt = &now
+		if err = s.r.SaveCredential(ctx, c); err != nil {
+			return
+		}
+	}
+	return c.Public(), nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
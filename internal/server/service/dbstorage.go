@@ -8,6 +8,10 @@ import (
 
 type MetricsDB interface {
 	CheckDB(ctx context.Context) error
+	// WriteBehindStats state of write-behind buffer of store, ErrNotExist if it is not used
+	WriteBehindStats() (repository.WriteBehindStats, error)
+	// BreakerStats state of circuit breaker of store, ErrNotExist if it is not used
+	BreakerStats() (repository.BreakerStats, error)
 }
 
 type MetricsDBService struct {
@@ -22,3 +26,13 @@ func NewMetricDBService(r repository.Repository) *MetricsDBService {
 func (s *MetricsDBService) CheckDB(ctx context.Context) error {
 	return s.r.Ping(ctx)
 }
+
+// WriteBehindStats state of write-behind buffer of store
+func (s *MetricsDBService) WriteBehindStats() (repository.WriteBehindStats, error) {
+	return s.r.WriteBehindStats()
+}
+
+// BreakerStats state of circuit breaker of store
+func (s *MetricsDBService) BreakerStats() (repository.BreakerStats, error) {
+	return s.r.BreakerStats()
+}
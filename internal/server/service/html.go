@@ -21,7 +21,7 @@ func NewMetricsHTMLService(r repository.Repository) *MetricsHTMLService {
 	return &MetricsHTMLService{r: r}
 }
 
-// GetMetricsHTMLPage get html page with all metrics
+// GetMetricsHTMLPage get html page with all metrics and agents of request tenant
 func (s *MetricsHTMLService) GetMetricsHTMLPage(ctx context.Context) (html []byte, err error) {
 	type lItem struct {
 		MValue interface{}
@@ -30,6 +30,7 @@ func (s *MetricsHTMLService) GetMetricsHTMLPage(ctx context.Context) (html []byt
 	var (
 		counter domain.Counters
 		gauge   domain.Gauges
+		agents  []domain.Agent
 		list    = map[string]lItem{}
 	)
 	if counter, err = s.r.GetAllCounters(ctx); err != nil {
@@ -50,6 +51,13 @@ func (s *MetricsHTMLService) GetMetricsHTMLPage(ctx context.Context) (html []byt
 			MValue: v,
 		}
 	}
-	html, err = helper.ParseHTMLTemplate(constant.MetricListTpl, list)
+	if agents, err = s.r.GetAgents(ctx); err != nil {
+		return
+	}
+	html, err = helper.ParseHTMLTemplate(constant.MetricListTpl, struct {
+		Metrics map[string]lItem
+		Tenant  string
+		Agents  []domain.Agent
+	}{Metrics: list, Tenant: domain.TenantFromContext(ctx), Agents: agents})
 	return
 }
@@ -0,0 +1,12 @@
+package service
+
+import (
+	"time"
+
+	"go-musthave-metrics/internal/server/limiter"
+)
+
+type Limits interface {
+	// Allow take ingestion rate tokens of agent, return time to wait if limits are exceeded
+	Allow(lim limiter.Limits, key string, requests, metrics int) time.Duration
+}
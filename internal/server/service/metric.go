@@ -2,6 +2,7 @@ package service
 
 import (
 	"errors"
+	"sync"
 
 	"golang.org/x/net/context"
 
@@ -25,6 +26,9 @@ type Metrics interface {
 type MetricsService struct {
 	r repository.Repository
 	c *config.StorageConfig
+	q seriesQuota
+	// wal order of store writes and write-ahead log records, store snapshot is taken between records
+	wal sync.Mutex
 }
 
 func NewMetricService(r repository.Repository, c *config.StorageConfig) *MetricsService {
@@ -33,33 +37,28 @@ func NewMetricService(r repository.Repository, c *config.StorageConfig) *Metrics
 
 // SetGauge save one gauge
 func (s *MetricsService) SetGauge(ctx context.Context, k string, v domain.Gauge) (err error) {
-	if err = s.r.SetGauge(ctx, k, v); err != nil {
+	if err = s.admit(ctx, domain.Metric{ID: k, MType: constant.MetricTypeGauge}); err != nil {
 		return
 	}
-	if s.c.FileStoragePath != "" && s.c.FileStoreInterval == 0 {
-		if _, err = s.SaveToFile(ctx); errors.Is(err, myErr.ErrNotMemMode) {
-			err = nil
-		}
-	}
+	_, err = s.persist(ctx, func() ([]domain.Metric, error) {
+		return []domain.Metric{{ID: k, MType: constant.MetricTypeGauge, Value: &v}}, s.r.SetGauge(ctx, k, v)
+	})
 	return
 }
 
 // IncreaseCounter set or increase counter if already exist
 func (s *MetricsService) IncreaseCounter(ctx context.Context, k string, v domain.Counter) (err error) {
-	var prev domain.Counter
-	if prev, err = s.r.GetCounter(ctx, k); err != nil && !errors.Is(err, myErr.ErrNotExist) {
-		return
-	}
-
-	if err = s.r.SetCounter(ctx, k, prev+v); err != nil {
+	if err = s.admit(ctx, domain.Metric{ID: k, MType: constant.MetricTypeCounter}); err != nil {
 		return
 	}
-	if s.c.FileStoragePath != "" && s.c.FileStoreInterval == 0 {
-		if _, err = s.SaveToFile(ctx); errors.Is(err, myErr.ErrNotMemMode) {
-			err = nil
+	_, err = s.persist(ctx, func() ([]domain.Metric, error) {
+		prev, err := s.r.GetCounter(ctx, k)
+		if err != nil && !errors.Is(err, myErr.ErrNotExist) {
+			return nil, err
 		}
-	}
-
+		total := prev + v
+		return []domain.Metric{{ID: k, MType: constant.MetricTypeCounter, Delta: &total}}, s.r.SetCounter(ctx, k, total)
+	})
 	return
 }
 
@@ -110,26 +109,86 @@ func (s *MetricsService) SetMetric(ctx context.Context, metric domain.Metric) (r
 		metric.Delta = &count
 	}
 	rm = metric
-	if s.c.FileStoragePath != "" && s.c.FileStoreInterval == 0 {
-		if _, err = s.SaveToFile(ctx); errors.Is(err, myErr.ErrNotMemMode) {
-			err = nil
-		}
-	}
-
 	return
 }
 
-// SetMetrics set several metrics
+// SetMetrics set several metrics. Metrics are returned in order of request, metrics rejected by quotas
+// have error reason. ErrQuotaExceeded is returned if all metrics are rejected
 func (s *MetricsService) SetMetrics(ctx context.Context, metrics []domain.Metric) (rMetrics []domain.Metric, err error) {
 	validate := validator.New()
 	if err = validate.Struct(domain.ValidateMetrics{Metrics: metrics}); err != nil {
 		return
 	}
-	rMetrics, err = s.r.SetMetrics(ctx, metrics)
-	if s.c.FileStoragePath != "" && s.c.FileStoreInterval == 0 {
+	var errs []error
+	if errs, err = s.q.admit(ctx, s.r, s.c.GetQuotas(), metrics...); err != nil {
+		return
+	}
+	accepted := make([]domain.Metric, 0, len(metrics))
+	for i, m := range metrics {
+		if errs[i] == nil {
+			accepted = append(accepted, m)
+		}
+	}
+	rMetrics = make([]domain.Metric, len(metrics))
+	if len(accepted) == 0 {
+		for i, m := range metrics {
+			m.Error = errs[i].Error()
+			rMetrics[i] = m
+		}
+		return rMetrics, myErr.ErrQuotaExceeded
+	}
+	var written []domain.Metric
+	if written, err = s.persist(ctx, func() ([]domain.Metric, error) {
+		return s.r.SetMetrics(ctx, accepted)
+	}); err != nil {
+		return nil, err
+	}
+	// rejected metrics are kept at place of request with reason
+	for i, j := 0, 0; i < len(metrics); i++ {
+		if errs[i] != nil {
+			rMetrics[i] = metrics[i]
+			rMetrics[i].Error = errs[i].Error()
+			continue
+		}
+		rMetrics[i] = written[j]
+		j++
+	}
+	return
+}
+
+// walEnabled is write-ahead log used, it is used with storage file only
+func (s *MetricsService) walEnabled() bool {
+	return s.c.FileStoragePath != "" && s.r.WALEnabled()
+}
+
+// persist make store write and keep written metrics: append them to write-ahead log in order of writes,
+// or save store to file on every change if store interval is 0
+func (s *MetricsService) persist(ctx context.Context, write func() ([]domain.Metric, error)) (written []domain.Metric, err error) {
+	if s.walEnabled() {
+		s.wal.Lock()
+		defer s.wal.Unlock()
+		if written, err = write(); err != nil {
+			return
+		}
+		err = s.r.AppendWAL(ctx, written)
+		return
+	}
+	if written, err = write(); err != nil {
+		return
+	}
+	if s.c.FileStoragePath != "" && s.c.GetFileStoreInterval() == 0 {
 		if _, err = s.SaveToFile(ctx); errors.Is(err, myErr.ErrNotMemMode) {
 			err = nil
 		}
 	}
 	return
 }
+
+// admit check one metric against quotas
+func (s *MetricsService) admit(ctx context.Context, metric domain.Metric) error {
+	errs, err := s.q.admit(ctx, s.r, s.c.GetQuotas(), metric)
+	if err != nil {
+		return err
+	}
+	return errs[0]
+}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"errors"
 
+	myErr "go-musthave-metrics/internal/server/errors"
 	"go-musthave-metrics/internal/server/repository"
 )
 
@@ -13,24 +15,39 @@ type MetricsFile interface {
 	RestoreFromFile(ctx context.Context) (int64, error)
 }
 
-// SaveToFile backup storage to file
+// SaveToFile backup storage to file. With write-ahead log store is saved as snapshot between log records,
+// log is truncated after snapshot
 func (s *MetricsService) SaveToFile(ctx context.Context) (n int64, err error) {
+	if s.walEnabled() {
+		s.wal.Lock()
+		defer s.wal.Unlock()
+	}
 	var m *repository.MemStorageRepo
 	m, err = s.r.MemStore(ctx)
 	if err == nil {
 		err = s.r.SaveToFile(m)
-		n = int64(len(m.MemStorageCounter.Counter) + len(m.MemStorageGauge.Gauge))
+		n = int64(m.Count())
+	}
+	if err == nil && s.walEnabled() {
+		err = s.r.TruncateWAL()
 	}
 	return
 }
 
-// RestoreFromFile restore backup storage from file
+// RestoreFromFile restore backup storage from file and replay write-ahead log over it.
+// Log is replayed over previous generation of storage file too, ErrSnapshotFallback is returned then
 func (s *MetricsService) RestoreFromFile(ctx context.Context) (n int64, err error) {
 	var m *repository.MemStorageRepo
 	m, err = s.r.MemStore(ctx)
 	if err == nil {
 		err = s.r.RestoreFromFile(m)
-		n = int64(len(m.MemStorageCounter.Counter) + len(m.MemStorageGauge.Gauge))
+	}
+	if (err == nil || errors.Is(err, myErr.ErrSnapshotFallback)) && s.walEnabled() {
+		_, er := s.r.ReplayWAL(m)
+		err = errors.Join(err, er)
+	}
+	if m != nil {
+		n = int64(m.Count())
 	}
 	return
 }
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	myErr "go-musthave-metrics/internal/server/errors"
+	"go-musthave-metrics/internal/server/repository"
+)
+
+// rejectedMetrics counters of metrics writes rejected by quotas, by reason. Served at /debug/vars
+var rejectedMetrics = expvar.NewMap("metrics_rejected")
+
+// seriesQuota known series of storage by tenants and series written by agents of tenants.
+// Series are loaded from storage on first check and counted at admission,
+// so counters are approximate if storage is shared or write fails
+type seriesQuota struct {
+	tenants map[string]map[string]struct{}
+	agents  map[string]map[string]struct{}
+	total   int
+	m       sync.Mutex
+	loaded  bool
+}
+
+func seriesKey(m domain.Metric) string {
+	return m.MType + "/" + m.ID
+}
+
+// rejectReason counter name of quota error
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, myErr.ErrNameTooLong):
+		return "name_length"
+	case errors.Is(err, myErr.ErrAgentSeriesLimit):
+		return "agent_series"
+	case errors.Is(err, myErr.ErrTenantSeriesLimit):
+		return "tenant_series"
+	case errors.Is(err, myErr.ErrSeriesLimit):
+		return "series"
+	}
+	return "other"
+}
+
+// load known series of all tenants from storage
+func (q *seriesQuota) load(ctx context.Context, r repository.DataStorage) (err error) {
+	var tenants []string
+	if tenants, err = r.GetTenants(ctx); err != nil {
+		return
+	}
+	q.tenants = make(map[string]map[string]struct{}, len(tenants))
+	q.agents = make(map[string]map[string]struct{})
+	q.total = 0
+	for _, tenant := range tenants {
+		var (
+			gauges   domain.Gauges
+			counters domain.Counters
+			tCtx     = domain.WithTenant(ctx, tenant)
+		)
+		if gauges, err = r.GetAllGauges(tCtx); err != nil {
+			return
+		}
+		if counters, err = r.GetAllCounters(tCtx); err != nil {
+			return
+		}
+		series := make(map[string]struct{}, len(gauges)+len(counters))
+		for k := range gauges {
+			series[seriesKey(domain.Metric{ID: k, MType: constant.MetricTypeGauge})] = struct{}{}
+		}
+		for k := range counters {
+			series[seriesKey(domain.Metric{ID: k, MType: constant.MetricTypeCounter})] = struct{}{}
+		}
+		q.tenants[tenant] = series
+		q.total += len(series)
+	}
+	q.loaded = true
+	return
+}
+
+// admit check metrics of request tenant against quotas, return quota error of each metric,
+// nil for accepted metric. Series of accepted metrics are counted
+func (q *seriesQuota) admit(ctx context.Context, r repository.DataStorage, quotas domain.Quotas, metrics ...domain.Metric) (errs []error, err error) {
+	errs = make([]error, len(metrics))
+	if !quotas.Enabled() {
+		return
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	track := quotas.Series > 0 || quotas.TenantSeries > 0 || quotas.AgentSeries > 0
+	if !track {
+		q.tenants, q.agents, q.total, q.loaded = nil, nil, 0, false
+	} else if !q.loaded {
+		if err = q.load(ctx, r); err != nil {
+			return
+		}
+	}
+	tenant := domain.TenantFromContext(ctx)
+	owner := domain.QuotaOwnerFromContext(ctx)
+	if owner != "" {
+		owner = tenant + "/" + owner
+	}
+	for i, m := range metrics {
+		if errs[i] = q.check(quotas, tenant, owner, m); errs[i] != nil {
+			rejectedMetrics.Add(rejectReason(errs[i]), 1)
+			continue
+		}
+		if !track {
+			continue
+		}
+		key := seriesKey(m)
+		if q.tenants[tenant] == nil {
+			q.tenants[tenant] = make(map[string]struct{})
+		}
+		if _, ok := q.tenants[tenant][key]; !ok {
+			q.tenants[tenant][key] = struct{}{}
+			q.total++
+		}
+		if quotas.AgentSeries > 0 && owner != "" {
+			if q.agents[owner] == nil {
+				q.agents[owner] = make(map[string]struct{})
+			}
+			q.agents[owner][key] = struct{}{}
+		}
+	}
+	return
+}
+
+func (q *seriesQuota) check(quotas domain.Quotas, tenant, owner string, m domain.Metric) error {
+	if quotas.NameLength > 0 && len(m.ID) > quotas.NameLength {
+		return myErr.ErrNameTooLong
+	}
+	key := seriesKey(m)
+	if quotas.AgentSeries > 0 && owner != "" {
+		if _, ok := q.agents[owner][key]; !ok && len(q.agents[owner]) >= quotas.AgentSeries {
+			return myErr.ErrAgentSeriesLimit
+		}
+	}
+	if _, ok := q.tenants[tenant][key]; ok {
+		return nil
+	}
+	if quotas.TenantSeries > 0 && len(q.tenants[tenant]) >= quotas.TenantSeries {
+		return myErr.ErrTenantSeriesLimit
+	}
+	if quotas.Series > 0 && q.total >= quotas.Series {
+		return myErr.ErrSeriesLimit
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/limiter"
 	"go-musthave-metrics/internal/server/repository"
 )
 
@@ -10,6 +11,11 @@ type Service struct {
 	MetricsHTML
 	MetricsDB
 	MetricsFile
+	Agents
+	Credentials
+	Access
+	Limits
+	Audit
 }
 
 // NewService return main service methods
@@ -20,5 +26,10 @@ func NewService(r repository.Repository, c *config.StorageConfig) *Service {
 		MetricsHTML: NewMetricsHTMLService(r),
 		MetricsDB:   NewMetricDBService(r),
 		MetricsFile: mainService,
+		Agents:      NewAgentsService(r),
+		Credentials: NewCredentialsService(r),
+		Access:      NewAccessService(r),
+		Limits:      limiter.New(),
+		Audit:       NewAuditService(r),
 	}
 }
@@ -0,0 +1,106 @@
+package sign
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	const key = "secretKey"
+	now := time.Now()
+	body := []byte(`[{"id":"c","type":"counter","delta":1}]`)
+	skew := time.Minute
+
+	signed := func(modify func(p *Params)) (Params, string) {
+		p := New(http.MethodPost, "/updates", body)
+		p.Timestamp = now.Unix()
+		s := p.Sign(key)
+		if modify != nil {
+			modify(&p)
+		}
+		return p, s
+	}
+
+	tests := []struct {
+		wantErr error
+		modify  func(p *Params)
+		name    string
+		key     string
+	}{
+		{name: "ok", key: key},
+		{name: "wrong key", key: "other", wantErr: ErrBadSign},
+		{name: "other body", key: key, modify: func(p *Params) { p.Body = []byte("[]") }, wantErr: ErrBadSign},
+		{name: "other path", key: key, modify: func(p *Params) { p.Path = "/update" }, wantErr: ErrBadSign},
+		{name: "other method", key: key, modify: func(p *Params) { p.Method = http.MethodPut }, wantErr: ErrBadSign},
+		{name: "other nonce", key: key, modify: func(p *Params) { p.Nonce = NewNonce() }, wantErr: ErrBadSign},
+		{name: "other timestamp", key: key, modify: func(p *Params) { p.Timestamp++ }, wantErr: ErrBadSign},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier()
+			v.now = func() time.Time { return now }
+			p, s := signed(tt.modify)
+			assert.ErrorIs(t, v.Verify(tt.key, p, s, skew), tt.wantErr)
+		})
+	}
+}
+
+func TestVerifyWindow(t *testing.T) {
+	const key = "secretKey"
+	now := time.Now()
+	skew := time.Minute
+	v := NewVerifier()
+	v.now = func() time.Time { return now }
+
+	for _, tt := range []struct {
+		wantErr error
+		name    string
+		shift   time.Duration
+	}{
+		{name: "past, in window", shift: -skew + time.Second},
+		{name: "future, in window", shift: skew - time.Second},
+		{name: "past, out of window", shift: -skew - time.Second, wantErr: ErrTimestamp},
+		{name: "future, out of window", shift: skew + time.Second, wantErr: ErrTimestamp},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(http.MethodPost, "/updates", nil)
+			p.Timestamp = now.Add(tt.shift).Unix()
+			assert.ErrorIs(t, v.Verify(key, p, p.Sign(key), skew), tt.wantErr)
+		})
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	const key = "secretKey"
+	now := time.Now()
+	skew := time.Minute
+	v := NewVerifier()
+	v.now = func() time.Time { return now }
+
+	p := New(http.MethodPost, "/updates", []byte("body"))
+	p.Timestamp = now.Unix()
+	require.NoError(t, v.Verify(key, p, p.Sign(key), skew))
+	assert.ErrorIs(t, v.Verify(key, p, p.Sign(key), skew), ErrReplay)
+
+	noNonce := p
+	noNonce.Nonce = ""
+	assert.ErrorIs(t, v.Verify(key, noNonce, noNonce.Sign(key), skew), ErrBadSign)
+
+	// nonce is forgotten after the window, old timestamp is rejected by window itself
+	now = now.Add(2 * skew)
+	assert.ErrorIs(t, v.Verify(key, p, p.Sign(key), skew), ErrTimestamp)
+	fresh := New(http.MethodPost, "/updates", []byte("body"))
+	fresh.Timestamp = now.Unix()
+	require.NoError(t, v.Verify(key, fresh, fresh.Sign(key), skew))
+	assert.Len(t, v.seen, 1)
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	v := NewVerifier()
+	assert.NoError(t, v.VerifyLegacy("key", []byte("body"), SignLegacy("key", []byte("body"))))
+	assert.ErrorIs(t, v.VerifyLegacy("key", []byte("body"), SignLegacy("other", []byte("body"))), ErrBadSign)
+}
@@ -0,0 +1,69 @@
+package sign
+
+import (
+	"crypto/hmac"
+	"sync"
+	"time"
+)
+
+// Verifier check signatures, timestamps and nonces of requests
+type Verifier struct {
+	seen      map[string]time.Time
+	lastPurge time.Time
+	now       func() time.Time
+	m         sync.Mutex
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{
+		seen: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
+
+// Verify signature of params. Timestamp must be within skew of current time,
+// nonce must not be used within the window
+func (v *Verifier) Verify(key string, p Params, signature string, skew time.Duration) error {
+	if p.Nonce == "" {
+		return ErrBadSign
+	}
+	if !hmac.Equal([]byte(p.Sign(key)), []byte(signature)) {
+		return ErrBadSign
+	}
+	now := v.now()
+	ts := time.Unix(p.Timestamp, 0)
+	if ts.Before(now.Add(-skew)) || ts.After(now.Add(skew)) {
+		return ErrTimestamp
+	}
+	if !v.remember(p.Nonce, ts.Add(skew), now) {
+		return ErrReplay
+	}
+	return nil
+}
+
+// VerifyLegacy signature of body only, old agents format
+func (v *Verifier) VerifyLegacy(key string, body []byte, signature string) error {
+	if !hmac.Equal([]byte(SignLegacy(key, body)), []byte(signature)) {
+		return ErrBadSign
+	}
+	return nil
+}
+
+// remember nonce until expire, false if nonce is already seen
+func (v *Verifier) remember(nonce string, expire, now time.Time) bool {
+	v.m.Lock()
+	defer v.m.Unlock()
+	if now.Sub(v.lastPurge) > time.Minute {
+		for k, e := range v.seen {
+			if e.Before(now) {
+				delete(v.seen, k)
+			}
+		}
+		v.lastPurge = now
+	}
+	if e, ok := v.seen[nonce]; ok && !e.Before(now) {
+		return false
+	}
+	v.seen[nonce] = expire
+	return true
+}
@@ -9,6 +9,8 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 
@@ -17,6 +19,7 @@ import (
 
 	"go-musthave-metrics/internal/agent/app"
 	"go-musthave-metrics/internal/agent/config"
+	helper "go-musthave-metrics/tests"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -158,6 +161,7 @@ func Test_app_Run(t *testing.T) {
 				`Runtime metrics collector is stopped`,
 				`Metrics sender is stopped`,
 				`metrics sent`,
+				`Agent ID: `,
 				`Agent stopped`,
 			},
 		},
@@ -209,6 +213,383 @@ func Test_app_Run(t *testing.T) {
 				`Agent stopped`,
 			},
 		},
+		{
+			name: "Agent and server GRPC with sign key",
+			fields: func() fields {
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = net.JoinHostPort("", fmt.Sprintf("%d", rand.Intn(200)+30000))
+				servCfg.Key = "secretKey"
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.GRPCAddress = servCfg.GRPCAddress
+				cfg.Key = servCfg.Key
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`grpc set metrics success`,
+				`metrics sent`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server with agent key and config from server",
+			fields: func() fields {
+				dir := t.TempDir()
+				credentials := filepath.Join(dir, "credentials.json")
+				require.NoError(t, os.WriteFile(credentials,
+					[]byte(`[{"key_id": "k-test", "agent_id": "agent-test", "secret": "agentSecretKey"}]`), 0o600))
+				agentsConfig := filepath.Join(dir, "agents.json")
+				require.NoError(t, os.WriteFile(agentsConfig,
+					[]byte(`{"agents": {"agent-test": {"report_interval": 1}}}`), 0o600))
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.StorageConfig.CredentialsPath = credentials
+				servCfg.StorageConfig.AgentsConfigPath = agentsConfig
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = ""
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.Address = servCfg.Address
+				cfg.AgentID = "agent-test"
+				cfg.Key = "agentSecretKey"
+				cfg.KeyID = "k-test"
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`Config from server applied: report interval: 1`,
+				`metrics sent`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server GRPC with agent key and config from server",
+			fields: func() fields {
+				dir := t.TempDir()
+				credentials := filepath.Join(dir, "credentials.json")
+				require.NoError(t, os.WriteFile(credentials,
+					[]byte(`[{"key_id": "k-test", "agent_id": "agent-test", "secret": "agentSecretKey"}]`), 0o600))
+				agentsConfig := filepath.Join(dir, "agents.json")
+				require.NoError(t, os.WriteFile(agentsConfig,
+					[]byte(`{"agents": {"agent-test": {"report_interval": 1}}}`), 0o600))
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.StorageConfig.CredentialsPath = credentials
+				servCfg.StorageConfig.AgentsConfigPath = agentsConfig
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = net.JoinHostPort("", fmt.Sprintf("%d", rand.Intn(200)+30000))
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.GRPCAddress = servCfg.GRPCAddress
+				cfg.AgentID = "agent-test"
+				cfg.Key = "agentSecretKey"
+				cfg.KeyID = "k-test"
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`Config from server applied: report interval: 1`,
+				`grpc set metrics success`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server with access token",
+			fields: func() fields {
+				access := filepath.Join(t.TempDir(), "access.json")
+				require.NoError(t, os.WriteFile(access,
+					[]byte(`{"principals": [{"name": "agent", "token": "agent-token", "role": "writer"}]}`), 0o600))
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.StorageConfig.AccessPath = access
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = ""
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.Address = servCfg.Address
+				cfg.APIToken = "agent-token"
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`metrics sent`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server with rate limit",
+			fields: func() fields {
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = ""
+				servCfg.LimitAgentMetrics = 1
+				servCfg.LimitBurst = 8
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 1
+				cfg.PollInterval = 1
+				cfg.SendSize = 8
+				cfg.Address = servCfg.Address
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`rate limit exceeded, retry after 8s`,
+				`wait 8 second before next try`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server with config from server",
+			fields: func() fields {
+				agentsConfig := filepath.Join(t.TempDir(), "agents.json")
+				require.NoError(t, os.WriteFile(agentsConfig,
+					[]byte(`{"groups": {"fast": {"report_interval": 1, "gauges_list": ["Alloc", "Sys"]}}}`), 0o600))
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.StorageConfig.AgentsConfigPath = agentsConfig
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = ""
+				servCfg.Key = "secretKey"
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.Address = servCfg.Address
+				cfg.Key = servCfg.Key
+				cfg.AgentGroup = "fast"
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+					buildInfo: app.BuildMetadata{
+						Version: "1.1-testing",
+						Date:    "24.05.24",
+						Commit:  "4444444",
+					},
+				}
+			}(),
+			wantStrings: []string{
+				`Report interval: 2`,
+				`Config from server applied: report interval: 1, poll interval: 1, rate limit: 1, send size: 10, metric names count: 3`,
+				`daemon started: fetch config from server interval 60`,
+				`metrics sent`,
+				`Config watcher is stopped`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server with encryption",
+			fields: func() fields {
+				dir := t.TempDir()
+				privateKey, publicKey := filepath.Join(dir, "private.key"), filepath.Join(dir, "public.key")
+				helper.CreateCertificates(privateKey, publicKey)
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = ""
+				servCfg.CryptoKey = privateKey
+				require.NoError(t, servCfg.LoadPrivateKey())
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.SendSize = 0
+				cfg.Address = servCfg.Address
+				cfg.CryptoKey = publicKey
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`metrics sent`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server GRPC with encryption",
+			fields: func() fields {
+				dir := t.TempDir()
+				privateKey, publicKey := filepath.Join(dir, "private.key"), filepath.Join(dir, "public.key")
+				helper.CreateCertificates(privateKey, publicKey)
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+30000))
+				servCfg.CryptoKey = privateKey
+				require.NoError(t, servCfg.LoadPrivateKey())
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.SendSize = 0
+				cfg.GRPCAddress = servCfg.GRPCAddress
+				cfg.CryptoKey = publicKey
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`grpc set metrics success`,
+				`metrics sent`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server with P-256 encryption",
+			fields: func() fields {
+				dir := t.TempDir()
+				privateKey, publicKey := filepath.Join(dir, "private.key"), filepath.Join(dir, "public.crt")
+				helper.CreateECCertificates(privateKey, publicKey)
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = ""
+				servCfg.CryptoKey = privateKey
+				require.NoError(t, servCfg.LoadPrivateKey())
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.Address = servCfg.Address
+				cfg.CryptoKey = publicKey
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`metrics sent`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server GRPC with X25519 encryption",
+			fields: func() fields {
+				dir := t.TempDir()
+				privateKey, publicKey := filepath.Join(dir, "private.key"), filepath.Join(dir, "public.key")
+				helper.CreateX25519Keys(privateKey, publicKey)
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+30000))
+				servCfg.CryptoKey = privateKey
+				require.NoError(t, servCfg.LoadPrivateKey())
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.GRPCAddress = servCfg.GRPCAddress
+				cfg.CryptoKey = publicKey
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`grpc set metrics success`,
+				`metrics sent`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server with mutual TLS",
+			fields: func() fields {
+				f := helper.CreateTLSCertificates(t.TempDir(), "tls-agent")
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = ""
+				servCfg.TLSCert, servCfg.TLSKey, servCfg.TLSClientCA = f.ServerCert, f.ServerKey, f.CA
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.Address = servCfg.Address
+				cfg.TLSCA, cfg.TLSCert, cfg.TLSKey = f.CA, f.ClientCert, f.ClientKey
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`Url for collect metric: https://localhost`,
+				`metrics sent`,
+				`Agent stopped`,
+			},
+		},
+		{
+			name: "Agent and server GRPC with mutual TLS",
+			fields: func() fields {
+				f := helper.CreateTLSCertificates(t.TempDir(), "tls-agent")
+
+				servCfg := servConfig.NewConfig()
+				servCfg.StorageConfig.FileStoragePath = ""
+				servCfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+20000))
+				servCfg.GRPCAddress = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+30000))
+				servCfg.TLSCert, servCfg.TLSKey, servCfg.TLSClientCA = f.ServerCert, f.ServerKey, f.CA
+
+				cfg := config.NewConfig()
+				cfg.ReportInterval = 2
+				cfg.PollInterval = 1
+				cfg.GRPCAddress = servCfg.GRPCAddress
+				cfg.TLSCA, cfg.TLSCert, cfg.TLSKey = f.CA, f.ClientCert, f.ClientKey
+
+				return fields{
+					cfg:  cfg,
+					sCfg: servCfg,
+				}
+			}(),
+			wantStrings: []string{
+				`grpc set metrics success`,
+				`metrics sent`,
+				`Agent stopped`,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -282,3 +663,54 @@ func WaitGRPCPort(ctx context.Context, addr string) error {
 		}
 	}
 }
+
+func Test_app_Reload(t *testing.T) {
+	oldArgs := os.Args[:]
+	flag.CommandLine = flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	os.Args = oldArgs[:1]
+	defer func() { os.Args = oldArgs }()
+
+	cnfFile := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(cnfFile,
+		[]byte(`{"report_interval": 2, "poll_interval": 1, "remote_config_interval": -1}`), 0o600))
+
+	cfg := config.NewConfig()
+	cfg.Config = cnfFile
+	require.NoError(t, cfg.Init())
+	// reload get config file from env
+	require.NoError(t, os.Setenv("CONFIG", cnfFile))
+	defer func() { require.NoError(t, os.Unsetenv("CONFIG")) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3500*time.Millisecond)
+	defer cancel()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer func() {
+		log.SetOutput(os.Stderr)
+	}()
+
+	time.AfterFunc(time.Second, func() {
+		assert.NoError(t, os.WriteFile(cnfFile,
+			[]byte(`{"report_interval": 2, "poll_interval": 2, "remote_config_interval": -1, "gauges_list": ["Alloc"]}`), 0o600))
+		assert.NoError(t, os.Chtimes(cnfFile, time.Now(), time.Now().Add(time.Second)))
+	})
+	time.AfterFunc(2500*time.Millisecond, func() {
+		assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	})
+
+	app.RunApp(ctx, cfg, app.BuildMetadata{})
+
+	t.Log(buf.String())
+	for _, s := range []string{
+		`Poll interval: 1`,
+		`Config file changed, reload config`,
+		`Local config applied`,
+		`Config reloaded`,
+		`SIGHUP received, reload config`,
+		`Config watcher is stopped`,
+		`Agent stopped`,
+	} {
+		assert.Contains(t, buf.String(), s, fmt.Sprintf("%s is expected at log out", s))
+	}
+}
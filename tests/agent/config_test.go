@@ -64,6 +64,12 @@ func (suite *ConfigTestSuite) setConfigFromMap(m map[string]any, sc ...*config.C
 				c.Address = v
 			case "key", "-k", "KEY":
 				c.Key = v
+			case "key_id", "-key-id", "KEY_ID":
+				c.KeyID = v
+			case "api_token", "-api-token", "API_TOKEN":
+				c.APIToken = v
+			case "tenant", "-tenant", "TENANT":
+				c.Tenant = v
 			case "crypto_key", "-crypto-key", "CRYPTO_KEY":
 				c.CryptoKey = v
 			case "config", "CONFIG":
@@ -132,6 +138,9 @@ func (suite *ConfigTestSuite) TestInit() {
 				"rate_limit":      11,
 				"send_size":       11,
 				"key":             "some-config-secret-key",
+				"key_id":          "some-config-key-id",
+				"api_token":       "some-config-api-token",
+				"tenant":          "some-config-tenant",
 				"crypto_key":      suite.publicKey,
 			},
 		},
@@ -159,6 +168,9 @@ func (suite *ConfigTestSuite) TestInit() {
 				"-l":          22,
 				"-s":          22,
 				"-k":          "some-flag-secret-key",
+				"-key-id":     "some-flag-key-id",
+				"-api-token":  "some-flag-api-token",
+				"-tenant":     "some-flag-tenant",
 				"-crypto-key": suite.publicKey,
 			},
 		},
@@ -185,6 +197,9 @@ func (suite *ConfigTestSuite) TestInit() {
 				"RATE_LIMIT":      "31",
 				"SEND_SIZE":       "31",
 				"KEY":             "some-env-secret-key",
+				"KEY_ID":          "some-env-key-id",
+				"API_TOKEN":       "some-env-api-token",
+				"TENANT":          "some-env-tenant",
 				"CRYPTO_KEY":      suite.publicKey,
 			},
 		},
@@ -391,3 +406,32 @@ func (suite *ConfigTestSuite) TestLoadPublicKey() {
 	}
 
 }
+
+func (suite *ConfigTestSuite) TestReload() {
+	t := suite.T()
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	cnfFile := filepath.Join(t.TempDir(), "config.json")
+
+	flag.CommandLine = flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	os.Args = []string{oldArgs[0], "-c=" + cnfFile, "-r=50"}
+	require.NoError(t, helper.CreateConfigFile(cnfFile, map[string]any{"report_interval": 10, "poll_interval": 3}))
+
+	cfg := config.NewConfig()
+	require.NoError(t, cfg.Init())
+	assert.Equal(t, 50, cfg.ReportInterval)
+	assert.Equal(t, 3, cfg.PollInterval)
+
+	require.NoError(t, helper.CreateConfigFile(cnfFile, map[string]any{"report_interval": 20, "rate_limit": 4}))
+	newCfg, err := cfg.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, 50, newCfg.ReportInterval, "flag is over config file")
+	assert.Equal(t, config.NewConfig().PollInterval, newCfg.PollInterval, "removed from file value is default")
+	assert.Equal(t, 4, newCfg.RateLimit)
+	assert.Equal(t, 3, cfg.PollInterval, "current config is not changed")
+
+	require.NoError(t, helper.CreateConfigFile(cnfFile, `{"report_interval": `))
+	_, err = cfg.Reload()
+	assert.Error(t, err)
+}
@@ -2,6 +2,9 @@ package testhelpers
 
 import (
 	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -12,6 +15,7 @@ import (
 	"math/big"
 	"net"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -69,6 +73,50 @@ func CreateCertificates(privateFile, publicFile string) {
 
 }
 
+// CreateECCertificates
+// create test P-256 key pair: SEC1 private key and self-signed certificate with public key
+func CreateECCertificates(privateFile, publicFile string) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeECKey(privateFile, privateKey)
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{
+			Organization: []string{"Yandex.Praktikum"},
+			Country:      []string{"RU"},
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().AddDate(10, 0, 0),
+		KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+	}
+	writeCertificate(publicFile, cert, cert, &privateKey.PublicKey, privateKey)
+}
+
+// CreateX25519Keys
+// create test X25519 key pair: PKCS8 private key and PKIX public key
+func CreateX25519Keys(privateFile, publicFile string) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = os.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		log.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(privateKey.PublicKey())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = os.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
 // CreateConfigFile
 // create come json config file. if config is string or byte, it will be saved "as is"
 func CreateConfigFile(configFile string, config any) (err error) {
@@ -86,3 +134,90 @@ func CreateConfigFile(configFile string, config any) (err error) {
 	}
 	return os.WriteFile(configFile, b, 0644)
 }
+
+// TLSFiles test tls certificates files
+type TLSFiles struct {
+	CA         string
+	ServerCert string
+	ServerKey  string
+	ClientCert string
+	ClientKey  string
+}
+
+// CreateTLSCertificates
+// create test CA, server certificate for localhost and client certificate with clientName common name at dir
+func CreateTLSCertificates(dir, clientName string) (f TLSFiles) {
+	f = TLSFiles{
+		CA:         filepath.Join(dir, "ca.crt"),
+		ServerCert: filepath.Join(dir, "server.crt"),
+		ServerKey:  filepath.Join(dir, "server.key"),
+		ClientCert: filepath.Join(dir, "client.crt"),
+		ClientKey:  filepath.Join(dir, "client.key"),
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "test CA", Organization: []string{"Yandex.Praktikum"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDER := writeCertificate(f.CA, ca, ca, &caKey.PublicKey, caKey)
+	if ca, err = x509.ParseCertificate(caDER); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		cert, key string
+		tpl       *x509.Certificate
+	}{
+		{cert: f.ServerCert, key: f.ServerKey, tpl: &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "localhost"},
+			DNSNames:    []string{"localhost"},
+			IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}},
+		{cert: f.ClientCert, key: f.ClientKey, tpl: &x509.Certificate{
+			Subject:     pkix.Name{CommonName: clientName},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}},
+	} {
+		key, er := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if er != nil {
+			log.Fatal(er)
+		}
+		c.tpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+		c.tpl.NotBefore = time.Now().Add(-time.Hour)
+		c.tpl.NotAfter = time.Now().AddDate(1, 0, 0)
+		c.tpl.KeyUsage = x509.KeyUsageDigitalSignature
+		writeCertificate(c.cert, c.tpl, ca, &key.PublicKey, caKey)
+		writeECKey(c.key, key)
+	}
+	return
+}
+
+func writeCertificate(certFile string, tpl, parent *x509.Certificate, pub, priv any) []byte {
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, pub, priv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		log.Fatal(err)
+	}
+	return der
+}
+
+func writeECKey(keyFile string, key *ecdsa.PrivateKey) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		log.Fatal(err)
+	}
+}
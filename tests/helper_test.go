@@ -1,8 +1,11 @@
 package testhelpers
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"go-musthave-metrics/internal/envelope"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -114,3 +117,57 @@ func TestCreateConfigFile(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateTLSCertificates(t *testing.T) {
+	f := CreateTLSCertificates(t.TempDir(), "test-agent")
+
+	caPEM, err := os.ReadFile(f.CA)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPEM))
+
+	for _, c := range []struct {
+		cert, key, name string
+		usage           x509.ExtKeyUsage
+	}{
+		{cert: f.ServerCert, key: f.ServerKey, name: "localhost", usage: x509.ExtKeyUsageServerAuth},
+		{cert: f.ClientCert, key: f.ClientKey, name: "test-agent", usage: x509.ExtKeyUsageClientAuth},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			pair, err := tls.LoadX509KeyPair(c.cert, c.key)
+			require.NoError(t, err)
+			cert, err := x509.ParseCertificate(pair.Certificate[0])
+			require.NoError(t, err)
+			assert.Equal(t, c.name, cert.Subject.CommonName)
+			_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{c.usage}})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCreateECKeys(t *testing.T) {
+	for name, create := range map[string]func(privateFile, publicFile string){
+		"p256":   CreateECCertificates,
+		"x25519": CreateX25519Keys,
+	} {
+		t.Run(name, func(t *testing.T) {
+			privateFile, publicFile := filepath.Join(t.TempDir(), "private.key"), filepath.Join(t.TempDir(), "public.key")
+			create(privateFile, publicFile)
+
+			b, err := os.ReadFile(privateFile)
+			require.NoError(t, err)
+			priv, err := envelope.ParsePrivateKeyPEM(b)
+			require.NoError(t, err)
+			b, err = os.ReadFile(publicFile)
+			require.NoError(t, err)
+			pub, err := envelope.ParsePublicKeyPEM(b)
+			require.NoError(t, err)
+
+			scheme, data, err := envelope.Seal(pub, []byte("data"))
+			require.NoError(t, err)
+			data, err = envelope.Open(priv, scheme, data)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("data"), data)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/server/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testAccess(suite HandlerTestSuite) {
+	t := suite.T()
+
+	accessFile := filepath.Join(t.TempDir(), "access.json")
+	require.NoError(t, os.WriteFile(accessFile, []byte(`{
+		"principals": [
+			{"name": "reader", "token": "reader-token", "role": "reader"},
+			{"name": "team-a", "token": "team-a-token", "role": "writer", "prefixes": ["teamA_"]},
+			{"name": "admin", "token": "admin-token", "role": "admin"}
+		]
+	}`), 0o600))
+
+	oldPath := suite.Cfg().AccessPath
+	suite.Cfg().AccessPath = accessFile
+	defer func() { suite.Cfg().AccessPath = oldPath }()
+
+	do := func(t *testing.T, method, path, token string, body []byte) int {
+		req, err := http.NewRequest(method, "http://"+suite.Cfg().Address+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set(constant.HeaderAuthorization, constant.BearerPrefix+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res.StatusCode
+	}
+	n := rand.Int()
+	metrics := func(names ...string) []byte {
+		b := bytes.NewBufferString("[")
+		for i, name := range names {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(fmt.Sprintf(`{"id": "%s%d", "type": "counter", "delta": 1}`, name, n))
+		}
+		b.WriteString("]")
+		return b.Bytes()
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   []byte
+		want   int
+	}{
+		{name: "Ping without token", method: http.MethodGet, path: "/ping", want: http.StatusOK},
+		{name: "Read without token", method: http.MethodGet, path: "/", want: http.StatusUnauthorized},
+		{name: "Read with unknown token", method: http.MethodGet, path: "/", token: "unknown", want: http.StatusUnauthorized},
+		{name: "Read by reader", method: http.MethodGet, path: "/", token: "reader-token", want: http.StatusOK},
+		{name: "Agents by reader", method: http.MethodGet, path: constant.AgentsRoute, token: "reader-token", want: http.StatusOK},
+		{name: "Write by reader", method: http.MethodPost, path: constant.UpdatesRoute, token: "reader-token",
+			body: metrics("teamA_counter"), want: http.StatusForbidden},
+		{name: "Write by writer at prefix", method: http.MethodPost, path: constant.UpdatesRoute, token: "team-a-token",
+			body: metrics("teamA_counter"), want: http.StatusOK},
+		{name: "Write by writer out of prefix", method: http.MethodPost, path: constant.UpdatesRoute, token: "team-a-token",
+			body: metrics("teamA_counter", "teamB_counter"), want: http.StatusForbidden},
+		{name: "Write one by url out of prefix", method: http.MethodPost, path: constant.UpdateRoute + "/counter/teamB_counter/1",
+			token: "team-a-token", want: http.StatusForbidden},
+		{name: "Write one by json at prefix", method: http.MethodPost, path: constant.UpdateRoute + "/", token: "team-a-token",
+			body: []byte(`{"id": "teamA_one", "type": "counter", "delta": 1}`), want: http.StatusOK},
+		{name: "Read by writer", method: http.MethodGet, path: constant.ValueRoute + "/counter/teamA_one", token: "team-a-token",
+			want: http.StatusOK},
+		{name: "Admin api by writer", method: http.MethodGet, path: constant.AdminKeysRoute + "/", token: "team-a-token",
+			want: http.StatusForbidden},
+		{name: "Admin api by admin", method: http.MethodGet, path: constant.AdminKeysRoute + "/", token: "admin-token",
+			want: http.StatusOK},
+		{name: "Profiler by reader", method: http.MethodGet, path: "/debug/pprof/", token: "reader-token",
+			want: http.StatusForbidden},
+		{name: "Profiler by admin", method: http.MethodGet, path: "/debug/pprof/", token: "admin-token",
+			want: http.StatusOK},
+		{name: "Write by admin", method: http.MethodPost, path: constant.UpdatesRoute, token: "admin-token",
+			body: metrics("teamB_counter"), want: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, do(t, test.method, test.path, test.token, test.body))
+		})
+	}
+
+	t.Run("Anonymous reader", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(accessFile, []byte(`{"anonymous": {"name": "anonymous", "role": "reader"}}`), 0o600))
+		assert.Equal(t, http.StatusOK, do(t, http.MethodGet, "/", "", nil))
+		assert.Equal(t, http.StatusForbidden, do(t, http.MethodPost, constant.UpdatesRoute, "", metrics("teamA_counter")))
+	})
+
+	t.Run("GRPC", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(accessFile, []byte(`{
+			"principals": [
+				{"name": "reader", "token": "reader-token", "role": "reader"},
+				{"name": "team-a", "token": "team-a-token", "role": "writer", "prefixes": ["teamA_"]}
+			]
+		}`), 0o600))
+		set := func(token, name string) codes.Code {
+			meta := map[string]string{"token": suite.Cfg().GRPCToken}
+			if token != "" {
+				meta["authorization"] = constant.BearerPrefix + token
+			}
+			ctx, conn, client, callOpt, err := testGRPCDial(suite, context.Background(), meta)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, conn.Close()) }()
+			_, err = client.SetMetrics(ctx, &pb.SetMetricsRequest{Metric: []*pb.Metric{
+				{Id: fmt.Sprintf("%s%d", name, n), Mtype: "counter", Delta: 1}}}, callOpt...)
+			return status.Code(err)
+		}
+		assert.Equal(t, codes.Unauthenticated, set("", "teamA_grpc"))
+		assert.Equal(t, codes.Unauthenticated, set("unknown", "teamA_grpc"))
+		assert.Equal(t, codes.PermissionDenied, set("reader-token", "teamA_grpc"))
+		assert.Equal(t, codes.OK, set("team-a-token", "teamA_grpc"))
+		assert.Equal(t, codes.PermissionDenied, set("team-a-token", "teamB_grpc"))
+	})
+}
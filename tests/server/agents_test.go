@@ -0,0 +1,239 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	"go-musthave-metrics/internal/server/handler/rest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func getAgents(t *testing.T, suite HandlerTestSuite) (agents map[string]domain.Agent) {
+	res, err := http.Get("http://" + suite.Cfg().Address + constant.AgentsRoute)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, res.Body.Close())
+	}()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/json; charset=utf-8", res.Header.Get("Content-Type"))
+	var list []domain.Agent
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+	agents = make(map[string]domain.Agent, len(list))
+	for _, a := range list {
+		agents[a.ID] = a
+	}
+	return
+}
+
+func testAgents(suite HandlerTestSuite) {
+	t := suite.T()
+
+	agentID := fmt.Sprintf("test-agent-%d", rand.Int())
+	headers := map[string]string{
+		constant.HeaderAgentID:       agentID,
+		constant.HeaderAgentHostname: "test-host",
+		constant.HeaderAgentVersion:  "1.0-test",
+		constant.HeaderAgentConfig:   "report_interval=10",
+		constant.HeaderXRealIP:       "10.0.0.1",
+	}
+
+	tests := []struct {
+		body          any
+		name          string
+		wantMetrics   int64
+		wantLastError bool
+	}{
+		{
+			name: "agent registered",
+			body: []map[string]any{
+				{"id": "testAgentCounter", "type": "counter", "delta": 1},
+				{"id": "testAgentGauge", "type": "gauge", "value": 1.5},
+			},
+			wantMetrics: 2,
+		},
+		{
+			name: "agent bad request, last error",
+			body: []map[string]any{
+				{"id": "testAgentCounter", "type": "unknown", "delta": 1},
+			},
+			wantMetrics:   2,
+			wantLastError: true,
+		},
+		{
+			name: "agent metrics count",
+			body: []map[string]any{
+				{"id": "testAgentCounter", "type": "counter", "delta": 1},
+			},
+			wantMetrics:   3,
+			wantLastError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := new(bytes.Buffer)
+			require.NoError(t, json.NewEncoder(b).Encode(test.body))
+			maybeCryptBody(b, suite.PublicKey())
+			req, err := http.NewRequest(http.MethodPost, "http://"+suite.Cfg().Address+constant.UpdatesRoute, b)
+			require.NoError(t, err)
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			_, err = io.Copy(io.Discard, res.Body)
+			require.NoError(t, err)
+			require.NoError(t, res.Body.Close())
+
+			agent, ok := getAgents(t, suite)[agentID]
+			require.True(t, ok, "agent is expected at registry")
+			assert.Equal(t, "test-host", agent.Hostname)
+			assert.Equal(t, "1.0-test", agent.Version)
+			assert.Equal(t, "10.0.0.1", agent.IP)
+			assert.Equal(t, test.wantMetrics, agent.MetricsCount)
+			assert.Equal(t, test.wantLastError, agent.LastError != "")
+			assert.False(t, agent.Missing)
+		})
+	}
+
+	t.Run("grpc agent registered", func(t *testing.T) {
+		ctx := context.Background()
+		grpcAgentID := agentID + "-grpc"
+		ctx, conn, client, callOpt, err := testGRPCDial(suite, ctx, map[string]string{
+			"token":                      suite.Cfg().GRPCToken,
+			constant.HeaderAgentID:       grpcAgentID,
+			constant.HeaderAgentHostname: "test-grpc-host",
+		})
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, conn.Close())
+		}()
+		_, err = client.SetMetrics(ctx, &pb.SetMetricsRequest{Metric: []*pb.Metric{
+			{Id: "testAgentCounter", Mtype: "counter", Delta: 1},
+			{Id: "testAgentGauge", Mtype: "gauge", Value: 1.5},
+		}}, callOpt...)
+		require.NoError(t, err)
+
+		out, err := client.GetAgents(ctx, &pb.GetAgentsRequest{}, callOpt...)
+		require.NoError(t, err)
+		var found *pb.Agent
+		for _, a := range out.GetAgent() {
+			if a.GetId() == grpcAgentID {
+				found = a
+			}
+		}
+		require.NotNil(t, found, "agent is expected at registry")
+		assert.Equal(t, "test-grpc-host", found.GetHostname())
+		assert.Equal(t, int64(2), found.GetMetricsCount())
+		assert.NotEmpty(t, found.GetIp())
+	})
+
+	t.Run("agents at html page", func(t *testing.T) {
+		res, err := http.Get("http://" + suite.Cfg().Address + "/")
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		assert.Contains(t, string(body), agentID)
+	})
+}
+
+func testAgentConfig(suite HandlerTestSuite) {
+	t := suite.T()
+
+	agentID := fmt.Sprintf("test-agent-%d", rand.Int())
+	cfgFile := filepath.Join(t.TempDir(), "agents.json")
+	require.NoError(t, os.WriteFile(cfgFile, []byte(`{
+		"default": {"report_interval": 10},
+		"groups": {"fast": {"report_interval": 1, "poll_interval": 1}},
+		"agents": {"`+agentID+`": {"send_size": 5}}
+	}`), 0o600))
+
+	oldPath := suite.Cfg().AgentsConfigPath
+	defer func() { suite.Cfg().AgentsConfigPath = oldPath }()
+
+	getConfig := func(t *testing.T, headers map[string]string) (code int, cfg domain.AgentConfig) {
+		req, err := http.NewRequest(http.MethodGet, "http://"+suite.Cfg().Address+constant.AgentsRoute+constant.AgentConfigRoute, nil)
+		require.NoError(t, err)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		if code = res.StatusCode; code == http.StatusOK {
+			assert.Equal(t, rest.SignData(suite.Cfg().Key, body), res.Header.Get(constant.HeaderSignKey))
+			require.NoError(t, json.Unmarshal(body, &cfg))
+		}
+		return
+	}
+
+	t.Run("not configured", func(t *testing.T) {
+		suite.Cfg().AgentsConfigPath = ""
+		code, _ := getConfig(t, map[string]string{constant.HeaderAgentID: agentID})
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	suite.Cfg().AgentsConfigPath = cfgFile
+
+	t.Run("no agent id", func(t *testing.T) {
+		code, _ := getConfig(t, nil)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+	t.Run("agent with group", func(t *testing.T) {
+		code, cfg := getConfig(t, map[string]string{
+			constant.HeaderAgentID:    agentID,
+			constant.HeaderAgentGroup: "fast",
+		})
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "fast", cfg.Group)
+		require.NotNil(t, cfg.ReportInterval)
+		assert.Equal(t, 1, *cfg.ReportInterval)
+		require.NotNil(t, cfg.SendSize)
+		assert.Equal(t, 5, *cfg.SendSize)
+	})
+	t.Run("unknown agent get default", func(t *testing.T) {
+		code, cfg := getConfig(t, map[string]string{constant.HeaderAgentID: agentID + "-other"})
+		require.Equal(t, http.StatusOK, code)
+		require.NotNil(t, cfg.ReportInterval)
+		assert.Equal(t, 10, *cfg.ReportInterval)
+		assert.Nil(t, cfg.SendSize)
+	})
+
+	t.Run("grpc agent config", func(t *testing.T) {
+		ctx, conn, client, callOpt, err := testGRPCDial(suite, context.Background(), map[string]string{
+			"token": suite.Cfg().GRPCToken,
+		})
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, conn.Close())
+		}()
+		out, err := client.GetAgentConfig(ctx, &pb.GetAgentConfigRequest{Id: agentID, Group: "fast"}, callOpt...)
+		require.NoError(t, err)
+		assert.Equal(t, rest.SignData(suite.Cfg().Key, out.GetConfig()), out.GetSign())
+		var cfg domain.AgentConfig
+		require.NoError(t, json.Unmarshal(out.GetConfig(), &cfg))
+		require.NotNil(t, cfg.PollInterval)
+		assert.Equal(t, 1, *cfg.PollInterval)
+
+		suite.Cfg().AgentsConfigPath = ""
+		_, err = client.GetAgentConfig(ctx, &pb.GetAgentConfigRequest{Id: agentID}, callOpt...)
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
@@ -179,3 +179,7 @@ func (suite *HandlerDBTestSuite) TestGRPCSetMetric() {
 func (suite *HandlerDBTestSuite) TestGRPCSetMetrics() {
 	testGRPCSetMetrics(suite)
 }
+
+func (suite *HandlerDBTestSuite) TestTenants() {
+	testTenants(suite)
+}
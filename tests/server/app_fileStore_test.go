@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rsa"
 	"fmt"
-	"go-musthave-metrics/internal/server/app"
 	"go-musthave-metrics/internal/server/config"
 	"go-musthave-metrics/internal/server/domain"
 	"go-musthave-metrics/internal/server/repository"
@@ -14,13 +13,11 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
-	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
-	"go.uber.org/zap"
 )
 
 type HandlerFileStoreTestSuite struct {
@@ -29,6 +26,7 @@ type HandlerFileStoreTestSuite struct {
 	stop context.CancelFunc
 	srv  *service.Service
 	cfg  *config.Config
+	done <-chan struct{}
 }
 
 func (suite *HandlerFileStoreTestSuite) SetupSuite() {
@@ -45,8 +43,7 @@ func (suite *HandlerFileStoreTestSuite) SetupSuite() {
 
 	testData(suite)
 
-	go app.RunApp(suite.ctx, suite.cfg, zap.NewNop(),
-		app.BuildMetadata{Version: "test", Date: time.Now().Format(time.RFC3339), Commit: "test"})
+	suite.done = runApp(suite.ctx, suite.cfg)
 
 	require.NoError(suite.T(), WaitHTTPPort(suite.ctx, suite))
 	require.NoError(suite.T(), WaitGRPCPort(suite.ctx, suite))
@@ -54,6 +51,7 @@ func (suite *HandlerFileStoreTestSuite) SetupSuite() {
 
 func (suite *HandlerFileStoreTestSuite) TearDownSuite() {
 	suite.stop()
+	<-suite.done
 	require.NoError(suite.T(), os.RemoveAll(suite.T().TempDir()))
 }
 
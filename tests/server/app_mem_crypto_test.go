@@ -9,7 +9,8 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"go-musthave-metrics/internal/server/app"
+	"go-musthave-metrics/internal/envelope"
+	pb "go-musthave-metrics/internal/grpc/proto"
 	"go-musthave-metrics/internal/server/config"
 	"go-musthave-metrics/internal/server/constant"
 	"go-musthave-metrics/internal/server/domain"
@@ -23,13 +24,14 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
-	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
-	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type HandlerMemCryptoTestSuite struct {
@@ -40,6 +42,7 @@ type HandlerMemCryptoTestSuite struct {
 	cfg        *config.Config
 	publicKey  *rsa.PublicKey
 	publicFile string
+	done       <-chan struct{}
 }
 
 func (suite *HandlerMemCryptoTestSuite) loadCerts() {
@@ -84,14 +87,14 @@ func (suite *HandlerMemCryptoTestSuite) SetupSuite() {
 
 	testData(suite)
 
-	go app.RunApp(suite.ctx, suite.cfg, zap.NewNop(),
-		app.BuildMetadata{Version: "test", Date: time.Now().Format(time.RFC3339), Commit: "test"})
+	suite.done = runApp(suite.ctx, suite.cfg)
 
 	require.NoError(suite.T(), WaitHTTPPort(suite.ctx, suite))
 	require.NoError(suite.T(), WaitGRPCPort(suite.ctx, suite))
 }
 func (suite *HandlerMemCryptoTestSuite) TearDownSuite() {
 	suite.stop()
+	<-suite.done
 	require.NoError(suite.T(), os.RemoveAll(suite.T().TempDir()))
 }
 
@@ -231,3 +234,149 @@ func (suite *HandlerMemCryptoTestSuite) TestUpdateMetricsNoCrypt() {
 		})
 	}
 }
+
+func (suite *HandlerMemCryptoTestSuite) TestUpdateMetricsEnvelope() {
+	t := suite.T()
+
+	testCounterName := fmt.Sprintf("testCounter%d", rand.Int())
+	metrics := make([]map[string]interface{}, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		metrics = append(metrics, map[string]interface{}{
+			"id":    fmt.Sprintf("%s_%d", testCounterName, i),
+			"type":  "counter",
+			"delta": i,
+		})
+	}
+	data, err := json.Marshal(metrics)
+	require.NoError(t, err)
+	require.Greater(t, len(data), suite.publicKey.Size())
+
+	tests := []struct {
+		modify func(scheme string, body []byte) (string, []byte)
+		name   string
+		code   int
+	}{
+		{
+			name: "Large batch",
+			code: http.StatusOK,
+		},
+		{
+			name: "Tampered body",
+			modify: func(scheme string, body []byte) (string, []byte) {
+				body[len(body)-1] ^= 0xff
+				return scheme, body
+			},
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "Unknown scheme",
+			modify: func(_ string, body []byte) (string, []byte) {
+				return "v0;unknown", body
+			},
+			code: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme, body, err := envelope.Seal(suite.publicKey, data)
+			require.NoError(t, err)
+			if test.modify != nil {
+				scheme, body = test.modify(scheme, body)
+			}
+
+			req, err := http.NewRequest(http.MethodPost, "http://"+suite.Cfg().Address+constant.UpdatesRoute, bytes.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set(envelope.Header, scheme)
+			req.Header.Set("Content-Type", "application/json")
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resBody, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.NoError(t, res.Body.Close())
+			require.Equal(t, test.code, res.StatusCode)
+
+			if test.code == http.StatusOK {
+				var got []domain.Metric
+				require.NoError(t, json.Unmarshal(resBody, &got))
+				assert.Len(t, got, len(metrics))
+			}
+		})
+	}
+}
+
+func (suite *HandlerMemCryptoTestSuite) TestGRPCSetMetricsEnvelope() {
+	t := suite.T()
+
+	testCounterName := fmt.Sprintf("testCounter%d", rand.Int())
+	plain := &pb.SetMetricsRequest{
+		Metric: []*pb.Metric{{Id: testCounterName, Mtype: "counter", Delta: 5}},
+	}
+	data, err := proto.Marshal(plain)
+	require.NoError(t, err)
+	scheme, encrypted, err := envelope.Seal(suite.publicKey, data)
+	require.NoError(t, err)
+
+	tests := []struct {
+		in       *pb.SetMetricsRequest
+		name     string
+		wantCode codes.Code
+	}{
+		{
+			name:     "Encrypted",
+			in:       &pb.SetMetricsRequest{Encrypted: encrypted, Encryption: scheme},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "Not encrypted",
+			in:       plain,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "Unknown scheme",
+			in:       &pb.SetMetricsRequest{Encrypted: encrypted, Encryption: "v0;unknown"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, conn, client, callOpt, err := testGRPCDial(suite, suite.ctx, nil)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, conn.Close())
+			}()
+			out, err := client.SetMetrics(ctx, test.in, callOpt...)
+			require.Equal(t, test.wantCode, status.Code(err))
+			if test.wantCode == codes.OK {
+				require.Len(t, out.GetMetric(), 1)
+				assert.Equal(t, int64(5), out.GetMetric()[0].GetDelta())
+			}
+		})
+	}
+
+	t.Run("Audit of encrypted request", func(t *testing.T) {
+		defer func(old string) { suite.cfg.AuditPath = old }(suite.cfg.AuditPath)
+		defer func(old int) { suite.cfg.MaxNameLength = old }(suite.cfg.MaxNameLength)
+		suite.cfg.AuditPath = filepath.Join(t.TempDir(), "audit.log")
+		suite.cfg.MaxNameLength = 10
+
+		data, err := proto.Marshal(&pb.SetMetricsRequest{Metric: []*pb.Metric{
+			{Id: "testAuditGaugeTooLong", Mtype: "gauge", Value: 1},
+			{Id: "testAuditCounterTooLong", Mtype: "counter", Delta: 1},
+		}})
+		require.NoError(t, err)
+		scheme, encrypted, err := envelope.Seal(suite.publicKey, data)
+		require.NoError(t, err)
+		agent := fmt.Sprintf("audit-agent-%d", rand.Int())
+		ctx, conn, client, callOpt, err := testGRPCDial(suite, suite.ctx, map[string]string{constant.HeaderAgentID: agent})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, conn.Close()) }()
+		_, err = client.SetMetrics(ctx, &pb.SetMetricsRequest{Encrypted: encrypted, Encryption: scheme}, callOpt...)
+		require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+		list, err := suite.srv.GetAudit(suite.ctx, domain.AuditFilter{Agent: agent})
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		assert.Equal(t, 2, list[0].Rejected, "metrics of decrypted request are counted")
+	})
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rsa"
 	"fmt"
-	"go-musthave-metrics/internal/server/app"
 	"go-musthave-metrics/internal/server/config"
 	"go-musthave-metrics/internal/server/repository"
 	"go-musthave-metrics/internal/server/service"
@@ -13,12 +12,10 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
-	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
-	"go.uber.org/zap"
 )
 
 type HandlerMemTestSuite struct {
@@ -27,6 +24,7 @@ type HandlerMemTestSuite struct {
 	stop context.CancelFunc
 	srv  *service.Service
 	cfg  *config.Config
+	done <-chan struct{}
 }
 
 func (suite *HandlerMemTestSuite) Srv() *service.Service {
@@ -53,8 +51,7 @@ func (suite *HandlerMemTestSuite) SetupSuite() {
 
 	testData(suite)
 
-	go app.RunApp(suite.ctx, suite.cfg, zap.NewNop(),
-		app.BuildMetadata{Version: "test", Date: time.Now().Format(time.RFC3339), Commit: "test"})
+	suite.done = runApp(suite.ctx, suite.cfg)
 
 	require.NoError(suite.T(), WaitHTTPPort(suite.ctx, suite))
 	require.NoError(suite.T(), WaitGRPCPort(suite.ctx, suite))
@@ -62,6 +59,7 @@ func (suite *HandlerMemTestSuite) SetupSuite() {
 
 func (suite *HandlerMemTestSuite) TearDownSuite() {
 	suite.stop()
+	<-suite.done
 	require.NoError(suite.T(), os.RemoveAll(suite.T().TempDir()))
 }
 
@@ -97,6 +95,14 @@ func (suite *HandlerMemTestSuite) TestPing() {
 	testPing(suite)
 }
 
+func (suite *HandlerMemTestSuite) TestAgents() {
+	testAgents(suite)
+}
+
+func (suite *HandlerMemTestSuite) TestAgentConfig() {
+	testAgentConfig(suite)
+}
+
 func (suite *HandlerMemTestSuite) TestGRPCGetMetric() {
 	testGRPCGetMetric(suite)
 }
@@ -116,3 +122,27 @@ func (suite *HandlerMemTestSuite) TestGRPCSetMetrics() {
 func (suite *HandlerMemTestSuite) TestGRPCProto() {
 	testGRPCProto(suite)
 }
+
+func (suite *HandlerMemTestSuite) TestCredentials() {
+	testCredentials(suite)
+}
+
+func (suite *HandlerMemTestSuite) TestAccess() {
+	testAccess(suite)
+}
+
+func (suite *HandlerMemTestSuite) TestRateLimit() {
+	testRateLimit(suite)
+}
+
+func (suite *HandlerMemTestSuite) TestQuotas() {
+	testQuotas(suite)
+}
+
+func (suite *HandlerMemTestSuite) TestTenants() {
+	testTenants(suite)
+}
+
+func (suite *HandlerMemTestSuite) TestAudit() {
+	testAudit(suite)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"go-musthave-metrics/internal/server/app"
+	"go-musthave-metrics/internal/server/config"
+	helper "go-musthave-metrics/tests"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestReload(t *testing.T) {
+	osArgs := os.Args
+	os.Args = osArgs[:1]
+	defer func() { os.Args = osArgs }()
+
+	cnfFile := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.Setenv("CONFIG", cnfFile))
+	defer func() { require.NoError(t, os.Unsetenv("CONFIG")) }()
+
+	cfg := config.NewConfig()
+	cfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+21000))
+	cfg.GRPCAddress = ""
+	cfg.FileStoragePath = ""
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core, logs := observer.New(zap.InfoLevel)
+	go app.RunApp(ctx, cfg, zap.New(core), app.BuildMetadata{})
+
+	require.Eventually(t, func() bool {
+		conn, _ := net.DialTimeout("tcp", cfg.Address, 50*time.Millisecond)
+		if conn != nil {
+			_ = conn.Close()
+		}
+		return conn != nil
+	}, 3*time.Second, 100*time.Millisecond)
+
+	getCode := func() int {
+		res, err := http.Get("http://" + cfg.Address + "/")
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res.StatusCode
+	}
+	require.Equal(t, http.StatusOK, getCode())
+
+	hup := func(message string) {
+		n := logs.FilterMessage(message).Len()
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+		require.Eventually(t, func() bool {
+			return logs.FilterMessage(message).Len() > n
+		}, 3*time.Second, 50*time.Millisecond)
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		require.NoError(t, helper.CreateConfigFile(cnfFile, map[string]any{
+			"address":             "localhost:1",
+			"key":                 "reloaded-key",
+			"trusted_subnet":      "10.0.0.0/8",
+			"grpc_token":          "reloaded-token",
+			"file_store_interval": 5,
+		}))
+		hup("Config reloaded")
+
+		assert.Equal(t, "reloaded-key", cfg.GetKey())
+		assert.Equal(t, "10.0.0.0/8", cfg.GetTrustedSubnet())
+		assert.Equal(t, "reloaded-token", cfg.GetGRPCToken())
+		assert.Equal(t, 5, cfg.GetFileStoreInterval())
+		assert.NotEqual(t, "localhost:1", cfg.Address, "address is not changed without restart")
+		assert.Equal(t, 1, logs.FilterMessage("Config values are changed, but not applied without restart").Len())
+		assert.Equal(t, http.StatusForbidden, getCode(), "trusted subnet is applied")
+	})
+
+	t.Run("bad config is not applied", func(t *testing.T) {
+		require.NoError(t, helper.CreateConfigFile(cnfFile, `{"key": `))
+		hup("Reload config, current config is kept")
+		assert.Equal(t, "reloaded-key", cfg.GetKey())
+	})
+
+	t.Run("server is not stopped", func(t *testing.T) {
+		require.NoError(t, helper.CreateConfigFile(cnfFile, map[string]any{}))
+		hup("Config reloaded")
+		assert.Equal(t, "", cfg.GetKey())
+		assert.Equal(t, http.StatusOK, getCode())
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"errors"
+	"go-musthave-metrics/internal/server/app"
 	"go-musthave-metrics/internal/server/config"
 	"go-musthave-metrics/internal/server/domain"
 	errM "go-musthave-metrics/internal/server/migrate"
@@ -18,6 +19,7 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 type HandlerTestSuite interface {
@@ -39,6 +41,18 @@ func testData(suite HandlerTestSuite) {
 	require.NoError(suite.T(), err)
 }
 
+// runApp run app in background, returned channel is closed after app is stopped.
+// Storage file is saved on shutdown, temp dir of suite should be removed after it
+func runApp(ctx context.Context, cfg *config.Config) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.RunApp(ctx, cfg, zap.NewNop(),
+			app.BuildMetadata{Version: "test", Date: time.Now().Format(time.RFC3339), Commit: "test"})
+	}()
+	return done
+}
+
 func testMigrate(suite HandlerTestSuite, db *sqlx.DB) {
 	t := suite.T()
 	t.Run("Migrate", func(t *testing.T) {
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/server/app"
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	helper "go-musthave-metrics/tests"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+func testTLSConfig(t *testing.T, f helper.TLSFiles, withCert bool) *tls.Config {
+	b, err := os.ReadFile(f.CA)
+	require.NoError(t, err)
+	c := &tls.Config{RootCAs: x509.NewCertPool(), MinVersion: tls.VersionTLS12}
+	require.True(t, c.RootCAs.AppendCertsFromPEM(b))
+	if withCert {
+		cert, err := tls.LoadX509KeyPair(f.ClientCert, f.ClientKey)
+		require.NoError(t, err)
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c
+}
+
+func TestTLS(t *testing.T) {
+	osArgs := os.Args
+	os.Args = osArgs[:1]
+	defer func() { os.Args = osArgs }()
+
+	dir := t.TempDir()
+	agentName := fmt.Sprintf("tls-agent-%d", rand.Int())
+	f := helper.CreateTLSCertificates(dir, agentName)
+
+	cfg := config.NewConfig()
+	cfg.Address = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+21200))
+	cfg.GRPCAddress = net.JoinHostPort("localhost", fmt.Sprintf("%d", rand.Intn(200)+31200))
+	cfg.FileStoragePath = ""
+	cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA = f.ServerCert, f.ServerKey, f.CA
+	for k, v := range map[string]string{"TLS_CERT": f.ServerCert, "TLS_KEY": f.ServerKey, "TLS_CLIENT_CA": f.CA} {
+		require.NoError(t, os.Setenv(k, v))
+		defer func(k string) { require.NoError(t, os.Unsetenv(k)) }(k)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core, logs := observer.New(zap.InfoLevel)
+	go app.RunApp(ctx, cfg, zap.New(core), app.BuildMetadata{})
+
+	for _, addr := range []string{cfg.Address, cfg.GRPCAddress} {
+		require.Eventually(t, func() bool {
+			conn, _ := net.DialTimeout("tcp", addr, 50*time.Millisecond)
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return conn != nil
+		}, 3*time.Second, 100*time.Millisecond)
+	}
+
+	client := func(tc *tls.Config) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: tc}}
+	}
+	post := func(tc *tls.Config) (*http.Response, error) {
+		body, err := json.Marshal([]domain.Metric{{ID: "tlsCounter", MType: "counter", Delta: &[]domain.Counter{1}[0]}})
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, "https://"+cfg.Address+constant.UpdatesRoute, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set(constant.HeaderAgentID, "header-agent-id")
+		return client(tc).Do(req)
+	}
+	getAgents := func(t *testing.T) map[string]domain.Agent {
+		res, err := client(testTLSConfig(t, f, true)).Get("https://" + cfg.Address + constant.AgentsRoute)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, res.Body.Close()) }()
+		var list []domain.Agent
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+		agents := make(map[string]domain.Agent, len(list))
+		for _, a := range list {
+			agents[a.ID] = a
+		}
+		return agents
+	}
+
+	t.Run("plain http is not served", func(t *testing.T) {
+		res, err := http.Get("http://" + cfg.Address + "/")
+		if err == nil {
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+			require.NoError(t, res.Body.Close())
+		}
+	})
+	t.Run("client certificate is required", func(t *testing.T) {
+		_, err := post(testTLSConfig(t, f, false))
+		assert.Error(t, err)
+	})
+	t.Run("agent identity from certificate", func(t *testing.T) {
+		res, err := post(testTLSConfig(t, f, true))
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		agents := getAgents(t)
+		assert.Contains(t, agents, agentName)
+		assert.NotContains(t, agents, "header-agent-id")
+	})
+	t.Run("grpc agent identity from certificate", func(t *testing.T) {
+		conn, err := grpc.DialContext(ctx, cfg.GRPCAddress,
+			grpc.WithTransportCredentials(credentials.NewTLS(testTLSConfig(t, f, true))))
+		require.NoError(t, err)
+		defer func() { require.NoError(t, conn.Close()) }()
+		gCtx := metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{constant.HeaderAgentID: "header-agent-id"}))
+		_, err = pb.NewMetricsClient(conn).SetMetrics(gCtx, &pb.SetMetricsRequest{Metric: []*pb.Metric{
+			{Id: "tlsCounter", Mtype: "counter", Delta: 1},
+			{Id: "tlsGauge", Mtype: "gauge", Value: 1},
+		}})
+		require.NoError(t, err)
+
+		agents := getAgents(t)
+		require.Contains(t, agents, agentName)
+		assert.Equal(t, int64(3), agents[agentName].MetricsCount)
+		assert.NotContains(t, agents, "header-agent-id")
+	})
+	t.Run("grpc client certificate is required", func(t *testing.T) {
+		conn, err := grpc.DialContext(ctx, cfg.GRPCAddress,
+			grpc.WithTransportCredentials(credentials.NewTLS(testTLSConfig(t, f, false))))
+		require.NoError(t, err)
+		defer func() { require.NoError(t, conn.Close()) }()
+		_, err = pb.NewMetricsClient(conn).GetAgents(ctx, &pb.GetAgentsRequest{})
+		assert.Error(t, err)
+	})
+	t.Run("certificates reload on SIGHUP", func(t *testing.T) {
+		oldTLS := testTLSConfig(t, f, true)
+		f = helper.CreateTLSCertificates(dir, agentName)
+		n := logs.FilterMessage("Config reloaded").Len()
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+		require.Eventually(t, func() bool {
+			return logs.FilterMessage("Config reloaded").Len() > n
+		}, 3*time.Second, 50*time.Millisecond)
+
+		_, err := post(oldTLS)
+		assert.Error(t, err, "old CA is not trusted")
+		res, err := post(testTLSConfig(t, f, true))
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+}
@@ -0,0 +1,136 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAudit(suite HandlerTestSuite) {
+	t := suite.T()
+
+	const adminToken = "#AdminSomeTokenString#"
+	suite.Cfg().AdminToken = adminToken
+	defer func() { suite.Cfg().AdminToken = "" }()
+	defer func(old string) { suite.Cfg().AuditPath = old }(suite.Cfg().AuditPath)
+	suite.Cfg().AuditPath = filepath.Join(t.TempDir(), "audit.log")
+
+	agent := fmt.Sprintf("audit-agent-%d", rand.Int())
+	from := time.Now().Add(-time.Second)
+
+	send := func(t *testing.T, path, body string) int {
+		req, err := http.NewRequest(http.MethodPost, "http://"+suite.Cfg().Address+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(constant.HeaderAgentID, agent)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res.StatusCode
+	}
+	query := func(t *testing.T, token string, params url.Values) (int, []domain.AuditRecord) {
+		req, err := http.NewRequest(http.MethodGet, "http://"+suite.Cfg().Address+constant.AuditRoute+"?"+params.Encode(), nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set(constant.HeaderAuthorization, constant.BearerPrefix+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		var list []domain.AuditRecord
+		if res.StatusCode == http.StatusOK {
+			require.NoError(t, json.Unmarshal(b, &list))
+		}
+		return res.StatusCode, list
+	}
+
+	t.Run("Write operations", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, send(t, "/update/gauge/testAuditGauge/1", ""))
+		require.Equal(t, http.StatusOK, send(t, constant.UpdatesRoute, `[
+			{"id": "testAuditGauge", "type": "gauge", "value": 1},
+			{"id": "testAuditCounter", "type": "counter", "delta": 1}]`))
+
+		defer func(old int) { suite.Cfg().MaxNameLength = old }(suite.Cfg().MaxNameLength)
+		suite.Cfg().MaxNameLength = 10
+		require.Equal(t, http.StatusOK, send(t, constant.UpdatesRoute, `[
+			{"id": "testAuditGaugeTooLong", "type": "gauge", "value": 1},
+			{"id": "short", "type": "gauge", "value": 1}]`))
+
+		ctx, conn, client, callOpt, err := testGRPCDial(suite, context.Background(),
+			map[string]string{"token": suite.Cfg().GRPCToken, constant.HeaderAgentID: agent})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, conn.Close()) }()
+		_, err = client.SetMetric(ctx, &pb.SetMetricRequest{Metric: &pb.Metric{Id: "short", Mtype: "gauge", Value: 2}}, callOpt...)
+		require.NoError(t, err)
+	})
+
+	t.Run("Admin operations", func(t *testing.T) {
+		code, _ := adminRequest(t, suite, http.MethodGet, "", "wrong token", nil)
+		require.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		code, _ := query(t, "", nil)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = query(t, adminToken, url.Values{"from": {"yesterday"}})
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		code, list := query(t, adminToken, url.Values{"agent": {agent}, "from": {from.Format(time.RFC3339)}})
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, list, 4)
+		assert.Equal(t, "POST /update/gauge/testAuditGauge/1", list[0].Action)
+		assert.Equal(t, 1, list[0].Metrics)
+		assert.Equal(t, "200", list[0].Status)
+		assert.Equal(t, 2, list[1].Metrics)
+		assert.Equal(t, 1, list[2].Metrics)
+		assert.Equal(t, 1, list[2].Rejected)
+		assert.Contains(t, list[2].Reason, "quota exceeded: metric name is too long")
+		assert.Equal(t, "/service.Metrics/SetMetric", list[3].Action)
+		assert.Equal(t, "OK", list[3].Status)
+		assert.Equal(t, 1, list[3].Metrics)
+
+		code, list = query(t, adminToken, url.Values{"agent": {agent}, "limit": {"1"}})
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, list, 1)
+		assert.Equal(t, "/service.Metrics/SetMetric", list[0].Action, "last records are kept by limit")
+
+		code, list = query(t, adminToken, url.Values{"to": {from.Format(time.RFC3339)}})
+		require.Equal(t, http.StatusOK, code)
+		assert.Empty(t, list)
+
+		code, list = query(t, adminToken, url.Values{"from": {from.Format(time.RFC3339)}})
+		require.Equal(t, http.StatusOK, code)
+		var admin bool
+		for _, rec := range list {
+			if rec.Action == "GET "+constant.AdminKeysRoute+"/" || rec.Action == "GET "+constant.AdminKeysRoute {
+				admin = true
+				assert.Equal(t, "401", rec.Status)
+				assert.Equal(t, http.StatusText(http.StatusUnauthorized), rec.Reason)
+			}
+		}
+		assert.True(t, admin, "admin action is audited")
+	})
+
+	t.Run("Not configured", func(t *testing.T) {
+		suite.Cfg().AuditPath = ""
+		code, _ := query(t, adminToken, nil)
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
@@ -0,0 +1,86 @@
+package server_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	"go-musthave-metrics/internal/server/handler/rest"
+	"go-musthave-metrics/internal/server/repository"
+	"go-musthave-metrics/internal/server/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// lostStore store with lost database connection
+type lostStore struct {
+	*repository.MemStorageRepo
+}
+
+func (s *lostStore) SetGauge(context.Context, string, domain.Gauge) error {
+	return driver.ErrBadConn
+}
+
+func (s *lostStore) GetGauge(context.Context, string) (domain.Gauge, error) {
+	return 0, driver.ErrBadConn
+}
+
+func (s *lostStore) Ping(context.Context) error {
+	return driver.ErrBadConn
+}
+
+func TestBreaker(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.BreakerFailures = 2
+	cfg.BreakerOpen = 30
+	data := repository.NewBreakerRepository(&lostStore{MemStorageRepo: repository.NewMemRepository()}, &cfg.StorageConfig)
+	srv := service.NewService(repository.NewStorage(&cfg.StorageConfig, data), &cfg.StorageConfig)
+	ts := httptest.NewServer(rest.NewHandler(srv, cfg, zap.NewNop()).Handler())
+	defer ts.Close()
+
+	request := func(method, path string) (*http.Response, string) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		require.NoError(t, err)
+		res, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, res.Body.Close()) }()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(body)
+	}
+
+	for i := 0; i < cfg.BreakerFailures; i++ {
+		res, _ := request(http.MethodPost, constant.UpdateRoute+"/gauge/testBreakerGauge/1")
+		assert.Equal(t, http.StatusInternalServerError, res.StatusCode, "failure before breaker is open")
+	}
+
+	t.Run("Fail fast", func(t *testing.T) {
+		res, _ := request(http.MethodPost, constant.UpdateRoute+"/gauge/testBreakerGauge/1")
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		assert.Equal(t, "30", res.Header.Get(constant.HeaderRetryAfter))
+
+		res, _ = request(http.MethodGet, constant.ValueRoute+"/gauge/testBreakerGauge")
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		assert.NotEmpty(t, res.Header.Get(constant.HeaderRetryAfter))
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		res, body := request(http.MethodGet, "/ping")
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		assert.True(t, strings.HasPrefix(body, "Status: circuit open"))
+		assert.NotEmpty(t, res.Header.Get(constant.HeaderRetryAfter))
+	})
+
+	stats := data.Stats()
+	assert.Equal(t, repository.BreakerOpen, stats.State)
+	assert.Equal(t, int64(3), stats.Rejected)
+}
@@ -0,0 +1,154 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	"go-musthave-metrics/internal/server/handler/rest"
+	"go-musthave-metrics/internal/sign"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminRequest(t *testing.T, suite HandlerTestSuite, method, path, token string, body any) (code int, answer []byte) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, "http://"+suite.Cfg().Address+constant.AdminKeysRoute+path, reqBody)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	answer, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	return res.StatusCode, answer
+}
+
+func testCredentials(suite HandlerTestSuite) {
+	t := suite.T()
+
+	const adminToken = "#AdminSomeTokenString#"
+	suite.Cfg().AdminToken = adminToken
+	defer func() { suite.Cfg().AdminToken = "" }()
+
+	agentID := fmt.Sprintf("test-agent-%d", rand.Int())
+
+	newKey := func(t *testing.T, path string, body any) (c domain.Credential) {
+		code, answer := adminRequest(t, suite, http.MethodPost, path, adminToken, body)
+		require.Equal(t, http.StatusCreated, code, string(answer))
+		require.NoError(t, json.Unmarshal(answer, &c))
+		require.NotEmpty(t, c.KeyID)
+		require.NotEmpty(t, c.Secret)
+		assert.Equal(t, agentID, c.AgentID)
+		return
+	}
+
+	// send signed metrics with key of agent, response sign is checked by key
+	send := func(t *testing.T, agent, keyID, secret string) int {
+		body := []byte(fmt.Sprintf(`[{"id": "testCredentialCounter%d", "type": "counter", "delta": 1}]`, rand.Int()))
+		req, err := http.NewRequest(http.MethodPost, "http://"+suite.Cfg().Address+constant.UpdatesRoute, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(constant.HeaderAgentID, agent)
+		req.Header.Set(constant.HeaderKeyID, keyID)
+		if secret != "" {
+			for k, v := range sign.New(http.MethodPost, constant.UpdatesRoute, body).Headers(secret, constant.HeaderSignKey) {
+				req.Header.Set(k, v)
+			}
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		answer, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		if res.StatusCode == http.StatusOK {
+			assert.Equal(t, rest.SignData(secret, answer), res.Header.Get(constant.HeaderSignKey))
+		}
+		return res.StatusCode
+	}
+
+	t.Run("Admin api auth", func(t *testing.T) {
+		code, _ := adminRequest(t, suite, http.MethodGet, "/", "", nil)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = adminRequest(t, suite, http.MethodGet, "/", "wrong token", nil)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = adminRequest(t, suite, http.MethodPost, "/", adminToken, map[string]any{})
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	k1 := newKey(t, "/", map[string]any{"agent_id": agentID})
+
+	t.Run("Signed by agent key", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(t, agentID, k1.KeyID, k1.Secret))
+	})
+	t.Run("Key of other agent", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send(t, "other-agent", k1.KeyID, k1.Secret))
+	})
+	t.Run("Unknown key", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send(t, agentID, "unknown", k1.Secret))
+	})
+	t.Run("Key id without sign", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, send(t, agentID, k1.KeyID, ""))
+	})
+	t.Run("Signed by other secret", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, send(t, agentID, k1.KeyID, "wrong secret"))
+	})
+
+	k2 := newKey(t, "/rotate", map[string]any{"agent_id": agentID})
+	t.Run("Rotated, both keys are active", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(t, agentID, k1.KeyID, k1.Secret))
+		assert.Equal(t, http.StatusOK, send(t, agentID, k2.KeyID, k2.Secret))
+	})
+
+	k3 := newKey(t, "/rotate", map[string]any{"agent_id": agentID, "overlap": 0})
+	t.Run("Rotated without overlap, old keys are expired", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send(t, agentID, k1.KeyID, k1.Secret))
+		assert.Equal(t, http.StatusUnauthorized, send(t, agentID, k2.KeyID, k2.Secret))
+		assert.Equal(t, http.StatusOK, send(t, agentID, k3.KeyID, k3.Secret))
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		code, answer := adminRequest(t, suite, http.MethodPost, "/"+k3.KeyID+"/revoke", adminToken, nil)
+		require.Equal(t, http.StatusOK, code)
+		var c domain.Credential
+		require.NoError(t, json.Unmarshal(answer, &c))
+		assert.NotNil(t, c.RevokedAt)
+		assert.Empty(t, c.Secret)
+		assert.Equal(t, http.StatusUnauthorized, send(t, agentID, k3.KeyID, k3.Secret))
+
+		code, _ = adminRequest(t, suite, http.MethodPost, "/unknown/revoke", adminToken, nil)
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("List keys of agent without secrets", func(t *testing.T) {
+		code, answer := adminRequest(t, suite, http.MethodGet, "/?agent_id="+agentID, adminToken, nil)
+		require.Equal(t, http.StatusOK, code)
+		var list []domain.Credential
+		require.NoError(t, json.Unmarshal(answer, &list))
+		require.Len(t, list, 3)
+		for i, k := range []domain.Credential{k1, k2, k3} {
+			assert.Equal(t, k.KeyID, list[i].KeyID)
+			assert.Empty(t, list[i].Secret)
+		}
+	})
+
+	t.Run("Admin api disabled without token", func(t *testing.T) {
+		suite.Cfg().AdminToken = ""
+		code, _ := adminRequest(t, suite, http.MethodGet, "/", adminToken, nil)
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
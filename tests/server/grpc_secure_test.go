@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	grpcHandler "go-musthave-metrics/internal/server/handler/grpc"
+	"go-musthave-metrics/internal/server/helper"
+	"go-musthave-metrics/internal/server/repository"
+	"go-musthave-metrics/internal/server/service"
+	"go-musthave-metrics/internal/sign"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	setMetricsMethod = "/service.Metrics/SetMetrics"
+	// trustedIP ip at trusted subnet of loopback peer address
+	trustedIP = "127.0.0.10"
+)
+
+type GRPCSecureTestSuite struct {
+	suite.Suite
+	ctx    context.Context
+	stop   context.CancelFunc
+	cfg    *config.Config
+	srv    *service.Service
+	client pb.MetricsClient
+	conn   *grpc.ClientConn
+}
+
+func (suite *GRPCSecureTestSuite) SetupSuite() {
+	suite.cfg = config.NewConfig()
+	suite.cfg.Key = "secretKey"
+	suite.cfg.TrustedSubnet = "127.0.0.0/8"
+	suite.ctx, suite.stop = context.WithCancel(context.Background())
+
+	repo := repository.NewRepository(&suite.cfg.StorageConfig, nil)
+	suite.srv = service.NewService(repo, &suite.cfg.StorageConfig)
+	s := grpcHandler.NewServer(suite.srv, suite.cfg, zap.NewNop()).Handler()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	go func() {
+		<-suite.ctx.Done()
+		s.Stop()
+	}()
+
+	suite.conn, err = grpc.DialContext(suite.ctx, lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(suite.T(), err)
+	suite.client = pb.NewMetricsClient(suite.conn)
+}
+
+func (suite *GRPCSecureTestSuite) TearDownSuite() {
+	require.NoError(suite.T(), suite.conn.Close())
+	suite.stop()
+}
+
+func TestGRPCSecure(t *testing.T) {
+	suite.Run(t, new(GRPCSecureTestSuite))
+}
+
+// signedContext outgoing context with x-real-ip and sign metadata of request, empty ip or key is not sent
+func signedContext(ctx context.Context, ip, key string, p sign.Params) context.Context {
+	var kv []string
+	if ip != "" {
+		kv = append(kv, constant.HeaderXRealIP, ip)
+	}
+	if key != "" {
+		for k, v := range p.Headers(key, constant.HeaderSignKey) {
+			kv = append(kv, strings.ToLower(k), v)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+func (suite *GRPCSecureTestSuite) TestSetMetrics() {
+	t := suite.T()
+
+	newRequest := func() (*pb.SetMetricsRequest, []byte) {
+		in := &pb.SetMetricsRequest{Metric: []*pb.Metric{{
+			Id: fmt.Sprintf("testCounter%d", rand.Int()), Mtype: "counter", Delta: 1}}}
+		data, err := (proto.MarshalOptions{Deterministic: true}).Marshal(in)
+		require.NoError(t, err)
+		return in, data
+	}
+	replayIn, replayData := newRequest()
+	replayParams := sign.New(sign.MethodGRPC, setMetricsMethod, replayData)
+
+	tests := []struct {
+		prepare  func() (*pb.SetMetricsRequest, context.Context)
+		name     string
+		wantCode codes.Code
+	}{
+		{
+			name: "Trusted ip, signed. Ok",
+			prepare: func() (*pb.SetMetricsRequest, context.Context) {
+				in, data := newRequest()
+				return in, signedContext(suite.ctx, trustedIP, suite.cfg.Key, sign.New(sign.MethodGRPC, setMetricsMethod, data))
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "Trusted ip, not signed",
+			prepare: func() (*pb.SetMetricsRequest, context.Context) {
+				in, _ := newRequest()
+				return in, signedContext(suite.ctx, trustedIP, "", sign.Params{})
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "Not trusted ip",
+			prepare: func() (*pb.SetMetricsRequest, context.Context) {
+				in, data := newRequest()
+				return in, signedContext(suite.ctx, "223.17.11.10", suite.cfg.Key, sign.New(sign.MethodGRPC, setMetricsMethod, data))
+			},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "Without x-real-ip, peer address is trusted. Ok",
+			prepare: func() (*pb.SetMetricsRequest, context.Context) {
+				in, data := newRequest()
+				return in, signedContext(suite.ctx, "", suite.cfg.Key, sign.New(sign.MethodGRPC, setMetricsMethod, data))
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "Wrong key",
+			prepare: func() (*pb.SetMetricsRequest, context.Context) {
+				in, data := newRequest()
+				return in, signedContext(suite.ctx, trustedIP, "wrong key", sign.New(sign.MethodGRPC, setMetricsMethod, data))
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "Signed other request",
+			prepare: func() (*pb.SetMetricsRequest, context.Context) {
+				in, _ := newRequest()
+				_, data := newRequest()
+				return in, signedContext(suite.ctx, trustedIP, suite.cfg.Key, sign.New(sign.MethodGRPC, setMetricsMethod, data))
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "Replay, first request. Ok",
+			prepare: func() (*pb.SetMetricsRequest, context.Context) {
+				return replayIn, signedContext(suite.ctx, trustedIP, suite.cfg.Key, replayParams)
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "Replay, same nonce",
+			prepare: func() (*pb.SetMetricsRequest, context.Context) {
+				return replayIn, signedContext(suite.ctx, trustedIP, suite.cfg.Key, replayParams)
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in, ctx := test.prepare()
+			var header metadata.MD
+			out, err := suite.client.SetMetrics(ctx, in, grpc.Header(&header))
+			require.Equal(t, test.wantCode, status.Code(err), err)
+			if test.wantCode != codes.OK {
+				return
+			}
+			data, err := (proto.MarshalOptions{Deterministic: true}).Marshal(out)
+			require.NoError(t, err)
+			assert.Equal(t, []string{helper.SignData(suite.cfg.Key, data)}, header.Get(constant.HeaderSignKey))
+		})
+	}
+}
+
+func (suite *GRPCSecureTestSuite) TestSetMetricsKeyID() {
+	t := suite.T()
+
+	const agentID = "test-agent-key"
+	cred, err := suite.srv.CreateCredential(suite.ctx, agentID, time.Time{}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		agentID  string
+		keyID    string
+		key      string
+		wantCode codes.Code
+	}{
+		{
+			name:     "Signed by agent key. Ok",
+			agentID:  agentID,
+			keyID:    cred.KeyID,
+			key:      cred.Secret,
+			wantCode: codes.OK,
+		},
+		{
+			name:     "Signed by config key",
+			agentID:  agentID,
+			keyID:    cred.KeyID,
+			key:      suite.cfg.Key,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "Key of other agent",
+			agentID:  "other-agent",
+			keyID:    cred.KeyID,
+			key:      cred.Secret,
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "Unknown key",
+			agentID:  agentID,
+			keyID:    "unknown",
+			key:      cred.Secret,
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "Not signed",
+			agentID:  agentID,
+			keyID:    cred.KeyID,
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := &pb.SetMetricsRequest{Metric: []*pb.Metric{{
+				Id: fmt.Sprintf("testCounter%d", rand.Int()), Mtype: "counter", Delta: 1}}}
+			data, err := (proto.MarshalOptions{Deterministic: true}).Marshal(in)
+			require.NoError(t, err)
+			ctx := signedContext(suite.ctx, trustedIP, test.key, sign.New(sign.MethodGRPC, setMetricsMethod, data))
+			ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(constant.HeaderAgentID), test.agentID,
+				strings.ToLower(constant.HeaderKeyID), test.keyID)
+
+			var header metadata.MD
+			out, err := suite.client.SetMetrics(ctx, in, grpc.Header(&header))
+			require.Equal(t, test.wantCode, status.Code(err), err)
+			if test.wantCode != codes.OK {
+				return
+			}
+			data, err = (proto.MarshalOptions{Deterministic: true}).Marshal(out)
+			require.NoError(t, err)
+			assert.Equal(t, []string{helper.SignData(cred.Secret, data)}, header.Get(constant.HeaderSignKey))
+		})
+	}
+
+	t.Run("Revoked key", func(t *testing.T) {
+		_, err := suite.srv.RevokeCredential(suite.ctx, cred.KeyID)
+		require.NoError(t, err)
+		in := &pb.SetMetricsRequest{Metric: []*pb.Metric{{Id: "testCounterRevoked", Mtype: "counter", Delta: 1}}}
+		data, err := (proto.MarshalOptions{Deterministic: true}).Marshal(in)
+		require.NoError(t, err)
+		ctx := signedContext(suite.ctx, trustedIP, cred.Secret, sign.New(sign.MethodGRPC, setMetricsMethod, data))
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(constant.HeaderAgentID), agentID,
+			strings.ToLower(constant.HeaderKeyID), cred.KeyID)
+		_, err = suite.client.SetMetrics(ctx, in)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), err)
+	})
+}
+
+// TestGRPCTrustedPeer x-real-ip metadata is not checked instead of peer address
+func TestGRPCTrustedPeer(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.TrustedSubnet = "10.17.0.0/16"
+	srv := service.NewService(repository.NewRepository(&cfg.StorageConfig, nil), &cfg.StorageConfig)
+	s := grpcHandler.NewServer(srv, cfg, zap.NewNop()).Handler()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	defer s.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, conn.Close()) }()
+
+	in := &pb.SetMetricsRequest{Metric: []*pb.Metric{{Id: "testCounterPeer", Mtype: "counter", Delta: 1}}}
+	_, err = pb.NewMetricsClient(conn).SetMetrics(signedContext(context.Background(), "10.17.0.10", "", sign.Params{}), in)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "peer address is not trusted")
+}
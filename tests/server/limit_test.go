@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/server/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func testRateLimit(suite HandlerTestSuite) {
+	t := suite.T()
+
+	defer func(old int) { suite.Cfg().LimitAgentRequests = old }(suite.Cfg().LimitAgentRequests)
+	defer func(old int) { suite.Cfg().LimitAgentMetrics = old }(suite.Cfg().LimitAgentMetrics)
+	// agents are separated by X-Real-IP of trusted proxy
+	defer func(old bool) { suite.Cfg().LimitRealIP = old }(suite.Cfg().LimitRealIP)
+	suite.Cfg().LimitRealIP = true
+
+	send := func(t *testing.T, ip, agentID string, n int) *http.Response {
+		b := bytes.NewBufferString("[")
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(fmt.Sprintf(`{"id": "testLimitCounter%d", "type": "counter", "delta": 1}`, i))
+		}
+		b.WriteString("]")
+		req, err := http.NewRequest(http.MethodPost, "http://"+suite.Cfg().Address+constant.UpdatesRoute, b)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(constant.HeaderAgentID, agentID)
+		req.Header.Set(constant.HeaderXRealIP, ip)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res
+	}
+
+	t.Run("Agent requests limit", func(t *testing.T) {
+		suite.Cfg().LimitAgentRequests, suite.Cfg().LimitAgentMetrics = 2, 0
+		ip := randomIP()
+		agentID := fmt.Sprintf("test-limit-agent-%d", rand.Int())
+		assert.Equal(t, http.StatusOK, send(t, ip, agentID, 1).StatusCode)
+		assert.Equal(t, http.StatusOK, send(t, ip, agentID, 1).StatusCode)
+		res := send(t, ip, agentID, 1)
+		assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+		assert.Equal(t, "1", res.Header.Get(constant.HeaderRetryAfter))
+		assert.Equal(t, http.StatusTooManyRequests, send(t, ip, agentID+"-other", 1).StatusCode,
+			"not verified agent id is not limit key")
+		assert.Equal(t, http.StatusOK, send(t, randomIP(), agentID, 1).StatusCode, "other agent is not limited")
+	})
+
+	t.Run("Real ip is not trusted by default", func(t *testing.T) {
+		suite.Cfg().LimitAgentRequests, suite.Cfg().LimitAgentMetrics = 2, 0
+		suite.Cfg().LimitRealIP = false
+		defer func() { suite.Cfg().LimitRealIP = true }()
+		agentID := fmt.Sprintf("test-limit-agent-%d", rand.Int())
+		assert.Equal(t, http.StatusOK, send(t, randomIP(), agentID, 1).StatusCode)
+		assert.Equal(t, http.StatusOK, send(t, randomIP(), agentID, 1).StatusCode)
+		assert.Equal(t, http.StatusTooManyRequests, send(t, randomIP(), agentID, 1).StatusCode,
+			"connection address is limit key")
+	})
+
+	t.Run("Agent metrics limit", func(t *testing.T) {
+		suite.Cfg().LimitAgentRequests, suite.Cfg().LimitAgentMetrics = 0, 5
+		ip, agentID := randomIP(), fmt.Sprintf("test-limit-agent-%d", rand.Int())
+		assert.Equal(t, http.StatusOK, send(t, ip, agentID, 3).StatusCode)
+		res := send(t, ip, agentID, 3)
+		assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+		assert.Equal(t, "1", res.Header.Get(constant.HeaderRetryAfter))
+		assert.Equal(t, http.StatusOK, send(t, ip, agentID, 2).StatusCode, "tokens are not taken by limited request")
+	})
+
+	t.Run("GRPC agent requests limit", func(t *testing.T) {
+		suite.Cfg().LimitAgentRequests, suite.Cfg().LimitAgentMetrics = 1, 0
+		agentID := fmt.Sprintf("test-limit-agent-%d", rand.Int())
+		ctx, conn, client, callOpt, err := testGRPCDial(suite, context.Background(),
+			map[string]string{"token": suite.Cfg().GRPCToken, "x-agent-id": agentID, "x-real-ip": randomIP()})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, conn.Close()) }()
+		in := &pb.SetMetricsRequest{Metric: []*pb.Metric{{Id: "testLimitCounter", Mtype: "counter", Delta: 1}}}
+
+		_, err = client.SetMetrics(ctx, in, callOpt...)
+		require.NoError(t, err)
+		var trailer metadata.MD
+		_, err = client.SetMetrics(ctx, in, append(callOpt, grpc.Trailer(&trailer))...)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, []string{"1"}, trailer.Get(constant.HeaderRetryAfter))
+	})
+}
+
+// randomIP client ip of own rate limits
+func randomIP() string {
+	return fmt.Sprintf("10.%d.%d.%d", rand.Intn(256), rand.Intn(256), rand.Intn(254)+1)
+}
@@ -0,0 +1,121 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"io"
+	"net/http"
+	"testing"
+
+	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testQuotas(suite HandlerTestSuite) {
+	t := suite.T()
+
+	defer func(old int) { suite.Cfg().MaxSeries = old }(suite.Cfg().MaxSeries)
+	defer func(old int) { suite.Cfg().MaxAgentSeries = old }(suite.Cfg().MaxAgentSeries)
+	defer func(old int) { suite.Cfg().MaxNameLength = old }(suite.Cfg().MaxNameLength)
+	// agents are separated by X-Real-IP of trusted proxy
+	defer func(old bool) { suite.Cfg().LimitRealIP = old }(suite.Cfg().LimitRealIP)
+	suite.Cfg().LimitRealIP = true
+
+	post := func(t *testing.T, ip, route, body string) (int, []byte) {
+		req, err := http.NewRequest(http.MethodPost, "http://"+suite.Cfg().Address+route, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(constant.HeaderXRealIP, ip)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res.StatusCode, b
+	}
+	rejected := func(reason string) int64 {
+		if v, ok := expvar.Get("metrics_rejected").(*expvar.Map).Get(reason).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+
+	t.Run("Name length", func(t *testing.T) {
+		suite.Cfg().MaxSeries, suite.Cfg().MaxAgentSeries, suite.Cfg().MaxNameLength = 0, 0, 16
+		before := rejected("name_length")
+		code, body := post(t, "10.0.0.1", "/update/gauge/testQuotaVeryLongGaugeName/1", "")
+		assert.Equal(t, http.StatusUnprocessableEntity, code)
+		assert.Contains(t, string(body), "metric name is too long")
+		assert.Equal(t, before+1, rejected("name_length"))
+
+		code, _ = post(t, "10.0.0.1", "/update/gauge/testQuotaGauge/1", "")
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("Agent series", func(t *testing.T) {
+		suite.Cfg().MaxSeries, suite.Cfg().MaxAgentSeries, suite.Cfg().MaxNameLength = 0, 2, 0
+		ip := randomIP()
+		before := rejected("agent_series")
+		code, body := post(t, ip, constant.UpdatesRoute, `[
+			{"id": "testQuotaAgent1", "type": "gauge", "value": 1},
+			{"id": "testQuotaAgent2", "type": "gauge", "value": 2},
+			{"id": "testQuotaAgent3", "type": "gauge", "value": 3}]`)
+		require.Equal(t, http.StatusOK, code)
+		var metrics []domain.Metric
+		require.NoError(t, json.Unmarshal(body, &metrics))
+		require.Len(t, metrics, 3)
+		assert.Empty(t, metrics[0].Error)
+		assert.Empty(t, metrics[1].Error)
+		assert.Equal(t, "testQuotaAgent3", metrics[2].ID)
+		assert.Equal(t, "quota exceeded: agent series limit", metrics[2].Error)
+		assert.Equal(t, before+1, rejected("agent_series"))
+
+		code, _ = post(t, ip, constant.UpdateRoute, `{"id": "testQuotaAgent1", "type": "gauge", "value": 11}`)
+		assert.Equal(t, http.StatusOK, code, "known series of agent are accepted")
+
+		code, body = post(t, ip, constant.UpdatesRoute, `[{"id": "testQuotaAgent4", "type": "counter", "delta": 1}]`)
+		assert.Equal(t, http.StatusUnprocessableEntity, code, "all metrics are rejected")
+		assert.Contains(t, string(body), "agent series limit")
+
+		code, _ = post(t, randomIP(), constant.UpdatesRoute, `[{"id": "testQuotaAgent4", "type": "counter", "delta": 1}]`)
+		assert.Equal(t, http.StatusOK, code, "other agent is not limited")
+	})
+
+	t.Run("Total series", func(t *testing.T) {
+		suite.Cfg().MaxSeries, suite.Cfg().MaxAgentSeries, suite.Cfg().MaxNameLength = 1, 0, 0
+		code, body := post(t, "10.0.0.1", constant.UpdatesRoute, `[
+			{"id": "testQuotaNewGauge", "type": "gauge", "value": 5},
+			{"id": "testQuotaGauge", "type": "gauge", "value": 5}]`)
+		require.Equal(t, http.StatusOK, code)
+		var metrics []domain.Metric
+		require.NoError(t, json.Unmarshal(body, &metrics))
+		require.Len(t, metrics, 2)
+		assert.Equal(t, "testQuotaNewGauge", metrics[0].ID, "order of request is kept")
+		assert.Equal(t, "quota exceeded: series limit", metrics[0].Error)
+		assert.Equal(t, "testQuotaGauge", metrics[1].ID)
+		assert.Empty(t, metrics[1].Error, "existing series is accepted")
+
+		ctx, conn, client, callOpt, err := testGRPCDial(suite, context.Background(),
+			map[string]string{"token": suite.Cfg().GRPCToken})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, conn.Close()) }()
+		out, err := client.SetMetrics(ctx, &pb.SetMetricsRequest{Metric: []*pb.Metric{
+			{Id: "testQuotaGauge", Mtype: "gauge", Value: 6},
+			{Id: "testQuotaNewGauge", Mtype: "gauge", Value: 6},
+		}}, callOpt...)
+		require.NoError(t, err)
+		require.Len(t, out.GetMetric(), 2)
+		assert.Equal(t, "quota exceeded: series limit", out.GetMetric()[1].GetError())
+
+		_, err = client.SetMetric(ctx, &pb.SetMetricRequest{Metric: &pb.Metric{Id: "testQuotaNewGauge", Mtype: "gauge", Value: 6}}, callOpt...)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"go-musthave-metrics/internal/server/constant"
 	"go-musthave-metrics/internal/server/domain"
 	"go-musthave-metrics/internal/server/handler/rest"
+	"go-musthave-metrics/internal/sign"
 	"io"
 	"math/rand"
 	"net/http"
@@ -1202,6 +1203,7 @@ func testGzip(suite HandlerTestSuite) {
 func testHashKey(suite HandlerTestSuite) {
 	t := suite.T()
 	suite.Cfg().Key = "secretKey"
+	defer func() { suite.Cfg().Key = "" }()
 
 	data1 := []domain.Metric{{ID: fmt.Sprintf("testCounter%d", rand.Int()), MType: "counter", Delta: &[]domain.Counter{1}[0]}, {ID: "testGauge", MType: "gauge", Value: &[]domain.Gauge{100.0015}[0]}}
 	dataBody1, err := json.Marshal(data1)
@@ -1211,6 +1213,18 @@ func testHashKey(suite HandlerTestSuite) {
 	dataBody2, err := json.Marshal(data2)
 	require.NoError(t, err)
 
+	data4 := []domain.Metric{{ID: fmt.Sprintf("testCounter%d", rand.Int()), MType: "counter", Delta: &[]domain.Counter{1}[0]}}
+	dataBody4, err := json.Marshal(data4)
+	require.NoError(t, err)
+	replayHeaders := signHeaders(suite.Cfg().Key, dataBody4, nil)
+
+	data5 := []domain.Metric{{ID: fmt.Sprintf("testCounter%d", rand.Int()), MType: "counter", Delta: &[]domain.Counter{1}[0]}}
+	dataBody5, err := json.Marshal(data5)
+	require.NoError(t, err)
+
+	expired := sign.New(http.MethodPost, constant.UpdatesRoute, dataBody1)
+	expired.Timestamp -= 2 * constant.SignSkew
+
 	data3 := []domain.Metric{{ID: fmt.Sprintf("testCounter%d", rand.Int()), MType: "counter", Delta: &[]domain.Counter{1}[0]}, {ID: "testGauge", MType: "gauge", Value: &[]domain.Gauge{100.0015}[0]}}
 	dataBody3, err := json.Marshal(data3)
 	require.NoError(t, err)
@@ -1225,6 +1239,7 @@ func testHashKey(suite HandlerTestSuite) {
 		method  string
 		headers map[string]string
 		body    []byte
+		legacy  bool
 	}
 	tests := []struct {
 		name string
@@ -1234,11 +1249,9 @@ func testHashKey(suite HandlerTestSuite) {
 		{
 			name: "Save json right secret key. Ok",
 			args: args{
-				method: http.MethodPost,
-				body:   dataBody1,
-				headers: map[string]string{
-					constant.HeaderSignKey: rest.SignData(suite.Cfg().Key, dataBody1),
-				},
+				method:  http.MethodPost,
+				body:    dataBody1,
+				headers: signHeaders(suite.Cfg().Key, dataBody1, nil),
 			},
 			want: want{
 				code:        http.StatusOK,
@@ -1254,11 +1267,10 @@ func testHashKey(suite HandlerTestSuite) {
 			args: args{
 				method: http.MethodPost,
 				body:   dataBody2,
-				headers: map[string]string{
-					"Accept-Encoding":      "gzip",
-					"Content-Encoding":     "gzip",
-					constant.HeaderSignKey: rest.SignData(suite.Cfg().Key, dataBody2),
-				},
+				headers: signHeaders(suite.Cfg().Key, dataBody2, map[string]string{
+					"Accept-Encoding":  "gzip",
+					"Content-Encoding": "gzip",
+				}),
 			},
 			want: want{
 				code:        http.StatusOK,
@@ -1273,11 +1285,9 @@ func testHashKey(suite HandlerTestSuite) {
 		{
 			name: "Save json. WRONG secret key. ",
 			args: args{
-				method: http.MethodPost,
-				body:   dataBody1,
-				headers: map[string]string{
-					constant.HeaderSignKey: rest.SignData("wrong secret key", dataBody1),
-				},
+				method:  http.MethodPost,
+				body:    dataBody1,
+				headers: signHeaders("wrong secret key", dataBody1, nil),
 			},
 			want: want{
 				code: http.StatusBadRequest,
@@ -1301,10 +1311,68 @@ func testHashKey(suite HandlerTestSuite) {
 			args: args{
 				method: http.MethodPost,
 				body:   dataBody2,
+				headers: signHeaders("wrong secret key", dataBody2, map[string]string{
+					"Accept-Encoding":  "gzip",
+					"Content-Encoding": "gzip",
+				}),
+			},
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "Save json. Replay, first request. Ok",
+			args: args{
+				method:  http.MethodPost,
+				body:    dataBody4,
+				headers: replayHeaders,
+			},
+			want: want{
+				code:     http.StatusOK,
+				response: data4,
+			},
+		},
+		{
+			name: "Save json. Replay, same nonce",
+			args: args{
+				method:  http.MethodPost,
+				body:    dataBody4,
+				headers: replayHeaders,
+			},
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "Save json. Expired timestamp",
+			args: args{
+				method:  http.MethodPost,
+				body:    dataBody1,
+				headers: expired.Headers(suite.Cfg().Key, constant.HeaderSignKey),
+			},
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "Save json. Signed for other path",
+			args: args{
+				method: http.MethodPost,
+				body:   dataBody1,
+				headers: sign.New(http.MethodPost, constant.UpdateRoute, dataBody1).
+					Headers(suite.Cfg().Key, constant.HeaderSignKey),
+			},
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "Save json. Legacy sign, not allowed",
+			args: args{
+				method: http.MethodPost,
+				body:   dataBody1,
 				headers: map[string]string{
-					"Accept-Encoding":      "gzip",
-					"Content-Encoding":     "gzip",
-					constant.HeaderSignKey: rest.SignData("wrong secret key", dataBody2),
+					constant.HeaderSignKey: sign.SignLegacy(suite.Cfg().Key, dataBody1),
 				},
 			},
 			want: want{
@@ -1312,7 +1380,22 @@ func testHashKey(suite HandlerTestSuite) {
 			},
 		},
 		{
-			name: "Save json, NO secret key (gzip).",
+			name: "Save json. Legacy sign, allowed. Ok",
+			args: args{
+				method: http.MethodPost,
+				body:   dataBody5,
+				legacy: true,
+				headers: map[string]string{
+					constant.HeaderSignKey: sign.SignLegacy(suite.Cfg().Key, dataBody5),
+				},
+			},
+			want: want{
+				code:     http.StatusOK,
+				response: data5,
+			},
+		},
+		{
+			name: "Save json, NOT signed (gzip).",
 			args: args{
 				method: http.MethodPost,
 				body:   dataBody3,
@@ -1322,14 +1405,26 @@ func testHashKey(suite HandlerTestSuite) {
 				},
 			},
 			want: want{
-				code:        http.StatusOK,
-				response:    data3,
-				contentType: "application/json; charset=utf-8",
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "Save json. Legacy sign allowed, NOT signed",
+			args: args{
+				method: http.MethodPost,
+				body:   dataBody3,
+				legacy: true,
+			},
+			want: want{
+				code: http.StatusBadRequest,
 			},
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
+			suite.Cfg().SignLegacy = test.args.legacy
+			defer func() { suite.Cfg().SignLegacy = false }()
+
 			b := new(bytes.Buffer)
 			b.Write(test.args.body)
 			if len(test.args.headers) > 0 && test.args.headers["Content-Encoding"] == "gzip" {
@@ -1401,6 +1496,15 @@ func testHashKey(suite HandlerTestSuite) {
 	}
 }
 
+// signHeaders sign headers of updates request with extra headers
+func signHeaders(key string, body []byte, headers map[string]string) map[string]string {
+	h := sign.New(http.MethodPost, constant.UpdatesRoute, body).Headers(key, constant.HeaderSignKey)
+	for k, v := range headers {
+		h[k] = v
+	}
+	return h
+}
+
 func testPing(suite HandlerTestSuite) {
 	t := suite.T()
 
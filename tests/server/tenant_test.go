@@ -0,0 +1,152 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "go-musthave-metrics/internal/grpc/proto"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testTenants(suite HandlerTestSuite) {
+	t := suite.T()
+
+	do := func(t *testing.T, method, path string, headers map[string]string, body string) (int, string) {
+		req, err := http.NewRequest(method, "http://"+suite.Cfg().Address+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		return res.StatusCode, string(b)
+	}
+	n := rand.Int()
+	teamA, teamB := fmt.Sprintf("teamA-%d", n), fmt.Sprintf("teamB-%d", n)
+	name := fmt.Sprintf("testTenantGauge%d", n)
+
+	t.Run("Isolated metrics", func(t *testing.T) {
+		for tenant, v := range map[string]string{"": "1", teamA: "2", teamB: "3"} {
+			code, _ := do(t, http.MethodPost, "/update/gauge/"+name+"/"+v, map[string]string{constant.HeaderTenant: tenant}, "")
+			require.Equal(t, http.StatusOK, code)
+		}
+		for tenant, v := range map[string]string{"": "1", teamA: "2", teamB: "3"} {
+			code, body := do(t, http.MethodGet, "/value/gauge/"+name, map[string]string{constant.HeaderTenant: tenant}, "")
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, v, body, "tenant %q", tenant)
+		}
+		code, _ := do(t, http.MethodGet, "/value/gauge/"+name, map[string]string{constant.HeaderTenant: "other-" + teamA}, "")
+		assert.Equal(t, http.StatusNotFound, code)
+
+		code, _ = do(t, http.MethodGet, "/value/gauge/"+name, map[string]string{constant.HeaderTenant: "bad/tenant"}, "")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("Listings", func(t *testing.T) {
+		code, _ := do(t, http.MethodPost, constant.UpdatesRoute, map[string]string{
+			constant.HeaderTenant:  teamA,
+			constant.HeaderAgentID: "tenant-agent",
+		}, `[{"id": "testTenantOnlyA", "type": "counter", "delta": 1}]`)
+		require.Equal(t, http.StatusOK, code)
+
+		code, body := do(t, http.MethodGet, "/", map[string]string{constant.HeaderTenant: teamA}, "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, "Metrics list: "+teamA)
+		assert.Contains(t, body, "testTenantOnlyA")
+		_, body = do(t, http.MethodGet, "/", map[string]string{constant.HeaderTenant: teamB}, "")
+		assert.NotContains(t, body, "testTenantOnlyA")
+
+		var agents []domain.Agent
+		code, body = do(t, http.MethodGet, constant.AgentsRoute, map[string]string{constant.HeaderTenant: teamA}, "")
+		require.Equal(t, http.StatusOK, code)
+		require.NoError(t, json.Unmarshal([]byte(body), &agents))
+		require.Len(t, agents, 1)
+		assert.Equal(t, "tenant-agent", agents[0].ID)
+		assert.Equal(t, teamA, agents[0].Tenant)
+
+		_, body = do(t, http.MethodGet, constant.AgentsRoute, map[string]string{constant.HeaderTenant: teamB}, "")
+		require.NoError(t, json.Unmarshal([]byte(body), &agents))
+		assert.Empty(t, agents)
+	})
+
+	t.Run("Tenant of principal", func(t *testing.T) {
+		accessFile := filepath.Join(t.TempDir(), "access.json")
+		require.NoError(t, os.WriteFile(accessFile, []byte(fmt.Sprintf(`{
+			"principals": [
+				{"name": "team-a", "token": "team-a-token", "role": "writer", "tenant": %q},
+				{"name": "admin", "token": "admin-token", "role": "admin"}
+			]
+		}`, teamA)), 0o600))
+		defer func(old string) { suite.Cfg().AccessPath = old }(suite.Cfg().AccessPath)
+		suite.Cfg().AccessPath = accessFile
+
+		teamToken := map[string]string{constant.HeaderAuthorization: constant.BearerPrefix + "team-a-token"}
+		code, body := do(t, http.MethodGet, "/value/gauge/"+name, teamToken, "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "2", body, "tenant of principal is used")
+
+		code, _ = do(t, http.MethodGet, "/value/gauge/"+name, map[string]string{
+			constant.HeaderAuthorization: constant.BearerPrefix + "team-a-token",
+			constant.HeaderTenant:        teamB,
+		}, "")
+		assert.Equal(t, http.StatusForbidden, code)
+
+		code, body = do(t, http.MethodGet, "/value/gauge/"+name, map[string]string{
+			constant.HeaderAuthorization: constant.BearerPrefix + "admin-token",
+			constant.HeaderTenant:        teamB,
+		}, "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "3", body, "principal without tenant selects tenant by header")
+	})
+
+	t.Run("Tenant quota", func(t *testing.T) {
+		defer func(old int) { suite.Cfg().MaxTenantSeries = old }(suite.Cfg().MaxTenantSeries)
+		suite.Cfg().MaxTenantSeries = 2
+		tenant := fmt.Sprintf("teamQuota-%d", n)
+		code, body := do(t, http.MethodPost, constant.UpdatesRoute, map[string]string{constant.HeaderTenant: tenant}, `[
+			{"id": "testTenantQuota1", "type": "gauge", "value": 1},
+			{"id": "testTenantQuota2", "type": "gauge", "value": 2},
+			{"id": "testTenantQuota3", "type": "gauge", "value": 3}]`)
+		require.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, "quota exceeded: tenant series limit")
+
+		code, _ = do(t, http.MethodPost, "/update/gauge/testTenantQuota3/1", map[string]string{constant.HeaderTenant: tenant + "-other"}, "")
+		assert.Equal(t, http.StatusOK, code, "other tenant has own quota")
+	})
+
+	t.Run("GRPC", func(t *testing.T) {
+		ctx, conn, client, callOpt, err := testGRPCDial(suite, context.Background(),
+			map[string]string{"token": suite.Cfg().GRPCToken, "x-tenant": teamA})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, conn.Close()) }()
+
+		out, err := client.GetMetric(ctx, &pb.GetMetricRequest{Metric: &pb.Metric{Id: name, Mtype: "gauge"}}, callOpt...)
+		require.NoError(t, err)
+		assert.Equal(t, float32(2), out.GetMetric().GetValue())
+
+		ctxB, connB, clientB, callOptB, err := testGRPCDial(suite, context.Background(),
+			map[string]string{"token": suite.Cfg().GRPCToken, "x-tenant": "bad/tenant"})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, connB.Close()) }()
+		_, err = clientB.GetMetric(ctxB, &pb.GetMetricRequest{Metric: &pb.Metric{Id: name, Mtype: "gauge"}}, callOptB...)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
@@ -0,0 +1,73 @@
+package server_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-musthave-metrics/internal/server/config"
+	"go-musthave-metrics/internal/server/constant"
+	"go-musthave-metrics/internal/server/domain"
+	"go-musthave-metrics/internal/server/repository"
+	"go-musthave-metrics/internal/server/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.NewConfig()
+	cfg.FileStoragePath = filepath.Join(t.TempDir(), "store.json")
+	cfg.WALPath = filepath.Join(t.TempDir(), "wal.log")
+	cfg.WALSync = constant.WALSyncAlways
+	cfg.FileStoreInterval = 0
+
+	newService := func() *service.Service {
+		return service.NewService(repository.NewRepository(&cfg.StorageConfig, nil), &cfg.StorageConfig)
+	}
+	value := func(s *service.Service, mType, id string) domain.Metric {
+		m, err := s.GetMetric(ctx, mType, id)
+		require.NoError(t, err)
+		return m
+	}
+
+	s := newService()
+	require.NoError(t, s.SetGauge(ctx, "testWALGauge", 1.5))
+	require.NoError(t, s.IncreaseCounter(ctx, "testWALCounter", 2))
+	_, err := s.SetMetrics(domain.WithTenant(ctx, "team"), []domain.Metric{
+		{ID: "testWALCounter", MType: constant.MetricTypeCounter, Delta: &[]domain.Counter{7}[0]},
+	})
+	require.NoError(t, err)
+
+	t.Run("Store file is not rewritten on change", func(t *testing.T) {
+		_, err := os.Stat(cfg.FileStoragePath)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("Restore after crash", func(t *testing.T) {
+		s := newService()
+		n, err := s.RestoreFromFile(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+		assert.Equal(t, domain.Gauge(1.5), *value(s, constant.MetricTypeGauge, "testWALGauge").Value)
+		assert.Equal(t, domain.Counter(2), *value(s, constant.MetricTypeCounter, "testWALCounter").Delta)
+	})
+
+	t.Run("Snapshot truncates log", func(t *testing.T) {
+		_, err := s.SaveToFile(ctx)
+		require.NoError(t, err)
+		_, err = os.Stat(cfg.WALPath)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		require.NoError(t, s.IncreaseCounter(ctx, "testWALCounter", 3))
+
+		restored := newService()
+		n, err := restored.RestoreFromFile(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+		assert.Equal(t, domain.Counter(5), *value(restored, constant.MetricTypeCounter, "testWALCounter").Delta,
+			"snapshot and log after it are restored")
+	})
+}